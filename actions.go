@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aymanbagabas/go-osc52/v2"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// completionAction is one follow-up offered after a test. Its key works
+// whether or not the menu is shown, so the menu and the bare shortcuts
+// can't drift apart; a new action is one entry in completionActions.
+type completionAction struct {
+	key   string
+	label string
+	// available reports whether the action applies to this run; nil
+	// means always.
+	available func(m speedTest) bool
+	run       func(m speedTest) (tea.Model, tea.Cmd)
+}
+
+var completionActions = []completionAction{
+	{"r", "Run again", nil, speedTest.restart},
+	{"n", "Run against a different server", speedTest.canChangeServer, speedTest.changeServer},
+	{"m", "Server latency map", speedTest.canMapServers, speedTest.openServerMap},
+	{"w", "Save report", nil, speedTest.saveReport},
+	{"y", "Copy JSON", nil, speedTest.copyJSON},
+	{"a", "Add a note", nil, speedTest.addNote},
+	{"h", "View history", speedTest.hasHistory, speedTest.toggleHistory},
+	{"t", "Troubleshoot", speedTest.needsTroubleshooting, speedTest.troubleshoot},
+	{"u", "Switch between Mbps and MB/s", nil, speedTest.switchUnits},
+	{"b", "Switch between speeds and percent of baseline", nil, speedTest.togglePercent},
+	{"p", "Save as default", speedTest.hasUnsavedPrefs, speedTest.saveDefaults},
+	{"e", "Change settings", nil, speedTest.changeSettings},
+}
+
+// reportPath is where "Save report" writes, relative to the working
+// directory, with --output's placeholders.
+const reportPath = "gofast-%Y%m%d-%H%M%S.json"
+
+// noticeMsg reports the outcome of an action on the completion screen.
+type noticeMsg string
+
+type historyMsg []Results
+
+// historyRows is how many recent runs "View history" lists.
+const historyRows = 10
+
+// actions returns the completion actions that apply to this run.
+func (m speedTest) actions() []completionAction {
+	var actions []completionAction
+	for _, a := range completionActions {
+		if a.available == nil || a.available(m) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// renderMenu lists the actions with the selected one highlighted and each
+// shortcut alongside.
+func (m speedTest) renderMenu() string {
+	var s strings.Builder
+	for i, a := range m.actions() {
+		if i == m.menu {
+			s.WriteString(fmt.Sprintf("  \033[36;1m▸ %-32s\033[0m \033[90m%s\033[0m\n", a.label, a.key))
+		} else {
+			s.WriteString(fmt.Sprintf("    %-32s \033[90m%s\033[0m\n", a.label, a.key))
+		}
+	}
+	s.WriteString("\033[90m↑/↓ to choose, enter to run, 'c' for a QR code\033[0m")
+	return s.String()
+}
+
+// shortcutHints is the one-line alternative to the menu.
+func (m speedTest) shortcutHints() string {
+	var hints []string
+	for _, a := range m.actions() {
+		hints = append(hints, fmt.Sprintf("'%s' %s", a.key, strings.ToLower(a.label[:1])+a.label[1:]))
+	}
+	return "Press " + strings.Join(append(hints, "'c' QR code"), ", ")
+}
+
+func (m speedTest) canChangeServer() bool {
+	_, ok := m.engine.provider.(serverSelector)
+	return ok
+}
+
+// changeServer runs again, trying the server just used last.
+func (m speedTest) changeServer() (tea.Model, tea.Cmd) {
+	m.engine.avoidServer = m.results.Server.Name
+	m.engine.preferServer = ""
+	return m.restart()
+}
+
+func (m speedTest) saveReport() (tea.Model, tea.Cmd) {
+	r := m.results
+	return m, func() tea.Msg {
+		path := expandOutputPath(reportPath, r.Timestamp)
+		if err := writeOutput(path, false, func(w io.Writer) error { return writeJSON(w, r) }); err != nil {
+			return noticeMsg(fmt.Sprintf("\033[31mCouldn't save the report: %v\033[0m", err))
+		}
+		return noticeMsg("Saved the report to " + path)
+	}
+}
+
+// copyJSON puts the results on the clipboard with an OSC 52 escape, which
+// works over SSH in terminals that support it.
+func (m speedTest) copyJSON() (tea.Model, tea.Cmd) {
+	data, err := json.MarshalIndent(m.results, "", "  ")
+	if err != nil {
+		m.notice = fmt.Sprintf("\033[31mCouldn't encode the results: %v\033[0m", err)
+		return m, nil
+	}
+	seq := osc52.New(string(data))
+	if os.Getenv("TMUX") != "" {
+		seq = seq.Tmux()
+	}
+	if _, err := seq.WriteTo(os.Stderr); err != nil {
+		m.notice = fmt.Sprintf("\033[31mCouldn't copy: %v\033[0m", err)
+		return m, nil
+	}
+	m.notice = "Copied the results JSON to the clipboard"
+	return m, nil
+}
+
+func (m speedTest) hasHistory() bool {
+	return m.engine.history != nil
+}
+
+func (m speedTest) toggleHistory() (tea.Model, tea.Cmd) {
+	if m.history != nil {
+		m.history = nil
+		return m, nil
+	}
+	store := *m.engine.history
+	return m, func() tea.Msg {
+		runs, err := store.Load()
+		if err != nil {
+			return noticeMsg(fmt.Sprintf("\033[31mCouldn't read history: %v\033[0m", err))
+		}
+		if len(runs) == 0 {
+			return noticeMsg("No runs in history yet")
+		}
+		return historyMsg(runs[max(len(runs)-historyRows, 0):])
+	}
+}
+
+// changeSettings leaves the TUI for the setup wizard; main runs it once the
+// program has exited.
+func (m speedTest) changeSettings() (tea.Model, tea.Cmd) {
+	m.reconfigure = true
+	m.cancel()
+	return m, tea.Quit
+}
+
+func (m speedTest) switchUnits() (tea.Model, tea.Cmd) {
+	speedUnits = map[string]string{"bits": "bytes", "bytes": "bits"}[speedUnits]
+	return m.touch("units", strconv.Quote(speedUnits), func(c *config) { c.Units = speedUnits })
+}
+
+// touch records a setting changed in the TUI, saving it straight away with
+// autosave on.
+func (m speedTest) touch(key, value string, apply func(c *config)) (tea.Model, tea.Cmd) {
+	m.prefs = maps.Clone(m.prefs)
+	if m.prefs == nil {
+		m.prefs = map[string]string{}
+	}
+	m.prefs[key] = value
+	m.notice = ""
+	if m.opts.Config != nil {
+		apply(m.opts.Config)
+		if m.opts.Config.AutoSave && m.opts.ConfigPath != "" {
+			return m.writePrefs(false)
+		}
+	}
+	return m, nil
+}
+
+func (m speedTest) hasUnsavedPrefs() bool {
+	return len(m.prefs) > 0 && m.opts.Config != nil && m.opts.ConfigPath != ""
+}
+
+func (m speedTest) saveDefaults() (tea.Model, tea.Cmd) {
+	return m.writePrefs(false)
+}
+
+// writePrefs saves the changed settings to the config file. A file changed
+// by something else meanwhile is only overwritten once the user confirms.
+func (m speedTest) writePrefs(force bool) (tea.Model, tea.Cmd) {
+	err := m.opts.Config.update(m.opts.ConfigPath, m.prefs, force)
+	switch {
+	case errors.Is(err, errConfigChanged):
+		m.confirmOverwrite = true
+	case err != nil:
+		m.notice = fmt.Sprintf("\033[31mCouldn't save settings: %v\033[0m", err)
+	default:
+		var saved []string
+		for _, key := range slices.Sorted(maps.Keys(m.prefs)) {
+			saved = append(saved, key+" = "+m.prefs[key])
+		}
+		m.notice = "Saved " + strings.Join(saved, ", ") + " to " + m.opts.ConfigPath
+		m.prefs = nil
+	}
+	return m, nil
+}
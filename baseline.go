@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// loadBaseline reads the reference run for --baseline: "last" is the most
+// recent run in history, anything else a results file written by --json.
+func loadBaseline(spec string) (*Results, error) {
+	var r Results
+	if spec == "last" {
+		path, err := defaultHistoryPath()
+		if err != nil {
+			return nil, err
+		}
+		runs, err := historyStore{path: path}.Load()
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, errors.New("baseline: no previous run in history")
+		}
+		r = runs[len(runs)-1]
+	} else {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("baseline: %w", err)
+		}
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("baseline %s: %w", spec, err)
+		}
+	}
+
+	if r.SchemaVersion < 1 || r.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("baseline: unsupported schema version %d", r.SchemaVersion)
+	}
+	if r.Download == nil {
+		return nil, errors.New("baseline: reference run has no download result")
+	}
+	return &r, nil
+}
+
+// baselineSpeeds returns the reference download and upload speeds, zero when
+// there is no baseline.
+func (m speedTest) baselineSpeeds() (download, upload float64) {
+	if m.opts.Baseline == nil {
+		return 0, 0
+	}
+	return transferMbps(m.opts.Baseline.Download), transferMbps(m.opts.Baseline.Upload)
+}
+
+// baselineSection compares the finished run against the reference.
+func (m speedTest) baselineSection() detailSection {
+	section := detailSection{title: "Versus baseline"}
+	b := m.opts.Baseline
+	if b == nil {
+		return section
+	}
+
+	section.add("Reference", b.Timestamp.Local().Format("2006-01-02 15:04"))
+	section.add("Download", speedDelta(transferMbps(m.results.Download), transferMbps(b.Download)))
+	if m.results.Upload != nil && b.Upload != nil {
+		section.add("Upload", speedDelta(m.results.Upload.Mbps, b.Upload.Mbps))
+	}
+	if b.Latency.Source.Available() && m.results.Latency.Source.Available() {
+		diff := m.results.Latency.P50 - b.Latency.P50
+		color := "32"
+		if diff > 0 {
+			color = "31"
+		}
+		section.add("Ping", fmt.Sprintf("\033[%sm%+.1f ms\033[0m vs %.1f ms", color, diff, b.Latency.P50))
+	}
+	return section
+}
+
+func speedDelta(current, reference float64) string {
+	if reference <= 0 {
+		return formatSpeed(current)
+	}
+	pct := (current - reference) / reference * 100
+	color := "32"
+	if pct < 0 {
+		color = "31"
+	}
+	return fmt.Sprintf("\033[%sm%+.0f%%\033[0m vs %s", color, pct, formatSpeed(reference))
+}
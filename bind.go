@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// --interface runs a test over one network interface. Its name travels on
+// the run's context, so every connection the run dials, by any provider,
+// is bound to it without each being handed a dialer.
+
+type interfaceKey struct{}
+
+// withInterface binds connections dialed under ctx to the named interface;
+// "" leaves them to the routing table.
+func withInterface(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, interfaceKey{}, name)
+}
+
+func boundInterface(ctx context.Context) string {
+	name, _ := ctx.Value(interfaceKey{}).(string)
+	return name
+}
+
+// bindDialer returns d set up to bind to the interface ctx names, if any.
+func bindDialer(ctx context.Context, d *net.Dialer) *net.Dialer {
+	if name := boundInterface(ctx); name != "" {
+		d.Control = bindToDevice(name)
+	}
+	return d
+}
+
+// dialBound dials like http.DefaultTransport, on the interface and over
+// the family ctx names.
+func dialBound(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return bindDialer(ctx, d).DialContext(ctx, familyNetwork(ctx, network), addr)
+}
+
+// interfaceIPv4 returns the first IPv4 address of the interface ctx names,
+// for sockets that can only be bound by address, or nil.
+func interfaceIPv4(ctx context.Context) net.IP {
+	name := boundInterface(ctx)
+	if name == "" {
+		return nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	addrs, _ := iface.Addrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP
+		}
+	}
+	return nil
+}
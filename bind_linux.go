@@ -0,0 +1,20 @@
+package main
+
+import "syscall"
+
+// bindToDevice returns a dialer Control function that binds sockets to
+// the named interface with SO_BINDTODEVICE, so traffic leaves through it
+// whatever the routing table prefers.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+func interfaceBindingSupported() error { return nil }
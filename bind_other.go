@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errNoInterfaceBinding = errors.New("binding to an interface is only supported on Linux")
+
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error { return errNoInterfaceBinding }
+}
+
+func interfaceBindingSupported() error { return errNoInterfaceBinding }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// nominalTransfer is how long a transfer direction is assumed to take
+	// when the profile leaves it to the provider.
+	nominalTransfer = 10 * time.Second
+	// minTransfer is the shortest transfer still worth reporting.
+	minTransfer = 2 * time.Second
+	// budgetMargin is held back from the transfers so they stop on their
+	// own before the hard deadline cancels the run.
+	budgetMargin = 500 * time.Millisecond
+)
+
+// budget enforces --max-duration across a whole run. Ping is never cut; when
+// the remaining time can't cover the transfers at full length, loaded-latency
+// diagnostics are dropped first, then upload, and the transfers that remain
+// are shortened to fit. Every cut is recorded for the results.
+type budget struct {
+	clock    Clock
+	deadline time.Time
+	cuts     []string
+}
+
+func newBudget(clock Clock, max time.Duration) *budget {
+	b := &budget{clock: clock}
+	if max > 0 {
+		b.deadline = clock.Now().Add(max)
+	}
+	return b
+}
+
+func (b *budget) limited() bool {
+	return !b.deadline.IsZero()
+}
+
+func (b *budget) remaining() time.Duration {
+	return b.deadline.Sub(b.clock.Now()) - budgetMargin
+}
+
+func (b *budget) cut(format string, args ...any) {
+	b.cuts = append(b.cuts, fmt.Sprintf(format, args...))
+}
+
+// transferPlan is what the budget allows the transfer phases after ping.
+type transferPlan struct {
+	probe    bool
+	upload   bool
+	download time.Duration
+}
+
+// plan decides which transfers still fit. full is the expected length of one
+// direction; upload reports whether the provider would run one.
+func (b *budget) plan(full time.Duration, upload bool) (transferPlan, error) {
+	p := transferPlan{probe: true, upload: upload}
+	if !b.limited() {
+		return p, nil
+	}
+
+	rem := b.remaining()
+	if rem < minTransfer {
+		return p, fmt.Errorf("--max-duration left %s for the download, need at least %s", rem.Round(100*time.Millisecond), minTransfer)
+	}
+	directions := time.Duration(1)
+	if upload {
+		directions = 2
+	}
+	if rem >= directions*full {
+		p.download = rem / directions
+		return p, nil
+	}
+
+	p.probe = false
+	b.cut("loaded latency skipped")
+	if upload && rem < 2*minTransfer {
+		p.upload = false
+		b.cut("upload skipped")
+	} else if upload {
+		rem /= 2
+	}
+	p.download = rem
+	if rem < full {
+		b.cut("download shortened to %s", rem.Round(100*time.Millisecond))
+	}
+	return p, nil
+}
+
+// uploadWindow is how long the upload may run once the download is done,
+// or false when there is no time left for it.
+func (b *budget) uploadWindow(full time.Duration) (time.Duration, bool) {
+	if !b.limited() {
+		return 0, true
+	}
+	rem := b.remaining()
+	if rem < minTransfer {
+		b.cut("upload skipped")
+		return 0, false
+	}
+	if rem < full {
+		b.cut("upload shortened to %s", rem.Round(100*time.Millisecond))
+	}
+	return rem, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudgetPlan(t *testing.T) {
+	tests := []struct {
+		max      time.Duration
+		upload   bool
+		want     transferPlan
+		cuts     []string
+		tooShort bool
+	}{
+		{max: 0, upload: true, want: transferPlan{probe: true, upload: true}},
+		{max: 30 * time.Second, upload: true, want: transferPlan{probe: true, upload: true, download: 14750 * time.Millisecond}},
+		{max: 15 * time.Second, upload: true, want: transferPlan{upload: true, download: 7250 * time.Millisecond},
+			cuts: []string{"loaded latency skipped", "download shortened to 7.3s"}},
+		{max: 15 * time.Second, upload: false, want: transferPlan{probe: true, download: 14500 * time.Millisecond}},
+		{max: 5 * time.Second, upload: true, want: transferPlan{upload: true, download: 2250 * time.Millisecond},
+			cuts: []string{"loaded latency skipped", "download shortened to 2.3s"}},
+		{max: 3 * time.Second, upload: true, want: transferPlan{download: 2500 * time.Millisecond},
+			cuts: []string{"loaded latency skipped", "upload skipped", "download shortened to 2.5s"}},
+		{max: 2 * time.Second, upload: true, tooShort: true},
+	}
+	for _, tt := range tests {
+		b := newBudget(newFakeClock(), tt.max)
+		got, err := b.plan(nominalTransfer, tt.upload)
+		if tt.tooShort {
+			if err == nil {
+				t.Errorf("max %v: planned %+v, want an error", tt.max, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("max %v: %v", tt.max, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("max %v, upload %v: plan %+v, want %+v", tt.max, tt.upload, got, tt.want)
+		}
+		if !reflect.DeepEqual(b.cuts, tt.cuts) {
+			t.Errorf("max %v, upload %v: cuts %q, want %q", tt.max, tt.upload, b.cuts, tt.cuts)
+		}
+	}
+}
+
+func TestBudgetUploadWindow(t *testing.T) {
+	tests := []struct {
+		max, download time.Duration
+		window        time.Duration
+		ok            bool
+		cuts          []string
+	}{
+		{max: 0, download: time.Minute, ok: true},
+		{max: 30 * time.Second, download: 10 * time.Second, window: 19500 * time.Millisecond, ok: true},
+		{max: 15 * time.Second, download: 7 * time.Second, window: 7500 * time.Millisecond, ok: true,
+			cuts: []string{"upload shortened to 7.5s"}},
+		{max: 10 * time.Second, download: 8 * time.Second, cuts: []string{"upload skipped"}},
+	}
+	for _, tt := range tests {
+		clock := newFakeClock()
+		b := newBudget(clock, tt.max)
+		<-clock.After(tt.download)
+		window, ok := b.uploadWindow(nominalTransfer)
+		if window != tt.window || ok != tt.ok {
+			t.Errorf("max %v after a %v download: window %v, %v; want %v, %v", tt.max, tt.download, window, ok, tt.window, tt.ok)
+		}
+		if !reflect.DeepEqual(b.cuts, tt.cuts) {
+			t.Errorf("max %v after a %v download: cuts %q, want %q", tt.max, tt.download, b.cuts, tt.cuts)
+		}
+	}
+}
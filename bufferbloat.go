@@ -0,0 +1,58 @@
+package main
+
+import "fmt"
+
+// Bufferbloat is how much the round trip grew while the transfers kept the
+// link full, against the idle ping.
+type Bufferbloat struct {
+	IncreaseMs float64 `json:"increase_ms"`
+	Grade      string  `json:"grade"`
+}
+
+// measureBufferbloat compares the loaded latency with the idle ping, or
+// returns nil when either is missing.
+func measureBufferbloat(idle Latency, loaded *Latency) *Bufferbloat {
+	if loaded == nil || !idle.Source.Available() {
+		return nil
+	}
+	increase := max(loaded.Avg-idle.Avg, 0)
+	return &Bufferbloat{IncreaseMs: increase, Grade: bufferbloatGrade(increase)}
+}
+
+// bufferbloatGrade rates an increase in latency under load from A, which
+// video calls and games won't notice, to F, which they can't get past.
+func bufferbloatGrade(increaseMs float64) string {
+	switch {
+	case increaseMs < 5:
+		return "A"
+	case increaseMs < 30:
+		return "B"
+	case increaseMs < 60:
+		return "C"
+	case increaseMs < 200:
+		return "D"
+	case increaseMs < 400:
+		return "E"
+	default:
+		return "F"
+	}
+}
+
+// cue shows the grade in the colors of the matching speed grade.
+func (b Bufferbloat) cue() cue {
+	switch b.Grade {
+	case "A":
+		return gradeExcellent.cue()
+	case "B":
+		return gradeGood.cue()
+	case "C", "D":
+		return gradeFair.cue()
+	default:
+		return gradePoor.cue()
+	}
+}
+
+// summary is the completion screen's line, e.g. "+185 ms (grade D)".
+func (b Bufferbloat) summary() string {
+	return b.cue().mark(fmt.Sprintf("+%.0f ms (grade %s)", b.IncreaseMs, b.Grade))
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A profile bundle carries one machine's gofast setup to others: a .tgz
+// with the config, thresholds, privacy switches and sinks included, as
+// config.toml, and a manifest.json. History never goes in, and neither
+// do secrets; the manifest lists those left out so the import can say
+// what to re-enter.
+
+// bundleVersion is the bundle format gofast profile export writes and the
+// newest import reads.
+const bundleVersion = 1
+
+// bundleFileLimit caps each file read from a bundle.
+const bundleFileLimit = 1 << 20
+
+type bundleManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Excluded names the secrets left out, such as "sink 1 (webhook)
+	// token".
+	Excluded []string `json:"excluded,omitempty"`
+}
+
+// secretSinkKeys are the sink options that hold credentials.
+var secretSinkKeys = []string{"token", "password", "secret"}
+
+// stripSecrets returns a copy of c without credentials, naming what it
+// took out. A sink URL keeps its scheme, host and path but loses any user
+// info and query string, where webhook services put their keys.
+func stripSecrets(c config) (config, []string) {
+	var excluded []string
+	sinks := make([]map[string]string, len(c.Sinks))
+	for i, sink := range c.Sinks {
+		sink = maps.Clone(sink)
+		name := fmt.Sprintf("sink %d (%s)", i+1, sink["type"])
+		for _, key := range secretSinkKeys {
+			if _, ok := sink[key]; ok {
+				delete(sink, key)
+				excluded = append(excluded, name+" "+key)
+			}
+		}
+		if u, err := url.Parse(sink["url"]); err == nil && (u.User != nil || u.RawQuery != "") {
+			u.User, u.RawQuery = nil, ""
+			sink["url"] = u.String()
+			excluded = append(excluded, name+" url credentials and query string")
+		}
+		sinks[i] = sink
+	}
+	c.Sinks = sinks
+	return c, excluded
+}
+
+// writeBundle writes c, without its secrets, as a bundle to w and returns
+// what was left out.
+func writeBundle(w io.Writer, c config, now time.Time) ([]string, error) {
+	c, excluded := stripSecrets(c)
+	var conf bytes.Buffer
+	if err := c.write(&conf); err != nil {
+		return nil, err
+	}
+	manifest, err := json.MarshalIndent(bundleManifest{Version: bundleVersion, Created: now.UTC(), Excluded: excluded}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"manifest.json", manifest}, {"config.toml", conf.Bytes()}} {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o600, Size: int64(len(f.data)), ModTime: now}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return excluded, gz.Close()
+}
+
+// readBundle reads a bundle's manifest and config.
+func readBundle(r io.Reader) (bundleManifest, config, error) {
+	var manifest bundleManifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, config{}, fmt.Errorf("not a gofast profile bundle: %w", err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, config{}, fmt.Errorf("not a gofast profile bundle: %w", err)
+		}
+		if h.Name != "manifest.json" && h.Name != "config.toml" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, bundleFileLimit+1))
+		if err != nil {
+			return manifest, config{}, err
+		}
+		if len(data) > bundleFileLimit {
+			return manifest, config{}, fmt.Errorf("%s in the bundle is over %s", h.Name, formatBytes(bundleFileLimit))
+		}
+		files[h.Name] = data
+	}
+	if files["manifest.json"] == nil || files["config.toml"] == nil {
+		return manifest, config{}, errors.New("not a gofast profile bundle: manifest.json or config.toml is missing")
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		return manifest, config{}, fmt.Errorf("manifest.json: %w", err)
+	}
+	if manifest.Version < 1 || manifest.Version > bundleVersion {
+		return manifest, config{}, fmt.Errorf("the bundle is format %d; this gofast reads up to %d", manifest.Version, bundleVersion)
+	}
+	c, err := parseConfig(bytes.NewReader(files["config.toml"]), "config.toml")
+	return manifest, c, err
+}
+
+// configValues flattens the effective config to its values as written to
+// config.toml, keys in tables prefixed with the table: "privacy.redact_ip",
+// "sink 1.type".
+func configValues(c config) map[string]string {
+	var b strings.Builder
+	c.write(&b)
+	values := map[string]string{}
+	table, sinks := "", 0
+	for _, line := range strings.Split(b.String(), "\n") {
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, "[") {
+			table = strings.Trim(t, "[]")
+			if table == "sink" {
+				sinks++
+				table = fmt.Sprintf("sink %d", sinks)
+			}
+			continue
+		}
+		key, value, ok := parseConfigLine(line)
+		if !ok {
+			continue
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// configChange is one setting an import changes; From or To is "" when
+// the setting is added or removed.
+type configChange struct {
+	Key, From, To string
+}
+
+func diffConfigs(from, to config) []configChange {
+	a, b := configValues(from), configValues(to)
+	union := maps.Clone(a)
+	maps.Copy(union, b)
+	var changes []configChange
+	for _, key := range slices.Sorted(maps.Keys(union)) {
+		if a[key] != b[key] {
+			changes = append(changes, configChange{Key: key, From: a[key], To: b[key]})
+		}
+	}
+	return changes
+}
+
+// previewValue shows a setting's value for the import preview, keeping
+// secrets being replaced off the screen.
+func previewValue(key, value string) string {
+	_, option, _ := strings.Cut(key, ".")
+	switch {
+	case value == "":
+		return "(none)"
+	case strings.HasPrefix(key, "sink ") && slices.Contains(secretSinkKeys, option):
+		return "(secret)"
+	}
+	if u, err := url.Parse(value); err == nil && option == "url" && (u.User != nil || u.RawQuery != "") {
+		u.User, u.RawQuery = nil, ""
+		return fmt.Sprintf("%q (with credentials)", u.String())
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+func writeImportPreview(w io.Writer, path string, changes []configChange) {
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "The bundle matches %s; nothing to change.\n", path)
+		return
+	}
+	width := 0
+	for _, c := range changes {
+		width = max(width, len(c.Key))
+	}
+	fmt.Fprintf(w, "\033[1mImporting changes %d setting(s) in %s:\033[0m\n", len(changes), path)
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %-*s  %s → \033[1m%s\033[0m\n", width, c.Key, previewValue(c.Key, c.From), previewValue(c.Key, c.To))
+	}
+}
+
+func writeExcluded(w io.Writer, excluded []string, path string) {
+	if len(excluded) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\033[33mSecrets aren't bundled; re-enter these in %s:\033[0m\n", path)
+	for _, e := range excluded {
+		fmt.Fprintf(w, "  \033[33m!\033[0m %s\n", e)
+	}
+}
+
+// runProfile implements gofast profile export and import, which copy a
+// setup between machines as a bundle.
+func runProfile(args []string) {
+	usage := "usage: gofast profile export BUNDLE.tgz | gofast profile import [--yes] BUNDLE.tgz"
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("profile "+args[0], flag.ExitOnError)
+	yes := fs.Bool("yes", false, "apply an import without asking; a bundle read from - is confirmed on the terminal otherwise")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	bundle := fs.Arg(0)
+	path, err := defaultConfigPath()
+	if err == nil && args[0] == "export" {
+		err = exportProfile(path, bundle)
+	} else if err == nil {
+		err = importProfile(path, bundle, *yes)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func exportProfile(path, bundle string) error {
+	cfg, exists, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("there is no %s to export yet", path)
+	}
+	var excluded []string
+	err = writeOutput(bundle, false, func(w io.Writer) error {
+		excluded, err = writeBundle(w, cfg, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var s strings.Builder
+	if bundle != "-" {
+		fmt.Fprintf(&s, "Exported %s to %s (no history, no secrets)\n", path, bundle)
+	}
+	writeExcluded(&s, excluded, "the config on each machine the bundle is imported on")
+	fmt.Fprint(os.Stderr, detectTerminal(os.Stderr).render(s.String()))
+	return nil
+}
+
+func importProfile(path, bundle string, yes bool) error {
+	var (
+		f   *os.File
+		err error
+	)
+	// The answer to "Apply?" is read from the terminal when the bundle
+	// itself comes in on stdin.
+	answers := os.Stdin
+	if bundle == "-" && !yes {
+		if answers, err = os.Open("/dev/tty"); err != nil {
+			return errors.New("the bundle is read from stdin and there's no terminal to confirm the import on; add --yes to apply it")
+		}
+		defer answers.Close()
+	}
+	if bundle == "-" {
+		f = os.Stdin
+	} else if f, err = os.Open(bundle); err != nil {
+		return err
+	}
+	manifest, incoming, err := readBundle(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", bundle, err)
+	}
+	current, exists, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "Bundle %s, exported %s\n\n", bundle, manifest.Created.Local().Format("2006-01-02 15:04"))
+	changes := diffConfigs(current, incoming)
+	writeImportPreview(&s, path, changes)
+	if len(changes) > 0 && exists {
+		fmt.Fprintf(&s, "The current file is kept as %s.bak.\n", path)
+	}
+	fmt.Fprint(os.Stdout, term.render(s.String()))
+	if len(changes) == 0 {
+		return nil
+	}
+	if !yes {
+		fmt.Print("Apply? [y/N] ")
+		answer, _ := bufio.NewReader(answers).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing changed.")
+			return nil
+		}
+	}
+
+	if exists {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// The backup holds the same sink tokens as the config, so it is
+		// no more readable than the config was.
+		if err := writeOutputMode(path+".bak", false, info.Mode().Perm(), func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if err := incoming.save(path); err != nil {
+		return err
+	}
+	s.Reset()
+	fmt.Fprintf(&s, "Imported into %s\n", path)
+	writeExcluded(&s, manifest.Excluded, path)
+	fmt.Print(term.render(s.String()))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func bundleConfig() config {
+	c := defaultConfig()
+	c.Units = "bytes"
+	c.PlanDownload = 500
+	c.Privacy.RedactIP = true
+	c.Sinks = []map[string]string{
+		{"type": "webhook", "url": "https://user:pw@hooks.example.com/in?key=abc", "token": "s3cret"},
+		{"type": "file", "path": "/var/lib/gofast/runs.json"},
+	}
+	return c
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	c := bundleConfig()
+	var buf bytes.Buffer
+	excluded, err := writeBundle(&buf, c, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, incoming, err := readBundle(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != bundleVersion || !slices.Equal(manifest.Excluded, excluded) {
+		t.Errorf("manifest = %+v, want version %d excluding %q", manifest, bundleVersion, excluded)
+	}
+	stripped, _ := stripSecrets(c)
+	var want, got strings.Builder
+	if err := stripped.write(&want); err != nil {
+		t.Fatal(err)
+	}
+	if err := incoming.write(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("imported config:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+	if strings.Contains(got.String(), "s3cret") || strings.Contains(got.String(), "key=abc") {
+		t.Errorf("a secret survived the round trip:\n%s", got.String())
+	}
+}
+
+func TestImportProfileKeepsBackupPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("units = \"bits\"\n\n[[sink]]\ntype = \"webhook\"\ntoken = \"s3cret\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "profile.tgz")
+	f, err := os.Create(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeBundle(f, bundleConfig(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := importProfile(path, bundle, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + ".bak"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", filepath.Base(p), got)
+		}
+	}
+}
+
+func TestImportFromStdinNeedsATerminalOrYes(t *testing.T) {
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		tty.Close()
+		t.Skip("a terminal is attached; the import would ask on it")
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := importProfile(path, "-", false)
+	if err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Fatalf("import from stdin without a terminal = %v, want an error asking for --yes", err)
+	}
+}
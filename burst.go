@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// warmupWindow is the start of a transfer left out of its sustained
+	// rate, while TCP ramps up and new connections open, and steadyWindow
+	// the sliding window of samples it is measured over.
+	warmupWindow = 2 * time.Second
+	// burstWindow is the start of a transfer checked for a speed boost
+	// against the steady window at its end.
+	burstWindow  = 2 * time.Second
+	steadyWindow = 3 * time.Second
+	// burstRatio is how far above the steady rate the start must run to
+	// count as a burst.
+	burstRatio = 1.3
+)
+
+// detectBurst looks for a connection that boosts its rate at first, as
+// cable and 5G links often do, and then settles lower. samples are rates
+// taken every interval; the mean over the first burst of them is compared
+// with the mean over the last steady. It reports false when the transfer
+// is too short to hold both windows apart or the start isn't burstRatio
+// above the end.
+func detectBurst(samples []float64, interval, burst, steady time.Duration) (burstMbps, sustainedMbps float64, ok bool) {
+	if interval <= 0 {
+		return 0, 0, false
+	}
+	head, tail := int(burst/interval), int(steady/interval)
+	if head < 1 || tail < 1 || len(samples) < head+tail {
+		return 0, 0, false
+	}
+	burstMbps, sustainedMbps = mean(samples[:head]), mean(samples[len(samples)-tail:])
+	if sustainedMbps <= 0 || burstMbps < sustainedMbps*burstRatio {
+		return 0, 0, false
+	}
+	return burstMbps, sustainedMbps, true
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// sustainedRate is the mean of samples, taken every interval, over the last
+// window of them once the first warmup is left out. A transfer shorter
+// than window past its warm-up is measured over what is left; one that
+// never got past its warm-up reports false.
+func sustainedRate(samples []float64, interval, warmup, window time.Duration) (float64, bool) {
+	if interval <= 0 {
+		return 0, false
+	}
+	skip := int(warmup / interval)
+	if len(samples) <= skip {
+		return 0, false
+	}
+	settled := samples[skip:]
+	n := max(int(window/interval), 1)
+	return mean(settled[max(len(settled)-n, 0):]), true
+}
+
+// settleRate makes the sustained rate t's headline, keeping the average over
+// the whole transfer beside it, and marks a burst at the start. A transfer
+// too short to get past its warm-up keeps the average as its headline.
+func settleRate(t *Transfer) {
+	t.AverageMbps = t.Mbps
+	interval := t.SampleInterval
+	if interval <= 0 {
+		interval = sampleInterval
+	}
+	sustained, ok := sustainedRate(t.Samples, interval, warmupWindow, steadyWindow)
+	if !ok {
+		return
+	}
+	t.SustainedMbps, t.Mbps, t.Settled = sustained, sustained, true
+	if burst, _, ok := detectBurst(t.Samples, interval, burstWindow, steadyWindow); ok {
+		t.BurstMbps = burst
+	}
+}
+
+// formatAverage is the completion screen's row with the whole-transfer
+// average next to the sustained headline, or a note that the transfer was
+// too short to leave its warm-up, in which case the average is the
+// headline.
+func formatAverage(t *Transfer) string {
+	switch {
+	case t == nil || t.AverageMbps == 0 || !t.Source.Available():
+		return ""
+	case !t.Settled:
+		return fmt.Sprintf("%s \033[90m(too short to get past the %s warm-up; the headline is this average)\033[0m", formatSpeed(t.AverageMbps), warmupWindow)
+	}
+	return fmt.Sprintf("%s \033[90m(over the whole transfer; the headline is the last %s, after a %s warm-up)\033[0m", formatSpeed(t.AverageMbps), steadyWindow, warmupWindow)
+}
+
+// formatBurst is the completion screen's row for a transfer that started
+// with a burst, or "" for one that didn't. It explains why the headline is
+// the lower figure.
+func formatBurst(t *Transfer) string {
+	if t == nil || t.BurstMbps == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s for the first %s, then %s sustained \033[90m(the link boosts new transfers briefly; the sustained speed is what long downloads get)\033[0m", formatSpeed(t.BurstMbps), burstWindow, formatSpeed(t.SustainedMbps))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func steady(mbps float64, n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = mbps
+	}
+	return s
+}
+
+func TestDetectBurst(t *testing.T) {
+	second := time.Second
+	tests := []struct {
+		name            string
+		samples         []float64
+		interval        time.Duration
+		burst, steady   time.Duration
+		ok              bool
+		burstMbps, tail float64
+	}{
+		{name: "shorter than both windows", samples: append(steady(300, 10), steady(100, 14)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+		{name: "just holds both windows", samples: append(steady(300, 10), steady(100, 15)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second, ok: true, burstMbps: 300, tail: 100},
+		{name: "flat", samples: steady(100, 50), interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+		{name: "burst then plateau", samples: append(steady(300, 10), steady(100, 40)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second, ok: true, burstMbps: 300, tail: 100},
+		{name: "at the burst ratio", samples: append(steady(130, 10), steady(100, 40)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second, ok: true, burstMbps: 130, tail: 100},
+		{name: "below the burst ratio", samples: append(steady(129, 10), steady(100, 40)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+		{name: "speeding up", samples: append(steady(100, 10), steady(300, 40)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+		{name: "stalled at the end", samples: append(steady(300, 10), steady(0, 40)...), interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+		{name: "short windows", samples: append(steady(300, 5), steady(200, 5)...), interval: sampleInterval, burst: second, steady: second, ok: true, burstMbps: 300, tail: 200},
+		{name: "short windows, too few samples", samples: steady(300, 9), interval: sampleInterval, burst: second, steady: second},
+		{name: "long windows at a coarser interval", samples: append(steady(300, 5), steady(100, 10)...), interval: second, burst: 5 * second, steady: 10 * second, ok: true, burstMbps: 300, tail: 100},
+		{name: "window under one sample", samples: append(steady(300, 10), steady(100, 40)...), interval: sampleInterval, burst: 100 * time.Millisecond, steady: 3 * second},
+		{name: "no interval", samples: append(steady(300, 10), steady(100, 40)...), burst: 2 * second, steady: 3 * second},
+		{name: "no samples", interval: sampleInterval, burst: 2 * second, steady: 3 * second},
+	}
+	for _, tt := range tests {
+		burst, tail, ok := detectBurst(tt.samples, tt.interval, tt.burst, tt.steady)
+		if ok != tt.ok || burst != tt.burstMbps || tail != tt.tail {
+			t.Errorf("%s: detectBurst = %g, %g, %v; want %g, %g, %v", tt.name, burst, tail, ok, tt.burstMbps, tt.tail, tt.ok)
+		}
+	}
+}
+
+func TestSettleRate(t *testing.T) {
+	warmup := int(warmupWindow / sampleInterval)
+	tests := []struct {
+		name      string
+		samples   []float64
+		average   float64
+		settled   bool
+		headline  float64
+		tooShort  bool
+		burstMbps float64
+	}{
+		{name: "no samples", average: 80, headline: 80, tooShort: true},
+		{name: "all warm-up", samples: steady(90, warmup), average: 85, headline: 85, tooShort: true},
+		{name: "one sample past warm-up", samples: append(steady(90, warmup), 60), average: 85, settled: true, headline: 60},
+		{name: "settled at zero", samples: append(steady(90, warmup), steady(0, 20)...), average: 30, settled: true, headline: 0},
+		{name: "burst then steady", samples: append(steady(300, warmup), steady(100, 20)...), average: 150, settled: true, headline: 100, burstMbps: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := Transfer{Mbps: tt.average, Samples: tt.samples, Source: provenanceMeasured}
+			settleRate(&tr)
+			if tr.Settled != tt.settled || tr.Mbps != tt.headline || tr.AverageMbps != tt.average {
+				t.Errorf("settled %v, headline %v, average %v; want %v, %v, %v", tr.Settled, tr.Mbps, tr.AverageMbps, tt.settled, tt.headline, tt.average)
+			}
+			if tr.BurstMbps != tt.burstMbps {
+				t.Errorf("burst = %v, want %v", tr.BurstMbps, tt.burstMbps)
+			}
+			if got := strings.Contains(formatAverage(&tr), "too short"); got != tt.tooShort {
+				t.Errorf("formatAverage = %q; too-short note %v, want %v", formatAverage(&tr), got, tt.tooShort)
+			}
+		})
+	}
+}
+
+func TestCombinedTransfersSettleTogether(t *testing.T) {
+	settled := Transfer{Mbps: 50, AverageMbps: 60, SustainedMbps: 50, Settled: true, Source: provenanceMeasured}
+	short := Transfer{Mbps: 20, AverageMbps: 20, Source: provenanceMeasured}
+	if c := combineTransfers([]Transfer{settled, settled}); !c.Settled || c.SustainedMbps != 100 {
+		t.Errorf("two settled families: settled %v, sustained %v; want true, 100", c.Settled, c.SustainedMbps)
+	}
+	if c := combineTransfers([]Transfer{settled, short}); c.Settled || c.SustainedMbps != 0 {
+		t.Errorf("one family in warm-up: settled %v, sustained %v; want false, 0", c.Settled, c.SustainedMbps)
+	}
+}
+
+// A transfer long enough to have its samples merged keeps its windows in
+// time, not in samples.
+func TestSettleRateMergedSamples(t *testing.T) {
+	var s sampleStore
+	burst := int(burstWindow / sampleInterval)
+	for i := range sampleRetention + 100 {
+		mbps := 100.0
+		if i < burst {
+			mbps = 300
+		}
+		s.Add(mbps)
+	}
+	tr := Transfer{Mbps: 100, Samples: s.Snapshot(), SampleInterval: s.Interval(sampleInterval)}
+	if tr.SampleInterval != 2*sampleInterval {
+		t.Fatalf("interval %v, want %v once merged", tr.SampleInterval, 2*sampleInterval)
+	}
+	settleRate(&tr)
+	if !tr.Settled || tr.Mbps != 100 || tr.BurstMbps != 300 {
+		t.Errorf("settled %v at %g, burst %g; want 100 after a burst of 300", tr.Settled, tr.Mbps, tr.BurstMbps)
+	}
+}
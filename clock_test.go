@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when waited on: After advances it
+// by the wait and fires at once, so paced code runs instantly and measures
+// exactly the time it asked for.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	ch <- now
+	return ch
+}
+
+func demoTransfers(t *testing.T, seed uint64) (Transfer, Transfer, time.Duration) {
+	t.Helper()
+	clock := newFakeClock()
+	p := demoProvider{clock: clock, rng: newRand(seed), duration: 2 * time.Second, noGeolocation: true}
+	start := clock.Now()
+	down, err := p.Download(context.Background(), &meter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := p.Upload(context.Background(), &meter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return down, up, clock.Since(start)
+}
+
+func TestDemoSeedReproducible(t *testing.T) {
+	down1, up1, _ := demoTransfers(t, 42)
+	down2, up2, _ := demoTransfers(t, 42)
+	if down1.Bytes != down2.Bytes || down1.Mbps != down2.Mbps {
+		t.Errorf("download differs between runs with one seed: %+v, %+v", down1, down2)
+	}
+	if up1.Bytes != up2.Bytes || up1.Mbps != up2.Mbps {
+		t.Errorf("upload differs between runs with one seed: %+v, %+v", up1, up2)
+	}
+	other, _, _ := demoTransfers(t, 43)
+	if other.Bytes == down1.Bytes {
+		t.Errorf("seeds 42 and 43 gave the same download of %d bytes", other.Bytes)
+	}
+}
+
+func TestDemoPacedByClock(t *testing.T) {
+	down, up, elapsed := demoTransfers(t, 1)
+	if down.Duration != 2*time.Second || up.Duration != 2*time.Second {
+		t.Errorf("transfers took %v and %v, want 2s each", down.Duration, up.Duration)
+	}
+	if elapsed != 4*time.Second {
+		t.Errorf("the clock moved %v, want 4s", elapsed)
+	}
+	if down.Mbps <= 0 || up.Mbps <= 0 {
+		t.Errorf("simulated rates %g and %g, want positive", down.Mbps, up.Mbps)
+	}
+}
+
+func TestDemoCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := demoProvider{clock: realClock{}, rng: newRand(1), duration: time.Hour}
+	if _, err := p.Download(ctx, &meter{}); err != context.Canceled {
+		t.Errorf("download of a cancelled run: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestModelUsesClock(t *testing.T) {
+	clock := newFakeClock()
+	e := engine{provider: demoProvider{clock: clock, rng: newRand(1)}, clock: clock}
+	m := initialModel(e, viewOptions{})
+	defer m.cancel()
+	m.phase = phaseDownloading
+	<-clock.After(1500 * time.Millisecond)
+	updated, _ := m.Update(speedMsg(80))
+	if got := updated.(speedTest).graph.series[seriesDownload]; len(got) != 1 || got[0].at != 1500*time.Millisecond {
+		t.Errorf("download sample plotted at %+v, want one at 1.5s", got)
+	}
+}
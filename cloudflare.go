@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// cloudflareBase serves speed.cloudflare.com's test endpoints: __down
+// returns as many bytes as asked for, __up accepts a POST body and
+// discards it, and the cf-meta headers of every response describe the data
+// centre that answered.
+const cloudflareBase = "https://speed.cloudflare.com"
+
+const (
+	// cloudflareChunk is the size of one download request. A stream asks
+	// for another as soon as one ends, until the phase is over, so it only
+	// bounds how much a request past the deadline wastes.
+	cloudflareChunk = 25 << 20
+	// cloudflareMinChunk is the smallest upload body tried when the
+	// server refuses large ones.
+	cloudflareMinChunk = 1 << 20
+	// cloudflareDuration is a transfer's length when the profile leaves
+	// it to the provider.
+	cloudflareDuration = 10 * time.Second
+	// cloudflareStreams is the number of parallel downloads when the
+	// profile doesn't say.
+	cloudflareStreams = 4
+)
+
+// cloudflareProvider measures real throughput against speed.cloudflare.com.
+type cloudflareProvider struct {
+	clock       Clock
+	base        string
+	duration    time.Duration
+	pingSamples int
+	ping        pingMethod
+	streams     int
+	// compressible sends zeros instead of random upload data.
+	compressible bool
+	// noGeolocation keeps the answering data centre's city out of the
+	// results.
+	noGeolocation bool
+}
+
+func newCloudflareProvider(opts providerOptions) Provider {
+	return cloudflareProvider{clock: opts.Clock, base: cloudflareBase, duration: opts.Duration, pingSamples: opts.PingSamples, ping: opts.Ping, streams: opts.Streams, compressible: opts.Compressible, noGeolocation: opts.NoGeolocation}
+}
+
+func (cloudflareProvider) Name() string { return "cloudflare" }
+
+func (cloudflareProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: true, LoadedLatency: true}
+}
+
+func (p cloudflareProvider) host() string {
+	if u, err := url.Parse(p.base); err == nil {
+		return u.Hostname()
+	}
+	return p.base
+}
+
+// Server asks for an empty payload and names the data centre that answered
+// it, which is the one the transfers will reach.
+func (p cloudflareProvider) Server(ctx context.Context) (Server, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.base+"/__down?bytes=0", nil)
+	if err != nil {
+		return Server{}, err
+	}
+	resp, err := (&http.Client{Transport: httpTransport()}).Do(req)
+	if err != nil {
+		return Server{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		return Server{}, fmt.Errorf("%s answered %s", p.host(), resp.Status)
+	}
+
+	s := Server{Name: "Cloudflare", Host: p.host(), URL: p.base}
+	if colo := resp.Header.Get("cf-meta-colo"); colo != "" {
+		s.Name += " " + colo
+	}
+	if p.noGeolocation {
+		return s, nil
+	}
+	s.Location, s.Country = resp.Header.Get("cf-meta-city"), resp.Header.Get("cf-meta-country")
+	s.LocationSource = provenanceMeasured
+	if s.Location == "" {
+		s.LocationSource = provenanceUnavailable
+	}
+	return s, nil
+}
+
+func (p cloudflareProvider) Ping(ctx context.Context) (Latency, error) {
+	return pingLatency(ctx, p.ping, p.host(), p.pingSamples)
+}
+
+func (p cloudflareProvider) Probe(ctx context.Context) (float64, error) {
+	return p.ping.Ping(ctx, p.host())
+}
+
+func (p cloudflareProvider) TraceRequest(ctx context.Context) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, p.base+"/__down?bytes=0", nil)
+}
+
+// Download reads __down payloads over parallel streams, adding every
+// payload byte to m as it arrives.
+func (p cloudflareProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error { return p.fetch(ctx, client, m) })
+}
+
+// DownloadFamily is Download with every stream pinned to one address
+// family, for --dual-stack.
+func (p cloudflareProvider) DownloadFamily(ctx context.Context, network string, m *meter) (Transfer, error) {
+	client := newFamilyClient(m, network)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error { return p.fetch(ctx, client, m) })
+}
+
+// Upload POSTs generated payload to __up over parallel streams until the
+// phase's duration is up, counting bytes into m as the transport reads
+// them for sending. When the server refuses a body for its size, every
+// stream goes on with bodies half as large, down to cloudflareMinChunk.
+func (p cloudflareProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	client := newTransferClient(m)
+	var chunk atomic.Int64
+	chunk.Store(cloudflareChunk)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		size := chunk.Load()
+		err := p.post(ctx, client, m, size)
+		if errors.Is(err, errBodyTooLarge) && size > cloudflareMinChunk {
+			chunk.CompareAndSwap(size, max(size/2, cloudflareMinChunk))
+			return nil
+		}
+		return err
+	})
+}
+
+// streamed runs request on every stream until the phase's duration is up,
+// with the provider's defaults for what the profile leaves open.
+func (p cloudflareProvider) streamed(ctx context.Context, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	duration := p.duration
+	if duration <= 0 {
+		duration = cloudflareDuration
+	}
+	streams := p.streams
+	if streams <= 0 {
+		streams = cloudflareStreams
+	}
+	return runStreams(ctx, p.clock, duration, streams, m, client, request)
+}
+
+// fetch downloads one chunk, counting it into m as it is read.
+func (p cloudflareProvider) fetch(ctx context.Context, client *http.Client, m *meter) error {
+	u := fmt.Sprintf("%s/__down?bytes=%d", p.base, cloudflareChunk)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	record := transferRecord{URL: u, Offset: -1, Began: p.clock.Now()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", p.host(), resp.Status)
+	}
+	record.Expected = resp.ContentLength
+	return readCounted(ctx, p.clock, resp, m, record)
+}
+
+// errBodyTooLarge reports an upload body the server refused for its size.
+var errBodyTooLarge = errors.New("upload body too large")
+
+// post sends one body of size bytes, counting it into m as it is read for
+// sending.
+func (p cloudflareProvider) post(ctx context.Context, client *http.Client, m *meter, size int64) error {
+	body := &meteredReader{r: uploadBody(size, p.compressible), m: m}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.base+"/__up", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: %s refused %s", errBodyTooLarge, p.host(), formatBytes(size))
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s answered %s", p.host(), resp.Status)
+	}
+	return nil
+}
+
+// meteredReader adds every byte read from r to m.
+type meteredReader struct {
+	r io.Reader
+	m *meter
+}
+
+func (r *meteredReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.m.Add(int64(n))
+	return n, err
+}
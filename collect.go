@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collectPath is where a collector accepts and serves results.
+const collectPath = "/results"
+
+// collectBodyLimit bounds one posted payload. A run with --include-samples
+// is the largest there is and stays well under it.
+const collectBodyLimit = 8 << 20
+
+// collectTokenEnv holds the collector's token when --token isn't given, to
+// keep it out of process listings.
+const collectTokenEnv = "GOFAST_COLLECT_TOKEN"
+
+// siteLabel is what a site may be called: short, and safe in a URL and a
+// table column.
+var siteLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// collector receives the results other gofast instances post with a
+// webhook sink and stores them in a history store, labelled by site. A run
+// is stored once per (site, timestamp), so a sink that retries after a lost
+// reply doesn't duplicate it.
+type collector struct {
+	store historyStore
+	token string
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func collectKey(site string, at time.Time) string {
+	return site + "\x00" + at.UTC().Format(time.RFC3339Nano)
+}
+
+// newCollector remembers the runs already in the store. Only the most
+// recent historyLoadLimit are checked for duplicates, which covers any
+// retry.
+func newCollector(store historyStore, token string) (*collector, error) {
+	runs, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	c := &collector{store: store, token: token, seen: make(map[string]bool)}
+	for _, r := range runs {
+		if r.Site != "" {
+			c.seen[collectKey(r.Site, r.Timestamp)] = true
+		}
+	}
+	return c, nil
+}
+
+// validatePayload checks a posted run before it is stored. Newer schema
+// versions are refused rather than stored with fields this version would
+// drop.
+func validatePayload(r Results) error {
+	switch {
+	case r.SchemaVersion < 1 || r.SchemaVersion > SchemaVersion:
+		return fmt.Errorf("unsupported schema_version %d (this collector accepts 1 to %d)", r.SchemaVersion, SchemaVersion)
+	case !siteLabel.MatchString(r.Site):
+		return fmt.Errorf("site %q must be 1 to 64 letters, digits, dots, dashes or underscores", r.Site)
+	case r.Timestamp.IsZero():
+		return errors.New("timestamp is missing")
+	case r.Provider == "":
+		return errors.New("provider is missing")
+	}
+	for _, t := range []struct {
+		name     string
+		transfer *Transfer
+	}{{"download", r.Download}, {"upload", r.Upload}} {
+		if t.transfer != nil && (t.transfer.Mbps < 0 || t.transfer.Bytes < 0) {
+			return fmt.Errorf("%s has a negative speed or size", t.name)
+		}
+	}
+	return nil
+}
+
+func (c *collector) authorized(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != collectPath {
+		http.NotFound(w, req)
+		return
+	}
+	if !c.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gofast"`)
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		c.receive(w, req)
+	case http.MethodGet:
+		c.list(w, req)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// receive stores one posted run. The site is the payload's, or else the
+// X-Gofast-Site header's.
+func (c *collector) receive(w http.ResponseWriter, req *http.Request) {
+	var r Results
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, collectBodyLimit)).Decode(&r); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Site == "" {
+		r.Site = req.Header.Get("X-Gofast-Site")
+	}
+	if err := validatePayload(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	key := collectKey(r.Site, r.Timestamp)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[key] {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "already stored")
+		return
+	}
+	if err := c.store.Append(r); err != nil {
+		http.Error(w, "couldn't store the run: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.seen[key] = true
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "stored")
+}
+
+// list serves the combined history as a JSON array, oldest first,
+// optionally for one ?site= and only the most recent ?limit= runs.
+func (c *collector) list(w http.ResponseWriter, req *http.Request) {
+	runs, err := c.store.Load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	runs = filterSite(runs, req.URL.Query().Get("site"))
+	if v := req.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		runs = runs[max(len(runs)-limit, 0):]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]Results{}, runs...))
+}
+
+// filterSite keeps the runs of one site; an empty site keeps all of them
+// and "local" keeps the runs made on this machine.
+func filterSite(runs []Results, site string) []Results {
+	if site == "" {
+		return runs
+	}
+	if site == "local" {
+		site = ""
+	}
+	var kept []Results
+	for _, r := range runs {
+		if r.Site == site {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// runCollect implements "gofast collect": a receiver for the webhook sinks
+// of gofast instances on other sites.
+func runCollect(args []string) {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	listen := fs.String("listen", ":9900", "address to accept results on")
+	token := fs.String("token", "", "shared token senders authenticate with (default $"+collectTokenEnv+")")
+	historyPath := fs.String("history", "", "history store to add the results to (default the user's)")
+	fs.Parse(args)
+
+	if *token == "" {
+		*token = os.Getenv(collectTokenEnv)
+	}
+	if *token == "" {
+		fmt.Fprintf(os.Stderr, "Error: a token is required (--token or $%s)\n", collectTokenEnv)
+		os.Exit(2)
+	}
+	if *historyPath == "" {
+		path, err := defaultHistoryPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*historyPath = path
+	}
+	c, err := newCollector(historyStore{path: *historyPath}, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: c, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(os.Stderr, "Collecting results on %s%s into %s\n", *listen, collectPath, *historyPath)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
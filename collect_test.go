@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const collectTestToken = "s3cret"
+
+// sitePayload is a run as a gofast on another site would post it.
+func sitePayload(site string, at time.Time, mbps float64) Results {
+	return Results{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     at,
+		Provider:      "cloudflare",
+		Site:          site,
+		Download:      &Transfer{Mbps: mbps, Bytes: int64(mbps * 1e6 / 8 * 10), Duration: 10 * time.Second},
+	}
+}
+
+func newTestCollector(t *testing.T) (*httptest.Server, historyStore) {
+	t.Helper()
+	store := historyStore{path: filepath.Join(t.TempDir(), "history.jsonl")}
+	c, err := newCollector(store, collectTestToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func postPayload(t *testing.T, url, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(msg))
+}
+
+func listCollected(t *testing.T, url string) []Results {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+collectTestToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	var runs []Results
+	if err := json.NewDecoder(resp.Body).Decode(&runs); err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
+
+func TestCollectFromSites(t *testing.T) {
+	srv, store := newTestCollector(t)
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	sites := []struct {
+		name string
+		mbps float64
+	}{{"berlin", 940}, {"lisbon", 95}, {"paris", 480}}
+
+	for i, site := range sites {
+		sink, err := newWebhookSink(map[string]string{"url": srv.URL + collectPath, "site": site.name, "token": collectTestToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The sink labels the run, so the payload carries no site of its own.
+		r := sitePayload("", at.Add(time.Duration(i)*time.Minute), site.mbps)
+		if err := sink.Write(context.Background(), r); err != nil {
+			t.Fatalf("%s: %v", site.name, err)
+		}
+	}
+	// Two sites may finish a run at the same instant.
+	body, _ := json.Marshal(sitePayload("lisbon-2", at, 50))
+	if code, msg := postPayload(t, srv.URL+collectPath, collectTestToken, string(body)); code != http.StatusCreated {
+		t.Errorf("same timestamp from another site: %d %s", code, msg)
+	}
+
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 4 {
+		t.Fatalf("stored %d runs, want 4", len(stored))
+	}
+	for i, site := range sites {
+		if stored[i].Site != site.name || stored[i].Download.Mbps != site.mbps {
+			t.Errorf("run %d stored as %s at %g Mbps, want %s at %g", i, stored[i].Site, stored[i].Download.Mbps, site.name, site.mbps)
+		}
+	}
+
+	if got := listCollected(t, srv.URL+collectPath); len(got) != 4 {
+		t.Errorf("GET served %d runs, want 4", len(got))
+	}
+	if got := listCollected(t, srv.URL+collectPath+"?site=lisbon"); len(got) != 1 || got[0].Site != "lisbon" {
+		t.Errorf("?site=lisbon served %+v", got)
+	}
+	if got := listCollected(t, srv.URL+collectPath+"?site=local"); len(got) != 0 {
+		t.Errorf("?site=local served %d collected runs", len(got))
+	}
+	if got := listCollected(t, srv.URL+collectPath+"?limit=2"); len(got) != 2 || got[1].Site != "lisbon-2" {
+		t.Errorf("?limit=2 served %+v, want the two most recent", got)
+	}
+}
+
+func TestCollectDeduplicates(t *testing.T) {
+	srv, store := newTestCollector(t)
+	body, _ := json.Marshal(sitePayload("berlin", time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC), 940))
+
+	if code, msg := postPayload(t, srv.URL+collectPath, collectTestToken, string(body)); code != http.StatusCreated || msg != "stored" {
+		t.Fatalf("first post: %d %s", code, msg)
+	}
+	if code, msg := postPayload(t, srv.URL+collectPath, collectTestToken, string(body)); code != http.StatusOK || msg != "already stored" {
+		t.Errorf("retried post: %d %s, want 200 already stored", code, msg)
+	}
+
+	// A restarted collector remembers what the store already holds.
+	c, err := newCollector(store, collectTestToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := httptest.NewServer(c)
+	defer restarted.Close()
+	if code, msg := postPayload(t, restarted.URL+collectPath, collectTestToken, string(body)); code != http.StatusOK {
+		t.Errorf("post after restart: %d %s, want 200", code, msg)
+	}
+	if runs, _ := store.Load(); len(runs) != 1 {
+		t.Errorf("stored %d runs, want 1", len(runs))
+	}
+}
+
+func TestCollectRejects(t *testing.T) {
+	at := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	payload := func(edit func(*Results)) string {
+		r := sitePayload("paris", at, 480)
+		edit(&r)
+		body, _ := json.Marshal(r)
+		return string(body)
+	}
+	valid := payload(func(*Results) {})
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"missing token", "", valid, http.StatusUnauthorized},
+		{"wrong token", "guess", valid, http.StatusUnauthorized},
+		{"bad JSON", collectTestToken, `{"schema_version":`, http.StatusBadRequest},
+		{"newer schema", collectTestToken, payload(func(r *Results) { r.SchemaVersion = 9 }), http.StatusUnprocessableEntity},
+		{"no schema", collectTestToken, payload(func(r *Results) { r.SchemaVersion = 0 }), http.StatusUnprocessableEntity},
+		{"missing site", collectTestToken, payload(func(r *Results) { r.Site = "" }), http.StatusUnprocessableEntity},
+		{"site with a slash", collectTestToken, payload(func(r *Results) { r.Site = "eu/paris" }), http.StatusUnprocessableEntity},
+		{"missing timestamp", collectTestToken, payload(func(r *Results) { r.Timestamp = time.Time{} }), http.StatusUnprocessableEntity},
+		{"missing provider", collectTestToken, payload(func(r *Results) { r.Provider = "" }), http.StatusUnprocessableEntity},
+		{"negative speed", collectTestToken, payload(func(r *Results) { r.Download.Mbps = -1 }), http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, store := newTestCollector(t)
+			if code, msg := postPayload(t, srv.URL+collectPath, tt.token, tt.body); code != tt.want {
+				t.Errorf("got %d %s, want %d", code, msg, tt.want)
+			}
+			if runs, _ := store.Load(); len(runs) != 0 {
+				t.Errorf("a rejected payload was stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// metricDelta is one headline figure of two runs. AOK and BOK are false
+// for a run that lacks it.
+type metricDelta struct {
+	Name     string
+	A, B     float64
+	AOK, BOK bool
+	// LowerIsBetter marks latencies and loss, whose rise is a regression.
+	LowerIsBetter bool
+	format        func(float64) string
+}
+
+// change is how B differs from A, e.g. "+12.3 Mbps (+15%)", colored by
+// whether it is better or worse.
+func (d metricDelta) change() string {
+	if !d.AOK || !d.BOK {
+		return "n/a"
+	}
+	diff := d.B - d.A
+	text := d.format(math.Abs(diff))
+	switch {
+	case diff > 0:
+		text = "+" + text
+	case diff < 0:
+		text = "-" + text
+	}
+	if d.A != 0 {
+		text += fmt.Sprintf(" (%+.0f%%)", diff/d.A*100)
+	}
+	// A change under 1% is noise either way.
+	if d.A == 0 || math.Abs(diff/d.A) < 0.01 {
+		return text
+	}
+	if (diff > 0) != d.LowerIsBetter {
+		return cues.Better.mark(text)
+	}
+	return cues.Worse.mark(text)
+}
+
+// runComparison is two stored runs set against each other, shared by
+// `gofast history diff` and the history view's comparison.
+type runComparison struct {
+	A, B    Results
+	Metrics []metricDelta
+	// Meta are the descriptive fields that differ, as label, A, B.
+	Meta [][3]string
+}
+
+// headlineMetric is a figure runs are compared by; pick reports false for
+// a run that lacks it.
+type headlineMetric struct {
+	name          string
+	lowerIsBetter bool
+	format        func(float64) string
+	pick          func(Results) (float64, bool)
+}
+
+func formatMs(v float64) string      { return fmt.Sprintf("%.1f ms", v) }
+func formatPercent(v float64) string { return fmt.Sprintf("%.1f%%", v) }
+
+var headlineMetrics = []headlineMetric{
+	{"Download", false, formatSpeed, func(r Results) (float64, bool) { return transferMbps(r.Download), r.Download != nil }},
+	{"Upload", false, formatSpeed, func(r Results) (float64, bool) { return transferMbps(r.Upload), r.Upload != nil }},
+	{"Ping", true, formatMs, func(r Results) (float64, bool) { return r.Latency.P50, r.Latency.Source.Available() }},
+	{"Jitter", true, formatMs, func(r Results) (float64, bool) { return r.Latency.Jitter, r.Latency.Source.Available() }},
+	{"Under load", true, formatMs, func(r Results) (float64, bool) {
+		if r.LoadedLatency == nil {
+			return 0, false
+		}
+		return r.LoadedLatency.Avg, true
+	}},
+	{"Loss", true, formatPercent, func(r Results) (float64, bool) {
+		if r.Loss == nil {
+			return 0, false
+		}
+		return *r.Loss, true
+	}},
+}
+
+func compareRuns(a, b Results) runComparison {
+	c := runComparison{A: a, B: b}
+	for _, m := range headlineMetrics {
+		va, aok := m.pick(a)
+		vb, bok := m.pick(b)
+		c.Metrics = append(c.Metrics, metricDelta{Name: m.name, A: va, B: vb, AOK: aok, BOK: bok, LowerIsBetter: m.lowerIsBetter, format: m.format})
+	}
+
+	for _, f := range []struct {
+		label string
+		pick  func(Results) string
+	}{
+		{"Server", func(r Results) string { return r.Server.Label() }},
+		{"Provider", func(r Results) string { return r.Provider }},
+		{"Profile", func(r Results) string { return r.Profile }},
+		{"Interface", func(r Results) string { return cmp.Or(r.Interface, envValue(r.Environment, "Interface")) }},
+		{"Site", func(r Results) string { return r.Site }},
+		{"Note", func(r Results) string { return r.Note }},
+	} {
+		if va, vb := f.pick(a), f.pick(b); va != vb {
+			c.Meta = append(c.Meta, [3]string{f.label, orNone(va), orNone(vb)})
+		}
+	}
+	return c
+}
+
+func envValue(items []EnvItem, name string) string {
+	for _, item := range items {
+		if item.Name == name {
+			return item.Value
+		}
+	}
+	return ""
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "—"
+	}
+	return s
+}
+
+// Glyphs of the two runs on an overlay graph, and of a cell both fall in.
+const (
+	overlayA    = "\033[36m●\033[0m"
+	overlayB    = "\033[35m◆\033[0m"
+	overlayBoth = "\033[1m▓\033[0m"
+)
+
+// overlayGraph plots two series against a shared scale, each stretched
+// to width columns so runs of different lengths line up from start to
+// end.
+func overlayGraph(a, b []float64, width, height int, format func(float64) string) string {
+	maxValue := 0.0
+	for _, v := range append(append([]float64{}, a...), b...) {
+		maxValue = math.Max(maxValue, v)
+	}
+	if maxValue == 0 || width < 2 || height < 1 {
+		return "\033[90mno samples to plot\033[0m\n"
+	}
+	row := func(series []float64, x int) int {
+		if len(series) == 0 {
+			return -1
+		}
+		v := series[min(x*len(series)/width, len(series)-1)]
+		return min(int(v/maxValue*float64(height-1)+0.5), height-1)
+	}
+	label := format(maxValue)
+	pad := len(label)
+	var s strings.Builder
+	for y := height - 1; y >= 0; y-- {
+		switch y {
+		case height - 1:
+			fmt.Fprintf(&s, "\033[90m%*s ┤\033[0m", pad, label)
+		case 0:
+			fmt.Fprintf(&s, "\033[90m%*s ┤\033[0m", pad, "0")
+		default:
+			fmt.Fprintf(&s, "\033[90m%*s │\033[0m", pad, "")
+		}
+		for x := range width {
+			ya, yb := row(a, x), row(b, x)
+			switch {
+			case ya == y && yb == y:
+				s.WriteString(overlayBoth)
+			case ya == y:
+				s.WriteString(overlayA)
+			case yb == y:
+				s.WriteString(overlayB)
+			default:
+				s.WriteString(" ")
+			}
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+// graphs are the overlaid throughput samples of both runs.
+func (c runComparison) graphs(width int) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s A  %s B  %s both\n", overlayA, overlayB, overlayBoth)
+	for _, dir := range []struct {
+		name string
+		pick func(Results) *Transfer
+	}{{"Download", func(r Results) *Transfer { return r.Download }}, {"Upload", func(r Results) *Transfer { return r.Upload }}} {
+		ta, tb := dir.pick(c.A), dir.pick(c.B)
+		if ta == nil && tb == nil {
+			continue
+		}
+		var a, b []float64
+		if ta != nil {
+			a = ta.Samples
+		}
+		if tb != nil {
+			b = tb.Samples
+		}
+		fmt.Fprintf(&s, "\n\033[1m%s\033[0m\n", dir.name)
+		s.WriteString(overlayGraph(a, b, width, 6, formatSpeed))
+	}
+	return s.String()
+}
+
+// deltas lists each metric for both runs and how B differs from A.
+func (c runComparison) deltas() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%-12s %12s %12s  %s\n", "", "A", "B", "Δ")
+	for _, d := range c.Metrics {
+		va, vb := "n/a", "n/a"
+		if d.AOK {
+			va = d.format(d.A)
+		}
+		if d.BOK {
+			vb = d.format(d.B)
+		}
+		fmt.Fprintf(&s, "%-12s %12s %12s  %s\n", d.Name, va, vb, d.change())
+	}
+	return s.String()
+}
+
+// meta lists the descriptive fields that differ, or says none do.
+func (c runComparison) meta(width int) string {
+	if len(c.Meta) == 0 {
+		return "\033[90mSame server, provider, profile, interface and note\033[0m\n"
+	}
+	col := max((width-14)/2-2, 12)
+	var s strings.Builder
+	for _, m := range c.Meta {
+		fmt.Fprintf(&s, "%-12s %-*s → %s\n", m[0], col, truncate(m[1], col), truncate(m[2], col))
+	}
+	return s.String()
+}
+
+// writeComparison prints a comparison for `gofast history diff`.
+func writeComparison(w io.Writer, c runComparison, width int) {
+	fmt.Fprintf(w, "A  %s  %s\nB  %s  %s\n\n", runID(c.A), c.A.Server.Label(), runID(c.B), c.B.Server.Label())
+	fmt.Fprint(w, c.deltas())
+	fmt.Fprintln(w)
+	fmt.Fprint(w, c.meta(width))
+	fmt.Fprintln(w)
+	fmt.Fprint(w, c.graphs(width-14))
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the preferences stored in config.toml. Flags given on the
+// command line override it.
+type config struct {
+	// Units is "bits" for Mbps readouts or "bytes" for MB/s.
+	Units   string
+	History bool
+	// PlanDownload and PlanUpload are the contracted speeds in Mbps, zero
+	// when unknown. The file may give them with a unit suffix.
+	PlanDownload float64
+	PlanUpload   float64
+	// PlanCost is the plan's monthly price, zero when unknown, in
+	// Currency, an ISO 4217 code or "" for the locale's.
+	PlanCost float64
+	Currency string
+	// Metered connections default to the quick profile.
+	Metered bool
+	// AutoSave writes settings changed in the TUI straight back to the
+	// file instead of waiting for "Save as default".
+	AutoSave bool
+	// RetestOnOutlier runs one confirmation test when a result's download
+	// is more than OutlierFactor times away from recent runs.
+	RetestOnOutlier bool
+	OutlierFactor   float64
+	// PercentOf is the baseline the gauges' percent mode measures against:
+	// "plan", "median" of recent runs, or "auto" for the plan when set.
+	PercentOf string
+	// StickyServer keeps scheduled runs on one server, chosen afresh after
+	// StickyReevaluate or when its latency grows past StickyDegrade times
+	// what it was.
+	StickyServer     bool
+	StickyReevaluate time.Duration
+	StickyDegrade    float64
+	// WebCheck times a fresh connection to each of WebDestinations after
+	// every test; none means defaultWebDestinations.
+	WebCheck        bool
+	WebDestinations []string
+	// SyntheticBaselines counts runs from gofast history seed towards the
+	// baselines results are judged against.
+	SyntheticBaselines bool
+	// Privacy is the [privacy] table.
+	Privacy privacy
+	// Sinks are the [[sink]] tables, each with a type and its options.
+	Sinks []map[string]string
+
+	// bare lists the speeds given without a unit.
+	bare []bareSpeed
+	// modTime is when the file was last read or written by gofast, zero
+	// when it didn't exist.
+	modTime time.Time
+}
+
+func defaultConfig() config {
+	return config{Units: "bits", History: true, OutlierFactor: defaultOutlierFactor, PercentOf: percentAuto,
+		StickyServer: true, StickyReevaluate: defaultStickyPolicy.Reevaluate, StickyDegrade: defaultStickyPolicy.Degrade}
+}
+
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofast", "config.toml"), nil
+}
+
+// loadConfig reads the config file, reporting whether it exists. Only flat
+// key = value pairs and [[sink]] tables are understood; unknown keys and
+// tables are ignored.
+func loadConfig(path string) (config, bool, error) {
+	c := defaultConfig()
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, err
+	}
+	defer f.Close()
+	c, err = parseConfig(f, path)
+	if info, statErr := f.Stat(); statErr == nil {
+		c.modTime = info.ModTime()
+	}
+	return c, true, err
+}
+
+// parseConfig reads a config from r; name is what errors call it.
+func parseConfig(r io.Reader, name string) (config, error) {
+	c := defaultConfig()
+	scanner := bufio.NewScanner(r)
+	section := ""
+	for line := 1; scanner.Scan(); line++ {
+		if text := strings.TrimSpace(scanner.Text()); strings.HasPrefix(text, "[") {
+			section = text
+			if section == "[[sink]]" {
+				c.Sinks = append(c.Sinks, map[string]string{})
+			}
+			continue
+		}
+		key, value, ok := parseConfigLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch section {
+		case "":
+			if err := c.set(key, value); err != nil {
+				return c, fmt.Errorf("%s:%d: %w", name, line, err)
+			}
+		case "[[sink]]":
+			c.Sinks[len(c.Sinks)-1][key] = value
+		case "[privacy]":
+			on, err := strconv.ParseBool(value)
+			if err == nil && !c.Privacy.set(key, on) {
+				err = errors.New("unknown privacy switch")
+			}
+			if err != nil {
+				return c, fmt.Errorf("%s:%d: %s: %w", name, line, key, err)
+			}
+		}
+	}
+	return c, scanner.Err()
+}
+
+// parseConfigLine splits a "key = value" line, dropping comments and
+// surrounding quotes. Blank lines, comments and tables report false.
+func parseConfigLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if prefix, err := strconv.QuotedPrefix(value); err == nil {
+		unquoted, _ := strconv.Unquote(prefix)
+		return key, unquoted, true
+	}
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return key, value, true
+}
+
+func (c *config) set(key, value string) error {
+	var err error
+	switch key {
+	case "units":
+		if value != "bits" && value != "bytes" {
+			return fmt.Errorf("units must be \"bits\" or \"bytes\", not %q", value)
+		}
+		c.Units = value
+	case "history":
+		c.History, err = strconv.ParseBool(value)
+	case "plan_download_mbps":
+		c.PlanDownload, err = c.setSpeed(key, value, false)
+	case "plan_upload_mbps":
+		c.PlanUpload, err = c.setSpeed(key, value, true)
+	case "plan_cost":
+		if c.PlanCost, err = strconv.ParseFloat(value, 64); err == nil && c.PlanCost < 0 {
+			err = errors.New("must not be negative")
+		}
+	case "currency":
+		if value != "" && !currencyCode(value) {
+			return fmt.Errorf("currency must be an ISO 4217 code such as \"USD\", or \"\" for the locale's, not %q", value)
+		}
+		c.Currency = strings.ToUpper(value)
+	case "metered":
+		c.Metered, err = strconv.ParseBool(value)
+	case "autosave":
+		c.AutoSave, err = strconv.ParseBool(value)
+	case "retest_on_outlier":
+		c.RetestOnOutlier, err = strconv.ParseBool(value)
+	case "percent_of":
+		if value != percentAuto && value != percentPlan && value != percentMedian {
+			return fmt.Errorf("percent_of must be \"auto\", \"plan\" or \"median\", not %q", value)
+		}
+		c.PercentOf = value
+	case "sticky_server":
+		c.StickyServer, err = strconv.ParseBool(value)
+	case "sticky_reevaluate":
+		if c.StickyReevaluate, err = time.ParseDuration(value); err == nil && c.StickyReevaluate < 0 {
+			err = errors.New("must not be negative")
+		}
+	case "sticky_degrade":
+		if c.StickyDegrade, err = strconv.ParseFloat(value, 64); err == nil && c.StickyDegrade != 0 && c.StickyDegrade <= 1 {
+			err = errors.New("must be greater than 1, or 0 to ignore latency")
+		}
+	case "web_check":
+		c.WebCheck, err = strconv.ParseBool(value)
+	case "web_destinations":
+		c.WebDestinations, err = parseWebDestinations(value)
+	case "synthetic_baselines":
+		c.SyntheticBaselines, err = strconv.ParseBool(value)
+	case "outlier_factor":
+		if c.OutlierFactor, err = strconv.ParseFloat(value, 64); err == nil && c.OutlierFactor <= 1 {
+			err = errors.New("must be greater than 1")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *config) setSpeed(key, value string, upload bool) (float64, error) {
+	mbps, bare, err := parseSpeed(value)
+	if bare {
+		c.bare = append(c.bare, bareSpeed{Name: key, Mbps: mbps, Upload: upload})
+	}
+	return mbps, err
+}
+
+// save writes the config atomically, creating its directory.
+func (c config) save(path string) error {
+	return writeOutput(path, true, c.write)
+}
+
+// write writes the config as config.toml, with comments on every key.
+func (c config) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `# gofast preferences. Command-line flags override these.
+
+# "bits" shows speeds in Mbps, "bytes" in MB/s.
+units = %q
+# Store every run and compare new results against recent ones.
+history = %t
+# Contracted plan speeds; 0 if unknown. Bare numbers are Mbps, or add a
+# unit such as "62.5MB/s" or "1Gbps".
+plan_download_mbps = %g
+plan_upload_mbps = %g
+# Monthly price of the plan, for the cost per delivered Mbps; 0 if
+# unknown. currency is an ISO 4217 code such as "INR"; "" takes it from
+# the locale.
+plan_cost = %g
+currency = %q
+# Default to the quick profile to save data on metered connections.
+metered = %t
+# Save settings changed in the TUI, such as units, without asking.
+autosave = %t
+# Run one confirmation test when the download is more than outlier_factor
+# times away from recent runs, either way. Never for quick or metered runs.
+retest_on_outlier = %t
+outlier_factor = %g
+# What 'b' shows speeds as a percentage of: "plan", "median" of recent
+# runs, or "auto" for the plan when it is set.
+percent_of = %q
+# Keep scheduled runs (gofast soak) on one server so they stay comparable.
+# It is chosen afresh after sticky_reevaluate, or sooner when its latency
+# grows past sticky_degrade times what it was; 0 disables either.
+sticky_server = %t
+sticky_reevaluate = %q
+sticky_degrade = %g
+# After the test, time how long a few sites take to answer a new
+# connection, reported as web responsiveness. The URLs never leave this
+# machine except as the requests themselves; "" uses a few major sites'
+# icons.
+web_check = %t
+web_destinations = %q
+# Count synthetic runs from gofast history seed towards the baselines that
+# flag slow runs and outliers, and towards percent mode.
+synthetic_baselines = %t
+
+# What results may reveal: on screen, in every output format, in history
+# and in what sinks send.
+[privacy]
+redact_ip = %t       # the public IP address
+country_only = %t    # locations only to the country, and no distances
+omit_hostname = %t   # this machine's hostname
+omit_isp = %t        # the ISP and ASN
+no_geolocation = %t  # never look the location up; servers by latency alone
+
+# Results can also be sent to sinks after every run, e.g.
+#
+#   [[sink]]
+#   type = "file"      # or "webhook" with url = "...", plus site = "..."
+#                      # and token = "..." for a gofast collect receiver
+#   path = "/var/lib/gofast/%%Y%%m%%d.json"
+#   timeout = "10s"
+`, c.Units, c.History, c.PlanDownload, c.PlanUpload, c.PlanCost, c.Currency, c.Metered, c.AutoSave, c.RetestOnOutlier, c.OutlierFactor, c.PercentOf, c.StickyServer, c.StickyReevaluate.String(), c.StickyDegrade,
+		c.WebCheck, strings.Join(c.WebDestinations, ", "), c.SyntheticBaselines,
+		c.Privacy.RedactIP, c.Privacy.CountryOnly, c.Privacy.OmitHostname, c.Privacy.OmitISP, c.Privacy.NoGeolocation)
+	if err != nil {
+		return err
+	}
+	for _, sink := range c.Sinks {
+		keys := make([]string, 0, len(sink))
+		for key := range sink {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "\n[[sink]]\n")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s = %q\n", key, sink[key])
+		}
+	}
+	return nil
+}
+
+// errConfigChanged reports that the file was modified by something else
+// since gofast read it.
+var errConfigChanged = errors.New("changed on disk since gofast read it")
+
+// update writes the given keys, already formatted as TOML values, back to
+// the file. Everything else in it, comments and unknown keys included, is
+// kept as it was; keys the file lacks are added before its first table.
+// Unless force is set, a file modified since it was read isn't touched.
+func (c *config) update(path string, values map[string]string, force bool) error {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := c.save(path); err != nil {
+			return err
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !force:
+		if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(c.modTime) {
+			return fmt.Errorf("%s %w", path, errConfigChanged)
+		}
+	}
+
+	lines := patchConfig(strings.SplitAfter(string(data), "\n"), values)
+	err = writeOutput(path, true, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, ""))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		c.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// patchConfig replaces the values of top-level keys in lines, each of which
+// keeps its newline, preserving indentation and trailing comments.
+func patchConfig(lines []string, values map[string]string) []string {
+	pending := maps.Clone(values)
+	var out []string
+	addPending := func() {
+		for _, key := range slices.Sorted(maps.Keys(pending)) {
+			out = append(out, fmt.Sprintf("%s = %s\n", key, pending[key]))
+		}
+		clear(pending)
+	}
+	top := true
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if top && strings.HasPrefix(strings.TrimSpace(line), "[") {
+			addPending()
+			top = false
+		}
+		key, _, ok := parseConfigLine(line)
+		value, touched := pending[key]
+		if !top || !ok || !touched {
+			out = append(out, line)
+			continue
+		}
+		body := strings.TrimRight(line, "\r\n")
+		indent := body[:len(body)-len(strings.TrimLeft(body, " \t"))]
+		_, raw, _ := strings.Cut(body, "=")
+		out = append(out, indent+key+" = "+value+trailingComment(raw)+line[len(body):])
+		delete(pending, key)
+	}
+	if len(out) > 0 && !strings.HasSuffix(out[len(out)-1], "\n") {
+		out[len(out)-1] += "\n"
+	}
+	addPending()
+	return out
+}
+
+// trailingComment returns the comment after a raw TOML value, with a space
+// before it, or "".
+func trailingComment(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if prefix, err := strconv.QuotedPrefix(raw); err == nil {
+		raw = raw[len(prefix):]
+	}
+	if i := strings.Index(raw, "#"); i >= 0 {
+		return " " + raw[i:]
+	}
+	return ""
+}
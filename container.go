@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"time"
+)
+
+// resolvConf is where the Go resolver reads its nameservers on Unix. Without
+// it, lookups go to localhost:53.
+const resolvConf = "/etc/resolv.conf"
+
+// systemEnv is what gofast needs from the system besides the network, as
+// functions so the checks can be run against a simulated empty container.
+type systemEnv struct {
+	os       string
+	roots    func() (*x509.CertPool, error)
+	exists   func(path string) bool
+	tz       string
+	loadZone func(name string) (*time.Location, error)
+}
+
+func hostEnv() systemEnv {
+	return systemEnv{
+		os:    runtime.GOOS,
+		roots: x509.SystemCertPool,
+		exists: func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		},
+		tz:       os.Getenv("TZ"),
+		loadZone: time.LoadLocation,
+	}
+}
+
+// containerWarnings names what a scratch or distroless image typically
+// leaves out that gofast would otherwise trip over confusingly later: CA
+// certificates (unless certificates aren't verified), a resolver
+// configuration and time zone data for $TZ (unless times are UTC anyway).
+func (env systemEnv) containerWarnings(verify, utc bool) []Warning {
+	var warnings []Warning
+	if verify {
+		pool, err := env.roots()
+		if err != nil || pool.Equal(x509.NewCertPool()) {
+			warnings = append(warnings, Warning{warnNoCACertificates, "no system CA certificates found — mount ca-certificates (e.g. /etc/ssl/certs) or build with -tags embedroots"})
+		}
+	}
+	if env.os == "linux" && !env.exists(resolvConf) {
+		warnings = append(warnings, Warning{warnNoResolvConf, "no " + resolvConf + ", so DNS lookups go to localhost:53 — mount the host's resolv.conf or run with network access"})
+	}
+	if !utc && env.tz != "" && env.tz != "UTC" {
+		if _, err := env.loadZone(env.tz); err != nil {
+			warnings = append(warnings, Warning{warnNoTZData, fmt.Sprintf("TZ=%s names a zone with no time zone data here, so times are shown in UTC — mount /usr/share/zoneinfo, build with -tags timetzdata, or pass --utc", env.tz)})
+		}
+	}
+	return warnings
+}
+
+// isMissingResolver matches a lookup that went to localhost:53 because
+// there is no resolver configuration.
+func isMissingResolver(err error) bool {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return false
+	}
+	host, _, _ := net.SplitHostPort(dnsErr.Server)
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback() && runtime.GOOS == "linux" && !hostEnv().exists(resolvConf)
+}
+
+// isMissingRoots matches a certificate that couldn't be verified because
+// the system has no roots to verify it against.
+func isMissingRoots(err error) bool {
+	var noRoots x509.SystemRootsError
+	if errors.As(err, &noRoots) {
+		return true
+	}
+	if !isUnknownAuthority(err) {
+		return false
+	}
+	pool, perr := x509.SystemCertPool()
+	return perr != nil || pool.Equal(x509.NewCertPool())
+}
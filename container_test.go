@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+)
+
+// emptyContainer is a scratch image: no certificates, no resolver
+// configuration and no time zone data.
+func emptyContainer() systemEnv {
+	return systemEnv{
+		os:       "linux",
+		roots:    func() (*x509.CertPool, error) { return x509.NewCertPool(), nil },
+		exists:   func(string) bool { return false },
+		tz:       "Europe/Berlin",
+		loadZone: func(string) (*time.Location, error) { return nil, errors.New("unknown time zone Europe/Berlin") },
+	}
+}
+
+func warningCodes(ws []Warning) []string {
+	var codes []string
+	for _, w := range ws {
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func TestContainerWarnings(t *testing.T) {
+	full := systemEnv{
+		os: "linux",
+		roots: func() (*x509.CertPool, error) {
+			pool := x509.NewCertPool()
+			pool.AddCert(&x509.Certificate{Raw: []byte{1}, RawSubject: []byte{1}})
+			return pool, nil
+		},
+		exists:   func(string) bool { return true },
+		tz:       "UTC",
+		loadZone: time.LoadLocation,
+	}
+	tests := []struct {
+		name      string
+		env       func() systemEnv
+		verify    bool
+		utc       bool
+		wantCodes []string
+	}{
+		{"empty container", emptyContainer, true, false, []string{warnNoCACertificates, warnNoResolvConf, warnNoTZData}},
+		{"unverified TLS needs no roots", emptyContainer, false, false, []string{warnNoResolvConf, warnNoTZData}},
+		{"UTC needs no zone data", emptyContainer, true, true, []string{warnNoCACertificates, warnNoResolvConf}},
+		{"cert pool error", func() systemEnv {
+			env := emptyContainer()
+			env.roots = func() (*x509.CertPool, error) { return nil, errors.New("crypto/x509: no roots") }
+			return env
+		}, true, true, []string{warnNoCACertificates, warnNoResolvConf}},
+		{"no resolv.conf off Linux is fine", func() systemEnv {
+			env := emptyContainer()
+			env.os = "darwin"
+			return env
+		}, false, true, nil},
+		{"complete system", func() systemEnv { return full }, true, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := warningCodes(tt.env().containerWarnings(tt.verify, tt.utc))
+			if !slices.Equal(got, tt.wantCodes) {
+				t.Errorf("warnings %v, want %v", got, tt.wantCodes)
+			}
+		})
+	}
+}
+
+// TestEmptyCertPool runs in a child process pointed at an empty set of
+// roots, since the system pool is loaded once per process.
+func TestEmptyCertPool(t *testing.T) {
+	if os.Getenv("GOFAST_EMPTY_ROOTS_CHILD") == "1" {
+		emptyRootsChild(t)
+		return
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEmptyCertPool$", "-test.v")
+	cmd.Env = append(os.Environ(), "GOFAST_EMPTY_ROOTS_CHILD=1", "SSL_CERT_FILE="+os.DevNull, "SSL_CERT_DIR="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child: %v\n%s", err, out)
+	}
+}
+
+func emptyRootsChild(t *testing.T) {
+	if pool, err := x509.SystemCertPool(); err == nil && !pool.Equal(x509.NewCertPool()) {
+		t.Skip("this build has fallback roots (-tags embedroots)")
+	}
+	env := hostEnv()
+	env.exists = func(string) bool { return true }
+	if got := warningCodes(env.containerWarnings(true, true)); !slices.Equal(got, []string{warnNoCACertificates}) {
+		t.Errorf("warnings %v with an empty cert pool, want %s", got, warnNoCACertificates)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	_, err := http.Get(srv.URL)
+	if err == nil {
+		t.Fatal("a certificate was verified with no roots")
+	}
+	if hint, ok := hintFor(err); !ok || hint.Title != "No CA certificates" {
+		t.Errorf("hintFor(%v) = %q, %v; want No CA certificates", err, hint.Title, ok)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// controlServer serves --control-socket, a line protocol on a Unix socket
+// for status-bar widgets and local scripts. A client sends one command per
+// line and gets JSON objects back, one per line:
+//
+//	subscribe  stream live events until the client disconnects
+//	status     the current phase and speed, and the schedule if any
+//	start      start a new test once the current one has finished
+//	abort      cancel the running test
+type controlServer struct {
+	ln      net.Listener
+	command func(string) error
+
+	mu     sync.Mutex
+	status controlStatus
+	subs   map[chan controlEvent]struct{}
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+
+	// plan and schedule are the timeline and the rest of the schedule
+	// status, when there is one; the next runs are listed at query time.
+	plan     *schedule
+	schedule scheduleStatus
+}
+
+// controlEvent is one live event sent to subscribers.
+type controlEvent struct {
+	Event   string   `json:"event"`
+	Mbps    float64  `json:"mbps,omitempty"`
+	PingMs  float64  `json:"ping_ms,omitempty"`
+	Server  string   `json:"server,omitempty"`
+	Results *Results `json:"results,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Warning *Warning `json:"warning,omitempty"`
+}
+
+type controlStatus struct {
+	Running bool    `json:"running"`
+	Phase   string  `json:"phase"`
+	Mbps    float64 `json:"mbps"`
+	// Process is gofast's resource use since it started.
+	Process *ProcessUsage `json:"process,omitempty"`
+	// Schedule is set when gofast runs tests on a schedule.
+	Schedule *scheduleStatus `json:"schedule,omitempty"`
+}
+
+type controlReply struct {
+	OK     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Status *controlStatus `json:"status,omitempty"`
+}
+
+// controlMsg delivers a start or abort command to the TUI.
+type controlMsg string
+
+// runStartedMsg is published when the engine begins a run. It never reaches
+// the TUI.
+type runStartedMsg struct{}
+
+// controlBuffer is how many events a slow subscriber may fall behind before
+// events are dropped for it; the engine never waits on a client.
+const controlBuffer = 64
+
+// listenControl creates the socket at path. A socket left behind by a
+// process that exited uncleanly is replaced; one that still accepts
+// connections is not, and anything at path that isn't a socket is left
+// alone.
+func listenControl(path string, command func(string) error) (*controlServer, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("control socket %s: a file that isn't a socket is already there", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another gofast", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("control socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+
+	s := &controlServer{
+		ln:      ln,
+		command: command,
+		status:  controlStatus{Phase: "idle"},
+		subs:    make(map[chan controlEvent]struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *controlServer) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *controlServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		switch cmd := strings.TrimSpace(scanner.Text()); cmd {
+		case "":
+		case "subscribe":
+			enc.Encode(controlReply{OK: true})
+			s.subscribe(conn, enc)
+			return
+		case "status":
+			s.mu.Lock()
+			status := s.status
+			if s.plan != nil {
+				sch := s.schedule
+				sch.Next = s.plan.upcoming(time.Now(), schedulePreview)
+				status.Schedule = &sch
+			}
+			s.mu.Unlock()
+			status.Process = usageMark{at: processStart}.since()
+			enc.Encode(controlReply{OK: true, Status: &status})
+		case "start", "abort":
+			if err := s.run(cmd); err != nil {
+				enc.Encode(controlReply{Error: err.Error()})
+			} else {
+				enc.Encode(controlReply{OK: true})
+			}
+		default:
+			enc.Encode(controlReply{Error: fmt.Sprintf("unknown command %q", cmd)})
+		}
+	}
+}
+
+func (s *controlServer) run(cmd string) error {
+	s.mu.Lock()
+	running := s.status.Running
+	s.mu.Unlock()
+	switch {
+	case cmd == "start" && running:
+		return errors.New("a test is already running")
+	case cmd == "abort" && !running:
+		return errors.New("no test is running")
+	}
+	return s.command(cmd)
+}
+
+// subscribe streams events to one client until it disconnects or the server
+// closes.
+func (s *controlServer) subscribe(conn net.Conn, enc *json.Encoder) {
+	events := make(chan controlEvent, controlBuffer)
+	s.mu.Lock()
+	s.subs[events] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, events)
+		s.mu.Unlock()
+	}()
+
+	// A subscriber only reads; EOF or an error means it went away.
+	gone := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				close(gone)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev := <-events:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// SetSchedule publishes the timeline and recent outcomes of scheduled runs.
+func (s *controlServer) SetSchedule(plan schedule, outputs []string, recent []runOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plan = &plan
+	s.schedule = scheduleStatus{Outputs: outputs, Recent: recent}
+}
+
+// Publish records an engine message and forwards it to subscribers. It is
+// safe to call from the engine goroutine.
+func (s *controlServer) Publish(msg tea.Msg) {
+	ev, ok := controlEventFor(msg)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch ev.Event {
+	case "start":
+		s.status = controlStatus{Running: true, Phase: "server"}
+	case "server":
+		s.status.Phase = "ping"
+	case "ping":
+		s.status.Phase = "download"
+	case "download":
+		s.status.Phase, s.status.Mbps = "upload", ev.Mbps
+	case "speed", "upload":
+		s.status.Mbps = ev.Mbps
+	case "complete", "error":
+		s.status.Running, s.status.Phase = false, ev.Event
+	}
+	for events := range s.subs {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+}
+
+func controlEventFor(msg tea.Msg) (controlEvent, bool) {
+	switch msg := msg.(type) {
+	case runStartedMsg:
+		return controlEvent{Event: "start"}, true
+	case serverMsg:
+		return controlEvent{Event: "server", Server: Server(msg).Label()}, true
+	case pingMsg:
+		return controlEvent{Event: "ping", PingMs: msg.Avg}, true
+	case speedMsg:
+		return controlEvent{Event: "speed", Mbps: float64(msg)}, true
+	case downloadMsg:
+		return controlEvent{Event: "download", Mbps: msg.Mbps}, true
+	case uploadMsg:
+		return controlEvent{Event: "upload", Mbps: msg.Mbps}, true
+	case completeMsg:
+		results := Results(msg)
+		return controlEvent{Event: "complete", Results: &results}, true
+	case errorMsg:
+		return controlEvent{Event: "error", Error: error(msg).Error()}, true
+	case warningMsg:
+		w := Warning(msg)
+		return controlEvent{Event: "warning", Warning: &w}, true
+	}
+	return controlEvent{}, false
+}
+
+// Close stops accepting clients, disconnects the connected ones and removes
+// the socket file.
+func (s *controlServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenControlKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "precious.txt")
+	if err := os.WriteFile(path, []byte("keep me"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := listenControl(path, func(string) error { return nil }); err == nil {
+		s.Close()
+		t.Fatal("listenControl took over a regular file")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "keep me" {
+		t.Fatalf("file after listenControl = %q, %v; want it untouched", b, err)
+	}
+}
+
+func TestListenControlReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gofast.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing a Unix listener removes its file; keep it to leave a stale
+	// socket behind, as a crashed process would.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	s, err := listenControl(path, func(string) error { return nil })
+	if err != nil {
+		t.Fatalf("listenControl over a stale socket: %v", err)
+	}
+	defer s.Close()
+	if _, err := listenControl(path, func(string) error { return nil }); err == nil {
+		t.Fatal("listenControl replaced a socket still in use")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// costWindow is how far back the delivered speed behind the cost per Mbps
+// is averaged.
+const costWindow = 30 * 24 * time.Hour
+
+// planCost sets what the plan costs per Mbps it advertises against what it
+// costs per Mbps it delivered, on average, over the last costWindow.
+type planCost struct {
+	Advertised, Delivered float64
+	// DeliveredMbps is the average download of Runs runs.
+	DeliveredMbps float64
+	Runs          int
+	Currency      currency
+}
+
+// computePlanCost works out the cost per Mbps from the monthly price, the
+// plan's download speed and the runs of the last costWindow before now.
+// It reports false without a price, a plan speed or a run to go by.
+func computePlanCost(monthly, planMbps float64, cur currency, past []Results, now time.Time) (planCost, bool) {
+	if monthly <= 0 || planMbps <= 0 {
+		return planCost{}, false
+	}
+	var sum float64
+	runs := 0
+	for _, r := range past {
+		if r.Download == nil || r.Site != "" || outOfBaseline(r) || now.Sub(r.Timestamp) > costWindow {
+			continue
+		}
+		sum += r.Download.Mbps
+		runs++
+	}
+	if runs == 0 || sum == 0 {
+		return planCost{}, false
+	}
+	delivered := sum / float64(runs)
+	return planCost{
+		Advertised:    monthly / planMbps,
+		Delivered:     monthly / delivered,
+		DeliveredMbps: delivered,
+		Runs:          runs,
+		Currency:      cur,
+	}, true
+}
+
+// summary is the completion screen's line, e.g. "you pay ₹4.1 per
+// delivered Mbps; advertised would be ₹2.6".
+func (c planCost) summary() string {
+	return fmt.Sprintf("you pay %s per delivered Mbps; advertised would be %s", c.Currency.format(c.Delivered), c.Currency.format(c.Advertised))
+}
+
+// currency formats amounts of money the way the locale writes them.
+type currency struct {
+	// symbol is the currency's sign, or its code when it has none known.
+	symbol string
+	// after puts the symbol after the amount, as in "4,1 €"; comma is the
+	// decimal separator instead of a point.
+	after, comma bool
+}
+
+// currencySymbols are the signs of common currencies; others are written
+// with their code.
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "INR": "₹", "JPY": "¥", "CNY": "¥",
+	"KRW": "₩", "RUB": "₽", "BRL": "R$", "CAD": "CA$", "AUD": "A$", "NZD": "NZ$",
+	"MXN": "MX$", "TRY": "₺", "UAH": "₴", "PLN": "zł", "PHP": "₱", "NGN": "₦",
+	"ILS": "₪", "VND": "₫", "THB": "฿", "SEK": "kr", "NOK": "kr", "DKK": "kr",
+}
+
+// territoryCurrencies maps a locale's territory to its currency.
+var territoryCurrencies = map[string]string{
+	"US": "USD", "GB": "GBP", "IN": "INR", "JP": "JPY", "CN": "CNY", "KR": "KRW",
+	"RU": "RUB", "BR": "BRL", "CA": "CAD", "AU": "AUD", "NZ": "NZD", "MX": "MXN",
+	"TR": "TRY", "UA": "UAH", "PL": "PLN", "PH": "PHP", "NG": "NGN", "IL": "ILS",
+	"VN": "VND", "TH": "THB", "SE": "SEK", "NO": "NOK", "DK": "DKK", "CH": "CHF",
+	"ZA": "ZAR", "SG": "SGD", "HK": "HKD", "ID": "IDR", "PK": "PKR", "BD": "BDT",
+	"DE": "EUR", "FR": "EUR", "ES": "EUR", "IT": "EUR", "NL": "EUR", "BE": "EUR",
+	"AT": "EUR", "IE": "EUR", "PT": "EUR", "FI": "EUR", "GR": "EUR", "SK": "EUR",
+	"SI": "EUR", "LU": "EUR", "EE": "EUR", "LV": "EUR", "LT": "EUR", "HR": "EUR",
+}
+
+// Languages that write a decimal comma, and of those, the ones that put
+// the currency after the amount.
+var (
+	decimalCommaLanguages = []string{"de", "fr", "es", "it", "nl", "pt", "ru", "pl", "sv", "nb", "nn", "da", "fi", "cs", "sk", "tr", "uk", "id", "vi"}
+	symbolAfterLanguages  = []string{"de", "fr", "es", "it", "ru", "pl", "sv", "nb", "nn", "da", "fi", "cs", "sk", "uk", "vi"}
+)
+
+// monetaryLocale returns the language and territory of the locale that
+// governs money, e.g. "en" and "IN" for en_IN.UTF-8.
+func monetaryLocale() (language, territory string) {
+	for _, name := range []string{"LC_ALL", "LC_MONETARY", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v, _, _ = strings.Cut(v, ".")
+			v, _, _ = strings.Cut(v, "@")
+			language, territory, _ = strings.Cut(v, "_")
+			return strings.ToLower(language), strings.ToUpper(territory)
+		}
+	}
+	return "", ""
+}
+
+// currencyCode reports whether s has the shape of an ISO 4217 code: three
+// letters.
+func currencyCode(s string) bool {
+	return len(s) == 3 && strings.IndexFunc(s, func(r rune) bool { return (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') }) < 0
+}
+
+// newCurrency formats amounts of the ISO 4217 code, or of the locale's
+// currency when code is "", the way the locale does. Without either,
+// amounts are bare numbers.
+func newCurrency(code string) currency {
+	language, territory := monetaryLocale()
+	code = strings.ToUpper(code)
+	if code == "" {
+		code = territoryCurrencies[territory]
+	}
+	c := currency{symbol: code}
+	if s, ok := currencySymbols[code]; ok {
+		c.symbol = s
+	}
+	for _, l := range decimalCommaLanguages {
+		c.comma = c.comma || l == language
+	}
+	for _, l := range symbolAfterLanguages {
+		c.after = c.after || l == language
+	}
+	return c
+}
+
+// format writes an amount to one decimal, or two below 1: "₹4.1",
+// "0,55 €", "CHF 3.2".
+func (c currency) format(v float64) string {
+	digits := 1
+	if v < 1 {
+		digits = 2
+	}
+	s := strconv.FormatFloat(v, 'f', digits, 64)
+	if c.comma {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	switch {
+	case c.symbol == "":
+		return s
+	case c.after:
+		return s + " " + c.symbol
+	case unicode.IsLetter([]rune(c.symbol)[len([]rune(c.symbol))-1]):
+		return c.symbol + " " + s
+	}
+	return c.symbol + s
+}
+
+// planCostMsg delivers the cost per Mbps, worked out from history once a
+// run completes.
+type planCostMsg struct{ cost planCost }
+
+// planCostCmd works out the cost per Mbps over recent history, or over
+// this run alone when history is off. It is nil unless both the plan's
+// download speed and its price are configured.
+func (m speedTest) planCostCmd() tea.Cmd {
+	cfg := m.opts.Config
+	if cfg == nil || cfg.PlanCost <= 0 || m.opts.PlanDownload <= 0 {
+		return nil
+	}
+	monthly, plan, cur := cfg.PlanCost, m.opts.PlanDownload, newCurrency(cfg.Currency)
+	history, results, now := m.engine.history, m.results, m.engine.clock.Now()
+	return func() tea.Msg {
+		past := []Results{results}
+		if history != nil {
+			past, _ = history.Load()
+		}
+		cost, ok := computePlanCost(monthly, plan, cur, past, now)
+		if !ok {
+			return nil
+		}
+		return planCostMsg{cost}
+	}
+}
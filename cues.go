@@ -0,0 +1,69 @@
+package main
+
+// cue is how a verdict is shown: an SGR color, and a symbol beside the
+// figure and a tag in plain text so it doesn't rest on color alone.
+type cue struct {
+	Color  string
+	Symbol string
+	Tag    string
+}
+
+// cueTheme has the cue for every verdict the views show.
+type cueTheme struct {
+	// Grades are indexed by speedGrade.
+	Grades [4]cue
+	// Better and Worse mark a change between two runs.
+	Better, Worse cue
+	// Concern marks a figure worth a look, such as a busy environment.
+	Concern cue
+	// Series are the speed history graph's series, indexed by
+	// seriesDownload and the like; Symbol is the glyph plotted and Tag
+	// the legend's name.
+	Series [seriesCount]cue
+}
+
+var defaultCues = cueTheme{
+	Grades: [4]cue{
+		gradePoor:      {Color: "31;1", Symbol: "✗", Tag: "[poor]"},
+		gradeFair:      {Color: "33;1", Symbol: "!", Tag: "[fair]"},
+		gradeGood:      {Color: "32;1", Symbol: "✓", Tag: "[good]"},
+		gradeExcellent: {Color: "36;1", Symbol: "✓", Tag: "[excellent]"},
+	},
+	Better:  cue{Color: "32", Symbol: "✓", Tag: "[better]"},
+	Worse:   cue{Color: "31", Symbol: "✗", Tag: "[worse]"},
+	Concern: cue{Color: "33", Symbol: "!", Tag: "[check]"},
+	Series: [seriesCount]cue{
+		seriesDownload: {Color: "36", Symbol: "█", Tag: "download"},
+		seriesUpload:   {Color: "32", Symbol: "▒", Tag: "upload"},
+		seriesLatency:  {Color: "33;1", Symbol: "•", Tag: "latency"},
+	},
+}
+
+// colorBlindCues, for --color-blind, never set red against green: verdicts
+// run from magenta through yellow to blue and cyan, which stay apart under
+// the common color vision deficiencies, and every grade has a symbol of
+// its own.
+var colorBlindCues = cueTheme{
+	Grades: [4]cue{
+		gradePoor:      {Color: "35;1", Symbol: "✗", Tag: "[poor]"},
+		gradeFair:      {Color: "33;1", Symbol: "!", Tag: "[fair]"},
+		gradeGood:      {Color: "34;1", Symbol: "✓", Tag: "[good]"},
+		gradeExcellent: {Color: "36;1", Symbol: "★", Tag: "[excellent]"},
+	},
+	Better:  cue{Color: "34;1", Symbol: "✓", Tag: "[better]"},
+	Worse:   cue{Color: "35;1", Symbol: "✗", Tag: "[worse]"},
+	Concern: cue{Color: "33;1", Symbol: "!", Tag: "[check]"},
+	Series: [seriesCount]cue{
+		seriesDownload: {Color: "34;1", Symbol: "█", Tag: "download"},
+		seriesUpload:   {Color: "33", Symbol: "▒", Tag: "upload"},
+		seriesLatency:  {Color: "37;1", Symbol: "•", Tag: "latency"},
+	},
+}
+
+// cues is the theme the views draw verdicts with.
+var cues = defaultCues
+
+// mark renders text in c's color with its symbol in front.
+func (c cue) mark(text string) string {
+	return "\033[" + c.Color + "m" + c.Symbol + " " + text + "\033[0m"
+}
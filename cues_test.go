@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muesli/termenv"
+)
+
+// lastLine is the final line of a rendered block.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return lines[len(lines)-1]
+}
+
+// gradedView has a verdict of every kind: the readouts of all four speed
+// grades, bufferbloat grades, the exit summary's tags and the changes
+// between two runs.
+func gradedView() string {
+	m := initialModel(engine{provider: demoProvider{}, clock: newFakeClock()}, viewOptions{NoMenu: true})
+	m.caps = Capabilities{Upload: true}
+	var s strings.Builder
+	s.WriteString(lastLine(m.renderDualSpeedometer(500, 0.5)) + "\n")
+	s.WriteString(lastLine(m.renderSpeedometer(5)) + "\n")
+	s.WriteString(lastLine(m.renderSpeedometer(50)) + "\n")
+	for _, b := range []Bufferbloat{{IncreaseMs: 3, Grade: "A"}, {IncreaseMs: 185, Grade: "D"}, {IncreaseMs: 520, Grade: "F"}} {
+		s.WriteString("Bufferbloat " + b.summary() + "\n")
+	}
+
+	at := time.Date(2026, 3, 2, 19, 30, 0, 0, time.Local)
+	latency := func(rtts ...float64) Latency {
+		l := summarizeLatency(rtts)
+		l.Source, l.Samples = provenanceMeasured, nil
+		return l
+	}
+	a := Results{Timestamp: at, Download: &Transfer{Mbps: 480}, Upload: &Transfer{Mbps: 0.6}, Latency: latency(12, 13, 11)}
+	b := Results{Timestamp: at.Add(time.Hour), Download: &Transfer{Mbps: 50}, Upload: &Transfer{Mbps: 8}, Latency: latency(30, 26, 34)}
+	s.WriteString(exitSummary([]Results{a}))
+	s.WriteString(exitSummary([]Results{b}))
+	s.WriteString(compareRuns(a, b).deltas())
+	s.WriteString(cues.Concern.mark("3 other devices busy") + "\n")
+	return s.String()
+}
+
+func TestCuesGolden(t *testing.T) {
+	saved := cues
+	defer func() { cues = saved }()
+	profiles := []struct {
+		name    string
+		profile termenv.Profile
+		unicode bool
+	}{
+		{"ansi", termenv.ANSI, true},
+		{"mono", termenv.Ascii, true},
+		{"ascii", termenv.Ascii, false},
+	}
+	for _, preset := range []struct {
+		name  string
+		theme cueTheme
+		// symbols are the grades' symbols, poor to excellent, on a
+		// Unicode terminal.
+		symbols []string
+	}{
+		{"default", defaultCues, []string{"✗", "!", "✓", "✓"}},
+		{"color-blind", colorBlindCues, []string{"✗", "!", "✓", "★"}},
+	} {
+		cues = preset.theme
+		view := gradedView()
+		for _, p := range profiles {
+			got := newTerminal(p.profile, p.unicode).render(view)
+			name := "cues-" + preset.name + "-" + p.name
+			checkGolden(t, name, got)
+
+			if p.profile == termenv.Ascii && strings.Contains(got, "\033") {
+				t.Errorf("%s: escapes left for a terminal without color:\n%q", name, got)
+			}
+			for _, tag := range []string{"[excellent]", "[poor]", "[good]", "[fair]"} {
+				if !strings.Contains(got, tag) {
+					t.Errorf("%s: no %s tag", name, tag)
+				}
+			}
+			for g, symbol := range preset.symbols {
+				if !p.unicode {
+					symbol = asciiGlyphs.Replace(symbol)
+				}
+				readout := symbol + " "
+				if !strings.Contains(got, readout) {
+					t.Errorf("%s: no %q for %s", name, readout, speedGrade(g))
+				}
+			}
+			if !p.unicode && strings.ContainsFunc(got, func(r rune) bool { return r > 127 }) {
+				t.Errorf("%s: glyphs left for a terminal without Unicode", name)
+			}
+		}
+		if preset.name == "color-blind" {
+			for _, red := range []string{"\033[31", "\033[32"} {
+				if strings.Contains(view, red) {
+					t.Errorf("color-blind view uses %q", red)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --max-bytes caps the data a run's transfers move in all, for metered
+// connections. The download gets half of it when there is an upload to
+// follow and the other half can hold one, and the upload whatever the
+// download left.
+
+// byteUnits are the suffixes parseByteSize takes, longest first so "MB"
+// isn't read as "B". They are powers of 1024, as formatBytes shows sizes.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"gib", 1 << 30}, {"mib", 1 << 20}, {"kib", 1 << 10},
+	{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// parseByteSize reads a size such as "100MB", "1.5 GB" or "500k"; a bare
+// number is bytes.
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a size such as 100MB or 1.5GB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// minCappedUpload is the least --max-bytes must leave the upload for it to
+// run at all.
+const minCappedUpload = 1 << 20
+
+// tighterCap is the smaller of two byte budgets, either of which may be
+// zero for none.
+func tighterCap(a, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// downloadShare is the part of the --max-bytes budget the download may
+// use, zero when there is none, and whether an upload still fits after it.
+// A budget too small to leave the upload minCappedUpload goes to the
+// download whole, rather than halving it for an upload that can't run.
+func (e engine) downloadShare(upload bool) (int64, bool) {
+	if !upload {
+		return e.maxBytes, false
+	}
+	if e.maxBytes > 0 && e.maxBytes-e.maxBytes/2 < minCappedUpload {
+		return e.maxBytes, false
+	}
+	return e.maxBytes / 2, true
+}
+
+// warnDataCap flags a transfer that --max-bytes stopped early: a transfer
+// cut short reads lower than the link can do.
+func warnDataCap(r *Results, emit func(tea.Msg), direction string, t Transfer, share int64) {
+	if share > 0 && t.Capped && t.Bytes >= share {
+		warn(r, emit, warnDataCapped, "%s stopped after %s at the --max-bytes budget; the speed may read low", direction, formatBytes(t.Bytes))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestDownloadShare(t *testing.T) {
+	tests := []struct {
+		maxBytes int64
+		upload   bool
+		share    int64
+		fits     bool
+	}{
+		{maxBytes: 0, upload: true, share: 0, fits: true},
+		{maxBytes: 0, upload: false, share: 0},
+		{maxBytes: 100 << 20, upload: true, share: 50 << 20, fits: true},
+		{maxBytes: 100 << 20, upload: false, share: 100 << 20},
+		{maxBytes: 2 * minCappedUpload, upload: true, share: minCappedUpload, fits: true},
+		{maxBytes: 2*minCappedUpload - 2, upload: true, share: 2*minCappedUpload - 2},
+		{maxBytes: 1 << 20, upload: true, share: 1 << 20},
+	}
+	for _, tt := range tests {
+		share, fits := engine{maxBytes: tt.maxBytes}.downloadShare(tt.upload)
+		if share != tt.share || fits != tt.fits {
+			t.Errorf("--max-bytes %d, upload %v: share %d, fits %v; want %d, %v", tt.maxBytes, tt.upload, share, fits, tt.share, tt.fits)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{"100MB": 100 << 20, "1.5 GB": 3 << 29, "500k": 500 << 10, "2048": 2048, "1MiB": 1 << 20} {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1MB", "lots"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) took a bad size", in)
+		}
+	}
+}
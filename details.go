@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// detailSection is one titled group of label/value rows on the completion
+// screen.
+type detailSection struct {
+	title string
+	rows  [][2]string
+}
+
+func (d *detailSection) add(label, value string) {
+	d.rows = append(d.rows, [2]string{label, value})
+}
+
+func (m speedTest) detailSections() []detailSection {
+	throughput := detailSection{title: "Throughput"}
+	download := fmt.Sprintf("%s%s (%s)", formatSpeed(m.downloadSpeed), transferMark(m.results.Download), gradeSpeed(m.downloadSpeed))
+	if m.results.MultiServer != nil {
+		download += " \033[90mmean of the servers below\033[0m"
+	}
+	throughput.add("Download", download)
+	if m.caps.Upload {
+		throughput.add("Upload", fmt.Sprintf("%s%s (%s)", formatSpeed(m.uploadSpeed), transferMark(m.results.Upload), gradeSpeed(m.uploadSpeed)))
+	} else {
+		throughput.add("Upload", "n/a")
+	}
+	if average := formatAverage(m.results.Download); average != "" {
+		throughput.add("Download average", average)
+	}
+	if average := formatAverage(m.results.Upload); average != "" {
+		throughput.add("Upload average", average)
+	}
+	if burst := formatBurst(m.results.Download); burst != "" {
+		throughput.add("Download burst", burst)
+	}
+	if burst := formatBurst(m.results.Upload); burst != "" {
+		throughput.add("Upload burst", burst)
+	}
+	if d := m.results.DualStack; d != nil {
+		throughput.add("  over IPv4", formatFamily(d.IPv4, transferMbps(m.results.Download)))
+		throughput.add("  over IPv6", formatFamily(d.IPv6, transferMbps(m.results.Download)))
+	}
+	if plan := m.planSummary(); plan != "" {
+		throughput.add("Versus plan", plan)
+	}
+	if c := m.cost; c != nil {
+		throughput.add("Cost per Mbps", fmt.Sprintf("%s \033[90m(%s average over %d run(s) in %d days)\033[0m", c.summary(), formatSpeed(c.DeliveredMbps), c.Runs, int(costWindow.Hours()/24)))
+	}
+	if overhead := m.overheadSummary(); overhead != "" {
+		throughput.add("Protocol overhead", overhead)
+	}
+	if stalls := m.stallSummary(); stalls != "" {
+		throughput.add("Stalled connections", "\033[33m"+stalls+"\033[0m restarted after "+stallTimeout.String()+" without progress")
+	}
+	if ramp := formatRamp(m.results.Download); ramp != "" {
+		throughput.add("Download streams", ramp)
+	}
+	if ramp := formatRamp(m.results.Upload); ramp != "" {
+		throughput.add("Upload streams", ramp)
+	}
+	if capped := m.cappedDirections(); capped != "" {
+		throughput.add("Capped", capped+" stopped at the data budget")
+	}
+	if m.results.CompressionSuspected {
+		throughput.add("Upload payload", "\033[33;1mcompression proxy suspected\033[0m (compressible upload far faster than usual)")
+	} else if m.results.Upload != nil && m.results.Upload.Compressible {
+		throughput.add("Upload payload", "compressible (--compressible)")
+	}
+	if r := m.results.Regression; r != nil && r.Notable {
+		throughput.add("Compared to usual", fmt.Sprintf("\033[33;1munusually slow for this connection\033[0m (below p%.0f of last %d runs)", r.Percentile, r.Compared))
+	}
+
+	latency := detailSection{title: "Latency"}
+	latency.add("Ping", formatPingJitter(m.results.Latency))
+	if method := m.results.Latency.Method; method != "" {
+		latency.add("Method", method+" (compare with --ping-compare)")
+	}
+	if l := m.results.Latency; l.Max > l.Min {
+		latency.add("Range", fmt.Sprintf("%.1f – %.1f ms", l.Min, l.Max))
+	}
+	if l := m.results.Latency; len(l.Samples) > 1 {
+		latency.add("p50 / p95 / p99", formatPercentiles(l))
+	}
+	if l := m.results.Loss; l != nil {
+		latency.add("Loss", fmt.Sprintf("%.1f%% \033[90m(%s probes)\033[0m", *l, strings.ToUpper(m.results.LossMethod)))
+	} else {
+		latency.add("Loss", "n/a")
+	}
+	if l := m.results.LoadedLatency; l != nil {
+		latency.add("Under load", fmt.Sprintf("%.1f ms", l.Avg))
+		if b := m.results.Bufferbloat; b != nil {
+			latency.add("Latency under load", b.summary())
+		}
+		latency.add("  p50 / p95 / p99", formatPercentiles(*l))
+	}
+	if w := m.results.Web; w != nil {
+		latency.add("Web responsiveness", formatWeb(w))
+	}
+
+	run := detailSection{title: "Run"}
+	if m.results.Note != "" {
+		run.add("Note", m.results.Note)
+	}
+	if srv := m.results.Server; srv.Location != "" && srv.Location != srv.Name {
+		run.add("Server", srv.Name+" ("+srv.Location+")")
+	} else if srv.Name != "" {
+		run.add("Server", srv.Name)
+	}
+	if isp := m.results.Client.ISP; isp != "" {
+		run.add("ISP", isp)
+	}
+	if race := m.results.ServerRace; len(race) > 1 {
+		run.add("Server race", formatRace(race))
+	}
+	if note := fallbackNote(m.results.Fallbacks, m.results.Server); note != "" {
+		run.add("Server fallback", note)
+	}
+	if c := m.results.ServerChange; c != nil {
+		run.add("Server changed", fmt.Sprintf("\033[33m%s → %s\033[0m because %s", c.From, c.To, c.Reason))
+	} else if c := m.results.ServerChoice; c != nil && c.Sticky {
+		run.add("Server choice", "kept from earlier runs, chosen "+c.EvaluatedAt.Local().Format("Jan 02 15:04"))
+	}
+	if f := m.results.Family; f != "" {
+		run.add("Address family", f+" (pinned with --"+strings.ToLower(f)+")")
+	} else if d := m.results.Download; d != nil && d.Family != "" {
+		run.add("Address family", d.Family)
+	}
+	if p := transferProtocols(m.results); p != "" {
+		run.add("HTTP protocol", p)
+	}
+	run.add("Profile", m.engine.profile.Name)
+	run.add("Duration", fmt.Sprintf("%.1fs", m.results.Duration.Seconds()))
+	if r := m.sinkReport; r != nil {
+		if len(r.Failures) > 0 {
+			run.add("Sinks", "\033[31m"+r.String()+"\033[0m")
+		} else {
+			run.add("Sinks", r.String())
+		}
+	} else if len(m.engine.sinks) > 0 {
+		run.add("Sinks", "sending…")
+	}
+	if len(m.results.BudgetCuts) > 0 {
+		run.add("Cut for time", strings.Join(m.results.BudgetCuts, ", "))
+	}
+	if m.results.CPUBound {
+		run.add("CPU", "\033[33;1mgofast kept a core busy\033[0m; this machine may have limited the result (see --measure-overhead)")
+	}
+	if u := m.results.Process; u != nil {
+		run.add("gofast used", formatUsage(u))
+	}
+	if withheld := m.engine.privacy.withheld(); withheld != "" {
+		run.add("Privacy", "withheld per config: "+withheld)
+	}
+	if m.results.TLSUnverified {
+		run.add("TLS", "\033[33;1munverified\033[0m (--insecure-skip-verify)")
+	}
+
+	sections := []detailSection{throughput, latency, run}
+	if multi := m.results.MultiServer; multi != nil {
+		sections = slices.Insert(sections, 1, serverLegsSection(multi))
+	}
+	if len(m.results.Environment) > 0 {
+		sections = append(sections, envSection(m.results.Environment))
+	}
+	if c := m.results.Connection; c != nil {
+		sections = append(sections, connectionSection(c))
+	}
+	if t := m.results.TLS; t != nil && m.opts.Verbose {
+		sections = append(sections, tlsSection(t))
+	}
+	if w := m.results.Web; w != nil && len(w.Destinations) > 0 {
+		sections = append(sections, webSection(w))
+	}
+	return sections
+}
+
+func formatPercentiles(l Latency) string {
+	return fmt.Sprintf("%.1f / %.1f / %.1f ms", l.P50, l.P95, l.P99)
+}
+
+func renderSections(sections []detailSection) string {
+	var s strings.Builder
+	for _, section := range sections {
+		if len(section.rows) == 0 {
+			continue
+		}
+		s.WriteString(fmt.Sprintf("\n\033[1m%s\033[0m\n", section.title))
+		for _, row := range section.rows {
+			s.WriteString(fmt.Sprintf("  %-18s %s\n", row[0], row[1]))
+		}
+	}
+	return s.String()
+}
+
+// renderTimeline shows every phase of the run with the current one
+// highlighted.
+func (m speedTest) renderTimeline() string {
+	var parts []string
+	for _, p := range m.engine.phases() {
+		switch {
+		case m.phase == phaseComplete || p < m.phase:
+			parts = append(parts, "\033[32m✓ "+p.String()+"\033[0m")
+		case p == m.phase:
+			parts = append(parts, "\033[1m▶ "+p.String()+"\033[0m")
+		default:
+			parts = append(parts, "\033[90m· "+p.String()+"\033[0m")
+		}
+	}
+	return strings.Join(parts, " ─ ") + "\n\n"
+}
+
+// planSummary compares the speeds with the plan from the config, e.g.
+// "84% of 100.00 Mbps down".
+func (m speedTest) planSummary() string {
+	var parts []string
+	if plan := m.opts.PlanDownload; plan > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% of %s down", m.downloadSpeed/plan*100, formatSpeed(plan)))
+	}
+	if plan := m.opts.PlanUpload; plan > 0 && m.caps.Upload {
+		parts = append(parts, fmt.Sprintf("%.0f%% of %s up", m.uploadSpeed/plan*100, formatSpeed(plan)))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// diagnosticsPath is the file name the diagnostics bundle is written to,
+// with --output's placeholders.
+const diagnosticsPath = "gofast-diagnostics-%Y%m%d-%H%M%S.json"
+
+// trailLimit is how many of a run's most recent events the diagnostics
+// keep.
+const trailLimit = 50
+
+// trailEvent is one line of a run's log: something the engine reported,
+// and when into the run.
+type trailEvent struct {
+	Elapsed float64 `json:"elapsed_s"`
+	Event   string  `json:"event"`
+}
+
+// describeMsg is the log line for a message from the engine, or false for
+// those too frequent or too minor to log, such as live speeds.
+func describeMsg(msg tea.Msg) (string, bool) {
+	switch msg := msg.(type) {
+	case serverMsg:
+		return "server " + Server(msg).Label(), true
+	case fallbackMsg:
+		var parts []string
+		for _, f := range msg {
+			parts = append(parts, f.Server+" ("+f.Reason+")")
+		}
+		return "fell back from " + strings.Join(parts, ", "), true
+	case pingMsg:
+		return "ping " + formatPingJitter(Latency(msg)), true
+	case lossMsg:
+		return fmt.Sprintf("loss %.1f%%", float64(msg)), true
+	case downloadMsg:
+		return "download " + formatSpeed(msg.Mbps), true
+	case uploadMsg:
+		return "upload " + formatSpeed(msg.Mbps), true
+	case warningMsg:
+		return "warning " + msg.Code + ": " + msg.Message, true
+	case errorMsg:
+		return "error: " + error(msg).Error(), true
+	}
+	return "", false
+}
+
+// logEvent adds msg to the run's log, dropping the oldest line past
+// trailLimit.
+func (m speedTest) logEvent(msg tea.Msg) speedTest {
+	line, ok := describeMsg(msg)
+	if !ok {
+		return m
+	}
+	event := trailEvent{Elapsed: m.engine.clock.Since(m.startTime).Seconds(), Event: line}
+	m.trail = append(slices.Clip(m.trail), event)
+	if len(m.trail) > trailLimit {
+		m.trail = m.trail[len(m.trail)-trailLimit:]
+	}
+	return m
+}
+
+// errorLink is one error in a chain: its Go type and its message.
+type errorLink struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// errorChain unwraps err, joined errors included, outermost first.
+func errorChain(err error) []errorLink {
+	var chain []errorLink
+	queue := []error{err}
+	for len(queue) > 0 {
+		err, queue = queue[0], queue[1:]
+		if err == nil {
+			continue
+		}
+		chain = append(chain, errorLink{Type: fmt.Sprintf("%T", err), Message: err.Error()})
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			queue = append(queue, u.Unwrap())
+		case interface{ Unwrap() []error }:
+			queue = append(queue, u.Unwrap()...)
+		}
+	}
+	return chain
+}
+
+// failingEndpoint is the URL or address err was about, or "" when it
+// doesn't say.
+func failingEndpoint(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Op + " " + urlErr.URL
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Addr != nil {
+		return opErr.Op + " " + opErr.Net + " " + opErr.Addr.String()
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "lookup " + dnsErr.Name
+	}
+	return ""
+}
+
+// diagnostics is what a maintainer needs about a failed run: the error in
+// full, the run's settings and the config without its secrets, the
+// machine, and the run's log.
+type diagnostics struct {
+	Created time.Time `json:"created"`
+	Error   struct {
+		Message  string      `json:"message"`
+		Chain    []errorLink `json:"chain"`
+		Endpoint string      `json:"endpoint,omitempty"`
+		// Phase is the one under way when the run failed, Elapsed how far
+		// into the run.
+		Phase   string  `json:"phase"`
+		Elapsed float64 `json:"elapsed_s"`
+	} `json:"error"`
+	Run struct {
+		Provider    string  `json:"provider"`
+		Profile     string  `json:"profile"`
+		Streams     int     `json:"streams,omitempty"`
+		Server      string  `json:"server,omitempty"`
+		Interface   string  `json:"interface,omitempty"`
+		MaxDuration float64 `json:"max_duration_s,omitempty"`
+		MaxBytes    int64   `json:"max_bytes,omitempty"`
+	} `json:"run"`
+	// Fallbacks are the servers given up on before the failure.
+	Fallbacks []ServerFallback  `json:"server_fallbacks,omitempty"`
+	Warnings  []Warning         `json:"warnings,omitempty"`
+	Config    map[string]string `json:"config,omitempty"`
+	// Excluded names the config's secrets left out.
+	Excluded    []string          `json:"config_excluded,omitempty"`
+	Environment map[string]string `json:"environment"`
+	Log         []trailEvent      `json:"log"`
+}
+
+func (m speedTest) diagnostics() diagnostics {
+	var d diagnostics
+	d.Created = m.engine.clock.Now()
+	if m.err != nil {
+		d.Error.Message = m.err.Error()
+		d.Error.Chain = errorChain(m.err)
+		d.Error.Endpoint = failingEndpoint(m.err)
+	}
+	d.Error.Phase = m.failedIn.String()
+	d.Error.Elapsed = m.failedAfter.Seconds()
+	d.Run.Provider = m.engine.provider.Name()
+	d.Run.Profile = m.engine.profile.Name
+	d.Run.Streams = m.engine.profile.Streams
+	d.Run.Server = m.results.Server.Label()
+	d.Run.Interface = m.engine.iface
+	d.Run.MaxDuration = m.engine.maxDuration.Seconds()
+	d.Run.MaxBytes = m.engine.maxBytes
+	d.Fallbacks = m.results.Fallbacks
+	d.Warnings = m.warnings
+	if m.opts.Config != nil {
+		c, excluded := stripSecrets(*m.opts.Config)
+		d.Config, d.Excluded = configValues(c), excluded
+	}
+	d.Environment = map[string]string{
+		"os":     runtime.GOOS + "/" + runtime.GOARCH,
+		"go":     runtime.Version(),
+		"gofast": buildVersion(),
+		"term":   os.Getenv("TERM"),
+	}
+	if os.Getenv("SSH_CONNECTION") != "" {
+		d.Environment["ssh"] = "yes"
+	}
+	d.Log = m.trail
+	return d
+}
+
+// buildVersion is gofast's module version, or for a development build the
+// commit it was built from when known.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return "(devel) " + s.Value
+		}
+	}
+	return "(devel)"
+}
+
+// text lays the diagnostics out for the error screen's details view.
+func (d diagnostics) text() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "\033[1mError\033[0m\n  %s\n", d.Error.Message)
+	if d.Error.Endpoint != "" {
+		fmt.Fprintf(&s, "  at %s\n", d.Error.Endpoint)
+	}
+	fmt.Fprintf(&s, "  during %s, %.1fs into the run\n", d.Error.Phase, d.Error.Elapsed)
+	s.WriteString("\n\033[1mError chain\033[0m\n")
+	for i, link := range d.Error.Chain {
+		fmt.Fprintf(&s, "  %d. \033[90m%s\033[0m %s\n", i+1, link.Type, link.Message)
+	}
+	s.WriteString("\n\033[1mRun\033[0m\n")
+	fmt.Fprintf(&s, "  provider %s, profile %s", d.Run.Provider, d.Run.Profile)
+	if d.Run.Streams > 0 {
+		fmt.Fprintf(&s, ", %d streams", d.Run.Streams)
+	}
+	s.WriteString("\n")
+	if d.Run.Server != "" {
+		fmt.Fprintf(&s, "  server %s\n", d.Run.Server)
+	}
+	if d.Run.Interface != "" {
+		fmt.Fprintf(&s, "  interface %s\n", d.Run.Interface)
+	}
+	if d.Run.MaxBytes > 0 {
+		fmt.Fprintf(&s, "  --max-bytes %s\n", formatBytes(d.Run.MaxBytes))
+	}
+	if d.Run.MaxDuration > 0 {
+		fmt.Fprintf(&s, "  --max-duration %s\n", time.Duration(d.Run.MaxDuration*float64(time.Second)))
+	}
+	if len(d.Fallbacks) > 0 {
+		s.WriteString("\n\033[1mServer fallbacks\033[0m\n")
+		for _, f := range d.Fallbacks {
+			fmt.Fprintf(&s, "  %s: %s\n", f.Server, f.Reason)
+		}
+	}
+	if len(d.Warnings) > 0 {
+		s.WriteString("\n\033[1mWarnings\033[0m\n")
+		for _, w := range d.Warnings {
+			fmt.Fprintf(&s, "  %s \033[90m[%s]\033[0m\n", w.Message, w.Code)
+		}
+	}
+	s.WriteString("\n\033[1mEnvironment\033[0m\n")
+	fmt.Fprintf(&s, "  gofast %s, %s, %s\n", d.Environment["gofast"], d.Environment["go"], d.Environment["os"])
+	if len(d.Log) > 0 {
+		s.WriteString("\n\033[1mLog\033[0m\n")
+		for _, e := range d.Log {
+			fmt.Fprintf(&s, "  %6.1fs  %s\n", e.Elapsed, e.Event)
+		}
+	}
+	return strings.TrimSuffix(s.String(), "\n")
+}
+
+// errDetailsKeys scroll the details view; the error screen's own keys,
+// such as d and c, are left alone.
+var errDetailsKeys = viewport.KeyMap{
+	Up:       key.NewBinding(key.WithKeys("up", "k")),
+	Down:     key.NewBinding(key.WithKeys("down", "j")),
+	PageUp:   key.NewBinding(key.WithKeys("pgup")),
+	PageDown: key.NewBinding(key.WithKeys("pgdown", " ")),
+}
+
+// errDetailsHeight is the details view's height in a terminal height
+// rows tall, leaving room for the title and hints; zero while the height
+// is unknown shows a fixed 20 rows.
+func errDetailsHeight(height int) int {
+	if height == 0 {
+		return 20
+	}
+	return max(height-8, 5)
+}
+
+// toggleErrDetails opens the error's technical details in a scrollable
+// view, or closes them.
+func (m speedTest) toggleErrDetails() speedTest {
+	if m.errDetails != nil {
+		m.errDetails = nil
+		return m
+	}
+	vp := viewport.New(max(m.width, 40), errDetailsHeight(m.height))
+	vp.KeyMap = errDetailsKeys
+	vp.SetContent(m.diagnostics().text())
+	m.errDetails = &vp
+	return m
+}
+
+// writeDiagnostics saves the diagnostics bundle for a bug report. It goes
+// to gofast's cache directory, or where that can't be written, to the
+// working directory or the temporary directory.
+func (m speedTest) writeDiagnostics() tea.Cmd {
+	d := m.diagnostics()
+	return func() tea.Msg {
+		name := expandOutputPath(diagnosticsPath, d.Created)
+		var dirs []string
+		if cache, err := os.UserCacheDir(); err == nil {
+			dirs = append(dirs, filepath.Join(cache, "gofast"))
+		}
+		dirs = append(dirs, ".", os.TempDir())
+		var errs []error
+		for i, dir := range dirs {
+			path := filepath.Join(dir, name)
+			err := writeOutput(path, i == 0, func(w io.Writer) error {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(d)
+			})
+			if err == nil {
+				if abs, err := filepath.Abs(path); err == nil {
+					path = abs
+				}
+				return noticeMsg("Wrote diagnostics to " + path + " — attach it to a bug report")
+			}
+			errs = append(errs, err)
+		}
+		return noticeMsg(fmt.Sprintf("\033[31mCouldn't write the diagnostics: %v\033[0m", errors.Join(errs...)))
+	}
+}
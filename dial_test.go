@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// dialModel is a speedTest showing finished results, for rendering the
+// gauges alone.
+func dialModel(upload bool) speedTest {
+	return speedTest{caps: Capabilities{Upload: upload}, opts: viewOptions{PeakHold: true}}
+}
+
+func TestSingleDialGolden(t *testing.T) {
+	for _, mbps := range []float64{0, 0.8, 47.5, 940} {
+		got := sgrPattern.ReplaceAllString(dialModel(true).renderSpeedometer(mbps), "")
+		checkGolden(t, fmt.Sprintf("single-dial-%g", mbps), got)
+	}
+}
+
+func TestDualDialGolden(t *testing.T) {
+	tests := []struct {
+		name             string
+		upload           bool
+		download, up     float64
+		downPeak, upPeak float64
+	}{
+		{"dual-dial-idle", true, 0, 0, 0, 0},
+		{"dual-dial-95-12", true, 95, 12, 0, 0},
+		{"dual-dial-peaks", true, 60, 20, 80, 35},
+		{"dual-dial-no-upload", false, 420, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		m := dialModel(tt.upload)
+		m.downloadPeak, m.uploadPeak = tt.downPeak, tt.upPeak
+		got := sgrPattern.ReplaceAllString(m.renderDualSpeedometer(tt.download, tt.up), "")
+		checkGolden(t, tt.name, got)
+	}
+}
+
+// Both dials of the dual layout carry the tick labels, 0 to X.
+func TestDualDialTickLabels(t *testing.T) {
+	got := sgrPattern.ReplaceAllString(dialModel(true).renderDualSpeedometer(50, 20), "")
+	for _, label := range []string{"0", "5", "X"} {
+		if n := strings.Count(got, label); n < 2 {
+			t.Errorf("tick label %s drawn %d times, want once per dial", label, n)
+		}
+	}
+}
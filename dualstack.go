@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"gofast/gauge"
+)
+
+// familyDownloader is implemented by providers that can pin a download's
+// connections to one address family, network being "tcp4" or "tcp6", so
+// --dual-stack can run both side by side.
+type familyDownloader interface {
+	DownloadFamily(ctx context.Context, network string, m *meter) (Transfer, error)
+}
+
+// stackFamilies are the families --dual-stack measures, in gauge order.
+var stackFamilies = []string{"tcp4", "tcp6"}
+
+func familyName(network string) string {
+	if network == "tcp6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// DualStack is the download of a --dual-stack run split by address
+// family; Download holds the two combined.
+type DualStack struct {
+	IPv4 FamilyTransfer `json:"ipv4"`
+	IPv6 FamilyTransfer `json:"ipv6"`
+}
+
+// FamilyTransfer is one family's share of a dual-stack download. A family
+// that couldn't connect has an empty transfer whose source is unavailable,
+// and Unavailable says why.
+type FamilyTransfer struct {
+	Transfer
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+func (d *DualStack) family(network string) *FamilyTransfer {
+	if network == "tcp6" {
+		return &d.IPv6
+	}
+	return &d.IPv4
+}
+
+// familySpeedMsg is a live throughput sample of one family's streams.
+type familySpeedMsg struct {
+	network string
+	mbps    float64
+}
+
+// familyRoute reports whether this host has a route to the internet over
+// network's family. It sends nothing: "connecting" a UDP socket to a
+// documentation address only looks the route up.
+func familyRoute(network string) error {
+	probe := "192.0.2.1:9"
+	if network == "tcp6" {
+		probe = "[2001:db8::1]:9"
+	}
+	conn, err := net.Dial("udp"+strings.TrimPrefix(network, "tcp"), probe)
+	if err != nil {
+		return fmt.Errorf("no %s route", familyName(network))
+	}
+	conn.Close()
+	return nil
+}
+
+// newFamilyClient is newTransferClient with every connection pinned to
+// network, "tcp4" or "tcp6", however the server's name resolves.
+func newFamilyClient(m *meter, network string) *http.Client {
+	client := newTransferClient(m)
+	transport := client.Transport.(*transferTransport).tcp
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+	return client
+}
+
+// dualStackDownload runs an IPv4 and an IPv6 download at once, each with
+// its own meter, and combines them. Live samples go out per family and as
+// their sum. A family that fails is reported unavailable with a warning;
+// only both failing fails the run. Loaded latency is probed on the IPv4
+// transfer alone.
+func (e engine) dualStackDownload(ctx context.Context, fd familyDownloader, limit transferLimit, results *Results, emit func(tea.Msg)) (Transfer, error) {
+	stack := &DualStack{}
+	var (
+		mu     sync.Mutex
+		latest = map[string]float64{}
+		errs   = make([]error, len(stackFamilies))
+		wg     sync.WaitGroup
+	)
+	for i, network := range stackFamilies {
+		// The families share the byte budget.
+		familyLimit := limit
+		familyLimit.bytes = limit.bytes / int64(len(stackFamilies))
+		familyLimit.probe = limit.probe && i == 0
+		familyEmit := func(msg tea.Msg) {
+			if probe, ok := msg.(probeMsg); ok {
+				mu.Lock()
+				emit(probe)
+				mu.Unlock()
+				return
+			}
+			speed, ok := msg.(speedMsg)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			latest[network] = float64(speed)
+			sum := latest["tcp4"] + latest["tcp6"]
+			emit(familySpeedMsg{network: network, mbps: float64(speed)})
+			emit(speedMsg(sum))
+			mu.Unlock()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			download := func(ctx context.Context, m *meter) (Transfer, error) {
+				return fd.DownloadFamily(ctx, network, m)
+			}
+			t, err := e.transfer(ctx, "download-"+strings.ToLower(familyName(network)), download, familyLimit, familyEmit)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			stack.family(network).Transfer = t
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return Transfer{}, ctx.Err()
+	}
+
+	var available []Transfer
+	for i, network := range stackFamilies {
+		f := stack.family(network)
+		if errs[i] == nil {
+			available = append(available, f.Transfer)
+			continue
+		}
+		f.Transfer = Transfer{Source: provenanceUnavailable}
+		f.Unavailable = errs[i].Error()
+	}
+	if len(available) == 0 {
+		return Transfer{}, fmt.Errorf("dual-stack: neither family could download: %w", errors.Join(errs...))
+	}
+	for _, network := range stackFamilies {
+		if f := stack.family(network); f.Unavailable != "" {
+			warn(results, emit, warnFamilyUnavailable, "%s unavailable for the dual-stack download: %s", familyName(network), f.Unavailable)
+		}
+	}
+	results.DualStack = stack
+	return combineTransfers(available), nil
+}
+
+// combineTransfers adds up transfers that ran at the same time: their
+// rates and bytes sum, sample by sample.
+func combineTransfers(ts []Transfer) Transfer {
+	c := Transfer{Settled: len(ts) > 0}
+	for _, t := range ts {
+		c.Mbps += t.Mbps
+		c.AverageMbps += t.AverageMbps
+		c.SustainedMbps += t.SustainedMbps
+		c.Settled = c.Settled && t.Settled
+		c.Bytes += t.Bytes
+		c.WireBytes += t.WireBytes
+		c.Duration = max(c.Duration, t.Duration)
+		c.Capped = c.Capped || t.Capped
+		c.Stalls += t.Stalls
+		c.Streams += t.Streams
+		c.LatencySamples = append(c.LatencySamples, t.LatencySamples...)
+		c.Quality = append(c.Quality, t.Quality...)
+		c.Source = t.Source
+		c.SampleInterval = t.SampleInterval
+		for i, v := range t.Samples {
+			if i < len(c.Samples) {
+				c.Samples[i] += v
+			} else {
+				c.Samples = append(c.Samples, v)
+			}
+		}
+	}
+	if !c.Settled {
+		// A sum missing a family isn't the sustained rate of both.
+		c.SustainedMbps = 0
+	}
+	return c
+}
+
+// formatFamily is one family's figure for the details panel.
+func formatFamily(f FamilyTransfer, total float64) string {
+	if f.Unavailable != "" {
+		return "\033[90munavailable\033[0m (" + f.Unavailable + ")"
+	}
+	share := ""
+	if total > 0 {
+		share = fmt.Sprintf(", %.0f%% of the traffic", f.Mbps/total*100)
+	}
+	return formatSpeed(f.Mbps) + share
+}
+
+// dualStack reports whether this run downloads over both families at once.
+func (m speedTest) dualStack() bool {
+	_, ok := m.engine.provider.(familyDownloader)
+	return ok && m.engine.dualStack
+}
+
+// renderFamilyGauges is the dual gauge of a --dual-stack download: IPv4 on
+// the left and IPv6 on the right, on a shared scale.
+func (m speedTest) renderFamilyGauges() string {
+	var s strings.Builder
+	s.WriteString("     ╔═══════════════════════════════════════════════════════════════════════════════════════════════╗\n")
+	s.WriteString("     ║                                    goFast tui                                                  ║\n")
+	s.WriteString("     ║                          IPv4              download              IPv6                          ║\n")
+	s.WriteString("     ╚═══════════════════════════════════════════════════════════════════════════════════════════════╝\n")
+
+	v4, v6 := m.familySpeeds["tcp4"], m.familySpeeds["tcp6"]
+	scale := m.scale
+	if scale == 0 {
+		scale = gaugeScale(max(v4, v6))
+	}
+	left := gauge.New(gauge.Options{Max: scale, Size: gauge.Medium, Theme: gauge.DefaultTheme})
+	left.SetValue(v4)
+	right := gauge.New(gauge.Options{Max: scale, Size: gauge.Medium, Theme: gauge.DefaultTheme})
+	right.SetValue(v6)
+	leftRows, rightRows := left.Rows(), right.Rows()
+	for row := range leftRows {
+		s.WriteString("     " + leftRows[row] + rightRows[row] + "\n")
+	}
+	_, unit := axisLabels(scale)
+	axis := axisLine(scale)
+	s.WriteString(axis + axis + "\n")
+	s.WriteString(strings.Repeat(" ", 27) + unit + strings.Repeat(" ", max(53-len(unit), 1)) + unit + "\n")
+	s.WriteString("     " + gradeSpeed(v4).cue().mark("IPv4: "+formatGaugeSpeed(v4, scale)))
+	s.WriteString("                                 " + gradeSpeed(v6).cue().mark("IPv6: "+formatGaugeSpeed(v6, scale)) + "\n")
+	return s.String()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.uber.org/goleak"
+)
+
+// mockSpeedServer serves what the url provider needs: a ?bytes= download
+// generator, an upload endpoint that takes POST bodies, and tiny ranged
+// responses for its HTTP pings.
+func mockSpeedServer() *httptest.Server {
+	chunk := make([]byte, 32<<10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /download", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if r.Header.Get("Range") != "" {
+			n = min(n, 1)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		for n > 0 {
+			k, err := w.Write(chunk[:min(n, int64(len(chunk)))])
+			if err != nil {
+				return
+			}
+			n -= int64(k)
+		}
+	})
+	mux.HandleFunc("POST /upload", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	return httptest.NewServer(mux)
+}
+
+// headlessEngine is an engine against srv, with its transfers shrunk so a
+// run takes a second or two.
+func headlessEngine(srv *httptest.Server) engine {
+	prof, _ := findProfile("quick")
+	prof.Duration, prof.PingSamples, prof.LoadedLatency = 500*time.Millisecond, 5, false
+	popts := providerOptions{
+		Clock:       realClock{},
+		Rand:        newRand(1),
+		Duration:    prof.Duration,
+		PingSamples: prof.PingSamples,
+		Streams:     2,
+		DownloadURL: srv.URL + "/download?bytes=0",
+		UploadURL:   srv.URL + "/upload",
+	}
+	return engine{
+		provider: newURLProvider(popts),
+		profile:  prof,
+		clock:    realClock{},
+		fresh:    func() Provider { return newURLProvider(popts) },
+	}
+}
+
+// watchedModel passes everything on to a speedTest and reports each run it
+// finishes, a failed one with only its Error set.
+type watchedModel struct {
+	speedTest
+	completed chan<- Results
+}
+
+func (w watchedModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if ev, ok := msg.(engineEvent); ok {
+		if r, ok := ev.msg.(completeMsg); ok {
+			w.completed <- Results(r)
+		}
+		if err, ok := ev.msg.(errorMsg); ok {
+			w.completed <- Results{Error: error(err).Error()}
+		}
+	}
+	m, cmd := w.speedTest.Update(msg)
+	if st, ok := m.(speedTest); ok {
+		w.speedTest = st
+		return w, cmd
+	}
+	return m, cmd
+}
+
+// syncBuffer is a bytes.Buffer the program can write frames to while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestHeadlessRun(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv := mockSpeedServer()
+	defer srv.Close()
+	completed := make(chan Results, 4)
+	model := watchedModel{speedTest: initialModel(headlessEngine(srv), viewOptions{FPS: 30, NoMenu: true}), completed: completed}
+	var out syncBuffer
+	p := tea.NewProgram(model, tea.WithInput(strings.NewReader("")), tea.WithOutput(&out), tea.WithoutSignalHandler())
+	finished := make(chan tea.Model, 1)
+	go func() {
+		final, err := p.Run()
+		if err != nil {
+			t.Errorf("program: %v", err)
+		}
+		finished <- final
+	}()
+
+	wait := func(what string) Results {
+		t.Helper()
+		select {
+		case r := <-completed:
+			return r
+		case <-time.After(8 * time.Second):
+			p.Kill()
+			t.Fatalf("no %s within 8s", what)
+		}
+		return Results{}
+	}
+
+	first := wait("first run")
+	p.Send(tea.WindowSizeMsg{Width: 72, Height: 40})
+	p.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	second := wait("restarted run")
+	p.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	final := (<-finished).(watchedModel)
+
+	for _, r := range []Results{first, second} {
+		if r.Error != "" {
+			t.Fatalf("run failed: %s", r.Error)
+		}
+		if r.Download == nil || r.Download.Mbps <= 0 || r.Upload == nil || r.Upload.Mbps <= 0 {
+			t.Fatalf("run missing a transfer: download %+v, upload %+v", r.Download, r.Upload)
+		}
+	}
+	if len(final.session) != 2 {
+		t.Errorf("session holds %d runs, want 2", len(final.session))
+	}
+	if final.width != 72 {
+		t.Errorf("width after resize = %d, want 72", final.width)
+	}
+	if out.Len() == 0 {
+		t.Error("the program wrote no frames")
+	}
+	frame := sgrPattern.ReplaceAllString(final.View(), "")
+	for _, want := range []string{
+		formatSpeed(second.Download.Mbps),
+		formatSpeed(second.Upload.Mbps),
+		strconv.FormatFloat(second.Latency.P50, 'f', 1, 64) + " ms",
+	} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("final frame lacks %q:\n%s", want, frame)
+		}
+	}
+}
@@ -0,0 +1,865 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// sampleInterval is how often the engine turns byte counts into live speed
+// samples during a transfer phase.
+const sampleInterval = 200 * time.Millisecond
+
+// meter counts bytes moved by a transfer. Providers may add to it from any
+// number of goroutines; the engine's sampler reads it concurrently. Payload
+// bytes are what the provider read or wrote; wire bytes are what crossed the
+// socket, when the provider's connections are counted.
+type meter struct {
+	bytes  atomic.Int64
+	wire   atomic.Int64
+	stalls atomic.Int64
+	// ipv4 and ipv6 count the connections dialed over each family.
+	ipv4, ipv6 atomic.Int64
+
+	// limit, when set, has stop called once the bytes reach it, so a byte
+	// budget ends the transfer right away rather than at the next sample.
+	limit int64
+	stop  func()
+
+	mu      sync.Mutex
+	records []transferRecord
+	// protocols are the HTTP versions responses came over.
+	protocols []string
+}
+
+func (m *meter) Add(n int64) {
+	if total := m.bytes.Add(n); m.limit > 0 && total >= m.limit {
+		m.stop()
+	}
+}
+
+func (m *meter) Bytes() int64 {
+	return m.bytes.Load()
+}
+
+func (m *meter) AddWire(n int64) {
+	m.wire.Add(n)
+}
+
+func (m *meter) WireBytes() int64 {
+	return m.wire.Load()
+}
+
+// AddStall counts a connection torn down for making no progress.
+func (m *meter) AddStall() {
+	m.stalls.Add(1)
+}
+
+func (m *meter) Stalls() int64 {
+	return m.stalls.Load()
+}
+
+// AddConn counts a connection by the family of its remote address.
+func (m *meter) AddConn(c net.Conn) {
+	m.AddRemote(c.RemoteAddr())
+}
+
+// AddRemote counts a connection to addr by its family, for connections
+// that aren't a net.Conn, such as QUIC's.
+func (m *meter) AddRemote(addr net.Addr) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	if ip != nil && ip.To4() == nil {
+		m.ipv6.Add(1)
+	} else {
+		m.ipv4.Add(1)
+	}
+}
+
+// Family names the address family the connections used: "IPv4", "IPv6",
+// "IPv4 and IPv6" when they were mixed, or "" when none were counted.
+func (m *meter) Family() string {
+	switch v4, v6 := m.ipv4.Load() > 0, m.ipv6.Load() > 0; {
+	case v4 && v6:
+		return "IPv4 and IPv6"
+	case v6:
+		return "IPv6"
+	case v4:
+		return "IPv4"
+	}
+	return ""
+}
+
+// AddProtocol notes that a response came over protocol, e.g. "HTTP/2".
+func (m *meter) AddProtocol(protocol string) {
+	m.mu.Lock()
+	if !slices.Contains(m.protocols, protocol) {
+		m.protocols = append(m.protocols, protocol)
+		slices.Sort(m.protocols)
+	}
+	m.mu.Unlock()
+}
+
+// Protocol names the HTTP versions the responses came over, e.g. "HTTP/2",
+// or "HTTP/1.1 and HTTP/2" when they were mixed, or "" when none were
+// counted.
+func (m *meter) Protocol() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(m.protocols, " and ")
+}
+
+// Record keeps a response's record for the data-quality checks.
+func (m *meter) Record(r transferRecord) {
+	m.mu.Lock()
+	if len(m.records) < recordRetention {
+		m.records = append(m.records, r)
+	}
+	m.mu.Unlock()
+}
+
+func (m *meter) Records() []transferRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.records)
+}
+
+// sampleStore holds the live speed samples of one phase. Snapshot returns a
+// copy so callers never share the backing array with the sampler.
+type sampleStore struct {
+	mu      sync.Mutex
+	samples []float64
+	// stride is how many samples added make up each one kept, doubled by
+	// every merge; pending sums the added ones towards the next.
+	stride  int
+	pending float64
+	added   int
+}
+
+// sampleRetention bounds the samples kept per transfer, 13 minutes at
+// sampleInterval. Past it neighbouring samples are averaged, halving the
+// resolution, so an unbounded transfer keeps its shape in constant memory.
+// Samples added after a merge are averaged to the same resolution, so the
+// kept ones stay evenly spaced.
+const sampleRetention = 4096
+
+func (s *sampleStore) Add(mbps float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stride == 0 {
+		s.stride = 1
+	}
+	s.pending, s.added = s.pending+mbps, s.added+1
+	if s.added < s.stride {
+		return
+	}
+	s.samples = append(s.samples, s.pending/float64(s.stride))
+	s.pending, s.added = 0, 0
+	if len(s.samples) > sampleRetention {
+		merged := s.samples[:0]
+		for i := 0; i+1 < len(s.samples); i += 2 {
+			merged = append(merged, (s.samples[i]+s.samples[i+1])/2)
+		}
+		if len(s.samples)%2 == 1 {
+			// The odd one out starts the next merged sample.
+			s.pending, s.added = s.samples[len(s.samples)-1]*float64(s.stride), s.stride
+		}
+		s.samples, s.stride = merged, s.stride*2
+	}
+}
+
+func (s *sampleStore) Snapshot() []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]float64(nil), s.samples...)
+}
+
+// Interval is the time each kept sample covers when samples are added
+// every base.
+func (s *sampleStore) Interval(base time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return base * time.Duration(max(s.stride, 1))
+}
+
+// engine runs a provider's phases in order. It owns the Results being built
+// and only hands out copies, so the TUI never shares state with it.
+type engine struct {
+	provider Provider
+	profile  profile
+	clock    Clock
+
+	// history, when set, receives every completed run and supplies the
+	// baseline for regression detection.
+	history    *historyStore
+	regression regressionPolicy
+	// retest confirms results far outside the usual range; pair is set on
+	// the engine running such a confirmation.
+	retest retestPolicy
+	pair   *Retest
+	// percent resolves the baseline echoed with results as Expected.
+	percent percentPolicy
+
+	compressible bool
+	// maxDuration, when set, is a hard ceiling on the whole run.
+	maxDuration time.Duration
+	// maxBytes, when set, caps the data download and upload move together.
+	maxBytes int64
+	// notify, when set, sees every message the run produces, including
+	// its start and its completeMsg or errorMsg.
+	notify func(tea.Msg)
+	// sinks receive the results of every completed run.
+	sinks []configuredSink
+	// avoidServer, when set, names a server to try only after every other
+	// candidate.
+	avoidServer string
+	// preferServer, when set, names a server to try before every other
+	// candidate.
+	preferServer string
+	// sticky, when set, keeps runs on the server of the last one, as for
+	// scheduled runs.
+	sticky *stickyPolicy
+	// processStats adds gofast's own CPU and memory use to the results.
+	processStats bool
+	// environment holds problems with the system itself, such as a
+	// container without CA certificates, raised as warnings on every run.
+	environment []Warning
+	// envReport checks the local environment before every run and keeps
+	// the report in the results.
+	envReport bool
+	// dualStack downloads over IPv4 and IPv6 at once, when the provider
+	// can pin connections to a family.
+	dualStack bool
+	// family, "tcp4" or "tcp6", pins every connection of the run to one
+	// address family, with --ipv4 or --ipv6.
+	family string
+	// http3 runs the transfers over HTTP/3 when the server answers QUIC,
+	// with --http3.
+	http3 bool
+	// servers, above one, downloads from that many of the best candidate
+	// servers in turn and reports their mean, with --servers.
+	servers int
+	// webCheck, when set, times a fresh connection to each of these URLs
+	// after the test, for the web responsiveness figure.
+	webCheck []string
+	// note is stored with every run's results.
+	note string
+	// privacy withholds what the config's [privacy] section says from
+	// everything the engine emits, records or returns.
+	privacy privacy
+	// withStreams, when set, builds the same provider with a different
+	// number of streams, for the troubleshooter's comparison.
+	withStreams func(streams int) Provider
+	// sampleCSV, when set, receives every raw sample as it is taken;
+	// includeSamples also keeps them in the results.
+	sampleCSV      *sampleCSV
+	includeSamples bool
+	// log is the current run's sampleLog, nil when samples aren't exported.
+	log *sampleLog
+	// perf times the phases for --perf-report; nil records nothing.
+	perf *perfRecorder
+	// iface, when set, binds every connection of the run to this network
+	// interface; fresh builds another instance of the provider, so each
+	// interface's run starts without what another's cached.
+	iface string
+	fresh func() Provider
+}
+
+// probeInterval spaces loaded-latency probes during a transfer.
+const probeInterval = 250 * time.Millisecond
+
+// phases lists the phases this engine will run, in order.
+func (e engine) phases() []phase {
+	phases := []phase{phaseInit, phasePing, phaseDownloading}
+	if e.provider.Capabilities().Upload {
+		phases = append(phases, phaseUploading)
+	}
+	return phases
+}
+
+// prober returns the provider's latency prober if this run should measure
+// latency under load.
+func (e engine) prober() (latencyProber, bool) {
+	if !e.profile.LoadedLatency || !e.provider.Capabilities().LoadedLatency {
+		return nil, false
+	}
+	p, ok := e.provider.(latencyProber)
+	return p, ok
+}
+
+// errAborted replaces the context error of a run that was cancelled on
+// request.
+var errAborted = errors.New("test aborted")
+
+// errInterrupted cancels a headless run on a first interrupt: the phase
+// under way stops with what it has measured, and the run ends with partial
+// results.
+var errInterrupted = errors.New("interrupted")
+
+// errCapped cancels a transfer that has used up its byte budget.
+var errCapped = errors.New("transfer byte budget exhausted")
+
+// engineEvent wraps a message produced by a running engine together with the
+// channel it came from, so the model can keep draining that channel.
+type engineEvent struct {
+	msg    tea.Msg
+	events <-chan tea.Msg
+}
+
+func waitForEvent(events <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-events
+		if !ok {
+			return nil
+		}
+		return engineEvent{msg: msg, events: events}
+	}
+}
+
+// engineRuns tracks the goroutines started by runSpeedTestCmd. Closing
+// engineShutdown tells them nobody reads their events any more.
+var (
+	engineRuns     sync.WaitGroup
+	engineShutdown = make(chan struct{})
+)
+
+// runSpeedTestCmd starts an engine on its own goroutine and returns the
+// command that delivers its first event.
+func runSpeedTestCmd(ctx context.Context, e engine) tea.Cmd {
+	events := make(chan tea.Msg)
+	send := func(msg tea.Msg) {
+		select {
+		case events <- msg:
+		case <-engineShutdown:
+		}
+	}
+
+	engineRuns.Add(1)
+	go func() {
+		defer engineRuns.Done()
+		defer close(events)
+		results, err := e.run(ctx, send)
+		if err != nil {
+			send(errorMsg(err))
+			return
+		}
+		send(completeMsg(results))
+	}()
+
+	return waitForEvent(events)
+}
+
+// drainEngines waits up to timeout for every engine started by
+// runSpeedTestCmd to return, once the program has stopped reading their
+// events and cancelled their contexts. It reports whether they all did.
+func drainEngines(timeout time.Duration) bool {
+	close(engineShutdown)
+	done := make(chan struct{})
+	go func() {
+		engineRuns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// run executes every phase the provider supports. emit, when non-nil,
+// receives progress messages; it is called from the engine goroutine only.
+func (e engine) run(ctx context.Context, emit func(tea.Msg)) (results Results, err error) {
+	if emit == nil {
+		emit = func(tea.Msg) {}
+	}
+	if e.notify != nil {
+		e.notify(runStartedMsg{})
+		defer func() {
+			if err != nil {
+				e.notify(errorMsg(err))
+			} else {
+				e.notify(completeMsg(results))
+			}
+		}()
+		inner := emit
+		emit = func(msg tea.Msg) {
+			e.notify(msg)
+			inner(msg)
+		}
+	}
+	// Deferred after notify's, so it runs first and what notify sees of a
+	// failed run is redacted too.
+	defer func() { results = e.privacy.redact(results) }()
+
+	results = newResults(e.provider, e.profile, e.clock)
+	results.Note = e.note
+	results.Interface = e.iface
+	ctx = withInterface(ctx, e.iface)
+	if e.family != "" {
+		results.Family = familyName(e.family)
+		ctx = withFamily(ctx, e.family)
+		if err := checkFamily(e.family); err != nil {
+			return results, err
+		}
+	}
+	if e.http3 {
+		results.Protocol = "HTTP/3"
+	}
+	results.Client.Hostname, _ = os.Hostname()
+	if e.pair != nil {
+		pair := *e.pair
+		results.Retest = &pair
+	}
+	start := e.clock.Now()
+	if e.sampleCSV != nil || e.includeSamples {
+		e.log = &sampleLog{run: results.Timestamp, start: start, clock: e.clock, csv: e.sampleCSV, keep: e.includeSamples}
+	}
+	usage := markUsage()
+	if results.TLSUnverified {
+		warn(&results, emit, warnTLSUnverified, "TLS certificates aren't verified (--insecure-skip-verify)")
+	}
+	for _, w := range e.environment {
+		warn(&results, emit, w.Code, "%s", w.Message)
+	}
+	if e.envReport {
+		done := e.perf.phase("environment")
+		results.Environment = envReport(ctx, e.clock)
+		done()
+	}
+	b := newBudget(e.clock, e.maxDuration)
+	if b.limited() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, b.deadline)
+		defer cancel()
+	}
+	results, err = e.runPhases(ctx, b, results, emit)
+	results.BudgetCuts = b.cuts
+	results.Samples = e.log.samples()
+	if errors.Is(context.Cause(ctx), errInterrupted) {
+		// Partial results are reported but never stored in history.
+		results.Partial = true
+		results.Duration = e.clock.Since(start)
+		return results, errInterrupted
+	}
+	if err != nil {
+		err = familyFailure(e.family, err)
+		switch {
+		case b.limited() && errors.Is(err, context.DeadlineExceeded):
+			err = fmt.Errorf("run exceeded --max-duration %s: %w", e.maxDuration, err)
+		case errors.Is(err, context.Canceled):
+			err = errAborted
+		}
+		return results, err
+	}
+	results.Duration = e.clock.Since(start)
+	if tracer, ok := e.provider.(connectionTracer); ok {
+		if b.limited() && b.remaining() < webCheckTimeout {
+			b.cut("connection breakdown skipped")
+			results.BudgetCuts = b.cuts
+		} else {
+			done := e.perf.phase("connection breakdown")
+			results.Connection = traceConnection(ctx, e.clock, tracer)
+			results.TLS = measureTLSHandshake(ctx, e.clock, tracer)
+			done()
+		}
+	}
+	if len(e.webCheck) > 0 {
+		if b.limited() && b.remaining() < webCheckTimeout {
+			b.cut("web responsiveness check skipped")
+			results.BudgetCuts = b.cuts
+		} else {
+			emit(webCheckMsg{})
+			done := e.perf.phase("web check")
+			results.Web = runWebCheck(ctx, e.clock, e.webCheck)
+			done()
+		}
+	}
+	if u := usage.since(); u != nil {
+		results.CPUBound = u.CPUBound()
+		if results.CPUBound {
+			warn(&results, emit, warnCPUBound, "gofast kept a core busy (%.0f%% CPU); this machine may have limited the result", u.CPUPercent)
+		}
+		if e.processStats {
+			results.Process = u
+		}
+	}
+	if jump := clockJump(e.clock, start); jump > clockJumpThreshold {
+		warn(&results, emit, warnClockJump, "the system clock was adjusted by %s during the run; the timestamp may be off", jump.Round(time.Millisecond))
+	}
+	results = e.privacy.redact(results)
+	done := e.perf.phase("history")
+	e.record(&results)
+	done()
+	if results.Retest != nil && results.Retest.Role == retestOutlier {
+		results = e.confirm(ctx, emit, results)
+	}
+	return results, nil
+}
+
+func (e engine) runPhases(ctx context.Context, b *budget, results Results, emit func(tea.Msg)) (Results, error) {
+	done := e.perf.phase("server")
+	var pick serverPick
+	err := e.retryPhase(ctx, phaseInit, "server lookup", &results, emit, func() (err error) {
+		pick, err = e.selectServer(ctx, emit)
+		return err
+	})
+	done()
+	results.Server, results.Fallbacks, results.ServerRace = pick.Server, pick.Fallbacks, pick.Race
+	results.ServerChoice, results.ServerChange = pick.Choice, pick.Change
+	legs := append([]Server{pick.Server}, pick.Others...)
+	if err != nil {
+		return results, err
+	}
+	if len(results.Fallbacks) > 0 {
+		emit(fallbackMsg(results.Fallbacks))
+		warn(&results, emit, warnServerFallback, "%s", fallbackNote(results.Fallbacks, results.Server))
+	}
+	emit(serverMsg(e.privacy.redactServer(results.Server)))
+	if results.Server.LocationSource == provenanceUnavailable {
+		warn(&results, emit, warnLocationUnavailable, "the server's location couldn't be looked up")
+	}
+	if e.servers > 1 && len(legs) < e.servers {
+		warn(&results, emit, warnFewerServers, "only %d of the %d servers asked for with --servers could be used", len(legs), e.servers)
+	}
+	if e.http3 {
+		ctx = e.http3Context(ctx, &results, emit)
+	}
+	// The client lookup runs alongside the idle pings, so taking enough
+	// samples for a jitter figure costs no time on top of it.
+	client := make(chan Client, 1)
+	go func() {
+		defer close(client)
+		if ci, ok := e.provider.(clientIdentifier); ok {
+			if c, err := ci.Client(ctx); err == nil {
+				client <- c
+			}
+		}
+	}()
+
+	done = e.perf.phase("ping")
+	err = e.retryPhase(ctx, phasePing, "ping", &results, emit, func() (err error) {
+		results.Latency, err = e.provider.Ping(withPingProgress(ctx, func(s pingSampleMsg) { emit(s) }))
+		return err
+	})
+	done()
+	if c, ok := <-client; ok {
+		c.Hostname = results.Client.Hostname
+		results.Client = c
+	}
+	if err != nil {
+		return results, err
+	}
+	if results.Latency.Source == "" {
+		results.Latency.Source = provenanceMeasured
+	}
+	// Providers report the idle pings together, so they share a timestamp.
+	for _, rtt := range results.Latency.Samples {
+		e.log.add("ping", metricRTT, rtt)
+	}
+	done = e.perf.phase("loss")
+	if loss, method, ok := e.measureLoss(ctx); ok {
+		results.Loss, results.LossMethod = &loss, method
+		emit(lossMsg(loss))
+	}
+	done()
+	emit(pingMsg(results.Latency))
+
+	full := e.profile.Duration
+	if full == 0 {
+		full = nominalTransfer
+	}
+	plan, err := b.plan(full, e.provider.Capabilities().Upload)
+	if err != nil {
+		return results, err
+	}
+
+	legs = fitLegs(b, legs, plan.download)
+	share, fits := e.downloadShare(plan.upload)
+	if plan.upload && !fits {
+		plan.upload = false
+		warn(&results, emit, warnDataCapped, "upload skipped: --max-bytes %s is too little to share with the download", formatBytes(e.maxBytes))
+	}
+	limit := transferLimit{bytes: tighterCap(e.profile.MaxDownload, share), time: plan.download, probe: plan.probe}
+	var download Transfer
+	done = e.perf.phase("download")
+	err = e.retryPhase(ctx, phaseDownloading, "download", &results, emit, func() error {
+		selector, multi := e.provider.(serverSelector)
+		if fd, ok := e.provider.(familyDownloader); ok && e.dualStack {
+			download, err = e.dualStackDownload(ctx, fd, limit, &results, emit)
+		} else if multi && len(legs) > 1 {
+			download, err = e.multiServerDownload(ctx, selector, legs, limit, full, &results, emit)
+		} else {
+			download, err = e.transfer(ctx, "download", e.provider.Download, limit, emit)
+		}
+		return err
+	})
+	done()
+	if err != nil {
+		return results, err
+	}
+	results.Download = &download
+	emit(downloadMsg(download))
+	warnStalls(&results, emit, "download", download)
+	warnQuality(&results, emit, "download", download)
+	warnDataCap(&results, emit, "download", download, share)
+	if ctx.Err() != nil {
+		return results, context.Cause(ctx)
+	}
+
+	if plan.upload && e.maxBytes > 0 {
+		if share = e.maxBytes - download.Bytes; share < minCappedUpload {
+			plan.upload = false
+			warn(&results, emit, warnDataCapped, "upload skipped: the download used the --max-bytes budget")
+		}
+	}
+	if plan.upload {
+		if window, ok := b.uploadWindow(full); ok {
+			done := e.perf.phase("upload")
+			var upload Transfer
+			err := e.retryPhase(ctx, phaseUploading, "upload", &results, emit, func() (err error) {
+				upload, err = e.transfer(ctx, "upload", e.provider.Upload, transferLimit{bytes: tighterCap(e.profile.MaxUpload, share), time: window, probe: plan.probe}, emit)
+				return err
+			})
+			done()
+			if err != nil {
+				return results, err
+			}
+			upload.Compressible = e.compressible
+			results.Upload = &upload
+			emit(uploadMsg(upload))
+			warnStalls(&results, emit, "upload", upload)
+			warnQuality(&results, emit, "upload", upload)
+			warnDataCap(&results, emit, "upload", upload, share)
+		}
+	}
+
+	results.LoadedLatency = loadedLatency(results.Download, results.Upload)
+	if results.LoadedLatency != nil {
+		// Probes go through the same method as the idle ping.
+		results.LoadedLatency.Method = results.Latency.Method
+	}
+	results.Bufferbloat = measureBufferbloat(results.Latency, results.LoadedLatency)
+	return results, nil
+}
+
+func warnStalls(r *Results, emit func(tea.Msg), direction string, t Transfer) {
+	switch {
+	case t.Stalls == 1:
+		warn(r, emit, warnStreamRestarted, "%s: a connection stalled and was restarted", direction)
+	case t.Stalls > 1:
+		warn(r, emit, warnStreamRestarted, "%s: %d connections stalled and were restarted", direction, t.Stalls)
+	}
+}
+
+// record compares results against history and appends them to it. History
+// is best effort: a store that can't be read or written never fails a run.
+func (e engine) record(results *Results) {
+	if e.history == nil {
+		results.Expected = e.percent.expected(results.Provider, nil)
+		return
+	}
+	if past, err := e.history.Load(); err == nil {
+		// Each interface is its own link, compared only with itself.
+		past = slices.DeleteFunc(past, func(r Results) bool { return r.Interface != results.Interface })
+		results.Expected = e.percent.expected(results.Provider, past)
+		results.Regression = detectRegression(*results, past, e.regression)
+		results.CompressionSuspected = compressionSuspected(*results, past)
+		if hints := expiryHints(results.Timestamp, hourlyDownload(past, results.Provider)); len(hints) > 0 {
+			results.Context = &RunContext{Hints: hints}
+		}
+		if baseline, ok := e.retest.suspect(*results, past, e.regression); ok {
+			results.Retest = &Retest{Role: retestOutlier, BaselineMbps: baseline}
+		}
+	}
+	_ = e.history.Append(*results)
+}
+
+// transferLimit bounds one transfer. Zero bytes or time means unlimited;
+// probe allows loaded-latency probing when the profile asks for it.
+type transferLimit struct {
+	bytes int64
+	time  time.Duration
+	probe bool
+}
+
+type transferResult struct {
+	t   Transfer
+	err error
+}
+
+// transfer runs one direction while a sampler goroutine converts the meter's
+// byte count into speedMsg samples and stops the transfer once it has moved
+// its byte or time limit, if set. The sampler has exited by the time transfer
+// returns.
+func (e engine) transfer(ctx context.Context, direction string, fn func(context.Context, *meter) (Transfer, error), limit transferLimit, emit func(tea.Msg)) (Transfer, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	window := e.profile.Duration
+	if window == 0 {
+		window = nominalTransfer
+	}
+	if limit.time > 0 {
+		window = min(window, limit.time)
+	}
+	emit(transferWindowMsg(window))
+
+	var m meter
+	m.limit, m.stop = limit.bytes, func() { cancel(errCapped) }
+	var store sampleStore
+	start := e.clock.Now()
+	samples := make(chan float64)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(samples)
+		last, lastAt := m.Bytes(), e.clock.Since(start)
+		for {
+			select {
+			case <-done:
+				return
+			case <-e.clock.After(sampleInterval):
+			}
+			// Rates divide by the monotonic time between ticks rather
+			// than the interval asked for or wall-clock readings, so a
+			// late tick or an NTP step mid-test can't skew them.
+			bytes, at := m.Bytes(), e.clock.Since(start)
+			interval := at - lastAt
+			if interval <= 0 {
+				interval = sampleInterval
+			}
+			mbps := float64(bytes-last) * 8 / 1e6 / interval.Seconds()
+			last, lastAt = bytes, at
+			store.Add(mbps)
+			e.log.add(direction, metricThroughput, mbps)
+			if limit.bytes > 0 && bytes >= limit.bytes {
+				cancel(errCapped)
+			}
+			if limit.time > 0 && e.clock.Since(start) >= limit.time {
+				cancel(errCapped)
+			}
+			select {
+			case samples <- mbps:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var probes sampleStore
+	rtts := make(chan float64)
+	// A probe still in flight when the transfer ends is cut off rather
+	// than waited for.
+	probeCtx, stopProbes := context.WithCancel(ctx)
+	defer stopProbes()
+	if prober, ok := e.prober(); ok && limit.probe {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case <-e.clock.After(probeInterval):
+				}
+				if rtt, err := prober.Probe(probeCtx); err == nil {
+					probes.Add(rtt)
+					e.log.add(direction, metricRTT, rtt)
+					select {
+					case rtts <- rtt:
+					case <-done:
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	result := make(chan transferResult, 1)
+	go func() {
+		t, err := fn(ctx, &m)
+		result <- transferResult{t, err}
+	}()
+
+	for {
+		select {
+		case mbps, ok := <-samples:
+			if ok {
+				emit(speedMsg(mbps))
+			}
+		case rtt := <-rtts:
+			emit(probeMsg(rtt))
+		case r := <-result:
+			close(done)
+			stopProbes()
+			wg.Wait()
+			cause := context.Cause(ctx)
+			if r.err != nil && (errors.Is(cause, errCapped) || errors.Is(cause, errInterrupted)) {
+				elapsed := e.clock.Since(start)
+				r.t = Transfer{
+					Bytes:    m.Bytes(),
+					Duration: elapsed,
+					Capped:   errors.Is(cause, errCapped),
+				}
+				// A cap can be hit before the clock moves; the rate is
+				// then unknown rather than infinite.
+				if elapsed > 0 {
+					r.t.Mbps = float64(m.Bytes()) * 8 / 1e6 / elapsed.Seconds()
+				}
+			} else if r.err != nil {
+				return Transfer{}, r.err
+			}
+			r.t.Samples, r.t.SampleInterval = store.Snapshot(), store.Interval(sampleInterval)
+			settleRate(&r.t)
+			r.t.LatencySamples = probes.Snapshot()
+			r.t.WireBytes = m.WireBytes()
+			r.t.Stalls = int(m.Stalls())
+			r.t.Family = m.Family()
+			r.t.Protocol = m.Protocol()
+			r.t.Quality = checkDataQuality(r.t, m.Records())
+			if r.t.Source == "" {
+				r.t.Source = provenanceMeasured
+				if e.provider.Capabilities().Simulated {
+					r.t.Source = provenanceSimulated
+				}
+			}
+			return r.t, nil
+		}
+	}
+}
+
+// loadedLatency summarizes the latency probed during the transfers, or nil
+// when none was probed.
+func loadedLatency(transfers ...*Transfer) *Latency {
+	var samples []float64
+	for _, t := range transfers {
+		if t != nil {
+			samples = append(samples, t.LatencySamples...)
+		}
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	latency := summarizeLatency(samples)
+	latency.Samples = nil
+	latency.Source = provenanceMeasured
+	return &latency
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// jumpClock is the real clock with a wall time that can be stepped, as NTP
+// does. Go can't make a time.Time with a stepped wall and the true
+// monotonic reading, so it remembers the monotonic reading behind every
+// time it hands out and Since measures from that.
+type jumpClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+	mono   map[time.Time]time.Time
+}
+
+func (c *jumpClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	real := time.Now()
+	wall := real.Round(0).Add(c.offset)
+	if c.mono == nil {
+		c.mono = map[time.Time]time.Time{}
+	}
+	c.mono[wall] = real
+	return wall
+}
+
+func (c *jumpClock) Since(t time.Time) time.Duration {
+	c.mu.Lock()
+	real, ok := c.mono[t]
+	c.mu.Unlock()
+	if !ok {
+		return c.Now().Sub(t)
+	}
+	return time.Since(real)
+}
+
+func (c *jumpClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (c *jumpClock) step(d time.Duration) {
+	c.mu.Lock()
+	c.offset += d
+	c.mu.Unlock()
+}
+
+// steadyTransfer feeds m at mbps until cancelled.
+func steadyTransfer(mbps float64) func(context.Context, *meter) (Transfer, error) {
+	return func(ctx context.Context, m *meter) (Transfer, error) {
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return Transfer{}, ctx.Err()
+			case now := <-time.After(5 * time.Millisecond):
+				m.Add(int64(mbps * 1e6 / 8 * now.Sub(last).Seconds()))
+				last = now
+			}
+		}
+	}
+}
+
+func TestWallClockJumpLeavesSpeeds(t *testing.T) {
+	for _, step := range []time.Duration{-time.Hour, time.Hour} {
+		t.Run(step.String(), func(t *testing.T) {
+			checkClockStep(t, step)
+		})
+	}
+}
+
+func checkClockStep(t *testing.T, step time.Duration) {
+	clock := &jumpClock{}
+	e := engine{provider: demoProvider{}, clock: clock, profile: profile{Duration: 1500 * time.Millisecond}}
+	e.log = &sampleLog{start: clock.Now(), clock: clock, keep: true}
+	start := clock.Now()
+	time.AfterFunc(700*time.Millisecond, func() { clock.step(step) })
+
+	tr, err := e.transfer(context.Background(), "download", steadyTransfer(80), transferLimit{time: 1500 * time.Millisecond}, func(tea.Msg) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Duration < time.Second || tr.Duration > 3*time.Second {
+		t.Errorf("transfer took %v across the step, want about 1.5s", tr.Duration)
+	}
+	if math.Abs(tr.Mbps-80) > 16 {
+		t.Errorf("transfer rate %.1f Mbps across the step, want about 80", tr.Mbps)
+	}
+	if len(tr.Samples) < 5 {
+		t.Fatalf("%d samples, want one every %v", len(tr.Samples), sampleInterval)
+	}
+	for i, mbps := range tr.Samples {
+		if mbps < 40 || mbps > 120 {
+			t.Errorf("sample %d is %.1f Mbps, want about 80", i, mbps)
+		}
+	}
+
+	samples := e.log.samples()
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Elapsed <= samples[i-1].Elapsed {
+			t.Errorf("elapsed_s went from %.3f to %.3f", samples[i-1].Elapsed, samples[i].Elapsed)
+		}
+	}
+	if jump := clockJump(clock, start); jump < time.Hour-time.Second || jump > time.Hour+time.Second {
+		t.Errorf("clockJump = %v, want about 1h", jump)
+	}
+}
+
+// frozenClock never moves and never fires.
+type frozenClock struct{ at time.Time }
+
+func (c frozenClock) Now() time.Time                  { return c.at }
+func (c frozenClock) Since(t time.Time) time.Duration { return c.at.Sub(t) }
+func (frozenClock) After(time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+func TestTransferCappedInstantly(t *testing.T) {
+	e := engine{provider: demoProvider{}, clock: frozenClock{at: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}}
+	burst := func(ctx context.Context, m *meter) (Transfer, error) {
+		m.Add(1 << 20)
+		<-ctx.Done()
+		return Transfer{}, ctx.Err()
+	}
+	tr, err := e.transfer(context.Background(), "download", burst, transferLimit{bytes: 1 << 20}, func(tea.Msg) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Capped || tr.Bytes != 1<<20 || tr.Duration != 0 {
+		t.Errorf("transfer %+v, want capped at 1 MiB in no time", tr)
+	}
+	if tr.Mbps != 0 || tr.AverageMbps != 0 {
+		t.Errorf("rate %g Mbps (average %g) over no time, want 0", tr.Mbps, tr.AverageMbps)
+	}
+	if _, err := json.Marshal(tr); err != nil {
+		t.Errorf("capped transfer doesn't encode: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvItem is one line of the environment report: something about this
+// machine or network that can colour a result. Concern marks the ones
+// worth a look before trusting it.
+type EnvItem struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Concern bool   `json:"concern,omitempty"`
+}
+
+// envCheck is one item of the environment report. A check that can't run
+// on this platform returns errNotApplicable and is left out.
+type envCheck struct {
+	name string
+	run  func(ctx context.Context, clock Clock) (string, bool, error)
+}
+
+// envChecks are independent and run concurrently; the report lists them in
+// this order. A new item is one entry here.
+var envChecks = []envCheck{
+	{"Interface", checkInterface},
+	{"Router latency", checkRouterLatency},
+	{"DNS resolver", checkResolver},
+	{"IPv6", checkIPv6},
+	{"VPN", checkVPN},
+	{"CPU load", checkLoad},
+	{"Other traffic", checkOtherTraffic},
+}
+
+// envTrafficWindow is how long the interface counters are watched for
+// traffic that isn't gofast's. Nothing else in the report takes as long.
+const envTrafficWindow = time.Second
+
+// envReport runs every check and returns the items of those that apply. A
+// check that fails is reported as such rather than left out.
+func envReport(ctx context.Context, clock Clock) []EnvItem {
+	items := make([]*EnvItem, len(envChecks))
+	var wg sync.WaitGroup
+	for i, c := range envChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, concern, err := c.run(ctx, clock)
+			switch {
+			case errors.Is(err, errNotApplicable):
+				return
+			case err != nil:
+				value, concern = "couldn't check: "+err.Error(), false
+			}
+			items[i] = &EnvItem{Name: c.name, Value: value, Concern: concern}
+		}()
+	}
+	wg.Wait()
+	var report []EnvItem
+	for _, item := range items {
+		if item != nil {
+			report = append(report, *item)
+		}
+	}
+	return report
+}
+
+func envSection(items []EnvItem) detailSection {
+	section := detailSection{title: "Environment"}
+	for _, item := range items {
+		if item.Concern {
+			section.add(item.Name, cues.Concern.mark(item.Value))
+		} else {
+			section.add(item.Name, item.Value)
+		}
+	}
+	return section
+}
+
+// writeEnvReport prints the report for `gofast env`.
+func writeEnvReport(w io.Writer, items []EnvItem) {
+	for _, item := range items {
+		mark := " "
+		if item.Concern {
+			mark = "!"
+		}
+		fmt.Fprintf(w, "%s %-16s %s\n", mark, item.Name, item.Value)
+	}
+}
+
+// runEnv implements "gofast env": the environment report alone.
+func runEnv(args []string) {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: gofast env")
+		os.Exit(2)
+	}
+	writeEnvReport(os.Stdout, envReport(context.Background(), realClock{}))
+}
+
+func checkInterface(ctx context.Context, clock Clock) (string, bool, error) {
+	iface, _, err := defaultRoute()
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", errNotApplicable, err)
+	}
+	sys := "/sys/class/net/" + iface
+	if _, err := os.Stat(sys + "/wireless"); err == nil {
+		return iface + " (Wi-Fi)", false, nil
+	}
+	data, err := os.ReadFile(sys + "/speed")
+	speed, perr := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || perr != nil || speed <= 0 {
+		return iface, false, nil
+	}
+	value := fmt.Sprintf("%s, %s link", iface, formatSpeed(float64(speed)))
+	// A link at 100 Mbps or less caps any faster connection.
+	if speed <= 100 {
+		return value + " — the link itself may cap the result", true, nil
+	}
+	return value, false, nil
+}
+
+func checkRouterLatency(ctx context.Context, clock Clock) (string, bool, error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", errNotApplicable, err)
+	}
+	method := "tcp"
+	if (icmpPing{}).Available() == nil {
+		method = "icmp"
+	}
+	rtt, err := gatewayRTT(ctx, clock, method, gateway)
+	if err != nil {
+		return "", false, fmt.Errorf("router %s: %w", gateway, err)
+	}
+	// A healthy home network answers in a few ms; see the troubleshooter.
+	return fmt.Sprintf("%s %.1f ms (%s)", gateway, rtt, method), rtt > 10, nil
+}
+
+func checkResolver(ctx context.Context, clock Clock) (string, bool, error) {
+	server := "system resolver"
+	if ns := resolvConfNameserver(); ns != "" {
+		server = ns
+	}
+	lookupCtx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	start := clock.Now()
+	if _, err := net.DefaultResolver.LookupHost(lookupCtx, pingHost); err != nil {
+		return server + " not answering", true, nil
+	}
+	rtt := msSince(clock, start)
+	return fmt.Sprintf("%s, %.0f ms to look up %s", server, rtt, pingHost), rtt > 100, nil
+}
+
+// resolvConfNameserver is the first nameserver in resolv.conf, if any.
+func resolvConfNameserver() string {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func checkIPv6(ctx context.Context, clock Clock) (string, bool, error) {
+	rtt, err := handshakeRTT(ctx, clock, "tcp6", pingHost)
+	if err != nil {
+		return "unavailable", false, nil
+	}
+	return fmt.Sprintf("available, %.0f ms to %s", rtt, pingHost), false, nil
+}
+
+// vpnPrefixes name the interfaces VPN clients create.
+var vpnPrefixes = []string{"tun", "tap", "wg", "ppp", "utun", "ipsec", "tailscale", "zt", "nordlynx", "proton"}
+
+func isVPNInterface(name string) bool {
+	for _, prefix := range vpnPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVPN suspects a VPN from the interfaces that are up, and is sure of
+// one when the default route goes through it.
+func checkVPN(ctx context.Context, clock Clock) (string, bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", false, err
+	}
+	var up []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && isVPNInterface(iface.Name) {
+			up = append(up, iface.Name)
+		}
+	}
+	if route, _, err := defaultRoute(); err == nil && isVPNInterface(route) {
+		return "traffic goes through " + route + "; results measure the VPN, not the line", true, nil
+	}
+	if len(up) > 0 {
+		return "possible: " + strings.Join(up, ", ") + " up", true, nil
+	}
+	return "none detected", false, nil
+}
+
+// checkLoad compares the one-minute load average with the CPU count.
+func checkLoad(ctx context.Context, clock Clock) (string, bool, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return "", false, fmt.Errorf("%w: the load average is only read on Linux", errNotApplicable)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", false, errors.New("unexpected /proc/loadavg")
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", false, err
+	}
+	cpus := runtime.NumCPU()
+	value := fmt.Sprintf("%.2f over %d CPUs", load, cpus)
+	if load > float64(cpus) {
+		return value + " — a busy machine can limit the result", true, nil
+	}
+	return value, false, nil
+}
+
+// checkOtherTraffic watches the interface counters briefly before the test.
+// Anything moving then is someone else's traffic competing with it.
+func checkOtherTraffic(ctx context.Context, clock Clock) (string, bool, error) {
+	before, err := interfaceBytes()
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", errNotApplicable, err)
+	}
+	start := clock.Now()
+	if err := sleepCtx(ctx, clock, envTrafficWindow); err != nil {
+		return "", false, err
+	}
+	after, err := interfaceBytes()
+	if err != nil {
+		return "", false, err
+	}
+	mbps := float64(after-before) * 8 / 1e6 / clock.Since(start).Seconds()
+	// Background chatter stays well under a megabit.
+	if mbps >= 1 {
+		return fmt.Sprintf("%s in use by other processes — the result will be lower", formatSpeed(mbps)), true, nil
+	}
+	return "quiet", false, nil
+}
+
+// interfaceBytes totals the bytes received and sent on every interface but
+// loopback, from /proc/net/dev.
+func interfaceBytes() (uint64, error) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, errors.New("interface counters are only read on Linux")
+	}
+	defer f.Close()
+	var total uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseUint(fields[0], 10, 64)
+		tx, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 == nil && err2 == nil {
+			total += rx + tx
+		}
+	}
+	return total, scanner.Err()
+}
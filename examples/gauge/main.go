@@ -0,0 +1,49 @@
+// Command gauge renders gofast's gauge for values given on the command line,
+// one dial per value:
+//
+//	go run ./examples/gauge -max 200 -unit °C 42 187
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gofast/gauge"
+)
+
+func main() {
+	max := flag.Float64("max", 100, "value at full scale")
+	unit := flag.String("unit", "", "unit to caption the dial with")
+	peak := flag.Float64("peak", 0, "peak marker, 0 for none")
+	baseline := flag.Float64("baseline", 0, "baseline marker, 0 for none")
+	plain := flag.Bool("plain", false, "draw the markers without color")
+	flag.Parse()
+
+	values := flag.Args()
+	if len(values) == 0 {
+		values = []string{"0"}
+	}
+	theme := gauge.DefaultTheme
+	if *plain {
+		theme = gauge.Theme{}
+	}
+	for i, arg := range values {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %q is not a number\n", arg)
+			os.Exit(2)
+		}
+		g := gauge.New(gauge.Options{Max: *max, Unit: *unit, Theme: theme})
+		g.SetValue(v)
+		g.SetPeak(*peak)
+		g.SetBaseline(*baseline)
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(strings.TrimSpace(arg + " " + *unit))
+		fmt.Print(g.View())
+	}
+}
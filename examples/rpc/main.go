@@ -0,0 +1,176 @@
+// Command rpc drives a gofast binary through --rpc the way an embedding UI
+// would: it checks that bad params are refused, configures a test, runs it
+// against the demo provider and follows the notifications to completion,
+// exiting non-zero if anything is off:
+//
+//	go build -o gofast . && go run ./examples/rpc -gofast ./gofast
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type client struct {
+	in     io.Writer
+	out    *bufio.Scanner
+	nextID int
+	// pending are notifications read while waiting for a reply.
+	pending []message
+}
+
+// call sends a request and returns its reply, keeping the notifications
+// that arrive first.
+func (c *client) call(method string, params any) message {
+	c.nextID++
+	req, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	fmt.Fprintf(c.in, "%s\n", req)
+	for {
+		m := c.read()
+		if m.ID != nil && *m.ID == c.nextID {
+			return m
+		}
+		c.pending = append(c.pending, m)
+	}
+}
+
+// notification returns the next notification.
+func (c *client) notification() message {
+	if len(c.pending) > 0 {
+		m := c.pending[0]
+		c.pending = c.pending[1:]
+		return m
+	}
+	return c.read()
+}
+
+func (c *client) read() message {
+	if !c.out.Scan() {
+		fail("gofast closed stdout: %v", c.out.Err())
+	}
+	var m message
+	if err := json.Unmarshal(c.out.Bytes(), &m); err != nil {
+		fail("not JSON-RPC: %s", c.out.Text())
+	}
+	return m
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "FAIL: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	gofast := flag.String("gofast", "gofast", "gofast binary to drive")
+	flag.Parse()
+
+	cmd := exec.Command(*gofast, "--rpc", "--provider", "demo", "--quick", "--no-history", "--no-wizard")
+	cmd.Stderr = os.Stderr
+	in, _ := cmd.StdinPipe()
+	out, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		fail("%v", err)
+	}
+	c := &client{in: in, out: bufio.NewScanner(out)}
+	c.out.Buffer(make([]byte, 64<<10), 4<<20)
+	timer := time.AfterFunc(2*time.Minute, func() { fail("no completion within 2 minutes") })
+	defer timer.Stop()
+
+	for _, bad := range []struct {
+		method string
+		params any
+		code   int
+	}{
+		{"configure", map[string]any{"streams": 0}, -32602},
+		{"configure", map[string]any{"profile": "nonexistent"}, -32602},
+		{"configure", map[string]any{"colour": "blue"}, -32602},
+		{"configure", []any{1}, -32602},
+		{"abort", nil, -32000},
+		{"launch", nil, -32601},
+	} {
+		if r := c.call(bad.method, bad.params); r.Error == nil || r.Error.Code != bad.code {
+			fail("%s %v: want error %d, got %+v", bad.method, bad.params, bad.code, r)
+		}
+	}
+	fmt.Println("ok  invalid calls refused")
+
+	if r := c.call("configure", map[string]any{"streams": 2, "note": "rpc example"}); r.Error != nil {
+		fail("configure: %s", r.Error.Message)
+	}
+	if r := c.call("start", nil); r.Error != nil {
+		fail("start: %s", r.Error.Message)
+	}
+	if r := c.call("start", nil); r.Error == nil {
+		fail("a second start while running succeeded")
+	}
+	var status struct {
+		Running bool `json:"running"`
+	}
+	if r := c.call("status", nil); json.Unmarshal(r.Result, &status) != nil || !status.Running {
+		fail("status while running: %s", r.Result)
+	}
+	fmt.Println("ok  started")
+
+	var phases []string
+	samples := 0
+	for {
+		n := c.notification()
+		switch n.Method {
+		case "phase":
+			var p struct {
+				Phase string `json:"phase"`
+			}
+			json.Unmarshal(n.Params, &p)
+			phases = append(phases, p.Phase)
+		case "sample":
+			samples++
+		case "complete":
+			var done struct {
+				Results *struct {
+					Note     string `json:"note"`
+					Download *struct {
+						Mbps float64 `json:"mbps"`
+					} `json:"download"`
+				} `json:"results"`
+				Error string `json:"error"`
+			}
+			json.Unmarshal(n.Params, &done)
+			switch {
+			case done.Error != "":
+				fail("run failed: %s", done.Error)
+			case done.Results == nil || done.Results.Download == nil:
+				fail("completion without a download: %s", n.Params)
+			case done.Results.Note != "rpc example":
+				fail("note not applied: %q", done.Results.Note)
+			case !slices.Equal(phases, []string{"server", "ping", "download", "upload"}):
+				fail("phases %v", phases)
+			case samples == 0:
+				fail("no samples")
+			}
+			fmt.Printf("ok  completed: %v, %d samples, download %.1f Mbps\n", phases, samples, done.Results.Download.Mbps)
+			in.Close()
+			if err := cmd.Wait(); err != nil {
+				fail("gofast exited: %v", err)
+			}
+			return
+		}
+	}
+}
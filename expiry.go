@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// RunContext is what history says about a result beyond its own figures.
+type RunContext struct {
+	Hints []ContextHint `json:"hints"`
+}
+
+// ContextHint is one note on how far a result can be relied on.
+type ContextHint struct {
+	// Kind is "expiry": speeds usually change from FromHour on.
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// FromHour is the local hour the change starts, 0-23.
+	FromHour int `json:"from_hour"`
+	// ChangePercent is how the download usually differs from then on,
+	// relative to the hour the result was measured in.
+	ChangePercent float64 `json:"change_percent"`
+}
+
+const (
+	// hourlyMinRuns is how many past runs an hour needs before its usual
+	// speed counts.
+	hourlyMinRuns = 3
+	// expiryChange is the difference between two hours' usual speeds that
+	// makes a result stale for the later one.
+	expiryChange = 0.2
+)
+
+// hourlyStats are the usual download speeds by local hour of day.
+type hourlyStats [24]struct {
+	Runs   int
+	Median float64
+}
+
+// hourlyDownload aggregates the past runs of provider made here by the
+// local hour they started in, leaving out those kept out of baselines.
+func hourlyDownload(past []Results, provider string) hourlyStats {
+	var speeds [24][]float64
+	for _, r := range past {
+		if r.Provider != provider || r.Site != "" || r.Download == nil || outOfBaseline(r) {
+			continue
+		}
+		h := r.Timestamp.Local().Hour()
+		speeds[h] = append(speeds[h], r.Download.Mbps)
+	}
+	var stats hourlyStats
+	for h, s := range speeds {
+		stats[h].Runs = len(s)
+		if len(s) > 0 {
+			stats[h].Median = median(s)
+		}
+	}
+	return stats
+}
+
+// expiryHints looks ahead from the hour a result was measured in for the
+// first hour whose usual speed differs by expiryChange or more. There is no
+// hint when either hour has too few runs to say what is usual.
+func expiryHints(measured time.Time, stats hourlyStats) []ContextHint {
+	measured = measured.Local()
+	now := stats[measured.Hour()]
+	if now.Runs < hourlyMinRuns || now.Median <= 0 {
+		return nil
+	}
+	for ahead := 1; ahead < 24; ahead++ {
+		h := (measured.Hour() + ahead) % 24
+		if stats[h].Runs < hourlyMinRuns {
+			continue
+		}
+		change := (stats[h].Median - now.Median) / now.Median
+		if math.Abs(change) < expiryChange {
+			continue
+		}
+		direction := "slower"
+		if change > 0 {
+			direction = "faster"
+		}
+		return []ContextHint{{
+			Kind: "expiry",
+			Message: fmt.Sprintf("measured at %s — speeds are typically %.0f%% %s after %02d:00 based on your history",
+				measured.Format("15:04"), math.Abs(change)*100, direction, h),
+			FromHour:      h,
+			ChangePercent: math.Round(change * 100),
+		}}
+	}
+	return nil
+}
+
+// contextLine is the completion screen's unobtrusive line of hints, empty
+// when there are none.
+func contextLine(c *RunContext) string {
+	if c == nil || len(c.Hints) == 0 {
+		return ""
+	}
+	messages := make([]string, len(c.Hints))
+	for i, h := range c.Hints {
+		messages[i] = h.Message
+	}
+	return "\033[90m" + strings.Join(messages, "; ") + "\033[0m"
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hours builds aggregates with runs runs at each given hour's median.
+func hours(runs int, medians map[int]float64) hourlyStats {
+	var stats hourlyStats
+	for h, m := range medians {
+		stats[h].Runs, stats[h].Median = runs, m
+	}
+	return stats
+}
+
+func TestExpiryHints(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 5, 4, h, m, 0, 0, time.Local) }
+	sparse := hours(5, map[int]float64{14: 100, 19: 63})
+	sparse[19].Runs = hourlyMinRuns - 1
+	sparse[21].Runs, sparse[21].Median = hourlyMinRuns, 50
+
+	tests := []struct {
+		name     string
+		measured time.Time
+		stats    hourlyStats
+		want     []ContextHint
+	}{
+		{"evening slowdown", at(14, 2), hours(5, map[int]float64{14: 100, 15: 95, 19: 63}), []ContextHint{{
+			Kind:          "expiry",
+			Message:       "measured at 14:02 — speeds are typically 37% slower after 19:00 based on your history",
+			FromHour:      19,
+			ChangePercent: -37,
+		}}},
+		{"faster overnight", at(22, 30), hours(3, map[int]float64{22: 40, 2: 90}), []ContextHint{{
+			Kind:          "expiry",
+			Message:       "measured at 22:30 — speeds are typically 125% faster after 02:00 based on your history",
+			FromHour:      2,
+			ChangePercent: 125,
+		}}},
+		{"hour with too few runs skipped", at(14, 0), sparse, []ContextHint{{
+			Kind:          "expiry",
+			Message:       "measured at 14:00 — speeds are typically 50% slower after 21:00 based on your history",
+			FromHour:      21,
+			ChangePercent: -50,
+		}}},
+		{"steady all day", at(9, 0), hours(4, map[int]float64{9: 100, 13: 85, 20: 119}), nil},
+		{"measured hour too sparse", at(14, 0), hours(hourlyMinRuns-1, map[int]float64{14: 100, 19: 50}), nil},
+		{"no history", at(14, 0), hourlyStats{}, nil},
+		{"nothing measured that hour", at(14, 0), hours(5, map[int]float64{14: 0, 19: 50}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expiryHints(tt.measured, tt.stats); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHourlyDownload(t *testing.T) {
+	run := func(provider string, h int, mbps float64) Results {
+		return Results{Provider: provider, Timestamp: time.Date(2026, 5, 4, h, 15, 0, 0, time.Local), Download: &Transfer{Mbps: mbps}}
+	}
+	collected := run("cloudflare", 9, 5)
+	collected.Site = "berlin"
+	past := []Results{
+		run("cloudflare", 9, 100), run("cloudflare", 9, 80), run("cloudflare", 9, 300),
+		run("cloudflare", 20, 40),
+		run("fast", 9, 1),
+		collected,
+		{Provider: "cloudflare", Timestamp: time.Date(2026, 5, 4, 9, 0, 0, 0, time.Local)},
+	}
+	stats := hourlyDownload(past, "cloudflare")
+	if s := stats[9]; s.Runs != 3 || s.Median != 100 {
+		t.Errorf("09:00 has %d runs at %g, want 3 at 100", s.Runs, s.Median)
+	}
+	if s := stats[20]; s.Runs != 1 || s.Median != 40 {
+		t.Errorf("20:00 has %d runs at %g, want 1 at 40", s.Runs, s.Median)
+	}
+	for h, s := range stats {
+		if h != 9 && h != 20 && s.Runs != 0 {
+			t.Errorf("%02d:00 has %d runs", h, s.Runs)
+		}
+	}
+}
+
+func TestExpiryNeedsHistory(t *testing.T) {
+	measured := time.Date(2026, 5, 4, 14, 2, 0, 0, time.Local)
+	store := historyStore{path: filepath.Join(t.TempDir(), "history.jsonl")}
+	for day := range 3 {
+		for h, mbps := range map[int]float64{14: 100, 19: 50} {
+			r := Results{Provider: "demo", Timestamp: measured.AddDate(0, 0, -day-1).Add(time.Duration(h-14) * time.Hour), Download: &Transfer{Mbps: mbps}}
+			if err := store.Append(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	newRun := func() Results {
+		return Results{Provider: "demo", Timestamp: measured, Download: &Transfer{Mbps: 100}}
+	}
+
+	r := newRun()
+	engine{}.record(&r)
+	if r.Context != nil {
+		t.Errorf("hints without history: %+v", r.Context)
+	}
+	complete := func(r Results) string {
+		m, _ := initialModel(engine{provider: demoProvider{}, clock: newFakeClock()}, viewOptions{NoMenu: true}).Update(completeMsg(r))
+		return m.View()
+	}
+	if strings.Contains(complete(r), "based on your history") {
+		t.Error("the completion screen shows a hint without history")
+	}
+
+	r = newRun()
+	engine{history: &store}.record(&r)
+	if r.Context == nil || len(r.Context.Hints) != 1 || r.Context.Hints[0].FromHour != 19 {
+		t.Fatalf("context with history: %+v", r.Context)
+	}
+	if !strings.Contains(complete(r), r.Context.Hints[0].Message) {
+		t.Error("the completion screen doesn't show the hint")
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Context struct {
+			Hints []map[string]any `json:"hints"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"kind": "expiry", "message": r.Context.Hints[0].Message, "from_hour": 19.0, "change_percent": -50.0}
+	if len(decoded.Context.Hints) != 1 || !reflect.DeepEqual(decoded.Context.Hints[0], want) {
+		t.Errorf("context.hints = %v, want [%v]", decoded.Context.Hints, want)
+	}
+}
+
+func TestContextLine(t *testing.T) {
+	if got := contextLine(nil); got != "" {
+		t.Errorf("no context rendered %q", got)
+	}
+	if got := contextLine(&RunContext{}); got != "" {
+		t.Errorf("no hints rendered %q", got)
+	}
+	got := contextLine(&RunContext{Hints: []ContextHint{{Message: "a"}, {Message: "b"}}})
+	if sgrPattern.ReplaceAllString(got, "") != "a; b" {
+		t.Errorf("hints rendered as %q", got)
+	}
+}
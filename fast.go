@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fast.com hands out test URLs on Netflix's Open Connect appliances
+// (OCAs), the caches that serve its video. The page's script carries a
+// token; the API exchanges it for a few signed target URLs near the
+// client, each of which serves /range/0-N as N+1 bytes and accepts a POST
+// body the same way.
+const (
+	fastBase = "https://fast.com"
+	fastAPI  = "https://api.fast.com/netflix/speedtest/v2"
+)
+
+const (
+	// fastTargets is how many target URLs are asked for.
+	fastTargets = 5
+	// fastChunk is the size of one download request.
+	fastChunk = 25 << 20
+	// fastUploadChunk is the size of one upload body.
+	fastUploadChunk = 4 << 20
+	// fastDuration is a transfer's length when the profile leaves it to
+	// the provider.
+	fastDuration = 10 * time.Second
+	// fastStreams is the number of parallel transfers when the profile
+	// doesn't say.
+	fastStreams = 4
+)
+
+var (
+	fastScriptPattern = regexp.MustCompile(`src="(/app-[0-9a-f]+\.js)"`)
+	fastTokenPattern  = regexp.MustCompile(`token:"([A-Za-z0-9]+)"`)
+)
+
+// fastProvider measures against the OCAs fast.com assigns. The location
+// shown is the OCA's city, where the streaming path actually ends.
+type fastProvider struct {
+	clock       Clock
+	base, api   string
+	duration    time.Duration
+	pingSamples int
+	streams     int
+	// compressible sends zeros instead of random upload data.
+	compressible bool
+	// noGeolocation keeps the OCA's city out of the results.
+	noGeolocation bool
+	ping          *serverPing
+	// session is the API's answer and the target in use, shared by every
+	// copy of the provider.
+	session *fastSession
+}
+
+type fastSession struct {
+	mu      sync.Mutex
+	answer  *fastAnswer
+	current Server
+}
+
+// fastAnswer is the API's reply.
+type fastAnswer struct {
+	Client struct {
+		IP       string `json:"ip"`
+		ASN      string `json:"asn"`
+		ISP      string `json:"isp"`
+		Location struct {
+			City    string `json:"city"`
+			Country string `json:"country"`
+		} `json:"location"`
+	} `json:"client"`
+	Targets []struct {
+		Name     string `json:"name"`
+		URL      string `json:"url"`
+		Location struct {
+			City    string `json:"city"`
+			Country string `json:"country"`
+		} `json:"location"`
+	} `json:"targets"`
+}
+
+func newFastProvider(opts providerOptions) Provider {
+	return fastProvider{
+		clock:         opts.Clock,
+		base:          fastBase,
+		api:           fastAPI,
+		duration:      opts.Duration,
+		pingSamples:   opts.PingSamples,
+		streams:       opts.Streams,
+		compressible:  opts.Compressible,
+		noGeolocation: opts.NoGeolocation,
+		ping:          newServerPing(opts.Ping),
+		session:       &fastSession{},
+	}
+}
+
+func (fastProvider) Name() string { return "fast" }
+
+func (fastProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: true, LoadedLatency: true}
+}
+
+func (p fastProvider) get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: httpTransport()}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// token reads the API token out of the page's script.
+func (p fastProvider) token(ctx context.Context) (string, error) {
+	page, err := p.get(ctx, p.base+"/")
+	if err != nil {
+		return "", err
+	}
+	script := fastScriptPattern.FindSubmatch(page)
+	if script == nil {
+		return "", errors.New("fast.com's page has no script to read the token from")
+	}
+	js, err := p.get(ctx, p.base+string(script[1]))
+	if err != nil {
+		return "", err
+	}
+	token := fastTokenPattern.FindSubmatch(js)
+	if token == nil {
+		return "", errors.New("fast.com's script has no token")
+	}
+	return string(token[1]), nil
+}
+
+// load asks the API for targets once for all copies of the provider. A
+// failure is tried again on the next call.
+func (p fastProvider) load(ctx context.Context) (*fastAnswer, error) {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	if p.session.answer != nil {
+		return p.session.answer, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	token, err := p.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting a fast.com token: %w", err)
+	}
+	q := url.Values{"https": {"true"}, "token": {token}, "urlCount": {strconv.Itoa(fastTargets)}}
+	body, err := p.get(ctx, p.api+"?"+q.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("asking fast.com for targets: %w", err)
+	}
+	var answer fastAnswer
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return nil, fmt.Errorf("reading fast.com's targets: %w", err)
+	}
+	if len(answer.Targets) == 0 {
+		return nil, errors.New("fast.com returned no targets")
+	}
+	p.session.answer = &answer
+	return &answer, nil
+}
+
+// Candidates returns the targets fast.com assigned, in its order.
+func (p fastProvider) Candidates(ctx context.Context) ([]Server, error) {
+	answer, err := p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	for _, t := range answer.Targets {
+		u, err := url.Parse(t.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		s := Server{Name: "Netflix " + u.Hostname(), Host: u.Hostname(), URL: t.URL}
+		if !p.noGeolocation {
+			s.Location, s.Country, s.LocationSource = t.Location.City, t.Location.Country, provenanceMeasured
+			if s.Location == "" {
+				s.LocationSource = provenanceUnavailable
+			}
+		}
+		servers = append(servers, s)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("fast.com returned no usable targets")
+	}
+	return servers, nil
+}
+
+// rangeURL addresses the first n bytes of a target.
+func rangeURL(target string, n int64) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.Path += fmt.Sprintf("/range/0-%d", max(n-1, 0))
+	return u.String()
+}
+
+func (p fastProvider) Handshake(ctx context.Context, s Server) error {
+	client := &http.Client{Transport: httpTransport()}
+	defer client.CloseIdleConnections()
+	return httpHandshake(ctx, client, p.clock, rangeURL(s.URL, 1024))
+}
+
+// ProbeServer times a connect to the target, for ranking the targets.
+func (p fastProvider) ProbeServer(ctx context.Context, s Server) (float64, error) {
+	return connectRTT(ctx, p.clock, s.URL)
+}
+
+func (p fastProvider) Use(s Server) {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	p.session.current = s
+}
+
+func (p fastProvider) target() Server {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	return p.session.current
+}
+
+// Server returns the target in use, taking the first healthy one if none
+// is.
+func (p fastProvider) Server(ctx context.Context) (Server, error) {
+	if s := p.target(); s.URL != "" {
+		return s, nil
+	}
+	candidates, err := p.Candidates(ctx)
+	if err != nil {
+		return Server{}, err
+	}
+	var errs []error
+	for _, s := range candidates {
+		if err := p.Handshake(ctx, s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Use(s)
+		return s, nil
+	}
+	return Server{}, &serverSelectionError{Errs: errs}
+}
+
+// Client reports the address, ISP and ASN fast.com saw the request come
+// from.
+func (p fastProvider) Client(ctx context.Context) (Client, error) {
+	answer, err := p.load(ctx)
+	if err != nil {
+		return Client{}, err
+	}
+	c := Client{IP: answer.Client.IP, ISP: answer.Client.ISP, ASN: answer.Client.ASN}
+	if c.ASN != "" && !strings.HasPrefix(c.ASN, "AS") {
+		c.ASN = "AS" + c.ASN
+	}
+	return c, nil
+}
+
+func (p fastProvider) check(resp *http.Response) error {
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// pinger times round trips for an empty range of s, as OCAs only answer
+// HTTPS.
+func (p fastProvider) pinger(s Server) *httpPinger {
+	return newHTTPPinger(p.clock, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, rangeURL(s.URL, 0), nil)
+	}, p.check)
+}
+
+func (p fastProvider) Ping(ctx context.Context) (Latency, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Latency{}, err
+	}
+	if l, ok, err := p.ping.latency(ctx, s.URL, p.pingSamples); ok || err != nil {
+		return l, err
+	}
+	pinger := p.pinger(s)
+	defer pinger.client.CloseIdleConnections()
+	return pinger.latency(ctx, p.pingSamples)
+}
+
+// Probe times one round trip on a fresh connection.
+func (p fastProvider) Probe(ctx context.Context) (float64, error) {
+	if rtt, ok, err := p.ping.probe(ctx, p.target().URL); ok {
+		return rtt, err
+	}
+	pinger := p.pinger(p.target())
+	defer pinger.client.CloseIdleConnections()
+	pinger.warm = true
+	return pinger.ping(ctx)
+}
+
+func (p fastProvider) TraceRequest(ctx context.Context) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, rangeURL(p.target().URL, 0), nil)
+}
+
+// Download reads ranges of the target over parallel streams, adding every
+// byte to m as it arrives.
+func (p fastProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Transfer{}, err
+	}
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rangeURL(s.URL, fastChunk), nil)
+		if err != nil {
+			return err
+		}
+		// The range is part of the path, so each response is a whole
+		// resource as far as the data-quality check goes.
+		record := transferRecord{URL: req.URL.String(), Offset: -1, Began: p.clock.Now()}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := p.check(resp); err != nil {
+			return err
+		}
+		record.Expected = resp.ContentLength
+		return readCounted(ctx, p.clock, resp, m, record)
+	})
+}
+
+// Upload POSTs generated payload to the target over parallel streams,
+// counting bytes into m as the transport reads them for sending.
+func (p fastProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Transfer{}, err
+	}
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		body := &meteredReader{r: uploadBody(fastUploadChunk, p.compressible), m: m}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, rangeURL(s.URL, fastUploadChunk), body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = fastUploadChunk
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return p.check(resp)
+	})
+}
+
+func (p fastProvider) streamed(ctx context.Context, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	duration := p.duration
+	if duration <= 0 {
+		duration = fastDuration
+	}
+	streams := p.streams
+	if streams <= 0 {
+		streams = fastStreams
+	}
+	return runStreams(ctx, p.clock, duration, streams, m, client, request)
+}
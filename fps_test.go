@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.uber.org/goleak"
+)
+
+func TestFrameRate(t *testing.T) {
+	tests := []struct {
+		fps      int
+		interval time.Duration
+	}{
+		{0, time.Second / defaultFPS},
+		{defaultFPS, time.Second / 60},
+		{sshFPS, 100 * time.Millisecond},
+		{lowBandwidthFPS, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := (viewOptions{FPS: tt.fps}).frameInterval(); got != tt.interval {
+			t.Errorf("frame interval at %d fps = %v, want %v", tt.fps, got, tt.interval)
+		}
+	}
+}
+
+// A second of easing and peak decay ends up in the same place whatever the
+// frame rate.
+func TestPerFrameRateIndependent(t *testing.T) {
+	for _, fps := range []int{lowBandwidthFPS, sshFPS, 30, defaultFPS} {
+		o := viewOptions{FPS: fps}
+		gap, peak := 100.0, 100.0
+		for range fps {
+			gap *= o.perFrame(0.85)
+			peak *= o.perFrame(peakDecay)
+		}
+		if want := 100 * math.Pow(0.85, defaultFPS); math.Abs(gap-want) > 1e-9 {
+			t.Errorf("at %d fps a second of easing leaves %g, want %g", fps, gap, want)
+		}
+		if want := 100 * math.Pow(peakDecay, defaultFPS); math.Abs(peak-want) > 1e-9 {
+			t.Errorf("at %d fps a second of decay leaves %g, want %g", fps, peak, want)
+		}
+	}
+}
+
+// frozenModel is a finished speedTest that starts nothing of its own.
+type frozenModel struct {
+	speedTest
+}
+
+func (frozenModel) Init() tea.Cmd { return nil }
+
+func (f frozenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(tea.KeyMsg); ok {
+		return f, tea.Quit
+	}
+	m, cmd := f.speedTest.Update(msg)
+	f.speedTest = m.(speedTest)
+	return f, cmd
+}
+
+type nudgeMsg struct{}
+
+func TestIdenticalFramesNotRepainted(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	m := initialModel(engine{provider: demoProvider{}, clock: realClock{}}, viewOptions{FPS: 60, NoMenu: true})
+	defer m.cancel()
+	m.phase, m.width, m.height = phaseComplete, 72, 40
+	m.results = Results{Provider: "demo", Download: &Transfer{Mbps: 95}, Upload: &Transfer{Mbps: 12}}
+	m.downloadSpeed, m.uploadSpeed = 95, 12
+
+	var out syncBuffer
+	p := tea.NewProgram(frozenModel{m}, tea.WithInput(strings.NewReader("")), tea.WithOutput(&out), tea.WithoutSignalHandler(), tea.WithFPS(60))
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Run()
+		done <- err
+	}()
+
+	settled := func() int {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		last := -1
+		for time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			if n := out.Len(); n > 0 && n == last {
+				return n
+			}
+			last = out.Len()
+		}
+		t.Fatal("the program never stopped writing")
+		return 0
+	}
+
+	first := settled()
+	for range 30 {
+		p.Send(nudgeMsg{})
+		time.Sleep(5 * time.Millisecond)
+	}
+	if after := settled(); after != first {
+		t.Errorf("%d unchanged frames wrote %d more bytes", 30, after-first)
+	}
+	p.Send(tea.WindowSizeMsg{Width: 60, Height: 40})
+	if resized := settled(); resized == first {
+		t.Error("a resized frame wrote nothing")
+	}
+	p.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
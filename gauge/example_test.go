@@ -0,0 +1,20 @@
+package gauge_test
+
+import (
+	"fmt"
+	"strings"
+
+	"gofast/gauge"
+)
+
+func Example() {
+	g := gauge.New(gauge.Options{Max: 100, Unit: "Mbps"})
+	g.SetValue(42)
+	lines := strings.Split(strings.TrimRight(g.View(), "\n"), "\n")
+	// The last two lines are the tick values and the unit.
+	fmt.Println(lines[len(lines)-2])
+	fmt.Println(strings.TrimSpace(lines[len(lines)-1]))
+	// Output:
+	//      0   10   20   30   40   50   60   70   80   90  100
+	// Mbps
+}
@@ -0,0 +1,282 @@
+// Package gauge draws the circular speedometer of gofast's TUI as text, for
+// any program that wants one. It knows nothing about speed tests: a Gauge
+// shows a value between zero and Max, optionally with a peak marker and a
+// baseline marker, on a 270° dial with eleven ticks.
+//
+//	g := gauge.New(gauge.Options{Max: 100, Unit: "Mbps"})
+//	g.SetValue(42)
+//	fmt.Print(g.View())
+//
+// View returns the dial, the tick values and the unit. Rows returns only the
+// dial, one string per row, for laying out several gauges side by side.
+//
+// Output uses Unicode block and box-drawing glyphs and ANSI SGR escapes
+// from the Theme. A Bubble Tea model can hold a Gauge and call View from
+// its own View; a Gauge has no messages of its own.
+package gauge
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is one of the dial layouts.
+type Size int
+
+const (
+	// Large is 50 columns by 35 rows, for a gauge on its own.
+	Large Size = iota
+	// Medium is 45 columns by 35 rows; two fit side by side in 90
+	// columns.
+	Medium
+)
+
+// Theme holds the ANSI SGR parameters (e.g. "35" or "33;1") the markers are
+// drawn with. An empty parameter draws without color.
+type Theme struct {
+	Peak     string
+	Baseline string
+}
+
+// DefaultTheme draws the peak in grey and the baseline in magenta.
+var DefaultTheme = Theme{Peak: "90", Baseline: "35"}
+
+// Options configure a Gauge.
+type Options struct {
+	// Max is the value at full scale. Values beyond it pin the needle.
+	Max float64
+	// Size is the dial layout, Large by default.
+	Size Size
+	// Unit captions the tick values in View.
+	Unit string
+	// Theme colors the markers; the zero Theme draws them uncolored. Use
+	// DefaultTheme for gofast's colors.
+	Theme Theme
+}
+
+// Gauge is one dial. The zero value isn't usable; call New.
+type Gauge struct {
+	opts     Options
+	dial     dial
+	value    float64
+	peak     float64
+	baseline float64
+}
+
+// New returns a gauge reading zero.
+func New(opts Options) *Gauge {
+	d := large
+	if opts.Size == Medium {
+		d = medium
+	}
+	return &Gauge{opts: opts, dial: d}
+}
+
+// SetValue moves the needle.
+func (g *Gauge) SetValue(v float64) { g.value = v }
+
+// SetPeak places the peak marker. It is drawn only while ahead of the
+// needle; zero hides it.
+func (g *Gauge) SetPeak(v float64) { g.peak = v }
+
+// SetBaseline places the baseline marker, a radial line across the dial;
+// zero hides it.
+func (g *Gauge) SetBaseline(v float64) { g.baseline = v }
+
+// SetMax changes the full-scale value.
+func (g *Gauge) SetMax(v float64) { g.opts.Max = v }
+
+// Max is the full-scale value.
+func (g *Gauge) Max() float64 { return g.opts.Max }
+
+// Fraction maps v onto [0, 1] of the gauge's range.
+func (g *Gauge) Fraction(v float64) float64 {
+	if g.opts.Max <= 0 || v <= 0 {
+		return 0
+	}
+	if v >= g.opts.Max {
+		return 1
+	}
+	return v / g.opts.Max
+}
+
+// Rows renders the dial alone, one string per row, every row the same
+// number of columns.
+func (g *Gauge) Rows() []string {
+	marks := marks{fraction: g.Fraction(g.value), peak: g.Fraction(g.peak), baseline: g.Fraction(g.baseline)}
+	rows := make([]string, g.dial.height)
+	for row := range rows {
+		var s strings.Builder
+		for col := 0; col < g.dial.width; col++ {
+			s.WriteString(g.dial.cell(float64(col), float64(row), marks, g.opts.Theme))
+		}
+		rows[row] = s.String()
+	}
+	return rows
+}
+
+// View renders the dial with the value of each tick beneath it and the
+// unit, if set.
+func (g *Gauge) View() string {
+	var s strings.Builder
+	for _, row := range g.Rows() {
+		s.WriteString(row + "\n")
+	}
+	s.WriteString(TickLine(TickLabels(g.opts.Max)) + "\n")
+	if g.opts.Unit != "" {
+		s.WriteString(fmt.Sprintf("%*s\n", g.dial.width/2+len(g.opts.Unit)/2, g.opts.Unit))
+	}
+	return s.String()
+}
+
+// TickLabels are the values of the eleven ticks, 0 to max, as the dial
+// numbers them 0–9 and X.
+func TickLabels(max float64) []string {
+	labels := make([]string, 11)
+	for i := range labels {
+		labels[i] = strconv.FormatFloat(max*float64(i)/10, 'f', -1, 64)
+	}
+	return labels
+}
+
+// TickLine lays eleven tick labels out in one line.
+func TickLine(labels []string) string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("%6s", labels[0]))
+	for _, label := range labels[1:] {
+		s.WriteString(fmt.Sprintf("%5s", label))
+	}
+	return s.String()
+}
+
+// marks are the positions drawn on a dial, as fractions of its range. A
+// zero peak or baseline isn't drawn.
+type marks struct {
+	fraction float64
+	peak     float64
+	baseline float64
+}
+
+// dial is the geometry of one layout: the size of its cell grid, where its
+// centre sits and the radii of its rings.
+type dial struct {
+	width, height    int
+	centerX, centerY float64
+	outerRadius      float64
+	innerRadius      float64
+}
+
+var (
+	large  = dial{width: 50, height: 35, centerX: 25, centerY: 20, outerRadius: 18, innerRadius: 14}
+	medium = dial{width: 45, height: 35, centerX: 22, centerY: 18, outerRadius: 18, innerRadius: 14}
+)
+
+// tickAngles place the eleven tick labels, 0 to X (full scale).
+var tickAngles = []float64{135, 162, 189, 216, 243, 270, 297, 324, 351, 18, 45}
+
+func colored(sgr, glyph string) string {
+	if sgr == "" {
+		return glyph
+	}
+	return "\033[" + sgr + "m" + glyph + "\033[0m"
+}
+
+func (d dial) cell(x, y float64, marks marks, theme Theme) string {
+	centerX, centerY := d.centerX, d.centerY
+	outerRadius, innerRadius := d.outerRadius, d.innerRadius
+	fraction := marks.fraction
+
+	if marks.peak > fraction {
+		peakAngle := (240.0 - marks.peak*270.0) * math.Pi / 180.0
+		markerRadius := (outerRadius + innerRadius) / 2
+		peakX := centerX + markerRadius*math.Cos(peakAngle)
+		peakY := centerY - markerRadius*math.Sin(peakAngle)
+		if math.Abs(x-peakX) < 0.75 && math.Abs(y-peakY) < 0.75 {
+			return colored(theme.Peak, "◆")
+		}
+	}
+
+	if marks.baseline > 0 {
+		baselineAngle := (240.0 - marks.baseline*270.0) * math.Pi / 180.0
+		ux, uy := math.Cos(baselineAngle), -math.Sin(baselineAngle)
+		along := (x-centerX)*ux + (y-centerY)*uy
+		across := math.Abs((x-centerX)*uy - (y-centerY)*ux)
+		if along >= innerRadius-1.0 && along <= outerRadius+1.0 && across < 0.6 {
+			return colored(theme.Baseline, "▪")
+		}
+	}
+
+	dx := x - centerX
+	dy := y - centerY
+	distance := math.Sqrt(dx*dx + dy*dy)
+	angle := math.Atan2(-dy, dx)
+
+	if angle < 0 {
+		angle += 2 * math.Pi
+	}
+
+	angleInDegrees := angle * 180.0 / math.Pi
+
+	inArc := (angleInDegrees >= 315.0 || angleInDegrees <= 225.0) && !(angleInDegrees > 225.0 && angleInDegrees < 315.0)
+
+	char := " "
+
+	if distance >= outerRadius-1.0 && distance <= outerRadius+1.0 && inArc {
+		char = "█"
+	} else if distance >= innerRadius-0.8 && distance <= innerRadius+0.8 && inArc {
+
+		char = "░"
+	} else if distance >= outerRadius+1.5 && distance <= outerRadius+4.0 && inArc {
+
+		for i, tickAngle := range tickAngles {
+			angleDiff := math.Abs(angleInDegrees - tickAngle)
+			if angleDiff > 180 {
+				angleDiff = 360 - angleDiff
+			}
+
+			if angleDiff < 4 {
+				if distance >= outerRadius+1.5 && distance <= outerRadius+2.5 {
+					char = "│"
+				} else if distance >= outerRadius+2.8 && distance <= outerRadius+4.0 {
+					char = string("0123456789X"[i])
+				}
+			}
+		}
+	} else if distance >= 3 && distance <= innerRadius-2 {
+
+		needleAngle := 240.0 - fraction*270.0
+		if needleAngle < 0.0 {
+			needleAngle += 360.0
+		}
+		needleAngleRad := needleAngle * math.Pi / 180.0
+
+		needleEndX := centerX + (innerRadius-3)*math.Cos(needleAngleRad)
+		needleEndY := centerY - (innerRadius-3)*math.Sin(needleAngleRad)
+
+		lineDistance := math.Abs((needleEndY-centerY)*x-(needleEndX-centerX)*y+needleEndX*centerY-needleEndY*centerX) /
+			math.Sqrt(math.Pow(needleEndY-centerY, 2)+math.Pow(needleEndX-centerX, 2))
+
+		if lineDistance < 1.2 &&
+			((x-centerX)*(needleEndX-centerX)+(y-centerY)*(needleEndY-centerY)) > 0 {
+			char = "━"
+		}
+	} else if distance <= 3.0 {
+
+		char = "●"
+	} else if distance >= 8 && distance <= 12 && inArc {
+
+		if angleInDegrees >= 135.0 && angleInDegrees <= 216.0 {
+			char = "▓"
+		} else if angleInDegrees >= 216.0 && angleInDegrees <= 297.0 {
+			char = "▒"
+		} else if (angleInDegrees >= 297.0) || (angleInDegrees >= 0.0 && angleInDegrees <= 18.0) {
+			char = "░"
+		} else if angleInDegrees >= 18.0 && angleInDegrees <= 45.0 {
+			char = "▓"
+		}
+	}
+
+	return char
+}
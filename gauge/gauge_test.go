@@ -0,0 +1,100 @@
+package gauge
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from %s:\n%s", name, path, got)
+	}
+}
+
+func TestViewGolden(t *testing.T) {
+	tests := []struct {
+		name                  string
+		opts                  Options
+		value, peak, baseline float64
+	}{
+		{"large-empty", Options{Max: 100, Unit: "Mbps"}, 0, 0, 0},
+		{"large-mid", Options{Max: 100, Unit: "Mbps"}, 47.5, 0, 0},
+		{"large-over-range", Options{Max: 100, Unit: "Mbps"}, 250, 0, 0},
+		{"large-sub-1", Options{Max: 1, Unit: "kbps"}, 0.35, 0, 0},
+		{"large-marks", Options{Max: 1000, Unit: "Mbps", Theme: DefaultTheme}, 400, 720, 900},
+		{"medium-marks", Options{Max: 100, Size: Medium, Theme: DefaultTheme}, 60, 80, 35},
+		{"medium-uncolored-marks", Options{Max: 100, Size: Medium}, 60, 80, 35},
+	}
+	for _, tt := range tests {
+		g := New(tt.opts)
+		g.SetValue(tt.value)
+		g.SetPeak(tt.peak)
+		g.SetBaseline(tt.baseline)
+		checkGolden(t, tt.name, g.View())
+	}
+}
+
+func TestRowsEvenWidth(t *testing.T) {
+	for _, size := range []struct {
+		size          Size
+		width, height int
+	}{{Large, 50, 35}, {Medium, 45, 35}} {
+		g := New(Options{Max: 100, Size: size.size})
+		g.SetValue(70)
+		g.SetPeak(90)
+		g.SetBaseline(50)
+		rows := g.Rows()
+		if len(rows) != size.height {
+			t.Errorf("size %d has %d rows, want %d", size.size, len(rows), size.height)
+		}
+		for i, row := range rows {
+			if n := utf8.RuneCountInString(row); n != size.width {
+				t.Errorf("size %d row %d is %d columns, want %d", size.size, i, n, size.width)
+			}
+		}
+	}
+}
+
+func TestFraction(t *testing.T) {
+	g := New(Options{Max: 200})
+	for _, tt := range []struct{ v, want float64 }{
+		{-5, 0}, {0, 0}, {50, 0.25}, {200, 1}, {900, 1},
+	} {
+		if got := g.Fraction(tt.v); got != tt.want {
+			t.Errorf("Fraction(%g) of 200 = %g, want %g", tt.v, got, tt.want)
+		}
+	}
+	if got := New(Options{}).Fraction(10); got != 0 {
+		t.Errorf("Fraction with no Max = %g, want 0", got)
+	}
+	g.SetMax(20)
+	if g.Max() != 20 || g.Fraction(10) != 0.5 {
+		t.Errorf("after SetMax(20): Max %g, Fraction(10) %g", g.Max(), g.Fraction(10))
+	}
+}
+
+func TestTickLine(t *testing.T) {
+	if got, want := TickLine(TickLabels(1000)), "     0  100  200  300  400  500  600  700  800  900 1000"; got != want {
+		t.Errorf("TickLine(1000) = %q, want %q", got, want)
+	}
+	if got, want := TickLabels(1)[5], "0.5"; got != want {
+		t.Errorf("middle tick of 1 = %q, want %q", got, want)
+	}
+}
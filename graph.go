@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Series of the live speed history graph.
+const (
+	seriesDownload = iota
+	seriesUpload
+	seriesLatency
+	seriesCount
+)
+
+const (
+	// graphWindow is how far back the speed history graph reaches.
+	graphWindow = 30 * time.Second
+	// graphHeight is the graph's height in rows, and graphWidth its
+	// widest in columns.
+	graphHeight = 8
+	graphWidth  = 50
+)
+
+// graphPoint is one sample of a series, at its time since the test began.
+type graphPoint struct {
+	at    time.Duration
+	value float64
+}
+
+// speedGraph holds the speed history graph's series. Speeds arrive every
+// sampleInterval and latency probes every probeInterval, so each sample
+// keeps its time and the columns line the series up on one time axis.
+type speedGraph struct {
+	series [seriesCount][]graphPoint
+	// hidden series were switched off with their number key.
+	hidden [seriesCount]bool
+}
+
+// add records a sample of series s, dropping samples that have scrolled
+// out of the window.
+func (g speedGraph) add(s int, at time.Duration, v float64) speedGraph {
+	points := append(slices.Clip(g.series[s]), graphPoint{at, v})
+	for len(points) > 0 && points[0].at < at-graphWindow {
+		points = points[1:]
+	}
+	g.series[s] = points
+	return g
+}
+
+// span returns the time the graph covers, from the oldest sample still in
+// the window to the newest of any series.
+func (g speedGraph) span() (from, to time.Duration, ok bool) {
+	for _, points := range g.series {
+		if len(points) == 0 {
+			continue
+		}
+		if !ok || points[0].at < from {
+			from = points[0].at
+		}
+		to = max(to, points[len(points)-1].at)
+		ok = true
+	}
+	return max(from, to-graphWindow), to, ok
+}
+
+// columns averages series s into width columns from from to to. A column
+// no sample fell in is NaN, unless it lies between two that have one: a
+// series sampled less often than the columns are wide carries its last
+// value across.
+func (g speedGraph) columns(s int, from, to time.Duration, width int) []float64 {
+	sums := make([]float64, width)
+	counts := make([]int, width)
+	step := (to - from) / time.Duration(width)
+	for _, p := range g.series[s] {
+		if p.at < from {
+			continue
+		}
+		col := min(int((p.at-from)/step), width-1)
+		sums[col] += p.value
+		counts[col]++
+	}
+	first, last := -1, -1
+	for i, n := range counts {
+		if n > 0 {
+			sums[i] /= float64(n)
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	for i := range sums {
+		switch {
+		case counts[i] > 0:
+		case i > first && i < last:
+			sums[i] = sums[i-1]
+		default:
+			sums[i] = math.NaN()
+		}
+	}
+	return sums
+}
+
+// toggleSeries shows or hides a series of the graph, for the number keys
+// 1 to 3.
+func (m speedTest) toggleSeries(key string) (tea.Model, tea.Cmd) {
+	s := int(key[0] - '1')
+	m.graph.hidden[s] = !m.graph.hidden[s]
+	return m, nil
+}
+
+// renderSpeedHistory draws the download, upload and loaded latency series
+// on a shared time axis, speeds against the left scale and latency against
+// the right, with a legend naming each series' key.
+func (m speedTest) renderSpeedHistory() string {
+	from, to, ok := m.graph.span()
+	width := min(graphWidth, int((to-from)/sampleInterval))
+	if m.width > 0 {
+		width = min(width, m.width-12)
+	}
+	if !ok || width < 2 {
+		return ""
+	}
+
+	var cols [seriesCount][]float64
+	present := [seriesCount]bool{}
+	for s := range cols {
+		cols[s] = m.graph.columns(s, from, to, width)
+		present[s] = len(m.graph.series[s]) > 0
+	}
+	shown := func(s int) bool { return present[s] && !m.graph.hidden[s] }
+
+	current, reference := seriesDownload, 0.0
+	if m.phase == phaseUploading {
+		current = seriesUpload
+		_, reference = m.baselineSpeeds()
+	} else {
+		reference, _ = m.baselineSpeeds()
+	}
+	peak := 0.0
+	for _, p := range m.graph.series[current] {
+		peak = math.Max(peak, p.value)
+	}
+	maxSpeed, maxLatency := reference, 0.0
+	for s := range cols {
+		if !shown(s) {
+			continue
+		}
+		for _, v := range cols[s] {
+			if math.IsNaN(v) {
+				continue
+			}
+			if s == seriesLatency {
+				maxLatency = math.Max(maxLatency, v)
+			} else {
+				maxSpeed = math.Max(maxSpeed, v)
+			}
+		}
+	}
+
+	var s strings.Builder
+	if reference > 0 {
+		s.WriteString(fmt.Sprintf("\nSpeed History (peak %s, \033[35mbaseline %s\033[0m):\n", m.formatLive(peak), m.formatLive(reference)))
+	} else {
+		s.WriteString(fmt.Sprintf("\nSpeed History (peak %s):\n", m.formatLive(peak)))
+	}
+
+	baselineRow := -1
+	if reference > 0 {
+		baselineRow = int(math.Round(reference / maxSpeed * float64(graphHeight-1)))
+	}
+	for row := graphHeight - 1; row >= 0; row-- {
+		threshold := float64(row) / float64(graphHeight-1) * maxSpeed
+		color := ""
+		put := func(c, glyph string) {
+			if c != color {
+				if color != "" {
+					s.WriteString("\033[0m")
+				}
+				if c != "" {
+					s.WriteString("\033[" + c + "m")
+				}
+				color = c
+			}
+			s.WriteString(glyph)
+		}
+		for col := range width {
+			lat := cols[seriesLatency][col]
+			switch {
+			case shown(seriesLatency) && maxLatency > 0 && !math.IsNaN(lat) && int(math.Round(lat/maxLatency*float64(graphHeight-1))) == row:
+				put(cues.Series[seriesLatency].Color, cues.Series[seriesLatency].Symbol)
+			case shown(seriesDownload) && maxSpeed > 0 && cols[seriesDownload][col] >= threshold:
+				put(cues.Series[seriesDownload].Color, cues.Series[seriesDownload].Symbol)
+			case shown(seriesUpload) && maxSpeed > 0 && cols[seriesUpload][col] >= threshold:
+				put(cues.Series[seriesUpload].Color, cues.Series[seriesUpload].Symbol)
+			case row == baselineRow:
+				put("35", "─")
+			default:
+				put("", " ")
+			}
+		}
+		put("", "")
+		if shown(seriesLatency) && maxLatency > 0 {
+			switch row {
+			case graphHeight - 1:
+				s.WriteString(fmt.Sprintf(" │ %.0f ms", maxLatency))
+			case 0:
+				s.WriteString(" │ 0 ms")
+			default:
+				s.WriteString(" │")
+			}
+		}
+		s.WriteString("\n")
+	}
+
+	var legend []string
+	for i, c := range cues.Series {
+		if !present[i] {
+			continue
+		}
+		entry := fmt.Sprintf("%d %s %s", i+1, c.Symbol, c.Tag)
+		if i == seriesLatency {
+			entry += " (right)"
+		}
+		if m.graph.hidden[i] {
+			legend = append(legend, "\033[90m"+entry+" (hidden)\033[0m")
+		} else {
+			legend = append(legend, "\033["+c.Color+"m"+entry+"\033[0m")
+		}
+	}
+	s.WriteString(strings.Join(legend, "   ") + "\n")
+	return s.String()
+}
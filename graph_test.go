@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// graphModel is a test in phase with the graph holding the given series:
+// download for the first 5s, then upload, and latency probes throughout,
+// each at its own sampling rate.
+func graphModel(p phase, width int, series ...int) speedTest {
+	m := speedTest{phase: p, width: width}
+	for _, s := range series {
+		switch s {
+		case seriesDownload:
+			for i := range 25 {
+				at := time.Duration(i+1) * sampleInterval
+				m.graph = m.graph.add(s, at, 80+30*math.Sin(float64(i)/4))
+			}
+		case seriesUpload:
+			for i := range 25 {
+				at := 5*time.Second + time.Duration(i+1)*sampleInterval
+				m.graph = m.graph.add(s, at, 20+8*math.Cos(float64(i)/3))
+			}
+		case seriesLatency:
+			for i := range 40 {
+				at := time.Duration(i+1) * probeInterval
+				m.graph = m.graph.add(s, at, 18+float64(i%7)*6)
+			}
+		}
+	}
+	return m
+}
+
+func TestSpeedHistoryGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		phase  phase
+		series []int
+	}{
+		{"graph-1-series", phaseDownloading, []int{seriesDownload}},
+		{"graph-2-series", phaseDownloading, []int{seriesDownload, seriesLatency}},
+		{"graph-3-series", phaseUploading, []int{seriesDownload, seriesUpload, seriesLatency}},
+	}
+	for _, tt := range tests {
+		for _, width := range []int{80, 40} {
+			got := sgrPattern.ReplaceAllString(graphModel(tt.phase, width, tt.series...).renderSpeedHistory(), "")
+			checkGolden(t, fmt.Sprintf("%s-%d", tt.name, width), got)
+			lines := strings.Split(strings.TrimSpace(got), "\n")
+			for _, line := range lines[1 : len(lines)-1] {
+				if n := len([]rune(line)); n > width {
+					t.Errorf("%s at %d columns has a row of %d: %q", tt.name, width, n, line)
+				}
+			}
+		}
+	}
+}
+
+func TestSpeedHistoryToggle(t *testing.T) {
+	m := graphModel(phaseUploading, 80, seriesDownload, seriesUpload, seriesLatency)
+	for _, key := range []string{"1", "3"} {
+		updated, _ := m.toggleSeries(key)
+		m = updated.(speedTest)
+	}
+	got := m.renderSpeedHistory()
+	if !strings.Contains(got, "1 █ download (hidden)") || !strings.Contains(got, "3 • latency (right) (hidden)") {
+		t.Errorf("legend doesn't mark the hidden series:\n%s", got)
+	}
+	plot := sgrPattern.ReplaceAllString(got[:strings.LastIndex(got[:len(got)-1], "\n")], "")
+	if strings.Contains(plot, "█") || strings.Contains(plot, "•") || strings.Contains(plot, " ms") {
+		t.Errorf("hidden series still plotted:\n%s", plot)
+	}
+	if !strings.Contains(plot, "▒") {
+		t.Errorf("the upload series went with the others:\n%s", plot)
+	}
+
+	updated, _ := m.toggleSeries("1")
+	if got := updated.(speedTest).renderSpeedHistory(); !strings.Contains(got, "\033["+cues.Series[seriesDownload].Color+"m█") {
+		t.Errorf("download not drawn in its color once shown again:\n%q", got)
+	}
+}
+
+// Speeds every sampleInterval and probes every probeInterval fill the
+// same columns, so neither series leaves gaps or runs ahead of the other.
+func TestGraphColumnsAligned(t *testing.T) {
+	m := graphModel(phaseDownloading, 80, seriesDownload, seriesLatency)
+	from, to, ok := m.graph.span()
+	if !ok || from != sampleInterval || to != 40*probeInterval {
+		t.Fatalf("span %s to %s, ok %v", from, to, ok)
+	}
+	const width = 40
+	speeds := m.graph.columns(seriesDownload, from, to, width)
+	probes := m.graph.columns(seriesLatency, from, to, width)
+	downloadEnd := int((25*sampleInterval - from) / ((to - from) / width))
+	for i := range width {
+		if math.IsNaN(probes[i]) {
+			t.Errorf("latency column %d is empty", i)
+		}
+		if math.IsNaN(speeds[i]) != (i > downloadEnd) {
+			t.Errorf("download column %d is %g, with the download ending in column %d", i, speeds[i], downloadEnd)
+		}
+	}
+
+	// A series sampled less often than the columns carries its value over.
+	var g speedGraph
+	g = g.add(seriesLatency, 0, 10)
+	g = g.add(seriesLatency, time.Second, 30)
+	got := g.columns(seriesLatency, 0, time.Second, 4)
+	if got[0] != 10 || got[1] != 10 || got[2] != 10 || got[3] != 30 {
+		t.Errorf("columns %v, want 10 carried to the last sample's 30", got)
+	}
+}
+
+func TestGraphWindow(t *testing.T) {
+	var g speedGraph
+	for i := range 300 {
+		g = g.add(seriesDownload, time.Duration(i)*sampleInterval, float64(i))
+	}
+	points := g.series[seriesDownload]
+	if first, last := points[0].at, points[len(points)-1].at; last-first > graphWindow {
+		t.Errorf("graph keeps %s of samples, more than its %s window", last-first, graphWindow)
+	}
+}
+
+func TestSeriesKeys(t *testing.T) {
+	m := initialModel(engine{provider: demoProvider{}, clock: newFakeClock()}, viewOptions{NoMenu: true})
+	m.phase = phaseDownloading
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("2")})
+	if !updated.(speedTest).graph.hidden[seriesUpload] {
+		t.Error("key 2 didn't hide the upload series")
+	}
+}
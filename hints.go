@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// errorHint turns a failure signature into something the user can act on.
+type errorHint struct {
+	Title  string
+	Advice string
+}
+
+// errorHints maps low-level failures to hints; the first match wins. Match
+// on typed errors rather than message text so wrapping and localized
+// system messages don't defeat them.
+var errorHints = []struct {
+	match func(error) bool
+	hint  errorHint
+}{
+	{isMissingRoots, errorHint{
+		Title:  "No CA certificates",
+		Advice: "This system has no CA certificates to verify HTTPS with, which is common in scratch and distroless containers. Mount ca-certificates (e.g. /etc/ssl/certs) or build gofast with -tags embedroots.",
+	}},
+	{isUnknownAuthority, errorHint{
+		Title:  "TLS interception",
+		Advice: "The server's certificate was signed by an authority this machine doesn't trust, which usually means a corporate proxy or antivirus is inspecting HTTPS. If you trust this network, --insecure-skip-verify runs the test anyway; its results are flagged as unverified.",
+	}},
+	{isRefusedEverywhere, errorHint{
+		Title:  "Every server refused the connection",
+		Advice: "Something is rejecting outbound connections before they reach the servers. Check for a firewall that blocks the test ports, or a VPN that restricts traffic, and try again without it.",
+	}},
+	{isMissingResolver, errorHint{
+		Title:  "No DNS configuration",
+		Advice: "There is no /etc/resolv.conf, so lookups went to localhost, where nothing answers. This is common in scratch and distroless containers: mount the host's resolv.conf, or give the container a network that provides one.",
+	}},
+	{isFamilyError, errorHint{
+		Title:  "Address family unavailable",
+		Advice: "The run was pinned to one address family with --ipv4 or --ipv6, and this machine or the test server can't be reached over it. Run without the flag to let the system choose, or try the other family; --compare-stacks shows which of the two work.",
+	}},
+	{isKnownHostNotFound, errorHint{
+		Title:  "DNS isn't resolving well-known names",
+		Advice: "Your resolver says a name that always exists doesn't. Check the DNS servers in your network settings, or a VPN or filter that rewrites DNS.",
+	}},
+}
+
+func hintFor(err error) (errorHint, bool) {
+	for _, h := range errorHints {
+		if h.match(err) {
+			return h.hint, true
+		}
+	}
+	return errorHint{}, false
+}
+
+func isUnknownAuthority(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	return errors.As(err, &unknown)
+}
+
+// isRefusedEverywhere matches a server selection where every candidate
+// refused the connection.
+func isRefusedEverywhere(err error) bool {
+	var sel *serverSelectionError
+	if !errors.As(err, &sel) || len(sel.Errs) == 0 {
+		return false
+	}
+	for _, err := range sel.Errs {
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return false
+		}
+	}
+	return true
+}
+
+// knownHosts always exist, so a resolver that can't find them is broken.
+var knownHosts = []string{pingHost, "ipapi.co"}
+
+func isKnownHostNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound && slices.Contains(knownHosts, dnsErr.Name)
+}
+
+// wrapText breaks s into lines of at most width columns at spaces.
+func wrapText(s string, width int) string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+// hintOnce prints hint to w unless a hint with the same key was shown
+// before, remembering shown keys next to the config file.
+func hintOnce(w io.Writer, key, hint string) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return
+	}
+	path := filepath.Join(dir, "gofast", "shown-hints")
+	data, _ := os.ReadFile(path)
+	if slices.Contains(strings.Split(string(data), "\n"), key) {
+		return
+	}
+	fmt.Fprintln(w, hint)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, key)
+	f.Close()
+}
+
+// checkBareSpeeds hints once about each bare speed that doesn't fit the
+// measured history.
+func checkBareSpeeds(w io.Writer, bare []bareSpeed, history *historyStore) {
+	if len(bare) == 0 || history == nil {
+		return
+	}
+	runs, err := history.Load()
+	if err != nil {
+		return
+	}
+	for _, b := range bare {
+		if hint := unitHint(b, runs); hint != "" {
+			hintOnce(w, fmt.Sprintf("units %s=%g", b.Name, b.Mbps), hint)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestHintUnknownAuthority(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	_, err := http.Get(srv.URL)
+	if err == nil {
+		t.Fatal("an untrusted certificate was accepted")
+	}
+	if !isUnknownAuthority(err) {
+		t.Fatalf("isUnknownAuthority(%v) = false", err)
+	}
+	if isUnknownAuthority(errors.New("x509: certificate signed by unknown authority")) {
+		t.Error("matched an untyped error by its text")
+	}
+	if pool, perr := x509.SystemCertPool(); perr != nil || pool.Equal(x509.NewCertPool()) {
+		t.Skip("no system roots here, so the missing-roots hint wins")
+	}
+	if hint, ok := hintFor(fmt.Errorf("download: %w", err)); !ok || hint.Title != "TLS interception" {
+		t.Errorf("hintFor(%v) = %q, %v; want TLS interception", err, hint.Title, ok)
+	}
+}
+
+func TestHintMissingRoots(t *testing.T) {
+	err := fmt.Errorf("handshake: %w", x509.SystemRootsError{})
+	if hint, ok := hintFor(err); !ok || hint.Title != "No CA certificates" {
+		t.Errorf("hintFor(%v) = %q, %v; want No CA certificates", err, hint.Title, ok)
+	}
+}
+
+func TestHintRefusedEverywhere(t *testing.T) {
+	// A listener closed again leaves a port nothing listens on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, refused := net.Dial("tcp", addr)
+	if !errors.Is(refused, syscall.ECONNREFUSED) {
+		t.Skipf("dialing a closed port gave %v", refused)
+	}
+
+	all := &serverSelectionError{Errs: []error{refused, fmt.Errorf("BOM: %w", refused)}}
+	if hint, ok := hintFor(fmt.Errorf("selecting a server: %w", all)); !ok || hint.Title != "Every server refused the connection" {
+		t.Errorf("hintFor(every server refused) = %q, %v", hint.Title, ok)
+	}
+	for _, sel := range []*serverSelectionError{
+		{Errs: []error{refused, errors.New("503 Service Unavailable")}},
+		{},
+	} {
+		if isRefusedEverywhere(sel) {
+			t.Errorf("isRefusedEverywhere(%v) = true", sel.Errs)
+		}
+	}
+	if isRefusedEverywhere(refused) {
+		t.Error("a single refused dial counted as every server refusing")
+	}
+}
+
+func TestHintKnownHostNotFound(t *testing.T) {
+	for _, host := range knownHosts {
+		err := &net.DNSError{Err: "no such host", Name: host, Server: "192.0.2.53:53", IsNotFound: true}
+		if hint, ok := hintFor(fmt.Errorf("ping: %w", err)); !ok || hint.Title != "DNS isn't resolving well-known names" {
+			t.Errorf("hintFor(NXDOMAIN for %s) = %q, %v", host, hint.Title, ok)
+		}
+	}
+	for _, err := range []*net.DNSError{
+		{Err: "no such host", Name: "typo.example", IsNotFound: true},
+		{Err: "i/o timeout", Name: pingHost, IsTimeout: true},
+	} {
+		if isKnownHostNotFound(err) {
+			t.Errorf("isKnownHostNotFound(%v) = true", err)
+		}
+	}
+}
+
+func TestHintMissingResolver(t *testing.T) {
+	err := &net.DNSError{Err: "connection refused", Name: "speed.cloudflare.com", Server: "127.0.0.1:53"}
+	want := runtime.GOOS == "linux" && !hostEnv().exists(resolvConf)
+	if got := isMissingResolver(err); got != want {
+		t.Errorf("isMissingResolver(lookup on localhost) = %v, want %v", got, want)
+	}
+	if isMissingResolver(&net.DNSError{Err: "connection refused", Name: "x", Server: "192.0.2.53:53"}) {
+		t.Error("a lookup on a remote resolver matched")
+	}
+}
+
+func TestHintFamily(t *testing.T) {
+	err := fmt.Errorf("run: %w", &familyError{"tcp6", errors.New("network is unreachable")})
+	if hint, ok := hintFor(err); !ok || hint.Title != "Address family unavailable" {
+		t.Errorf("hintFor(%v) = %q, %v", err, hint.Title, ok)
+	}
+}
+
+func TestHintNone(t *testing.T) {
+	if hint, ok := hintFor(errors.New("unexpected status 500")); ok {
+		t.Errorf("hintFor(an unknown failure) = %q", hint.Title)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText("Check for a firewall that blocks the test ports", 16)
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > 16 {
+			t.Errorf("line %q is over 16 columns", line)
+		}
+	}
+	if strings.Join(strings.Fields(got), " ") != "Check for a firewall that blocks the test ports" {
+		t.Errorf("wrapping changed the words: %q", got)
+	}
+}
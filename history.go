@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// historyStore persists completed runs as one JSON document per line. Any
+// number of processes may share a store: appends hold an exclusive lock and
+// write each run in a single write, and reads take a snapshot of the file's
+// length so they never see a half-written line or hold up a writer.
+type historyStore struct {
+	path string
+}
+
+func defaultHistoryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofast", "history.jsonl"), nil
+}
+
+func (h historyStore) Append(r Results) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	f, err := h.openLocked(os.O_APPEND|os.O_CREATE|os.O_WRONLY, true)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openLocked opens the store and locks it. A file quarantined by another
+// process while we waited for the lock is no longer the store, so we retry
+// on whatever is at the path now.
+func (h historyStore) openLocked(flag int, exclusive bool) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(h.path, flag, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if h.isCurrent(f) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+func (h historyStore) isCurrent(f *os.File) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(h.path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// historyLoadLimit bounds the runs Load returns. The file itself keeps
+// everything; a months-old store is read through, not held in memory.
+const historyLoadLimit = 1000
+
+// Load returns the most recent historyLoadLimit runs, oldest first. Lines
+// that don't decode are skipped so one bad write doesn't hide the rest of
+// the history, but a file that is mostly unreadable is quarantined next to
+// the store and a new history started, rather than failing every run from
+// then on.
+func (h historyStore) Load() ([]Results, error) {
+	f, err := h.openLocked(os.O_RDONLY, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	unlockFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []Results
+	good, bad := 0, 0
+	scanner := bufio.NewScanner(io.LimitReader(f, info.Size()))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r Results
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			bad++
+			continue
+		}
+		good++
+		runs = append(runs, r)
+		if len(runs) == 2*historyLoadLimit {
+			runs = append(runs[:0], runs[historyLoadLimit:]...)
+		}
+	}
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) || bad > good {
+		return nil, h.quarantine(f)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(runs) > historyLoadLimit {
+		runs = slices.Clone(runs[len(runs)-historyLoadLimit:])
+	}
+	return runs, nil
+}
+
+// Remove deletes every stored run that match selects and reports how many
+// there were.
+func (h historyStore) Remove(match func(Results) bool) (int, error) {
+	return h.rewrite(match, nil)
+}
+
+// rewrite applies change to the JSON fields of every stored run that match
+// selects and reports how many runs matched; a nil change drops them. The store is rewritten to a temporary file that replaces it while the lock
+// is held, so a concurrent Append waits and then writes to the new file.
+// Fields this version doesn't know are kept as they were.
+func (h historyStore) rewrite(match func(Results) bool, change func(fields map[string]json.RawMessage) error) (int, error) {
+	f, err := h.openLocked(os.O_RDONLY, true)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var r Results
+		if json.Unmarshal(line, &r) != nil || !match(r) {
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		n++
+		if change == nil {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return 0, err
+		}
+		if err := change(fields); err != nil {
+			return 0, err
+		}
+		if line, err = json.Marshal(fields); err != nil {
+			return 0, err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), "."+filepath.Base(h.path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), h.path)
+}
+
+// quarantine moves a corrupt store aside, unless another process already
+// has.
+func (h historyStore) quarantine(f *os.File) error {
+	if err := lockFile(f, true); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	if !h.isCurrent(f) {
+		return nil
+	}
+	moved := h.path + ".corrupt-" + time.Now().Format("20060102-150405")
+	if err := os.Rename(h.path, moved); err != nil {
+		return fmt.Errorf("history %s is unreadable: %w", h.path, err)
+	}
+	return fmt.Errorf("history %s was unreadable and has been moved to %s; starting a new one", h.path, moved)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHistoryConcurrentStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	const writers, runs, readers = 6, 40, 3
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+readers)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store := historyStore{path: path}
+			for i := range runs {
+				// A long note makes each write big enough to tear if the
+				// writes weren't single and locked.
+				r := Results{Provider: "demo", Note: fmt.Sprintf("w%d-%d %s", w, i, strings.Repeat("x", 4096))}
+				if err := store.Append(r); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	var readWG sync.WaitGroup
+	for range readers {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			store := historyStore{path: path}
+			last := 0
+			for {
+				got, err := store.Load()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(got) < last {
+					errs <- fmt.Errorf("a read saw %d runs after one saw %d", len(got), last)
+					return
+				}
+				last = len(got)
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readWG.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	got, err := historyStore{path: path}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != writers*runs {
+		t.Fatalf("history holds %d runs, want %d", len(got), writers*runs)
+	}
+	seen := map[string]bool{}
+	for _, r := range got {
+		seen[r.Note] = true
+	}
+	if len(seen) != writers*runs {
+		t.Errorf("history holds %d distinct runs, want %d", len(seen), writers*runs)
+	}
+}
+
+func TestHistoryRewriteKeepsConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	const runs = 100
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		store := historyStore{path: path}
+		for i := range runs {
+			if err := store.Append(Results{Provider: "demo", Note: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		store := historyStore{path: path}
+		for range 20 {
+			if _, err := store.Remove(func(r Results) bool { return r.Provider == "gone" }); err != nil && !os.IsNotExist(err) {
+				t.Error(err)
+				return
+			}
+			store.Append(Results{Provider: "gone"})
+		}
+	}()
+	wg.Wait()
+
+	got, err := historyStore{path: path}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for _, r := range got {
+		if r.Provider == "demo" {
+			kept++
+		}
+	}
+	if kept != runs {
+		t.Errorf("%d of %d appended runs survived the rewrites", kept, runs)
+	}
+}
+
+func TestHistoryQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	if err := os.WriteFile(path, []byte("{\"provider\":\"demo\"}\nnot json\nnor this\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := historyStore{path: path}
+	if _, err := store.Load(); err == nil || !strings.Contains(err.Error(), "moved to") {
+		t.Fatalf("loading a corrupt history: %v, want it quarantined", err)
+	}
+	moved, _ := filepath.Glob(path + ".corrupt-*")
+	if len(moved) != 1 {
+		t.Fatalf("quarantined files: %v, want one", moved)
+	}
+	if err := store.Append(Results{Provider: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Load(); err != nil || len(got) != 1 {
+		t.Errorf("new history holds %d runs (%v), want 1", len(got), err)
+	}
+}
+
+func TestHistorySkipsABadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte("{\"provider\":\"a\"}\n{\"prov\n{\"provider\":\"b\"}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := historyStore{path: path}.Load()
+	if err != nil || len(got) != 2 || got[0].Provider != "a" || got[1].Provider != "b" {
+		t.Errorf("Load = %+v, %v; want runs a and b", got, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// comparePanelWidth is the width of one run's panel in a comparison. Below
+// two of them side by side, the panels are stacked.
+const comparePanelWidth = 38
+
+// historyView is "View history": the recent runs, two of which can be
+// tagged and compared side by side.
+type historyView struct {
+	runs   []Results
+	cursor int
+	// tagged are the indexes of the tagged runs in the order they were
+	// tagged; the first is A and the second B.
+	tagged []int
+	// compare is the open comparison, nil while the list is shown.
+	compare *runComparison
+}
+
+func newHistoryView(runs []Results) *historyView {
+	return &historyView{runs: runs, cursor: len(runs) - 1}
+}
+
+// updateHistory handles keys while the history is open: up/down move,
+// space tags a run, 'c' compares the two tagged, and esc or 'h' closes the
+// comparison, then the history.
+func (m speedTest) updateHistory(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	h := m.history
+	if h.compare != nil {
+		switch msg.String() {
+		case "c", "esc", "h":
+			h.compare = nil
+		}
+		return m, nil
+	}
+	switch msg.String() {
+	case "h", "esc":
+		m.history = nil
+	case "up", "k":
+		h.cursor = max(h.cursor-1, 0)
+	case "down", "j":
+		h.cursor = min(h.cursor+1, len(h.runs)-1)
+	case " ":
+		h.toggleTag(h.cursor)
+	case "c":
+		if len(h.tagged) < 2 {
+			m.notice = "Tag two runs with space to compare them"
+			break
+		}
+		c := compareRuns(h.runs[h.tagged[0]], h.runs[h.tagged[1]])
+		h.compare = &c
+		m.notice = ""
+	}
+	return m, nil
+}
+
+// toggleTag tags or untags a run. Tagging a third replaces the first.
+func (h *historyView) toggleTag(i int) {
+	if j := slices.Index(h.tagged, i); j >= 0 {
+		h.tagged = slices.Delete(h.tagged, j, j+1)
+		return
+	}
+	if len(h.tagged) == 2 {
+		h.tagged = h.tagged[1:]
+	}
+	h.tagged = append(h.tagged, i)
+}
+
+func (h *historyView) view(width int) string {
+	if width <= 0 {
+		width = 80
+	}
+	if h.compare != nil {
+		return h.compare.view(width) + "\nPress 'c' to return to the list"
+	}
+	var s strings.Builder
+	s.WriteString("Recent runs:\n\n")
+	fmt.Fprintf(&s, "     %-15s %12s %12s %10s  %s\n", "ID", "Download", "Upload", "Ping", "Note")
+	for i, r := range h.runs {
+		cursor := "  "
+		if i == h.cursor {
+			cursor = "\033[36;1m▸\033[0m "
+		}
+		tag := " "
+		if j := slices.Index(h.tagged, i); j >= 0 {
+			tag = "\033[1m" + string(rune('A'+j)) + "\033[0m"
+		}
+		upload := "n/a"
+		if r.Upload != nil {
+			upload = formatSpeed(r.Upload.Mbps)
+		}
+		fmt.Fprintf(&s, "%s%s  %-15s %12s %12s %10s  %s\n", cursor, tag, runID(r),
+			formatSpeed(transferMbps(r.Download)), upload, formatPing(r.Latency), truncate(r.Note, max(width-62, 0)))
+	}
+	s.WriteString("\n\033[90m↑/↓ to move, space to tag two runs, 'c' to compare them\033[0m\n")
+	s.WriteString("Press 'h' to return to the results")
+	return s.String()
+}
+
+// comparePanel is one run's headline numbers in a comparison.
+func comparePanel(tag string, r Results) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "\033[1m%s\033[0m  %s\n", tag, runID(r))
+	fmt.Fprintf(&s, "  Download  %s\n", formatSpeed(transferMbps(r.Download)))
+	if r.Upload != nil {
+		fmt.Fprintf(&s, "  Upload    %s\n", formatSpeed(r.Upload.Mbps))
+	} else {
+		s.WriteString("  Upload    n/a\n")
+	}
+	fmt.Fprintf(&s, "  Ping      %s\n", formatPing(r.Latency))
+	fmt.Fprintf(&s, "  Server    %s", truncate(orNone(r.Server.Label()), comparePanelWidth-12))
+	return s.String()
+}
+
+// view lays out a comparison: the two runs' panels side by side, or
+// stacked when the terminal is too narrow, then the deltas, the metadata
+// that differs and the overlaid sample graphs.
+func (c runComparison) view(width int) string {
+	a, b := comparePanel(overlayA+" A", c.A), comparePanel(overlayB+" B", c.B)
+	var s strings.Builder
+	if width >= 2*comparePanelWidth+2 {
+		s.WriteString(lipgloss.JoinHorizontal(lipgloss.Top, lipgloss.NewStyle().Width(comparePanelWidth).Render(a), "  ", b))
+		s.WriteString("\n")
+	} else {
+		s.WriteString(a + "\n\n" + b + "\n")
+	}
+	s.WriteString("\n" + c.deltas())
+	s.WriteString("\n" + c.meta(width))
+	s.WriteString("\n" + c.graphs(max(min(width, 100)-14, 20)))
+	return s.String()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --http3 runs the transfers over HTTP/3. Like the address family, the
+// choice travels on the run's context: every transfer client dispatches
+// each request by the context it was made under, so any provider's
+// transfers switch without each being handed a transport.
+//
+// QUIC comes from quic-go; see http3_quic.go.
+
+// quicHandshakeTimeout bounds the QUIC handshake. A network that drops
+// UDP never answers, so this is how long --http3 waits before falling
+// back to HTTP/2, well short of handshakeTimeout.
+const quicHandshakeTimeout = 3 * time.Second
+
+type http3Key struct{}
+
+// withHTTP3 sends transfer requests made under ctx over HTTP/3.
+func withHTTP3(ctx context.Context) context.Context {
+	return context.WithValue(ctx, http3Key{}, true)
+}
+
+func wantsHTTP3(ctx context.Context) bool {
+	on, _ := ctx.Value(http3Key{}).(bool)
+	return on
+}
+
+// protocolName is the HTTP version a response came over, as reported in
+// the results.
+func protocolName(resp *http.Response) string {
+	switch resp.ProtoMajor {
+	case 3:
+		return "HTTP/3"
+	case 2:
+		return "HTTP/2"
+	}
+	return "HTTP/1.1"
+}
+
+// transferProtocols names the HTTP versions of r's transfers for the
+// details panel, each direction's when they differ, or "" when none were
+// counted.
+func transferProtocols(r Results) string {
+	var download, upload string
+	if r.Download != nil {
+		download = r.Download.Protocol
+	}
+	if r.Upload != nil {
+		upload = r.Upload.Protocol
+	}
+	switch {
+	case download == upload || upload == "":
+		return download
+	case download == "":
+		return upload
+	}
+	return fmt.Sprintf("%s down, %s up", download, upload)
+}
+
+// http3Context returns ctx with HTTP/3 turned on when the server answers a
+// QUIC handshake in time. When it doesn't, typically because UDP is
+// blocked, the run goes on over HTTP/2 with a warning rather than hanging
+// until the transfers time out.
+func (e engine) http3Context(ctx context.Context, r *Results, emit func(tea.Msg)) context.Context {
+	if err := probeHTTP3(ctx, r.Server); err != nil {
+		warn(r, emit, warnHTTP3Unavailable, "%v; testing over HTTP/2 instead", err)
+		return ctx
+	}
+	return withHTTP3(ctx)
+}
+
+// probeHTTP3 checks that s can be reached over HTTP/3 at all, on the UDP
+// port matching the TCP port its URL names.
+func probeHTTP3(ctx context.Context, s Server) error {
+	host, port := s.Host, "443"
+	if u, err := url.Parse(s.URL); err == nil {
+		if u.Scheme == "http" {
+			return errors.New("HTTP/3 needs an https:// server")
+		}
+		if u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		if u.Port() != "" {
+			port = u.Port()
+		}
+	}
+	if host == "" {
+		return errors.New("the server's host isn't known, so HTTP/3 can't be tried")
+	}
+	ctx, cancel := context.WithTimeout(ctx, quicHandshakeTimeout)
+	defer cancel()
+	if err := probeQUIC(ctx, host, port); err != nil {
+		return &http3Error{host: host, port: port, err: err}
+	}
+	return nil
+}
+
+// http3Error is a server that didn't complete a QUIC handshake.
+type http3Error struct {
+	host, port string
+	err        error
+}
+
+func (e *http3Error) Error() string {
+	return fmt.Sprintf("no HTTP/3 answer from %s on UDP port %s within %s (%v); UDP/%s may be blocked", e.host, e.port, quicHandshakeTimeout, e.err, e.port)
+}
+
+func (e *http3Error) Unwrap() error { return e.err }
+
+// protocolRuns are the protocols --compare-protocols measures, in column
+// order.
+var protocolRuns = []string{"HTTP/2", "HTTP/3"}
+
+// compareProtocols runs a download over HTTP/2 and then over HTTP/3, for
+// --compare-protocols. A protocol that can't be used is reported in its
+// entry and doesn't stop the other.
+func compareProtocols(ctx context.Context, e engine) []Results {
+	var runs []Results
+	for _, protocol := range protocolRuns {
+		runs = append(runs, e.protocolRun(ctx, protocol))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return runs
+}
+
+// protocolRun is one protocol's entry, with a provider of its own so the
+// second download doesn't start on connections the first left open.
+func (e engine) protocolRun(ctx context.Context, protocol string) Results {
+	if e.fresh != nil {
+		e.provider = e.fresh()
+	}
+	r := newResults(e.provider, e.profile, e.clock)
+	r.Interface, r.Protocol = e.iface, protocol
+	err := func() error {
+		ctx := withFamily(withInterface(ctx, e.iface), e.family)
+		server, err := e.provider.Server(ctx)
+		if err != nil {
+			return err
+		}
+		r.Server = server
+		if protocol == "HTTP/3" {
+			if err := probeHTTP3(ctx, server); err != nil {
+				return err
+			}
+			ctx = withHTTP3(ctx)
+		}
+		limit := transferLimit{bytes: tighterCap(e.profile.MaxDownload, e.maxBytes/int64(len(protocolRuns)))}
+		download, err := e.transfer(ctx, "download", e.provider.Download, limit, func(tea.Msg) {})
+		if err != nil {
+			return err
+		}
+		r.Download = &download
+		return nil
+	}()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return e.privacy.redact(r)
+}
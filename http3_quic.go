@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func quicConfig() *quic.Config {
+	// A connection that goes quiet for stallTimeout is torn down, as
+	// countingConn does for TCP.
+	return &quic.Config{HandshakeIdleTimeout: quicHandshakeTimeout, MaxIdleTimeout: stallTimeout}
+}
+
+func quicTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+}
+
+// newQUICTransport is the HTTP/3 side of a transfer client. Its
+// connections are counted into m like the TCP side's.
+func newQUICTransport(m *meter) http.RoundTripper {
+	return &http3.Transport{
+		TLSClientConfig: quicTLSConfig(),
+		QUICConfig:      quicConfig(),
+		Dial: func(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+			return dialQUIC(ctx, addr, tlsConf, conf, m)
+		},
+	}
+}
+
+// probeQUIC completes a QUIC handshake with host on UDP port and closes it
+// again.
+func probeQUIC(ctx context.Context, host, port string) error {
+	tlsConf := quicTLSConfig()
+	tlsConf.ServerName = host
+	tlsConf.NextProtos = []string{http3.NextProtoH3}
+	conn, err := dialQUIC(ctx, net.JoinHostPort(host, port), tlsConf, quicConfig(), nil)
+	if err != nil {
+		return err
+	}
+	return conn.CloseWithError(0, "")
+}
+
+// dialQUIC opens a QUIC connection to addr from a socket of its own, on the
+// interface and over the family ctx names. With m set, every datagram is
+// counted into its wire bytes.
+func dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config, m *meter) (*quic.Conn, error) {
+	network := familyNetwork(ctx, "udp")
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	if name := boundInterface(ctx); name != "" {
+		lc.Control = bindToDevice(name)
+	}
+	pc, err := lc.ListenPacket(ctx, network, ":0")
+	if err != nil {
+		return nil, err
+	}
+	var packets net.PacketConn = pc
+	if m != nil {
+		m.AddRemote(raddr)
+		packets = countingPacketConn{PacketConn: pc, m: m}
+	}
+	conn, err := quic.DialEarly(ctx, packets, raddr, tlsConf, conf)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	// quic-go leaves a socket it was handed open; it goes with the
+	// connection.
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	return conn, nil
+}
+
+// countingPacketConn attributes every datagram, QUIC headers and all, to a
+// meter's wire counter.
+type countingPacketConn struct {
+	net.PacketConn
+	m *meter
+}
+
+func (c countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.m.AddWire(int64(n))
+	return n, addr, err
+}
+
+func (c countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.m.AddWire(int64(n))
+	return n, err
+}
+
+// SetReadBuffer and SetWriteBuffer let quic-go size the socket's buffers as
+// it would an unwrapped one. Nothing else of the *net.UDPConn is passed
+// through, so quic-go keeps to ReadFrom and WriteTo, which count.
+
+func (c countingPacketConn) SetReadBuffer(bytes int) error {
+	return c.PacketConn.(*net.UDPConn).SetReadBuffer(bytes)
+}
+
+func (c countingPacketConn) SetWriteBuffer(bytes int) error {
+	return c.PacketConn.(*net.UDPConn).SetWriteBuffer(bytes)
+}
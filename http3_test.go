@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// startHTTP3 serves handler over HTTP/3 on a loopback UDP port, with
+// httptest's self-signed certificate, and returns the server's URL.
+func startHTTP3(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	certs := httptest.NewUnstartedServer(nil)
+	certs.StartTLS()
+	tlsConf := certs.TLS.Clone()
+	certs.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http3.Server{Handler: handler, TLSConfig: http3.ConfigureTLSConfig(tlsConf)}
+	go srv.Serve(pc)
+	t.Cleanup(func() {
+		srv.Close()
+		pc.Close()
+	})
+	return "https://" + pc.LocalAddr().String() + "/"
+}
+
+func trustAnyCert(t *testing.T) {
+	saved := insecureSkipVerify
+	insecureSkipVerify = true
+	t.Cleanup(func() { insecureSkipVerify = saved })
+}
+
+func TestProbeHTTP3UsesTheURLPort(t *testing.T) {
+	trustAnyCert(t)
+	u := startHTTP3(t, http.NotFoundHandler())
+	if err := probeHTTP3(context.Background(), Server{URL: u, Host: "127.0.0.1"}); err != nil {
+		t.Fatalf("probe of %s: %v", u, err)
+	}
+}
+
+func TestProbeHTTP3NamesThePort(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	pc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = probeHTTP3(ctx, Server{URL: "https://127.0.0.1:" + port + "/"})
+	if err == nil {
+		t.Fatal("probe of a closed port succeeded")
+	}
+	if !strings.Contains(err.Error(), "UDP port "+port) {
+		t.Errorf("error %q doesn't name UDP port %s", err, port)
+	}
+}
+
+func TestTransferClientOverHTTP3(t *testing.T) {
+	trustAnyCert(t)
+	u := startHTTP3(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 64<<10))
+	}))
+	m := &meter{}
+	client := newTransferClient(m)
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(withHTTP3(context.Background()), http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if n != 64<<10 {
+		t.Errorf("read %d bytes, want %d", n, 64<<10)
+	}
+	if got := m.Protocol(); got != "HTTP/3" {
+		t.Errorf("protocol = %q, want HTTP/3", got)
+	}
+	if m.wire.Load() < n {
+		t.Errorf("wire bytes %d below the %d bytes of payload", m.wire.Load(), n)
+	}
+}
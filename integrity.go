@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errBadPayload fails a download whose response can't be trusted as payload.
+var errBadPayload = errors.New("server returned an invalid download")
+
+const downloadBufferSize = 32 << 10
+
+// readCounted reads a download response body to its end, adding every byte
+// to m as it arrives, and records it for the data-quality check. The first
+// chunk is sniffed so an HTML error page served with a 200 isn't timed as a
+// fast download, and a body longer than record.Expected fails the phase.
+// A body shorter than promised raises the short_read flag and the stream
+// asks again. The bytes of a rejected or short response are taken back out
+// of m, so they never reach the throughput figure.
+func readCounted(ctx context.Context, clock Clock, resp *http.Response, m *meter, record transferRecord) error {
+	buf := make([]byte, downloadBufferSize)
+	want := record.Expected
+	reject := func(format string, args ...any) error {
+		m.Add(-record.Received)
+		return fmt.Errorf("%w: "+format, append([]any{errBadPayload}, args...)...)
+	}
+	for {
+		n, err := resp.Body.Read(buf)
+		if record.Received == 0 && n > 0 && looksLikeHTML(resp.Header.Get("Content-Type"), buf[:n]) {
+			return reject("got an HTML page instead of test data from %s", resp.Request.URL.Host)
+		}
+		m.Add(int64(n))
+		record.Received += int64(n)
+		if want >= 0 && record.Received > want {
+			return reject("got more than the %s %s promised", formatBytes(want), resp.Request.URL.Host)
+		}
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// Cut short by the server or a middlebox: kept for the
+			// data-quality check, and the stream asks again.
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// Cut off by the end of the phase; the bytes so far
+				// still count, but the response was never going to be
+				// complete.
+				return nil
+			}
+			return err
+		}
+	}
+	if want >= 0 && record.Received < want && ctx.Err() == nil {
+		m.Add(-record.Received)
+	}
+	record.Ended = clock.Now()
+	m.Record(record)
+	return nil
+}
+
+// looksLikeHTML reports whether a response that should be binary test data
+// is really a captive portal or error page.
+func looksLikeHTML(contentType string, head []byte) bool {
+	if strings.HasPrefix(contentType, "text/html") {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(head), "text/html")
+}
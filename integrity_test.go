@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readFrom runs readCounted over one GET of handler's response.
+func readFrom(t *testing.T, handler http.HandlerFunc) (*meter, error) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	m := &meter{}
+	record := transferRecord{URL: srv.URL, Offset: -1, Expected: resp.ContentLength}
+	return m, readCounted(context.Background(), realClock{}, resp, m, record)
+}
+
+func TestReadCounted(t *testing.T) {
+	payload := strings.Repeat("\x00\x01", 50_000)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		bytes   int64
+		bad     bool
+		short   bool
+	}{
+		{
+			name: "whole body counts",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte(payload))
+			},
+			bytes: int64(len(payload)),
+		},
+		{
+			name: "HTML page is rejected",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<!DOCTYPE html><html><body>Sign in to the Wi-Fi</body></html>" + strings.Repeat(" ", 75_000)))
+			},
+			bad: true,
+		},
+		{
+			name: "HTML sniffed without a content type",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte("<html><head><title>502</title></head></html>"))
+			},
+			bad: true,
+		},
+		{
+			name: "short 200 body doesn't count",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("Content-Length", strconv.Itoa(2*len(payload)))
+				w.Write([]byte(payload))
+			},
+			short: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := readFrom(t, tt.handler)
+			if tt.bad {
+				if !errors.Is(err, errBadPayload) {
+					t.Fatalf("err = %v, want errBadPayload", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Bytes(); got != tt.bytes {
+				t.Errorf("counted %d bytes, want %d", got, tt.bytes)
+			}
+			flagged := false
+			for _, q := range checkDataQuality(Transfer{}, m.Records()) {
+				flagged = flagged || q.Flag == flagShortRead
+			}
+			if flagged != tt.short {
+				t.Errorf("short_read flagged = %v, want %v", flagged, tt.short)
+			}
+		})
+	}
+}
+
+func TestReadCountedRejectsOverlongBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(make([]byte, 100_000))
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	m := &meter{}
+	// The server promised less than it sent, as a misconfigured proxy
+	// that rewrites the body but not the length does.
+	record := transferRecord{URL: srv.URL, Offset: -1, Expected: 40_000}
+	if err := readCounted(context.Background(), realClock{}, resp, m, record); !errors.Is(err, errBadPayload) {
+		t.Fatalf("err = %v, want errBadPayload", err)
+	}
+	if got := m.Bytes(); got != 0 {
+		t.Errorf("counted %d bytes of a rejected response", got)
+	}
+}
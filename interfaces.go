@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// interfaceList is the repeatable --interface flag.
+type interfaceList []string
+
+func (l *interfaceList) String() string { return strings.Join(*l, ",") }
+
+func (l *interfaceList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// interfaceNames lists the machine's interfaces, for suggestions.
+func interfaceNames() []string {
+	ifaces, _ := net.Interfaces()
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return names
+}
+
+// onInterface returns the engine for a run bound to the named interface,
+// with a provider of its own.
+func (e engine) onInterface(name string) engine {
+	e.iface = name
+	if e.fresh != nil {
+		e.provider = e.fresh()
+	}
+	return e
+}
+
+// failedRun is the entry for an interface whose run failed.
+func (e engine) failedRun(err error) Results {
+	return Results{Timestamp: e.clock.Now(), Provider: e.provider.Name(), Profile: e.profile.Name, Interface: e.iface, Error: err.Error()}
+}
+
+// nextInterface records the run on the interface under test, finished or
+// failed, and starts the next interface's, if there is one left; cmd is
+// whatever the finished run still has to do.
+func (m speedTest) nextInterface(cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	if m.engine.iface == "" {
+		return m, cmd
+	}
+	r := m.results
+	if m.phase == phaseError {
+		r = m.engine.failedRun(m.err)
+	}
+	runs := append(slices.Clip(m.ifaceRuns), r)
+	if len(runs) == len(m.opts.Interfaces) {
+		m.ifaceRuns = runs
+		return m, cmd
+	}
+	next, start := m.startOver(m.engine.onInterface(m.opts.Interfaces[len(runs)]))
+	next.ifaceRuns = runs
+	return next, tea.Batch(cmd, start)
+}
+
+// interfaceComparison sets the runs on every interface side by side once
+// the last has finished, with the best of each figure in bold.
+func (m speedTest) interfaceComparison() string {
+	if len(m.ifaceRuns) < 2 {
+		return ""
+	}
+	return compareInterfaces(m.ifaceRuns)
+}
+
+func compareInterfaces(runs []Results) string {
+	return compareColumns(runs, "Interface", func(r Results) string { return r.Interface })
+}
+
+// compareColumns sets runs side by side in columns headed by name, with the
+// best of each figure in bold.
+func compareColumns(runs []Results, header string, name func(Results) string) string {
+	width := 14
+	for _, r := range runs {
+		width = max(width, len(name(r))+2)
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "\033[1m%-12s", header)
+	for _, r := range runs {
+		fmt.Fprintf(&s, " %*s", width, name(r))
+	}
+	s.WriteString("\033[0m\n")
+	for _, metric := range headlineMetrics {
+		values := make([]float64, len(runs))
+		ok := make([]bool, len(runs))
+		best := -1
+		for i, r := range runs {
+			if r.Error != "" {
+				continue
+			}
+			if values[i], ok[i] = metric.pick(r); !ok[i] {
+				continue
+			}
+			if best < 0 || (metric.lowerIsBetter && values[i] < values[best]) || (!metric.lowerIsBetter && values[i] > values[best]) {
+				best = i
+			}
+		}
+		if !slices.Contains(ok, true) {
+			continue
+		}
+		fmt.Fprintf(&s, "%-12s", metric.name)
+		for i, r := range runs {
+			switch {
+			case r.Error != "":
+				fmt.Fprintf(&s, " \033[31m%*s\033[0m", width, "failed")
+			case !ok[i]:
+				fmt.Fprintf(&s, " %*s", width, "n/a")
+			case i == best && len(runs) > 1:
+				fmt.Fprintf(&s, " \033[1m%*s\033[0m", width, metric.format(values[i]))
+			default:
+				fmt.Fprintf(&s, " %*s", width, metric.format(values[i]))
+			}
+		}
+		s.WriteString("\n")
+	}
+	for _, r := range runs {
+		if r.Error != "" {
+			fmt.Fprintf(&s, "\033[31m%s failed: %s\033[0m\n", name(r), r.Error)
+		}
+	}
+	return s.String()
+}
+
+// runInterfaces runs a headless test on each interface in turn. A failure
+// on one is reported in its entry and doesn't stop the others; an
+// interrupt ends the sequence with the partial run. It returns every
+// interface's entry and whether any run failed or was interrupted.
+func runInterfaces(ctx context.Context, e engine, interfaces []string) (runs []Results, failed, partial bool) {
+	for _, name := range interfaces {
+		bound := e.onInterface(name)
+		results, err := bound.run(ctx, nil)
+		if errors.Is(err, errInterrupted) {
+			return append(runs, results), failed, true
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", name, err)
+			if hint, ok := hintFor(err); ok {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", hint.Title, hint.Advice)
+			}
+			results, failed = bound.failedRun(err), true
+		}
+		for _, w := range results.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s [%s]\n", name, w.Message, w.Code)
+		}
+		runs = append(runs, results)
+	}
+	return runs, failed, false
+}
+
+// writeInterfaceRuns writes the per-interface entries, or the per-family
+// ones of --compare-stacks, as a JSON array.
+func writeInterfaceRuns(w io.Writer, runs []Results) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(runs)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --provider lan tests against a peer on the local network: one running
+// gofast serve, found by a broadcast with --lan, or any given with
+// --server, such as a NAS or another machine serving a large file. A peer
+// given with --server needs nothing of gofast's own: downloads fetch the
+// URL, a file or a ?bytes= generator as with --url, and uploads POST to it
+// when it takes POST bodies. The server label names the peer and the local
+// interface the test reaches it through.
+
+const (
+	// lanDiscoveryPort is the UDP port gofast serve answers discovery
+	// queries on.
+	lanDiscoveryPort = 8737
+	// lanQuery is the discovery query gofast --lan broadcasts.
+	lanQuery = "gofast-discover/1"
+	// lanDiscoveryWait is how long --lan waits for peers to answer.
+	lanDiscoveryWait = time.Second
+)
+
+// lanAnnouncement is a peer's answer to a discovery query: its name and
+// the port it serves speed tests on. The address is the one the answer
+// came from, so a peer with several needn't know which the client reaches.
+type lanAnnouncement struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// lanPeer is a gofast serve instance that answered a discovery query.
+type lanPeer struct {
+	Name string
+	URL  string
+}
+
+func newLANProvider(opts providerOptions) Provider {
+	opts.DownloadURL = opts.ServerURL
+	return lanProvider{urlProvider: newURLProvider(opts).(urlProvider), peer: opts.PeerName}
+}
+
+// lanProvider is the url provider pointed at --server, with the peer named
+// for what it is rather than by its URL.
+type lanProvider struct {
+	urlProvider
+	// peer is the name a discovered peer announced itself by.
+	peer string
+}
+
+func (lanProvider) Name() string { return "lan" }
+
+// Server names the peer by the name it announced, or by its hostname,
+// looked up from its address when --server gives one, and by the interface the run reaches it through.
+func (p lanProvider) Server(ctx context.Context) (Server, error) {
+	if p.download == "" {
+		return Server{}, errors.New("the lan provider needs --server with the peer's URL, e.g. http://192.168.1.10:8080")
+	}
+	u, err := url.Parse(p.download)
+	if err != nil {
+		return Server{}, fmt.Errorf("--server %s: %w", p.download, err)
+	}
+	name := p.peer
+	if name != "" {
+		name = fmt.Sprintf("%s (%s)", name, u.Hostname())
+	} else {
+		name = peerName(ctx, u.Hostname())
+	}
+	return Server{Name: name, Host: u.Host, URL: p.download, Via: peerInterface(ctx, u)}, nil
+}
+
+// peerName is host with the name its address resolves back to, when it is
+// an address that does.
+func peerName(ctx context.Context, host string) string {
+	if net.ParseIP(host) == nil {
+		return host
+	}
+	names, err := net.DefaultResolver.LookupAddr(ctx, host)
+	if err != nil || len(names) == 0 {
+		return host
+	}
+	return fmt.Sprintf("%s (%s)", strings.TrimSuffix(names[0], "."), host)
+}
+
+// peerInterface is the local interface that reaches u's host: the one
+// --interface binds, or else the one the routing table picks, or "" when
+// it can't be told.
+func peerInterface(ctx context.Context, u *url.URL) string {
+	if name := boundInterface(ctx); name != "" {
+		return name
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	// Connecting a UDP socket sends nothing; it only picks the route.
+	conn, err := net.Dial(familyNetwork(ctx, "udp"), net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(local) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// checkLANPeer makes sure, before the test starts, that the peer given
+// with --server serves its URL, and returns the URL to upload to: the same
+// one, or "" when the peer won't take POST bodies and the run is download
+// only.
+func checkLANPeer(ctx context.Context, server string) (string, error) {
+	if server == "" {
+		return "", errors.New("--provider lan needs --server with the peer's URL, e.g. http://192.168.1.10:8080")
+	}
+	client := &http.Client{Transport: httpTransport(), Timeout: handshakeTimeout}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sizedURL(server, 1024), nil)
+	if err != nil {
+		return "", fmt.Errorf("--server %s: %w", server, err)
+	}
+	req.Header.Set("Range", "bytes=0-1023")
+	if err := checkTestRequest(client, req, "--server"); err != nil {
+		return "", err
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, server, http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("--server %s: %w", server, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if checkTestRequest(client, req, "--server") != nil {
+		return "", nil
+	}
+	return server, nil
+}
+
+// answerDiscovery answers every discovery query conn receives with a until
+// ctx is done, then closes conn.
+func answerDiscovery(ctx context.Context, conn *net.UDPConn, a lanAnnouncement) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	reply, _ := json.Marshal(a)
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if string(buf[:n]) == lanQuery {
+			conn.WriteToUDP(reply, from)
+		}
+	}
+}
+
+// discoverLANPeers broadcasts a discovery query on the local networks, or
+// only on the one --interface binds, and returns the peers that answer
+// within wait, the quickest first.
+func discoverLANPeers(ctx context.Context, wait time.Duration) ([]lanPeer, error) {
+	targets, err := broadcastAddrs(boundInterface(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return queryLANPeers(ctx, targets, wait)
+}
+
+// broadcastAddrs is where discovery queries go: the broadcast address of
+// every IPv4 network of the interfaces that are up, or of iface's, and the
+// limited broadcast address unless iface is given.
+func broadcastAddrs(iface string) ([]*net.UDPAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var targets []*net.UDPAddr
+	if iface == "" {
+		targets = append(targets, &net.UDPAddr{IP: net.IPv4bcast, Port: lanDiscoveryPort})
+	}
+	for _, i := range ifaces {
+		if iface != "" && i.Name != iface || i.Flags&net.FlagUp == 0 || i.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, _ := i.Addrs()
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil || len(n.Mask) != net.IPv4len {
+				continue
+			}
+			ip := slices.Clone(n.IP.To4())
+			for b := range ip {
+				ip[b] |= ^n.Mask[b]
+			}
+			targets = append(targets, &net.UDPAddr{IP: ip, Port: lanDiscoveryPort})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("interface %s has no IPv4 network to look for peers on", iface)
+	}
+	return targets, nil
+}
+
+// queryLANPeers sends a discovery query to each of targets and collects
+// the answers that arrive within wait, one peer per address.
+func queryLANPeers(ctx context.Context, targets []*net.UDPAddr, wait time.Duration) ([]lanPeer, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var sent int
+	for _, t := range targets {
+		if _, err = conn.WriteToUDP([]byte(lanQuery), t); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("couldn't send a discovery query: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	var peers []lanPeer
+	seen := make(map[string]bool)
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		var a lanAnnouncement
+		if json.Unmarshal(buf[:n], &a) != nil || a.Port < 1 || a.Port > 65535 {
+			continue
+		}
+		addr := net.JoinHostPort(from.IP.String(), strconv.Itoa(a.Port))
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		peers = append(peers, lanPeer{Name: a.Name, URL: "http://" + addr + "/?bytes=0"})
+	}
+	return peers, ctx.Err()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestCheckLANPeer(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		post   bool
+		upload bool
+	}{
+		{name: "takes uploads", post: true, upload: true},
+		{name: "download only", post: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost && !tt.post {
+					w.WriteHeader(http.StatusNotImplemented)
+					return
+				}
+				w.Write(make([]byte, 1024))
+			}))
+			defer srv.Close()
+			upload, err := checkLANPeer(context.Background(), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := upload != ""; got != tt.upload {
+				t.Errorf("upload URL %q, want an upload %v", upload, tt.upload)
+			}
+		})
+	}
+}
+
+func TestCheckLANPeerUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u := srv.URL
+	srv.Close()
+	if _, err := checkLANPeer(context.Background(), u); err == nil || !strings.Contains(err.Error(), "--server") {
+		t.Fatalf("checkLANPeer of a closed port = %v, want an error naming --server", err)
+	}
+}
+
+func TestLANServerLabel(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	p := newLANProvider(providerOptions{ServerURL: srv.URL})
+	if p.Name() != "lan" || p.Capabilities().Upload {
+		t.Errorf("provider %s, upload %v; want lan without an upload URL", p.Name(), p.Capabilities().Upload)
+	}
+	s, err := p.Server(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Via == "" {
+		t.Fatalf("no interface found for the loopback peer %+v", s)
+	}
+	if !strings.Contains(s.Label(), "127.0.0.1") || !strings.HasSuffix(s.Label(), " via "+s.Via) {
+		t.Errorf("label %q doesn't name the peer and interface", s.Label())
+	}
+	s, err = p.Server(withInterface(context.Background(), "eth7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Via != "eth7" {
+		t.Errorf("via %q with --interface eth7", s.Via)
+	}
+}
+
+func TestPeerHandler(t *testing.T) {
+	srv := httptest.NewServer(peerHandler{})
+	defer srv.Close()
+	for _, tt := range []struct {
+		method, query string
+		status        int
+		size          int64
+	}{
+		{http.MethodGet, "?bytes=12345", http.StatusOK, 12345},
+		{http.MethodGet, "?bytes=0", http.StatusOK, 0},
+		{http.MethodGet, "", http.StatusOK, urlChunk},
+		{http.MethodGet, "?bytes=-1", http.StatusBadRequest, -1},
+		{http.MethodGet, "?bytes=lots", http.StatusBadRequest, -1},
+		{http.MethodPost, "", http.StatusOK, 0},
+		{http.MethodPut, "", http.StatusMethodNotAllowed, -1},
+	} {
+		var body io.Reader
+		if tt.method != http.MethodGet {
+			body = strings.NewReader(strings.Repeat("x", 1<<16))
+		}
+		req, _ := http.NewRequest(tt.method, srv.URL+"/"+tt.query, body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || tt.size >= 0 && n != tt.size {
+			t.Errorf("%s %s: %s with %d bytes, want %d with %d", tt.method, tt.query, resp.Status, n, tt.status, tt.size)
+		}
+	}
+
+	upload, err := checkLANPeer(context.Background(), srv.URL+"/?bytes=0")
+	if err != nil || upload == "" {
+		t.Errorf("checkLANPeer of gofast serve = %q, %v; want it to take uploads", upload, err)
+	}
+}
+
+// A peer found with --lan is tested like one given with --server.
+func TestLANRunAgainstServe(t *testing.T) {
+	srv := httptest.NewServer(peerHandler{})
+	defer srv.Close()
+	e := headlessEngine(srv)
+	popts := providerOptions{Clock: realClock{}, Duration: e.profile.Duration, PingSamples: e.profile.PingSamples, Streams: 2, ServerURL: srv.URL + "/?bytes=0", UploadURL: srv.URL + "/?bytes=0", PeerName: "nas"}
+	e.provider = newLANProvider(popts)
+	e.fresh = func() Provider { return newLANProvider(popts) }
+	r, err := e.run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Provider != "lan" || r.Download == nil || r.Download.Mbps <= 0 || r.Upload == nil || r.Upload.Mbps <= 0 {
+		t.Fatalf("run against gofast serve: provider %s, download %+v, upload %+v", r.Provider, r.Download, r.Upload)
+	}
+	if !strings.HasPrefix(r.Server.Label(), "nas (127.0.0.1)") {
+		t.Errorf("label %q doesn't name the announced peer", r.Server.Label())
+	}
+}
+
+func TestDiscoverLANPeers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	announce := func(a lanAnnouncement) *net.UDPAddr {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			answerDiscovery(ctx, conn, a)
+			close(done)
+		}()
+		t.Cleanup(func() { <-done })
+		return conn.LocalAddr().(*net.UDPAddr)
+	}
+	nas := announce(lanAnnouncement{Name: "nas", Port: 8080})
+	unnamed := announce(lanAnnouncement{Port: 9000})
+	broken := announce(lanAnnouncement{Name: "broken"})
+
+	// Nothing listens on quiet, and nas is asked twice but counted once.
+	quiet, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quiet.Close()
+	start := time.Now()
+	peers, err := queryLANPeers(ctx, []*net.UDPAddr{nas, quiet.LocalAddr().(*net.UDPAddr), unnamed, broken, nas}, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("stopped listening after %s", waited)
+	}
+	want := map[string]string{"http://127.0.0.1:8080/?bytes=0": "nas", "http://127.0.0.1:9000/?bytes=0": ""}
+	if len(peers) != len(want) {
+		t.Fatalf("peers %+v, want %v", peers, want)
+	}
+	for _, p := range peers {
+		if name, ok := want[p.URL]; !ok || name != p.Name {
+			t.Errorf("peer %+v, want one of %v", p, want)
+		}
+	}
+
+	// Anything but the query goes unanswered.
+	conn, err := net.DialUDP("udp4", nil, nas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Errorf("answered a stray datagram with %d bytes", n)
+	}
+
+	// Cancelling stops the wait early.
+	waiting, stop := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, stop)
+	start = time.Now()
+	if _, err := queryLANPeers(waiting, []*net.UDPAddr{quiet.LocalAddr().(*net.UDPAddr)}, 10*time.Second); err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("cancelled discovery returned %v after %s", err, time.Since(start))
+	}
+	cancel()
+}
+
+func TestBroadcastAddrs(t *testing.T) {
+	all, err := broadcastAddrs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all[0].IP.Equal(net.IPv4bcast) || all[0].Port != lanDiscoveryPort {
+		t.Errorf("first target %v, want the limited broadcast address", all[0])
+	}
+	if _, err := broadcastAddrs("lo"); err == nil {
+		t.Error("found a broadcast address on loopback")
+	}
+	for _, a := range all[1:] {
+		iface, err := interfaceWithAddr(a.IP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if only, err := broadcastAddrs(iface); err != nil || len(only) == 0 || only[0].IP.Equal(net.IPv4bcast) {
+			t.Errorf("targets on %s: %v, %v", iface, only, err)
+		}
+	}
+}
+
+// interfaceWithAddr names the interface whose network has broadcast
+// address ip.
+func interfaceWithAddr(ip net.IP) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, i := range ifaces {
+		addrs, _ := i.Addrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.Contains(ip) {
+				return i.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LibreSpeed servers serve three endpoints, in a backend/ directory of the
+// server's root or, in older setups, the root itself: garbage.php returns
+// ckSize MiB of random data, empty.php answers with nothing and accepts a
+// POST body, and getIP.php describes the client.
+const (
+	// librespeedChunkMiB is the ckSize of one download request.
+	librespeedChunkMiB = 100
+	// librespeedUploadChunk is the size of one upload body, as LibreSpeed's
+	// own client sends.
+	librespeedUploadChunk = 20 << 20
+	// librespeedDuration is a transfer's length when the profile leaves it
+	// to the provider.
+	librespeedDuration = 10 * time.Second
+	// librespeedStreams is the number of parallel transfers when the
+	// profile doesn't say.
+	librespeedStreams = 4
+)
+
+// librespeedProvider measures against a self-hosted LibreSpeed server given
+// with --server, or the closest of several. Latency is timed the way
+// LibreSpeed's own client does it, as round trips to empty.php over one
+// kept-alive connection, so it works on whatever port the server listens
+// on.
+type librespeedProvider struct {
+	clock Clock
+	// servers are the URLs given with --server; layout holds the one in
+	// use.
+	servers     []string
+	username    string
+	password    string
+	duration    time.Duration
+	pingSamples int
+	streams     int
+	// compressible sends zeros instead of random upload data.
+	compressible bool
+	ping         *serverPing
+	// layout is the server in use and where its endpoints turned out to
+	// be, found on first use and shared by every copy of the provider.
+	layout *librespeedLayout
+}
+
+type librespeedLayout struct {
+	mu sync.Mutex
+	// base is the server in use, the first given until another is
+	// chosen; prefix is "/backend/" or "/", empty until found.
+	base, prefix string
+}
+
+// librespeedServers splits --server's comma-separated URLs.
+func librespeedServers(list string) []string {
+	var servers []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSuffix(strings.TrimSpace(s), "/"); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+func newLibrespeedProvider(opts providerOptions) Provider {
+	servers := librespeedServers(opts.ServerURL)
+	layout := &librespeedLayout{}
+	if len(servers) > 0 {
+		layout.base = servers[0]
+	}
+	return librespeedProvider{
+		clock:        opts.Clock,
+		servers:      servers,
+		username:     opts.Username,
+		password:     opts.Password,
+		duration:     opts.Duration,
+		pingSamples:  opts.PingSamples,
+		streams:      opts.Streams,
+		compressible: opts.Compressible,
+		ping:         newServerPing(opts.Ping),
+		layout:       layout,
+	}
+}
+
+func (librespeedProvider) Name() string { return "librespeed" }
+
+func (librespeedProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: true, LoadedLatency: true}
+}
+
+// base is the URL of the server in use.
+func (p librespeedProvider) base() string {
+	p.layout.mu.Lock()
+	defer p.layout.mu.Unlock()
+	return p.layout.base
+}
+
+func (p librespeedProvider) host() string {
+	return librespeedHost(p.base())
+}
+
+func librespeedHost(base string) string {
+	if u, err := url.Parse(base); err == nil {
+		return u.Host
+	}
+	return base
+}
+
+// request builds a request for one of the server's endpoints, with the
+// credentials when there are any. query is added to the endpoint's URL.
+func (p librespeedProvider) request(ctx context.Context, method, endpoint, query string, body io.Reader) (*http.Request, error) {
+	prefix, err := p.locate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u := p.base() + prefix + endpoint
+	if query != "" {
+		u += "?" + query
+	}
+	return p.newRequest(ctx, method, u, body)
+}
+
+func (p librespeedProvider) newRequest(ctx context.Context, method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if p.username != "" || p.password != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+	return req, nil
+}
+
+// check turns a response that isn't a success into an error that says what
+// to do about it.
+func (p librespeedProvider) check(resp *http.Response) error {
+	host := resp.Request.URL.Host
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && p.username == "" && p.password == "":
+		return fmt.Errorf("%s asks for a login; pass it with --credentials user:password", host)
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%s rejected the credentials given with --credentials", host)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s answered %s", host, resp.Status)
+	}
+	return nil
+}
+
+// locate finds the endpoints of the server in use under backend/ or,
+// failing that, the root, once for all copies of the provider. A failure
+// is tried again on the next request.
+func (p librespeedProvider) locate(ctx context.Context) (string, error) {
+	p.layout.mu.Lock()
+	defer p.layout.mu.Unlock()
+	if p.layout.prefix != "" {
+		return p.layout.prefix, nil
+	}
+	prefix, err := p.find(ctx, p.layout.base)
+	if err != nil {
+		return "", err
+	}
+	p.layout.prefix = prefix
+	return prefix, nil
+}
+
+// find looks for the endpoints of the server at base.
+func (p librespeedProvider) find(ctx context.Context, base string) (string, error) {
+	client := &http.Client{Transport: httpTransport()}
+	defer client.CloseIdleConnections()
+	for _, prefix := range []string{"/backend/", "/"} {
+		req, err := p.newRequest(ctx, http.MethodGet, base+prefix+"empty.php", nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err := p.check(resp); err != nil {
+			return "", err
+		}
+		return prefix, nil
+	}
+	return "", fmt.Errorf("%s has no LibreSpeed endpoints under / or /backend/", librespeedHost(base))
+}
+
+// librespeedServer names the server at base by its address; LibreSpeed
+// doesn't report a name or location of its own.
+func librespeedServer(base string) Server {
+	host := librespeedHost(base)
+	return Server{Name: "LibreSpeed " + host, Host: host, URL: base, LocationSource: provenanceUnavailable}
+}
+
+// Server finds the endpoints of the server in use.
+func (p librespeedProvider) Server(ctx context.Context) (Server, error) {
+	if len(p.servers) == 0 {
+		return Server{}, errors.New("the librespeed provider needs --server with the server's URL")
+	}
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	if _, err := p.locate(ctx); err != nil {
+		return Server{}, err
+	}
+	return librespeedServer(p.base()), nil
+}
+
+// Candidates returns the servers given with --server, in their order.
+func (p librespeedProvider) Candidates(ctx context.Context) ([]Server, error) {
+	if len(p.servers) == 0 {
+		return nil, errors.New("the librespeed provider needs --server with the server's URL")
+	}
+	candidates := make([]Server, len(p.servers))
+	for i, base := range p.servers {
+		candidates[i] = librespeedServer(base)
+	}
+	return candidates, nil
+}
+
+// Handshake checks that s serves the LibreSpeed endpoints.
+func (p librespeedProvider) Handshake(ctx context.Context, s Server) error {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	_, err := p.find(ctx, s.URL)
+	return err
+}
+
+// ProbeServer times a connect to the server, for ranking candidates.
+func (p librespeedProvider) ProbeServer(ctx context.Context, s Server) (float64, error) {
+	return connectRTT(ctx, p.clock, s.URL)
+}
+
+func (p librespeedProvider) Use(s Server) {
+	p.layout.mu.Lock()
+	defer p.layout.mu.Unlock()
+	if p.layout.base != s.URL {
+		p.layout.base, p.layout.prefix = s.URL, ""
+	}
+}
+
+// librespeedIP is getIP.php's answer with isp=true. Older servers answer
+// with the bare address instead.
+type librespeedIP struct {
+	ProcessedString string `json:"processedString"`
+	RawISPInfo      struct {
+		IP  string `json:"ip"`
+		Org string `json:"org"`
+	} `json:"rawIspInfo"`
+}
+
+// Client asks getIP.php what the server sees of this client: its public
+// address and, when the server looks it up, the ISP.
+func (p librespeedProvider) Client(ctx context.Context) (Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	req, err := p.request(ctx, http.MethodGet, "getIP.php", "isp=true", nil)
+	if err != nil {
+		return Client{}, err
+	}
+	resp, err := (&http.Client{Transport: httpTransport()}).Do(req)
+	if err != nil {
+		return Client{}, err
+	}
+	defer resp.Body.Close()
+	if err := p.check(resp); err != nil {
+		return Client{}, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return Client{}, err
+	}
+	var info librespeedIP
+	if json.Unmarshal(body, &info) != nil {
+		return Client{IP: strings.TrimSpace(string(body))}, nil
+	}
+	c := Client{IP: info.RawISPInfo.IP}
+	if c.IP == "" {
+		c.IP, _, _ = strings.Cut(info.ProcessedString, " - ")
+	}
+	// org reads "AS64496 Example ISP".
+	if asn, isp, ok := strings.Cut(info.RawISPInfo.Org, " "); ok && strings.HasPrefix(asn, "AS") {
+		c.ASN, c.ISP = asn, isp
+	} else {
+		c.ISP = info.RawISPInfo.Org
+	}
+	return c, nil
+}
+
+// pinger times round trips to empty.php.
+func (p librespeedProvider) pinger() *httpPinger {
+	return newHTTPPinger(p.clock, func(ctx context.Context) (*http.Request, error) {
+		return p.request(ctx, http.MethodGet, "empty.php", "r="+strconv.FormatInt(p.clock.Now().UnixNano(), 36), nil)
+	}, p.check)
+}
+
+func (p librespeedProvider) Ping(ctx context.Context) (Latency, error) {
+	if l, ok, err := p.ping.latency(ctx, p.base(), p.pingSamples); ok || err != nil {
+		return l, err
+	}
+	pinger := p.pinger()
+	defer pinger.client.CloseIdleConnections()
+	return pinger.latency(ctx, p.pingSamples)
+}
+
+// Probe times one round trip on a fresh connection, as loaded latency
+// probes are spaced too far apart to keep one alive.
+func (p librespeedProvider) Probe(ctx context.Context) (float64, error) {
+	if rtt, ok, err := p.ping.probe(ctx, p.base()); ok {
+		return rtt, err
+	}
+	pinger := p.pinger()
+	defer pinger.client.CloseIdleConnections()
+	pinger.warm = true
+	return pinger.ping(ctx)
+}
+
+func (p librespeedProvider) TraceRequest(ctx context.Context) (*http.Request, error) {
+	return p.request(ctx, http.MethodGet, "empty.php", "r="+strconv.FormatInt(p.clock.Now().UnixNano(), 36), nil)
+}
+
+// Download reads garbage.php over parallel streams, adding every byte to m
+// as it arrives.
+func (p librespeedProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		req, err := p.request(ctx, http.MethodGet, "garbage.php", fmt.Sprintf("ckSize=%d&r=%d", librespeedChunkMiB, p.clock.Now().UnixNano()), nil)
+		if err != nil {
+			return err
+		}
+		record := transferRecord{URL: req.URL.String(), Offset: -1, Began: p.clock.Now()}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := p.check(resp); err != nil {
+			return err
+		}
+		record.Expected = resp.ContentLength
+		return readCounted(ctx, p.clock, resp, m, record)
+	})
+}
+
+// Upload POSTs generated payload to empty.php over parallel streams,
+// counting bytes into m as the transport reads them for sending.
+func (p librespeedProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		body := &meteredReader{r: uploadBody(librespeedUploadChunk, p.compressible), m: m}
+		req, err := p.request(ctx, http.MethodPost, "empty.php", "", body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = librespeedUploadChunk
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return p.check(resp)
+	})
+}
+
+func (p librespeedProvider) streamed(ctx context.Context, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	duration := p.duration
+	if duration <= 0 {
+		duration = librespeedDuration
+	}
+	streams := p.streams
+	if streams <= 0 {
+		streams = librespeedStreams
+	}
+	return runStreams(ctx, p.clock, duration, streams, m, client, request)
+}
+
+// parseCredentials reads --credentials: user:password, or @FILE for a file
+// holding them, which keeps the password out of the process list.
+func parseCredentials(v string) (username, password string, err error) {
+	if path, ok := strings.CutPrefix(v, "@"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		v = strings.TrimSpace(string(b))
+	}
+	username, password, ok := strings.Cut(v, ":")
+	if !ok {
+		return "", "", errors.New("expected user:password")
+	}
+	return username, password, nil
+}
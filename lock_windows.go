@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset places the lock far past any data: Windows locks are
+// mandatory, and a lock over the contents would make unlocked reads fail.
+var lockOffset = windows.Overlapped{OffsetHigh: 0x7fffffff}
+
+// lockFile takes a lock on f, shared or exclusive, waiting for conflicting
+// holders to release theirs. Closing f releases it.
+func lockFile(f *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := lockOffset
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+}
+
+func unlockFile(f *os.File) error {
+	ol := lockOffset
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
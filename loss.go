@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// lossProbes is the size of the UDP burst sent to estimate packet
+	// loss, spaced lossSpacing apart; a probe unanswered lossTimeout after
+	// the last went out is lost.
+	lossProbes  = 50
+	lossSpacing = 10 * time.Millisecond
+	lossTimeout = time.Second
+	// lossHTTPProbes small requests, lossHTTPParallel at a time, stand in
+	// where UDP is blocked. Each gets lossHTTPTimeout, as it includes
+	// connection setup.
+	lossHTTPProbes   = 30
+	lossHTTPParallel = 4
+	lossHTTPTimeout  = 2 * time.Second
+	// lossMinProbes is the fewest probes a loss figure is reported from,
+	// so a run cut short can't turn one timeout into a large loss.
+	lossMinProbes = 20
+)
+
+// lossMsg is the packet loss measured before the download, in percent.
+type lossMsg float64
+
+// measureLoss sends a burst of DNS queries over UDP and reports the share
+// never answered. Where UDP gets no answer at all, which is a blocked
+// port far more often than total loss, it falls back to the provider's
+// probe requests; TCP retries hide light loss from those, so they only
+// catch heavy loss. It reports false without enough probes to go by.
+func (e engine) measureLoss(ctx context.Context) (percent float64, method string, ok bool) {
+	if sent, answered := udpLoss(ctx, e.clock, udpResolver(ctx)); sent >= lossMinProbes && answered > 0 {
+		return lossPercent(sent, answered), "udp", true
+	}
+	prober, ok := e.provider.(latencyProber)
+	if !ok {
+		return 0, "", false
+	}
+	if sent, answered := httpLoss(ctx, prober); sent >= lossMinProbes && answered > 0 {
+		return lossPercent(sent, answered), "http", true
+	}
+	return 0, "", false
+}
+
+func lossPercent(sent, answered int) float64 {
+	return float64(sent-answered) / float64(sent) * 100
+}
+
+// udpLoss sends lossProbes queries to the DNS resolver at addr, each with
+// its own ID, and counts the IDs answered.
+func udpLoss(ctx context.Context, clock Clock, addr string) (sent, answered int) {
+	conn, err := bindDialer(ctx, &net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return 0, 0
+	}
+	defer conn.Close()
+
+	const base = 0x6700
+	// The reader is done with seen by the time done is closed.
+	seen := map[int]bool{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 512)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 {
+				continue
+			}
+			if id := int(buf[0])<<8 | int(buf[1]); id >= base && id < base+lossProbes {
+				seen[id] = true
+			}
+		}
+	}()
+	for i := range lossProbes {
+		if _, err := conn.Write(dnsQuery(uint16(base+i), pingHost)); err != nil {
+			break
+		}
+		sent++
+		if sleepCtx(ctx, clock, lossSpacing) != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(lossTimeout))
+	<-done
+	return sent, len(seen)
+}
+
+// httpLoss makes lossHTTPProbes probe requests and counts those answered
+// in time.
+func httpLoss(ctx context.Context, prober latencyProber) (sent, answered int) {
+	var (
+		wg  sync.WaitGroup
+		ok  atomic.Int64
+		sem = make(chan struct{}, lossHTTPParallel)
+	)
+	for range lossHTTPProbes {
+		if ctx.Err() != nil {
+			break
+		}
+		sent++
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(ctx, lossHTTPTimeout)
+			defer cancel()
+			if _, err := prober.Probe(ctx); err == nil {
+				ok.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		// Probes cut off by the run ending weren't lost.
+		return 0, 0
+	}
+	return sent, int(ok.Load())
+}
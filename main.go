@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"math"
+	"math/rand/v2"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-runewidth"
+
+	"gofast/gauge"
 )
 
 type phase int
@@ -23,182 +37,1307 @@ const (
 	phaseError
 )
 
+func (p phase) String() string {
+	switch p {
+	case phaseInit:
+		return "server"
+	case phasePing:
+		return "ping"
+	case phaseDownloading:
+		return "download"
+	case phaseUploading:
+		return "upload"
+	case phaseComplete:
+		return "complete"
+	default:
+		return "error"
+	}
+}
+
+// viewOptions are the display preferences that survive a restart.
+type viewOptions struct {
+	PeakHold bool
+	// SessionSummary prints the session's runs to stdout on quit.
+	SessionSummary bool
+	// Baseline is a reference run drawn as markers on the gauges and the
+	// history graph.
+	Baseline *Results
+	// FPS caps animation ticks and terminal repaints; zero means defaultFPS.
+	FPS int
+	// PlanDownload and PlanUpload are the contracted speeds from the
+	// config, zero when unknown.
+	PlanDownload float64
+	PlanUpload   float64
+	// NoMenu replaces the completion menu with a line of shortcuts.
+	NoMenu bool
+	// Config is the loaded config file at ConfigPath, which settings
+	// changed in the TUI are saved back to. ConfigPath is empty when there
+	// is nowhere to save them.
+	Config     *config
+	ConfigPath string
+	// Interfaces are tested one after another, with --interface; the
+	// engine is bound to the first when the TUI starts.
+	Interfaces []string
+	// Verbose adds the TLS handshake section to the completion screen.
+	Verbose bool
+}
+
+const (
+	defaultFPS = 60
+	// sshFPS is used over SSH unless --fps says otherwise; full-rate
+	// repaints of the gauges can saturate a slow session.
+	sshFPS          = 10
+	lowBandwidthFPS = 5
+)
+
+func (o viewOptions) fps() int {
+	if o.FPS <= 0 {
+		return defaultFPS
+	}
+	return o.FPS
+}
+
+func (o viewOptions) frameInterval() time.Duration {
+	return time.Second / time.Duration(o.fps())
+}
+
+// perFrame converts a per-tick factor tuned for defaultFPS to the configured
+// frame rate, so animations run at the same speed whatever the FPS.
+func (o viewOptions) perFrame(factor float64) float64 {
+	return math.Pow(factor, float64(defaultFPS)/float64(o.fps()))
+}
+
+// peakDecay is applied to the peak-hold markers on every animation tick at
+// defaultFPS.
+const peakDecay = 0.998
+
 type speedTest struct {
+	engine         engine
+	opts           viewOptions
+	caps           Capabilities
 	phase          phase
 	downloadSpeed  float64
 	uploadSpeed    float64
 	ping           float64
-	serverLocation string
+	results        Results
 	progress       progress.Model
 	err            error
-	speedHistory   []float64
+	graph          speedGraph
 	startTime      time.Time
-	testDuration   time.Duration
 	targetSpeed    float64
 	animationSpeed float64
+	downloadPeak   float64
+	uploadPeak     float64
+	// familySpeeds are the live speeds of a --dual-stack download by
+	// network, "tcp4" and "tcp6".
+	familySpeeds map[string]float64
+	// scale is the dual gauge's range in Mbps, stepped with hysteresis;
+	// zero until the first reading.
+	scale float64
+	// percent shows the gauges as percent of expected, the baseline
+	// resolved the first time it is switched on; restarting carries both
+	// over.
+	percent  bool
+	expected *Expectation
+	// session holds every run completed since the TUI started; restarting
+	// carries it over.
+	session     []Results
+	showSession bool
+	showQR      bool
+	// errDetails shows the error's technical details, nil when closed.
+	// failedIn is the phase the run failed in and failedAfter how far into
+	// it; trail is the run's log, for the details and the diagnostics.
+	errDetails  *viewport.Model
+	failedIn    phase
+	failedAfter time.Duration
+	trail       []trailEvent
+	// confirmQuit is set while asking whether to abort a running test.
+	confirmQuit bool
+	// menu is the selected completion action; notice reports the last
+	// one's outcome.
+	menu   int
+	notice string
+	// history is "View history", nil when hidden.
+	history *historyView
+	// troubleshooting is the guided troubleshooter, nil when closed.
+	troubleshooting *troubleshooting
+	// serverMap is the server latency map, nil when closed.
+	serverMap *serverMap
+	// prefs are the settings changed in the TUI and not yet saved, as
+	// config keys and TOML values; restarting carries them over.
+	prefs map[string]string
+	// confirmOverwrite is set while asking whether to save over a config
+	// file that changed on disk.
+	confirmOverwrite bool
+	// noteInput is the note being typed for this run, nil when closed.
+	noteInput *textinput.Model
+	// retesting is set while a confirmation test of an unusual result runs.
+	retesting *retestMsg
+	// checkingWeb is set while the web responsiveness check runs.
+	checkingWeb bool
+	// warnings arrive while the test runs; the first dismissedWarnings
+	// of them are no longer counted in the header.
+	warnings          []Warning
+	dismissedWarnings int
+	showWarnings      bool
+	// reconfigure asks main to run the setup wizard after quitting.
+	reconfigure bool
+	// sinkReport is the outcome of sending the results to the configured
+	// sinks, nil until it arrives.
+	sinkReport *sinkReport
+	// cost is the plan's cost per delivered Mbps, nil until it arrives or
+	// when the plan's price isn't configured.
+	cost *planCost
+	// width and height are the terminal size, zero until known.
+	width, height int
+	// ctx is the running test's context; cancel aborts it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ifaceRuns are the finished runs on the interfaces tested before the
+	// current one, then on all of them; a failed one has its Error set.
+	ifaceRuns []Results
+	// pingSample is the latest idle ping while they come in, and pingMin
+	// and pingMax the range so far, then the summary's; the headline ping
+	// is their median.
+	pingSample       pingSampleMsg
+	pingMin, pingMax float64
+	// race is the race among candidate servers while it runs.
+	race candidatesMsg
+	// transferStart and transferWindow time the running transfer for its
+	// progress bar.
+	transferStart  time.Time
+	transferWindow time.Duration
+	// retrying is the latest retry of a failed phase, shown while that
+	// phase runs.
+	retrying retryMsg
+	// serverLeg is the server a --servers download is on.
+	serverLeg serverLegMsg
 }
 
 type tickMsg time.Time
 type speedMsg float64
-type pingMsg float64
-type uploadMsg float64
-type serverMsg string
+
+// probeMsg is one loaded latency probe's round trip in ms.
+type probeMsg float64
+
+// transferWindowMsg is how long the transfer starting now is meant to run.
+type transferWindowMsg time.Duration
+
+type pingMsg Latency
+type downloadMsg Transfer
+type uploadMsg Transfer
+type serverMsg Server
 type errorMsg error
-type completeMsg struct {
-	download float64
-	upload   float64
-	ping     float64
-	server   string
-}
+type completeMsg Results
+
+// Exit codes of a headless run besides 1, failed, and 2, bad usage.
+const (
+	// exitPartial follows a first interrupt, once the partial results
+	// are written.
+	exitPartial = 3
+	// exitForced follows a second interrupt, which quits at once.
+	exitForced = 130
+)
 
 func main() {
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
+	term = detectTerminal(os.Stdout)
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		runSoak(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "collect" {
+		runCollect(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "widget" {
+		runWidget(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "env" {
+		runEnv(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "profile" {
+		runProfile(os.Args[2:])
+		return
+	}
+
+	providerName := flag.String("provider", "cloudflare", "speed test provider ("+strings.Join(providerNames(), ", ")+")")
+	testURL := flag.String("url", "", "download this HTTP(S) resource instead of a provider's servers: a file, fetched again and again, or a generator taking ?bytes=N (implies --provider url)")
+	uploadURL := flag.String("upload-url", "", "with --url, POST upload data to this URL; without it the upload is skipped")
+	serverURL := flag.String("server", "", "URL of the server to test against, for --provider librespeed, e.g. https://speed.example.lan, where several separated by commas use the closest; or of the peer for --provider lan, e.g. http://192.168.1.10:8080")
+	lan := flag.Bool("lan", false, "test against a gofast serve peer on the local network, found by a broadcast unless --server names it (implies --provider lan)")
+	credentials := flag.String("credentials", "", "user:password for a server behind HTTP basic auth; @FILE reads them from a file")
+	profileName := flag.String("profile", defaultProfile, "test profile (quick, standard, thorough)")
+	quick := flag.Bool("quick", false, "shorthand for --profile quick")
+	thorough := flag.Bool("thorough", false, "shorthand for --profile thorough")
+	format := flag.String("format", "", "run without the TUI and print results as json or prometheus")
+	jsonOut := flag.Bool("json", false, "shorthand for --format json")
+	output := flag.String("output", "-", "file to write --format output to, '-' for stdout; strftime placeholders like %Y%m%d are expanded")
+	mkdir := flag.Bool("mkdir", false, "create missing parent directories of --output and --samples")
+	samplesPath := flag.String("samples", "", "stream every raw throughput and latency sample to this CSV file as it is taken; strftime placeholders are expanded")
+	includeSamples := flag.Bool("include-samples", false, "add every raw sample, with its timestamp and phase, to the JSON results")
+	note := flag.String("note", "", "free-text note stored with the results and history, e.g. \"after router reboot\"")
+	dryRun := flag.Bool("dry-run", false, "describe the test and its estimated data usage without running it")
+	seed := flag.Uint64("seed", 0, "seed for simulated values, for reproducible demo runs")
+	peakHold := flag.Bool("peak-hold", true, "mark the highest recent speed on the gauges")
+	duration := flag.Duration("duration", 0, "how long each of the download and upload runs, e.g. 20s (default the profile's, 10s for standard)")
+	maxBytes := flag.String("max-bytes", "", "cap the data download and upload move together, e.g. 100MB or 1.5GB; transfers stop early and are marked capped")
+	maxDuration := flag.Duration("max-duration", 0, "hard ceiling on the whole run, e.g. 45s; phases are shortened or skipped to fit")
+	baselineSpec := flag.String("baseline", "", "reference run to compare against live: \"last\" or a results JSON file")
+	rpc := flag.Bool("rpc", false, "no TUI: take JSON-RPC requests on stdin and send replies and progress notifications on stdout, for programs that embed gofast")
+	controlSocket := flag.String("control-socket", "", "serve live events, status and start/abort on this Unix socket")
+	fps := flag.Int("fps", defaultFPS, "maximum redraws per second (defaults to 10 over SSH)")
+	lowBandwidth := flag.Bool("low-bandwidth", false, "redraw at 5 fps, for slow remote sessions")
+	compressible := flag.Bool("compressible", false, "send compressible upload data, to check for compressing middleboxes")
+	streamsFlag := flag.String("streams", "", "parallel connections per direction, or auto to add streams while they raise the aggregate (default the profile's)")
+	connections := flag.Int("connections", 0, "parallel download and upload connections, 1 to 64; the same as --streams N (default the profile's, 4 for standard)")
+	pingMethodName := flag.String("ping-method", "auto", "latency probe: icmp, tcp, udp, https, or auto for the most accurate one available")
+	insecure := flag.Bool("insecure-skip-verify", false, "don't verify TLS certificates, for networks that intercept HTTPS; results are flagged")
+	utc := flag.Bool("utc", false, "show and name files by UTC times, e.g. in containers without time zone data")
+	envReportFlag := flag.Bool("env-report", false, "check the interface, router, DNS, IPv6, VPN, CPU load and other traffic before testing and keep the report in the results")
+	colorBlind := flag.Bool("color-blind", false, "show verdicts in colors that stay apart for color-blind eyes, each with its own symbol")
+	dualStack := flag.Bool("dual-stack", false, "download over IPv4 and IPv6 at the same time and show each family on its own gauge")
+	ipv4 := flag.Bool("ipv4", false, "make every connection of the test over IPv4")
+	ipv6 := flag.Bool("ipv6", false, "make every connection of the test over IPv6")
+	compareStacksFlag := flag.Bool("compare-stacks", false, "run a quick ping and download over IPv4, then over IPv6, show them side by side and exit")
+	http3 := flag.Bool("http3", false, "run the download and upload over HTTP/3 (QUIC), falling back to HTTP/2 when the server can't be reached over UDP")
+	serverCount := flag.Int("servers", 1, "download from this many of the best candidate servers in turn, each for a share of the time, and report their mean and median (fast, librespeed and ookla)")
+	verbose := flag.Bool("verbose", false, "show the TLS handshake with the test server, its version and cipher suite on the completion screen")
+	compareProtocolsFlag := flag.Bool("compare-protocols", false, "run a quick download over HTTP/2, then over HTTP/3, show them side by side and exit")
+	var interfaces interfaceList
+	flag.Var(&interfaces, "interface", "bind the test to this network interface; repeat to test several one after another and compare them, e.g. --interface wan0 --interface lte0 (Linux)")
+	webCheck := flag.Bool("web-check", false, "after the test, time DNS, connect, TLS and first byte to a few sites for a web responsiveness figure; --web-check=false skips it (default the config's web_check)")
+	processStats := flag.Bool("process-stats", false, "include gofast's own CPU time and peak memory in the results")
+	perfReport := flag.Bool("perf-report", false, "print where wall time and CPU went at the end: engine phases, and the TUI's message handling and rendering")
+	measureOverhead := flag.Bool("measure-overhead", false, "stream over loopback to estimate the fastest speed this machine can measure, then exit")
+	pingCompare := flag.Bool("ping-compare", false, "measure latency once with every ping method, show the spread and exit")
+	sessionSummary := flag.Bool("session-summary", false, "print every run of the session to the terminal on quit")
+	noExitSummary := flag.Bool("no-exit-summary", false, "don't print the latest result to the terminal on quit")
+	noMenu := flag.Bool("no-menu", false, "list shortcuts instead of the actions menu after a test")
+	noWizard := flag.Bool("no-wizard", false, "don't offer first-run setup when there is no config file")
+	noHistory := flag.Bool("no-history", false, "don't store results or compare against past runs")
+	regressionWindow := flag.Int("regression-window", defaultRegressionPolicy.Window, "number of recent runs a result is compared against")
+	regressionPercentile := flag.Float64("regression-percentile", defaultRegressionPolicy.Percentile, "percentile of recent runs below which a result is flagged as unusually slow")
+	flag.Parse()
+
+	if *testURL != "" && !flagSet("provider") {
+		*providerName = "url"
+	}
+	if *lan && !flagSet("provider") {
+		*providerName = "lan"
+	}
+	if problems := validateOptions(runOptions{
+		Provider:             *providerName,
+		Server:               *serverURL,
+		LAN:                  *lan,
+		URL:                  *testURL,
+		UploadURL:            *uploadURL,
+		Profile:              *profileName,
+		Quick:                *quick,
+		Thorough:             *thorough,
+		Format:               *format,
+		JSON:                 *jsonOut,
+		Output:               *output,
+		RPC:                  *rpc,
+		Streams:              *streamsFlag,
+		Connections:          *connections,
+		PingMethod:           *pingMethodName,
+		Duration:             *duration,
+		MaxBytes:             *maxBytes,
+		MaxDuration:          *maxDuration,
+		FPS:                  *fps,
+		LowBandwidth:         *lowBandwidth,
+		RegressionWindow:     *regressionWindow,
+		RegressionPercentile: *regressionPercentile,
+		DualStack:            *dualStack,
+		IPv4:                 *ipv4,
+		IPv6:                 *ipv6,
+		CompareStacks:        *compareStacksFlag,
+		HTTP3:                *http3,
+		CompareProtocols:     *compareProtocolsFlag,
+		Servers:              *serverCount,
+		Interfaces:           interfaces,
+		MeasureOverhead:      *measureOverhead,
+		PingCompare:          *pingCompare,
+		set:                  flagSet,
+	}); len(problems) > 0 {
+		var b strings.Builder
+		writeProblems(&b, problems)
+		fmt.Fprint(os.Stderr, detectTerminal(os.Stderr).render(b.String()))
+		os.Exit(2)
+	}
+
+	if flagSet("connections") {
+		*streamsFlag = strconv.Itoa(*connections)
+	}
+
+	clock := realClock{}
+	insecureSkipVerify = *insecure
+	if *colorBlind {
+		cues = colorBlindCues
+	}
+	if *utc {
+		time.Local = time.UTC
+	}
+
+	if *quick || (*compareStacksFlag || *compareProtocolsFlag) && !flagSet("profile") && !*thorough {
+		*profileName = "quick"
+	}
+	if *thorough {
+		*profileName = "thorough"
+	}
+	if *jsonOut {
+		*format = "json"
+	}
+	if *format == "" && *output != "-" {
+		*format = formatForPath(*output)
+	}
+	write, err := outputWriter(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if !*mkdir {
+		if err := checkOutputDir(expandOutputPath(*output, clock.Now())); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	cfg := defaultConfig()
+	if path, err := defaultConfigPath(); err == nil {
+		var exists bool
+		if cfg, exists, err = loadConfig(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if !exists && !*noWizard && write == nil && !*dryRun && !*rpc && interactive() {
+			var proceed bool
+			cfg, proceed, err = runWizard(path, cfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			if !proceed {
+				return
+			}
+		}
+	}
+	speedUnits = cfg.Units
+	syntheticBaselines = cfg.SyntheticBaselines
+	if !flagSet("no-history") {
+		*noHistory = !cfg.History
+	}
+	if !flagSet("web-check") {
+		*webCheck = cfg.WebCheck
+	}
+	if cfg.Metered && !flagSet("profile") && !*quick && !*thorough {
+		*profileName = "quick"
+	}
+
+	sinks, err := newSinks(cfg.Sinks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	prof, err := findProfile(*profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	streams, autoStreams, err := parseStreams(*streamsFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if streams > 0 {
+		prof.Streams = streams
+	}
+	if *duration > 0 {
+		prof.Duration = *duration
+	}
+	var dataCap int64
+	if *maxBytes != "" {
+		if dataCap, err = parseByteSize(*maxBytes); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-bytes %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	var baseline *Results
+	if *baselineSpec != "" {
+		if baseline, err = loadBaseline(*baselineSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *measureOverhead {
+		mbps, usage, err := measureLoopback(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loopback ceiling: %s", formatSpeed(mbps))
+		if usage != nil {
+			fmt.Printf(" using %s", formatUsage(usage))
+		}
+		fmt.Println("\nResults close to this are limited by this machine, not the network.")
+		return
+	}
+	if *pingCompare {
+		fmt.Println(comparePingMethods(context.Background(), clock, pingHost))
+		return
+	}
+	ping, err := choosePingMethod(*pingMethodName, clock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	family := ""
+	switch {
+	case *ipv4:
+		family = "tcp4"
+	case *ipv6:
+		family = "tcp6"
+	}
+	if ping.Name() == "icmp" && (family == "tcp6" || *compareStacksFlag) {
+		// Only reached with auto: an explicit icmp is refused above.
+		ping = tcpPing{clock}
+	}
+
+	rng := newRand(*seed)
+
+	popts := providerOptions{
+		Clock:         clock,
+		Rand:          rng,
+		Duration:      prof.Duration,
+		PingSamples:   prof.PingSamples,
+		Streams:       prof.Streams,
+		AutoStreams:   autoStreams,
+		Compressible:  *compressible,
+		Ping:          ping,
+		NoGeolocation: cfg.Privacy.NoGeolocation,
+		ServerURL:     *serverURL,
+		DownloadURL:   *testURL,
+		UploadURL:     *uploadURL,
+	}
+	if *credentials != "" {
+		if popts.Username, popts.Password, err = parseCredentials(*credentials); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --credentials: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	provider, err := newProvider(*providerName, popts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	e := engine{
+		provider:     provider,
+		profile:      prof,
+		clock:        clock,
+		compressible: *compressible,
+		maxDuration:  *maxDuration,
+		maxBytes:     dataCap,
+		sinks:        sinks,
+		processStats: *processStats,
+		note:         strings.TrimSpace(*note),
+		privacy:      cfg.Privacy,
+		environment:  hostEnv().containerWarnings(!*insecure, *utc),
+		envReport:    *envReportFlag,
+		dualStack:    *dualStack,
+		family:       family,
+		http3:        *http3,
+		servers:      *serverCount,
+		fresh:        func() Provider { return providers[*providerName](popts) },
+		withStreams: func(streams int) Provider {
+			opts := popts
+			opts.Streams, opts.AutoStreams = streams, false
+			return providers[*providerName](opts)
+		},
+		regression: regressionPolicy{
+			Window:     *regressionWindow,
+			Percentile: *regressionPercentile,
+			MinRuns:    min(defaultRegressionPolicy.MinRuns, *regressionWindow),
+		},
+	}
+	if *webCheck {
+		e.webCheck = cfg.WebDestinations
+		if len(e.webCheck) == 0 {
+			e.webCheck = defaultWebDestinations
+		}
+	}
+	if !*noHistory {
+		if path, err := defaultHistoryPath(); err == nil {
+			e.history = &historyStore{path: path}
+		}
+	}
+	e.percent = percentPolicy{
+		Source:       cfg.PercentOf,
+		PlanDownload: cfg.PlanDownload,
+		PlanUpload:   cfg.PlanUpload,
+		Window:       e.regression.Window,
+		MinRuns:      e.regression.MinRuns,
+	}
+	if cfg.RetestOnOutlier && !cfg.Metered && prof.Name != "quick" {
+		e.retest = retestPolicy{Factor: cfg.OutlierFactor, Delay: retestDelay}
+	}
+
+	checkBareSpeeds(os.Stderr, cfg.bare, e.history)
+
+	if *dryRun {
+		writeDryRun(os.Stdout, prof, provider)
+		return
+	}
+
+	e.includeSamples = *includeSamples
+	if *samplesPath != "" {
+		if e.sampleCSV, err = createSampleCSV(expandOutputPath(*samplesPath, clock.Now()), *mkdir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: samples: %v\n", err)
+			os.Exit(2)
+		}
+		defer func() {
+			if err := e.sampleCSV.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: samples: %v\n", err)
+			}
+		}()
+	}
+
+	if len(interfaces) > 0 {
+		e = e.onInterface(interfaces[0])
+	}
+
+	if family != "" {
+		if err := checkFamily(family); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if hint, ok := hintFor(err); ok {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", hint.Title, hint.Advice)
+			}
+			os.Exit(1)
+		}
+	}
+
+	if *testURL != "" {
+		ctx, cancel := context.WithTimeout(withFamily(withInterface(context.Background(), e.iface), family), handshakeTimeout)
+		err := checkTestURLs(ctx, *testURL, *uploadURL)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *providerName == "lan" {
+		ctx, cancel := context.WithTimeout(withFamily(withInterface(context.Background(), e.iface), family), handshakeTimeout)
+		if *serverURL == "" {
+			peers, err := discoverLANPeers(ctx, lanDiscoveryWait)
+			if err == nil && len(peers) == 0 {
+				err = errors.New("no gofast serve answered on the local network; start one on the peer, or give its URL with --server")
+			}
+			if err != nil {
+				cancel()
+				fmt.Fprintf(os.Stderr, "Error: --lan: %v\n", err)
+				os.Exit(1)
+			}
+			*serverURL, popts.PeerName = peers[0].URL, peers[0].Name
+			popts.ServerURL = *serverURL
+			if len(peers) > 1 {
+				var others []string
+				for _, p := range peers[1:] {
+					others = append(others, p.URL)
+				}
+				e.environment = append(e.environment, Warning{Code: warnLANPeers, Message: fmt.Sprintf("%d peers answered; testing against the quickest, %s. Give --server to test against %s", len(peers), peers[0].URL, strings.Join(others, " or "))})
+			}
+		}
+		popts.UploadURL, err = checkLANPeer(ctx, *serverURL)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if popts.UploadURL == "" {
+			e.environment = append(e.environment, Warning{Code: warnLANDownloadOnly, Message: fmt.Sprintf("%s doesn't take POST bodies, so only the download is tested", *serverURL)})
+		}
+		e.provider = e.fresh()
+	}
+
+	// compare shows the runs of --compare-stacks or --compare-protocols
+	// side by side, or writes them as a JSON array, and reports whether
+	// any of them succeeded.
+	compare := func(runs []Results, header string, name func(Results) string) bool {
+		table := compareColumns(runs, header, name)
+		if write == nil {
+			fmt.Print(detectTerminal(os.Stdout).render(table))
+		} else {
+			path := expandOutputPath(*output, runs[0].Timestamp)
+			if err := writeOutput(path, *mkdir, func(w io.Writer) error { return writeInterfaceRuns(w, runs) }); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprint(os.Stderr, detectTerminal(os.Stderr).render(table))
+		}
+		for _, r := range runs {
+			if r.Error == "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if *compareStacksFlag {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if compare(compareStacks(ctx, e), "Family", func(r Results) string { return r.Family }) {
+			return
+		}
+		os.Exit(1)
+	}
+
+	if *compareProtocolsFlag {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		// The HTTP/2 column names what the server actually spoke, which
+		// may be HTTP/1.1.
+		runs := compareProtocols(ctx, e)
+		if compare(runs, "Protocol", func(r Results) string {
+			if r.Download != nil && r.Download.Protocol != "" {
+				return r.Download.Protocol
+			}
+			return r.Protocol
+		}) {
+			return
+		}
+		os.Exit(1)
+	}
+
+	if *rpc {
+		destinations := cfg.WebDestinations
+		if len(destinations) == 0 {
+			destinations = defaultWebDestinations
+		}
+		s := newRPCServer(e, streams, autoStreams, func(prof profile, streams int, auto bool) (Provider, error) {
+			opts := popts
+			opts.Duration, opts.PingSamples, opts.Streams, opts.AutoStreams = prof.Duration, prof.PingSamples, prof.Streams, auto
+			return newProvider(*providerName, opts)
+		}, os.Stdout)
+		s.webDestinations = destinations
+		if err := s.serve(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if write != nil {
+		if *perfReport {
+			e.perf = newPerfRecorder(0)
+		}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		go func() {
+			<-interrupts
+			fmt.Fprintln(os.Stderr, "Interrupted: finishing with the results so far; interrupt again to quit at once")
+			cancel(errInterrupted)
+			<-interrupts
+			os.Exit(exitForced)
+		}()
+		closeControl := func() {}
+		if *controlSocket != "" {
+			ctl, err := listenControl(*controlSocket, func(cmd string) error {
+				if cmd != "abort" {
+					return fmt.Errorf("%s isn't supported with headless output", cmd)
+				}
+				cancel(nil)
+				return nil
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(2)
+			}
+			e.notify = ctl.Publish
+			closeControl = func() { ctl.Close() }
+		}
+
+		if len(interfaces) > 1 {
+			runs, failed, partial := runInterfaces(ctx, e, interfaces)
+			closeControl()
+			path := expandOutputPath(*output, runs[0].Timestamp)
+			if err := writeOutput(path, *mkdir, func(w io.Writer) error { return writeInterfaceRuns(w, runs) }); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			for _, r := range runs {
+				if len(e.sinks) > 0 && r.Error == "" && !r.Partial {
+					fmt.Fprintln(os.Stderr, dispatch(context.WithoutCancel(ctx), e.sinks, r))
+				}
+			}
+			if len(runs) > 1 {
+				fmt.Fprint(os.Stderr, detectTerminal(os.Stderr).render("\n"+compareInterfaces(runs)))
+			}
+			e.perf.report(os.Stderr)
+			if partial || failed {
+				if e.sampleCSV != nil {
+					e.sampleCSV.Close()
+				}
+				if partial {
+					os.Exit(exitPartial)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+
+		results, err := e.run(ctx, nil)
+		closeControl()
+		partial := errors.Is(err, errInterrupted)
+		if err != nil && !partial {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if hint, ok := hintFor(err); ok {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", hint.Title, hint.Advice)
+			}
+			os.Exit(1)
+		}
+		for _, w := range results.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s [%s]\n", w.Message, w.Code)
+		}
+		path := expandOutputPath(*output, results.Timestamp)
+		if err := writeOutput(path, *mkdir, func(w io.Writer) error { return write(w, results) }); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(e.sinks) > 0 {
+			fmt.Fprintln(os.Stderr, dispatch(context.WithoutCancel(ctx), e.sinks, results))
+		}
+		e.perf.report(os.Stderr)
+		if partial {
+			if e.sampleCSV != nil {
+				e.sampleCSV.Close()
+			}
+			os.Exit(exitPartial)
+		}
+		return
+	}
+
+	opts := viewOptions{
+		PeakHold:       *peakHold,
+		SessionSummary: *sessionSummary,
+		Baseline:       baseline,
+		FPS:            *fps,
+		PlanDownload:   cfg.PlanDownload,
+		PlanUpload:     cfg.PlanUpload,
+		NoMenu:         *noMenu,
+		Config:         &cfg,
+		Interfaces:     interfaces,
+		Verbose:        *verbose,
+	}
+	opts.ConfigPath, _ = defaultConfigPath()
+	if *lowBandwidth {
+		opts.FPS = lowBandwidthFPS
+	} else if !flagSet("fps") && os.Getenv("SSH_CONNECTION") != "" {
+		opts.FPS = sshFPS
+	}
+	// The engine only starts once the program runs, so ctl is set before
+	// the first message is published.
+	var ctl *controlServer
+	if *controlSocket != "" {
+		e.notify = func(msg tea.Msg) { ctl.Publish(msg) }
+	}
+	// The renderer only writes lines that changed since the last frame,
+	// so capping its rate bounds the bytes sent per second.
+	if *perfReport {
+		e.perf = newPerfRecorder(opts.frameInterval())
+	}
+	var model tea.Model = initialModel(e, opts)
+	if e.perf != nil {
+		model = perfModel{model, e.perf}
+	}
+	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithFPS(opts.fps()))
+	if *controlSocket != "" {
+		ctl, err = listenControl(*controlSocket, func(cmd string) error {
+			p.Send(controlMsg(cmd))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		defer ctl.Close()
+	}
+	final, err := p.Run()
+	if m, ok := final.(perfModel); ok {
+		final = m.Model
+	}
+	if m, ok := final.(speedTest); ok {
+		m.cancel()
+	}
+	drainEngines(time.Second)
+	e.perf.report(os.Stderr)
+	if err != nil {
 		fmt.Printf("Error: %v", err)
+		return
+	}
+	if m, ok := final.(speedTest); ok && !*noExitSummary && len(m.session) > 0 {
+		fmt.Print(exitSummary(m.session))
+	}
+	if m, ok := final.(speedTest); ok && !*noExitSummary && len(m.ifaceRuns) > 1 {
+		fmt.Print(term.render("\n" + compareInterfaces(m.ifaceRuns)))
+	}
+	if m, ok := final.(speedTest); ok && opts.SessionSummary && len(m.session) > 0 {
+		fmt.Print(sessionTable(m.session))
+	}
+	if m, ok := final.(speedTest); ok && m.reconfigure {
+		path, err := defaultConfigPath()
+		saved := false
+		if err == nil {
+			_, saved, err = runWizard(path, cfg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if saved {
+			fmt.Println("Settings saved to " + path + "; they apply from the next run.")
+		}
 	}
 }
 
-func initialModel() speedTest {
+// flagSet reports whether a flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func initialModel(e engine, opts viewOptions) speedTest {
+	ctx, cancel := context.WithCancel(context.Background())
 	return speedTest{
-		phase:        phaseInit,
-		progress:     progress.New(progress.WithDefaultGradient()),
-		speedHistory: make([]float64, 0),
-		startTime:    time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		engine:    e,
+		opts:      opts,
+		caps:      e.provider.Capabilities(),
+		phase:     phaseInit,
+		progress:  progress.New(progress.WithDefaultGradient()),
+		startTime: e.clock.Now(),
 	}
 }
 
 func (m speedTest) Init() tea.Cmd {
 	return tea.Batch(
-		tickCmd(),
+		tickCmd(m.engine.clock, m.opts.frameInterval()),
 		m.progress.Init(),
-		runSpeedTestCmd(),
+		runSpeedTestCmd(m.ctx, m.engine),
 	)
 }
 
+// restart starts a new run, carrying the session over. With several
+// interfaces it starts over from the first.
+func (m speedTest) restart() (tea.Model, tea.Cmd) {
+	e := m.engine
+	if len(m.opts.Interfaces) > 0 {
+		e = e.onInterface(m.opts.Interfaces[0])
+	}
+	return m.startOver(e)
+}
+
+// startOver starts a run of e in a new model, carrying over the session
+// and the view's settings.
+func (m speedTest) startOver(e engine) (speedTest, tea.Cmd) {
+	newModel := initialModel(e, m.opts)
+	newModel.session = m.session
+	newModel.prefs = m.prefs
+	newModel.percent, newModel.expected = m.percent, m.expected
+	newModel.graph.hidden = m.graph.hidden
+	// The terminal keeps its size; no new WindowSizeMsg comes to say so.
+	newModel.width, newModel.height, newModel.progress.Width = m.width, m.height, m.progress.Width
+	return newModel, tea.Batch(
+		tickCmd(e.clock, m.opts.frameInterval()),
+		runSpeedTestCmd(newModel.ctx, e),
+	)
+}
+
+// transferProgress is the bar under the gauges showing how much of the
+// running transfer's window has passed.
+func (m speedTest) transferProgress() string {
+	if m.transferWindow <= 0 {
+		return ""
+	}
+	elapsed := min(m.engine.clock.Since(m.transferStart), m.transferWindow)
+	label := fmt.Sprintf(" %ds / %s", int(elapsed.Seconds()), m.transferWindow)
+	bar := m.progress
+	bar.Width = max(bar.Width-len(label), 10)
+	return "\n" + bar.ViewAs(elapsed.Seconds()/m.transferWindow.Seconds()) + "\033[90m" + label + "\033[0m\n"
+}
+
+// serverLine names the server after prefix, cut to the terminal width; the
+// details panel shows it in full.
+func (m speedTest) serverLine(prefix string) string {
+	label := m.results.Server.Label()
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf("\033[32;1m%s%s\033[0m\n\n", prefix, truncate(label, m.fitWidth(prefix)))
+}
+
+// fitWidth is the room left on a line after prefix, zero while the terminal
+// width is unknown.
+func (m speedTest) fitWidth(prefix string) int {
+	if m.width == 0 {
+		return 0
+	}
+	return max(m.width-runewidth.StringWidth(prefix), 1)
+}
+
+// testing reports whether a test is in progress.
+func (m speedTest) testing() bool {
+	return m.phase != phaseComplete && m.phase != phaseError
+}
+
 func (m speedTest) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m = m.logEvent(msg)
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.confirmQuit {
+			m.confirmQuit = false
+			if key := msg.String(); key == "y" || key == "ctrl+c" {
+				m.cancel()
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+		if m.confirmOverwrite {
+			m.confirmOverwrite = false
+			if msg.String() == "y" {
+				return m.writePrefs(true)
+			}
+			m.notice = "Not saved; 'p' tries again"
+			return m, nil
+		}
+		if m.noteInput != nil && msg.String() != "ctrl+c" {
+			return m.updateNote(msg)
+		}
+		if m.troubleshooting != nil && msg.String() != "q" && msg.String() != "ctrl+c" {
+			return m.updateTroubleshoot(msg)
+		}
+		if m.serverMap != nil && msg.String() != "q" && msg.String() != "ctrl+c" {
+			return m.updateServerMap(msg)
+		}
+		if m.history != nil && msg.String() != "q" && msg.String() != "ctrl+c" {
+			return m.updateHistory(msg)
+		}
+		if m.errDetails != nil {
+			vp, _ := m.errDetails.Update(msg)
+			m.errDetails = &vp
+		}
 		switch msg.String() {
 		case "q", "ctrl+c", "esc":
+			if m.testing() {
+				m.confirmQuit = true
+				return m, nil
+			}
+			m.cancel()
 			return m, tea.Quit
+		case "s":
+			if m.phase == phaseComplete && len(m.session) > 1 {
+				m.showSession = !m.showSession
+				m.showQR = false
+			}
+		case "c":
+			if m.phase == phaseComplete {
+				m.showQR = !m.showQR
+				m.showSession = false
+			}
+			if m.phase == phaseError {
+				return m, m.writeDiagnostics()
+			}
+		case "!":
+			if len(m.warnings) > m.dismissedWarnings {
+				m.showWarnings = !m.showWarnings
+			}
+			return m, nil
+		case "x":
+			if m.showWarnings {
+				m.dismissedWarnings, m.showWarnings = len(m.warnings), false
+				return m, nil
+			}
+		case "b":
+			if m.testing() {
+				return m.togglePercent()
+			}
+		case "1", "2", "3":
+			if m.testing() {
+				return m.toggleSeries(msg.String())
+			}
+		case "d":
+			if m.phase == phaseError {
+				m = m.toggleErrDetails()
+			}
 		case "r":
-			if m.phase == phaseComplete || m.phase == phaseError {
-				newModel := initialModel()
-				return newModel, tea.Batch(
-					tickCmd(),
-					runSpeedTestCmd(),
-				)
+			if m.phase == phaseError {
+				return m.restart()
+			}
+		case "up", "k":
+			if m.phase == phaseComplete && !m.opts.NoMenu {
+				m.menu = (m.menu + len(m.actions()) - 1) % len(m.actions())
+			}
+		case "down", "j":
+			if m.phase == phaseComplete && !m.opts.NoMenu {
+				m.menu = (m.menu + 1) % len(m.actions())
+			}
+		case "enter":
+			if m.phase == phaseComplete && !m.opts.NoMenu {
+				return m.actions()[m.menu].run(m)
+			}
+		}
+		if m.phase == phaseComplete {
+			for _, a := range m.actions() {
+				if msg.String() == a.key {
+					return a.run(m)
+				}
 			}
 		}
 
-	case tickMsg:
-		if m.phase == phaseDownloading || m.phase == phaseUploading {
+	case noticeMsg:
+		m.notice = string(msg)
 
-			var targetSpeed float64
-			if m.phase == phaseDownloading {
+	case noteMsg:
+		m.notice = msg.notice()
 
-				elapsed := time.Since(m.startTime).Seconds()
-				if elapsed > 2.0 && elapsed < 7.0 {
-					maxSpeed := 50.0 + float64(time.Now().UnixNano()%50)
-					progress := (elapsed - 2.0) / 5.0
-					if progress > 1.0 {
-						progress = 1.0
-					}
-
-					variation := math.Sin(elapsed*2) * 5.0
-					targetSpeed = maxSpeed*(0.2+0.8*progress) + variation
-					if targetSpeed < 0 {
-						targetSpeed = 5.0
-					}
-					m.downloadSpeed = targetSpeed
-					m.targetSpeed = targetSpeed
-
-					m.speedHistory = append(m.speedHistory, targetSpeed)
-					if len(m.speedHistory) > 60 {
-						m.speedHistory = m.speedHistory[1:]
-					}
-				} else {
-					targetSpeed = m.downloadSpeed
-				}
-			} else {
+	case expectationMsg:
+		m = m.showPercent(msg)
 
-				elapsed := time.Since(m.startTime).Seconds()
-				if elapsed > 7.0 && elapsed < 11.0 {
-					maxSpeed := 25.0 + float64(time.Now().UnixNano()%25)
-					progress := (elapsed - 7.0) / 4.0
-					if progress > 1.0 {
-						progress = 1.0
-					}
-					variation := math.Sin(elapsed*3) * 3.0
-					targetSpeed = maxSpeed*(0.3+0.7*progress) + variation
-					if targetSpeed < 0 {
-						targetSpeed = 8.0
-					}
-					m.uploadSpeed = targetSpeed
-					m.targetSpeed = targetSpeed
-				} else {
-					targetSpeed = m.uploadSpeed
-				}
-			}
+	case historyMsg:
+		m.history = newHistoryView(msg)
+
+	case troubleshootMsg:
+		if m.troubleshooting != nil {
+			m.troubleshooting.finishCheck(msg)
+		}
+
+	case serverMapMsg:
+		if m.serverMap != nil {
+			return m, m.serverMap.addProbe(msg)
+		}
 
-			diff := targetSpeed - m.animationSpeed
+	case controlMsg:
+		switch {
+		case msg == "start" && (m.phase == phaseComplete || m.phase == phaseError):
+			return m.restart()
+		case msg == "abort":
+			m.cancel()
+		}
+
+	case engineEvent:
+		updated, cmd := m.Update(msg.msg)
+		return updated, tea.Batch(cmd, waitForEvent(msg.events))
+
+	case tickMsg:
+		if m.phase == phaseDownloading || m.phase == phaseUploading {
+			diff := m.targetSpeed - m.animationSpeed
 			if math.Abs(diff) > 0.5 {
-				m.animationSpeed += diff * 0.15
+				m.animationSpeed += diff * (1 - m.opts.perFrame(0.85))
 			} else {
-				m.animationSpeed = targetSpeed
+				m.animationSpeed = m.targetSpeed
 			}
 
-			return m, tickCmd()
+			if m.phase == phaseDownloading {
+				m.downloadPeak = math.Max(m.downloadPeak*m.opts.perFrame(peakDecay), m.animationSpeed)
+			} else {
+				m.uploadPeak = math.Max(m.uploadPeak*m.opts.perFrame(peakDecay), m.animationSpeed)
+			}
+			m.scale = nextGaugeScale(m.scale, m.gaugeDemand())
+
+			return m, tickCmd(m.engine.clock, m.opts.frameInterval())
 		}
 
 	case speedMsg:
-		if m.phase == phaseDownloading {
+		switch m.phase {
+		case phaseDownloading:
 			m.downloadSpeed = float64(msg)
-			m.targetSpeed = m.downloadSpeed
+		case phaseUploading:
+			m.uploadSpeed = float64(msg)
+		}
+		m.targetSpeed = float64(msg)
+		series := seriesDownload
+		if m.phase == phaseUploading {
+			series = seriesUpload
 		}
-		m.speedHistory = append(m.speedHistory, float64(msg))
-		if len(m.speedHistory) > 60 {
-			m.speedHistory = m.speedHistory[1:]
+		m.graph = m.graph.add(series, m.engine.clock.Since(m.startTime), float64(msg))
+		return m, nil
+
+	case probeMsg:
+		m.graph = m.graph.add(seriesLatency, m.engine.clock.Since(m.startTime), float64(msg))
+		return m, nil
+
+	case familySpeedMsg:
+		speeds := maps.Clone(m.familySpeeds)
+		if speeds == nil {
+			speeds = map[string]float64{}
 		}
+		speeds[msg.network] = msg.mbps
+		m.familySpeeds = speeds
+		return m, nil
+
+	case retestMsg:
+		// The confirmation test arrives on the same event stream; start the
+		// view over, keeping the unusual run in the session.
+		m.session = appendSession(m.session, msg.outlier)
+		m.retesting = &msg
+		m.phase = phaseInit
+		m.results = Results{}
+		m.downloadSpeed, m.uploadSpeed, m.ping = 0, 0, 0
+		m.pingSample, m.pingMin, m.pingMax = pingSampleMsg{}, 0, 0
+		m.race = candidatesMsg{}
+		m.animationSpeed, m.targetSpeed, m.downloadPeak, m.uploadPeak = 0, 0, 0, 0
+		m.graph = speedGraph{hidden: m.graph.hidden}
+		m.warnings, m.dismissedWarnings, m.showWarnings = nil, 0, false
 		return m, nil
 
 	case completeMsg:
 		m.phase = phaseComplete
-		m.downloadSpeed = msg.download
-		m.uploadSpeed = msg.upload
-		m.ping = msg.ping
-		m.serverLocation = msg.server
-		m.testDuration = time.Since(m.startTime)
+		m.retesting = nil
+		m.checkingWeb = false
+		m.results = Results(msg)
+		m.session = appendSession(m.session, m.results)
+		m.downloadSpeed = transferMbps(m.results.Download)
+		m.uploadSpeed = transferMbps(m.results.Upload)
+		m.ping = m.results.Latency.P50
 		m.targetSpeed = math.Max(m.downloadSpeed, m.uploadSpeed)
 		m.animationSpeed = m.targetSpeed
+		m.scale = nextGaugeScale(m.scale, m.gaugeDemand())
+		if m.results.Expected != nil {
+			m.expected = m.results.Expected
+		}
+		cmd := m.planCostCmd()
+		if len(m.engine.sinks) > 0 {
+			cmd = tea.Batch(cmd, dispatchCmd(m.engine.sinks, m.results))
+		}
+		return m.nextInterface(cmd)
+
+	case planCostMsg:
+		m.cost = &msg.cost
+		return m, nil
+
+	case sinkReportMsg:
+		report := sinkReport(msg)
+		m.sinkReport = &report
+		return m, nil
+
+	case fallbackMsg:
+		m.results.Fallbacks = msg
+		return m, nil
+
+	case retryMsg:
+		m.retrying = msg
+		if msg.phase == phasePing {
+			m.pingSample, m.pingMin, m.pingMax = pingSampleMsg{}, 0, 0
+		}
+		return m, nil
+
+	case candidatesMsg:
+		m.race = msg
+		return m, nil
+
+	case webCheckMsg:
+		m.checkingWeb = true
+		return m, nil
+
+	case warningMsg:
+		m.warnings = append(m.warnings, Warning(msg))
 		return m, nil
 
 	case serverMsg:
-		m.serverLocation = string(msg)
+		m.results.Server = Server(msg)
 		m.phase = phasePing
 		return m, nil
 
+	case serverLegMsg:
+		m.results.Server, m.serverLeg = msg.server, msg
+		return m, nil
+
+	case transferWindowMsg:
+		m.transferStart, m.transferWindow = m.engine.clock.Now(), time.Duration(msg)
+		return m, nil
+
+	case pingSampleMsg:
+		m.pingSample = msg
+		if !msg.lost {
+			if m.pingMax == 0 || msg.rtt < m.pingMin {
+				m.pingMin = msg.rtt
+			}
+			m.pingMax = max(m.pingMax, msg.rtt)
+		}
+		return m, nil
+
+	case lossMsg:
+		loss := float64(msg)
+		m.results.Loss = &loss
+		return m, nil
+
 	case pingMsg:
-		m.ping = float64(msg)
+		m.results.Latency = Latency(msg)
+		m.ping = msg.P50
+		m.pingMin, m.pingMax = msg.Min, msg.Max
 		m.phase = phaseDownloading
 
 		m.animationSpeed = 0
 		m.targetSpeed = 0
-		return m, tickCmd()
+		m.downloadPeak = 0
+		m.familySpeeds = nil
+		return m, tickCmd(m.engine.clock, m.opts.frameInterval())
+
+	case downloadMsg:
+		transfer := Transfer(msg)
+		m.results.Download = &transfer
+		m.downloadSpeed = transfer.Mbps
+		if m.caps.Upload {
+			m.phase = phaseUploading
+			m.animationSpeed = 0
+			m.targetSpeed = 0
+			m.uploadPeak = 0
+		}
+		return m, nil
 
 	case uploadMsg:
-		m.uploadSpeed = float64(msg)
-		m.phase = phaseUploading
-		m.targetSpeed = m.uploadSpeed
+		transfer := Transfer(msg)
+		m.results.Upload = &transfer
+		m.uploadSpeed = transfer.Mbps
 		return m, nil
 
 	case errorMsg:
+		m.failedIn, m.failedAfter = m.phase, m.engine.clock.Since(m.startTime)
 		m.phase = phaseError
+		m.checkingWeb = false
 		m.err = msg
-		return m, nil
+		return m.nextInterface(nil)
 
 	case tea.WindowSizeMsg:
 		m.progress.Width = msg.Width - 4
+		m.width, m.height = msg.Width, msg.Height
+		if m.errDetails != nil {
+			vp := *m.errDetails
+			vp.Width, vp.Height = max(m.width, 40), errDetailsHeight(m.height)
+			m.errDetails = &vp
+		}
 	}
 
 	return m, nil
@@ -207,72 +1346,186 @@ func (m speedTest) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m speedTest) View() string {
 	var s strings.Builder
 
-	title := "\033[37;1;44m GoFast - Speed Test \033[0m"
+	title := term.title("GoFast - Speed Test")
+	if name := m.engine.iface; name != "" {
+		title += "  \033[36;1m" + name + "\033[0m"
+		if n := len(m.opts.Interfaces); n > 1 {
+			title += fmt.Sprintf(" \033[90m(%d of %d)\033[0m", min(len(m.ifaceRuns)+1, n), n)
+		}
+	}
 
-	s.WriteString(title + "\n\n")
+	s.WriteString(title + m.warningBadge() + "\n\n")
+	if m.showWarnings {
+		s.WriteString(m.warningList() + "\n")
+	}
+	if m.phase != phaseError {
+		s.WriteString(m.renderTimeline())
+	}
+	if note := fallbackNote(m.results.Fallbacks, m.results.Server); note != "" && m.phase != phaseInit {
+		s.WriteString(fmt.Sprintf("\033[33m⚠ %s\033[0m\n\n", truncate(note, m.fitWidth("⚠ "))))
+	}
+	if r := m.retrying; r.of > 0 && r.phase == m.phase {
+		note := fmt.Sprintf("retrying %s (%d/%d)... %v", r.name, r.attempt, r.of, r.err)
+		s.WriteString(fmt.Sprintf("\033[33m⟳ %s\033[0m\n\n", truncate(note, m.fitWidth("⟳ "))))
+	}
+	if r := m.retesting; r != nil {
+		s.WriteString(fmt.Sprintf("\033[36m%s is far from the usual %s; running one confirmation test\033[0m\n\n",
+			formatSpeed(transferMbps(r.outlier.Download)), formatSpeed(r.baseline)))
+	}
+	if m.checkingWeb {
+		s.WriteString("\033[36mChecking web responsiveness...\033[0m\n\n")
+	}
 
 	switch m.phase {
 	case phaseInit:
 		s.WriteString("Initializing speed test...\n")
-		s.WriteString("Getting server location...\n\n")
+		if m.race.total > 0 {
+			s.WriteString(truncate(m.race.summary(), m.fitWidth("")) + "\n\n")
+		} else {
+			s.WriteString("Getting server location...\n\n")
+		}
 		s.WriteString(m.renderSpeedometer(0))
 
 	case phasePing:
 		s.WriteString("Testing connection to server...\n\n")
-		if m.serverLocation != "" {
-			s.WriteString(fmt.Sprintf("\033[32;1m🌐 Server: %s\033[0m\n\n", m.serverLocation))
-		}
+		s.WriteString(m.serverLine("🌐 Server: "))
 		s.WriteString(m.renderSpeedometer(0))
-		if m.ping > 0 {
-			s.WriteString(fmt.Sprintf("\nPing: %.1f ms\n", m.ping))
-		} else {
+		switch sample := m.pingSample; {
+		case m.ping > 0:
+			s.WriteString(fmt.Sprintf("\nPing: %.1f ms \033[90m· %.1f–%.1f ms\033[0m%s\n", m.ping, m.pingMin, m.pingMax, pingMethodNote(m.results.Latency.Method)))
+		case sample.of > 0 && sample.lost:
+			s.WriteString(fmt.Sprintf("\nTesting ping... sample %d/%d: lost\n", sample.n, sample.of))
+		case sample.of > 0:
+			s.WriteString(fmt.Sprintf("\nTesting ping... sample %d/%d: %.0f ms \033[90m(%.0f–%.0f ms)\033[0m\n", sample.n, sample.of, sample.rtt, m.pingMin, m.pingMax))
+		default:
 			s.WriteString("\nTesting ping...")
 		}
 
 	case phaseDownloading:
-		s.WriteString("Testing download speed...\n\n")
-		if m.serverLocation != "" {
-			s.WriteString(fmt.Sprintf("\033[32;1mConnected to: %s\033[0m\n\n", m.serverLocation))
-		}
-		s.WriteString(m.renderDualSpeedometer(m.animationSpeed, 0))
-		s.WriteString(fmt.Sprintf("\nDownload Speed: %.2f Mbps\n", m.downloadSpeed))
-		if m.ping > 0 {
-			s.WriteString(fmt.Sprintf("Ping: %.1f ms\n", m.ping))
+		if m.dualStack() {
+			s.WriteString("Testing download speed over IPv4 and IPv6 at once...\n\n")
+			s.WriteString(m.serverLine("Connected to: "))
+			s.WriteString(m.renderFamilyGauges())
+		} else if leg := m.serverLeg; leg.of > 1 {
+			s.WriteString(fmt.Sprintf("Testing download speed on server %d of %d...\n\n", leg.leg, leg.of))
+			s.WriteString(m.serverLine("Connected to: "))
+			s.WriteString(m.renderDualSpeedometer(m.animationSpeed, 0))
+		} else {
+			s.WriteString("Testing download speed...\n\n")
+			s.WriteString(m.serverLine("Connected to: "))
+			s.WriteString(m.renderDualSpeedometer(m.animationSpeed, 0))
 		}
+		s.WriteString(m.transferProgress())
+		s.WriteString(fmt.Sprintf("\nDownload Speed: %s\n", m.formatLive(m.downloadSpeed)))
+		s.WriteString("Ping: " + formatPingJitter(m.results.Latency) + pingMethodNote(m.results.Latency.Method) + "\n")
 		s.WriteString(m.renderSpeedHistory())
 
 	case phaseUploading:
 		s.WriteString("Testing upload speed...\n\n")
-		if m.serverLocation != "" {
-			s.WriteString(fmt.Sprintf("\033[32;1mConnected to: %s\033[0m\n\n", m.serverLocation))
-		}
+		s.WriteString(m.serverLine("Connected to: "))
 		s.WriteString(m.renderDualSpeedometer(m.downloadSpeed, m.animationSpeed))
-		s.WriteString(fmt.Sprintf("\nDownload: %.2f Mbps\n", m.downloadSpeed))
-		s.WriteString(fmt.Sprintf("Upload: %.2f Mbps\n", m.uploadSpeed))
-		if m.ping > 0 {
-			s.WriteString(fmt.Sprintf("Ping: %.1f ms\n", m.ping))
-		}
+		s.WriteString(m.transferProgress())
+		s.WriteString(fmt.Sprintf("\nDownload: %s\n", m.formatLive(m.downloadSpeed)))
+		s.WriteString("Upload: " + m.formatUpload(m.uploadSpeed) + "\n")
+		s.WriteString("Ping: " + formatPingJitter(m.results.Latency) + pingMethodNote(m.results.Latency.Method) + "\n")
+		s.WriteString(m.renderSpeedHistory())
 
 	case phaseComplete:
 		s.WriteString("Speed test complete!\n\n")
-		if m.serverLocation != "" {
-			s.WriteString(fmt.Sprintf("\033[32;1mTested via: %s\033[0m\n\n", m.serverLocation))
+		s.WriteString(m.serverLine("Tested via: "))
+		if m.showSession {
+			s.WriteString(sessionTable(m.session))
+			s.WriteString("\nPress 's' to return to the results")
+			break
+		}
+		if m.troubleshooting != nil {
+			s.WriteString(m.troubleshooting.view())
+			break
+		}
+		if m.serverMap != nil {
+			s.WriteString(m.serverMap.view(m.width))
+			break
+		}
+		if m.history != nil {
+			s.WriteString(m.history.view(m.width))
+			if m.notice != "" {
+				s.WriteString("\n" + m.notice)
+			}
+			break
+		}
+		if m.showQR {
+			// Leave room for the header above and the hints below.
+			code, err := renderQR(shareText(m.results), m.width, max(m.height-12, 0))
+			if err != nil {
+				s.WriteString(fmt.Sprintf("\033[33m%v\033[0m\n", err))
+			} else {
+				s.WriteString(code)
+				s.WriteString("\n\033[90mScan for a summary of this run\033[0m\n")
+			}
+			s.WriteString("\nPress 'c' to return to the results")
+			break
 		}
 		s.WriteString(m.renderDualSpeedometer(m.downloadSpeed, m.uploadSpeed))
-		s.WriteString(fmt.Sprintf("\nDownload: %.2f Mbps\n", m.downloadSpeed))
-		s.WriteString(fmt.Sprintf("Upload: %.2f Mbps\n", m.uploadSpeed))
-		s.WriteString(fmt.Sprintf("Ping: %.1f ms\n", m.ping))
-		s.WriteString(fmt.Sprintf("Test Duration: %.1fs\n", m.testDuration.Seconds()))
-		s.WriteString("\nPress 'r' to run again")
+		s.WriteString(renderSections(append(m.detailSections(), m.baselineSection())))
+		if c := m.interfaceComparison(); c != "" {
+			s.WriteString("\n" + c)
+		}
+		if note := provenanceFootnote(m.results); note != "" {
+			s.WriteString(fmt.Sprintf("\n\033[90m%s\033[0m\n", note))
+		}
+		if line := contextLine(m.results.Context); line != "" {
+			s.WriteString("\n" + line + "\n")
+		}
+		if strip := sessionStrip(m.session); strip != "" {
+			s.WriteString(fmt.Sprintf("\n%s  \033[90m(press 's' to compare)\033[0m\n", strip))
+		}
+		if m.engine.profile.Label != "" {
+			s.WriteString(fmt.Sprintf("\n\033[90m%s\033[0m\n", m.engine.profile.Label))
+		}
+		if m.notice != "" {
+			s.WriteString("\n" + m.notice + "\n")
+		}
+		if m.noteInput != nil {
+			s.WriteString("\n" + m.noteInput.View() + "\n\033[90menter to save, esc to cancel\033[0m")
+		} else if m.opts.NoMenu {
+			s.WriteString("\n" + m.shortcutHints())
+		} else {
+			s.WriteString("\n" + m.renderMenu())
+		}
 
 	case phaseError:
-		s.WriteString("Error occurred:\n")
-		s.WriteString(fmt.Sprintf("%v\n", m.err))
-		s.WriteString("\nPress 'r' to try again")
+		if hint, ok := hintFor(m.err); ok {
+			s.WriteString(fmt.Sprintf("\033[31;1m%s\033[0m\n\n", hint.Title))
+			s.WriteString(wrapText(hint.Advice, max(m.width-2, 40)) + "\n")
+		} else {
+			s.WriteString("Error occurred:\n")
+			s.WriteString(fmt.Sprintf("%v\n", m.err))
+		}
+		if m.errDetails != nil {
+			s.WriteString("\n" + m.errDetails.View() + "\n")
+			s.WriteString(fmt.Sprintf("\033[90m%3.0f%% · ↑/↓ to scroll\033[0m\n", m.errDetails.ScrollPercent()*100))
+		}
+		if m.notice != "" {
+			s.WriteString("\n" + m.notice + "\n")
+		}
+		details := "'d' for technical details"
+		if m.errDetails != nil {
+			details = "'d' to hide the details"
+		}
+		s.WriteString("\nPress 'r' to try again, " + details + ", 'c' to save diagnostics for a bug report")
+		if c := m.interfaceComparison(); c != "" {
+			s.WriteString("\n\n" + c)
+		}
 	}
 
-	s.WriteString("\n\nPress 'q' to quit")
-	return s.String()
+	if m.confirmQuit {
+		s.WriteString("\n\n\033[33;1mAbort test and quit? (y/n)\033[0m")
+	} else if m.confirmOverwrite {
+		s.WriteString("\n\n\033[33;1m" + m.opts.ConfigPath + " was changed by something else since gofast started. Overwrite it? (y/n)\033[0m")
+	} else if m.noteInput == nil {
+		s.WriteString("\n\nPress 'q' to quit")
+	}
+	return term.render(s.String())
 }
 
 func (m speedTest) renderDualSpeedometer(downloadSpeed, uploadSpeed float64) string {
@@ -283,455 +1536,249 @@ func (m speedTest) renderDualSpeedometer(downloadSpeed, uploadSpeed float64) str
 	s.WriteString("     ║                        DOWNLOAD                              UPLOAD                              ║\n")
 	s.WriteString("     ╚═══════════════════════════════════════════════════════════════════════════════════════════════╝\n")
 
-	for row := 0; row < 35; row++ {
-		s.WriteString("     ")
-		for col := 0; col < 90; col++ {
-			char := " "
-
-			if col < 45 {
-				char = m.renderSingleGauge(float64(col), float64(row), 22.0, 18.0, downloadSpeed, 18.0, 14.0)
-			}
+	downloadPeak, uploadPeak := 0.0, 0.0
+	if m.opts.PeakHold {
+		downloadPeak, uploadPeak = m.downloadPeak, m.uploadPeak
+	}
 
-			if col >= 45 {
-				char = m.renderSingleGauge(float64(col-45), float64(row), 22.0, 18.0, uploadSpeed, 18.0, 14.0)
-			}
+	downloadBaseline, uploadBaseline := m.baselineSpeeds()
+	if !m.caps.Upload {
+		uploadBaseline = 0
+	}
 
-			s.WriteString(char)
+	scale := m.scale
+	if scale == 0 {
+		scale = gaugeScale(math.Max(math.Max(math.Max(downloadSpeed, uploadSpeed), math.Max(downloadPeak, uploadPeak)), math.Max(downloadBaseline, uploadBaseline)))
+	}
+	gaugeDownload, gaugeUpload := downloadSpeed, uploadSpeed
+	// In percent mode the gauges read percent of the expected speeds and
+	// the baseline markers sit at 100%, "performing as expected".
+	var expectDownload, expectUpload float64
+	if m.percent {
+		expectDownload, expectUpload = m.expected.DownloadMbps, m.expected.UploadMbps
+		if !m.caps.Upload {
+			expectUpload = 0
 		}
-		s.WriteString("\n")
+		scale = percentScale
+		gaugeDownload, gaugeUpload = percentOf(downloadSpeed, expectDownload), percentOf(uploadSpeed, expectUpload)
+		downloadPeak, uploadPeak = percentOf(downloadPeak, expectDownload), percentOf(uploadPeak, expectUpload)
+		downloadBaseline, uploadBaseline = percentOf(expectDownload, expectDownload), percentOf(expectUpload, expectUpload)
+	}
+	download := gauge.New(gauge.Options{Max: scale, Size: gauge.Medium, Theme: gauge.DefaultTheme})
+	download.SetValue(gaugeDownload)
+	download.SetPeak(downloadPeak)
+	download.SetBaseline(downloadBaseline)
+	upload := gauge.New(gauge.Options{Max: scale, Size: gauge.Medium, Theme: gauge.DefaultTheme})
+	upload.SetValue(gaugeUpload)
+	upload.SetPeak(uploadPeak)
+	upload.SetBaseline(uploadBaseline)
+
+	downloadRows, uploadRows := download.Rows(), upload.Rows()
+	for row := range downloadRows {
+		s.WriteString("     " + downloadRows[row] + uploadRows[row] + "\n")
 	}
 //temp probably need to try somethign else
-	s.WriteString("     0   10   20   30   40   50   60   70   80   90  100     0   10   20   30   40   50   60   70   80   90  100\n")
-	s.WriteString("                           Mbps                                                 Mbps\n")
-
-	var downloadDisplay, uploadDisplay string
-	if downloadSpeed >= 80 {
-		downloadDisplay = fmt.Sprintf("     \033[31;1mDownload: %.1f Mbps\033[0m", downloadSpeed)
-	} else if downloadSpeed >= 60 {
-		downloadDisplay = fmt.Sprintf("     \033[33;1mDownload: %.1f Mbps\033[0m", downloadSpeed)
-	} else if downloadSpeed >= 30 {
-		downloadDisplay = fmt.Sprintf("     \033[32;1mDownload: %.1f Mbps\033[0m", downloadSpeed)
-	} else {
-		downloadDisplay = fmt.Sprintf("     \033[36;1mDownload: %.1f Mbps\033[0m", downloadSpeed)
+	_, unit := axisLabels(scale)
+	axis := axisLine(scale)
+	downloadReadout, uploadReadout := formatGaugeSpeed(downloadSpeed, scale), formatGaugeSpeed(uploadSpeed, scale)
+	if m.percent {
+		unit, axis = "% of "+m.expected.Source, gauge.TickLine(gauge.TickLabels(percentScale))
+		downloadReadout, uploadReadout = percentReadout(downloadSpeed, expectDownload), percentReadout(uploadSpeed, expectUpload)
 	}
+	s.WriteString(axis + axis + "\n")
+	s.WriteString(strings.Repeat(" ", 27) + unit + strings.Repeat(" ", max(53-len(unit), 1)) + unit + "\n")
 
-	if uploadSpeed >= 80 {
-		uploadDisplay = fmt.Sprintf("                                 \033[31;1mUpload: %.1f Mbps\033[0m\n", uploadSpeed)
-	} else if uploadSpeed >= 60 {
-		uploadDisplay = fmt.Sprintf("                                 \033[33;1mUpload: %.1f Mbps\033[0m\n", uploadSpeed)
-	} else if uploadSpeed >= 30 {
-		uploadDisplay = fmt.Sprintf("                                 \033[32;1mUpload: %.1f Mbps\033[0m\n", uploadSpeed)
+	downloadDisplay := "     " + gradeSpeed(downloadSpeed).cue().mark("Download: "+downloadReadout)
+
+	var uploadDisplay string
+	if !m.caps.Upload {
+		uploadDisplay = "                                 \033[90mUpload: n/a\033[0m\n"
 	} else {
-		uploadDisplay = fmt.Sprintf("                                 \033[36;1mUpload: %.1f Mbps\033[0m\n", uploadSpeed)
+		uploadDisplay = "                                 " + gradeSpeed(uploadSpeed).cue().mark("Upload: "+uploadReadout) + "\n"
 	}
 
 	s.WriteString(downloadDisplay + uploadDisplay)
 	return s.String()
 }
 
-func (m speedTest) renderSingleGauge(x, y, centerX, centerY, speed, outerRadius, innerRadius float64) string {
-
-	dx := x - centerX
-	dy := y - centerY
-	distance := math.Sqrt(dx*dx + dy*dy)
-	angle := math.Atan2(-dy, dx)
-
-	if angle < 0 {
-		angle += 2 * math.Pi
+// cappedDirections names the transfers that stopped at their byte budget.
+func (m speedTest) cappedDirections() string {
+	var capped []string
+	if m.results.Download != nil && m.results.Download.Capped {
+		capped = append(capped, "Download")
 	}
-
-	angleInDegrees := angle * 180.0 / math.Pi
-
-	inArc := (angleInDegrees >= 315.0 || angleInDegrees <= 225.0) && !(angleInDegrees > 225.0 && angleInDegrees < 315.0)
-
-	char := " "
-
-	if distance >= outerRadius-1.0 && distance <= outerRadius+1.0 && inArc {
-		char = "█"
-	} else if distance >= innerRadius-0.8 && distance <= innerRadius+0.8 && inArc {
-
-		char = "░"
-	} else if distance >= 3 && distance <= innerRadius-2 {
-
-		needleAngle := 240.0 - (speed/100.0)*270.0
-		if needleAngle < 0.0 {
-			needleAngle += 360.0
-		}
-		needleAngleRad := needleAngle * math.Pi / 180.0
-
-		needleEndX := centerX + (innerRadius-3)*math.Cos(needleAngleRad)
-		needleEndY := centerY - (innerRadius-3)*math.Sin(needleAngleRad)
-
-		lineDistance := math.Abs((needleEndY-centerY)*x-(needleEndX-centerX)*y+needleEndX*centerY-needleEndY*centerX) /
-			math.Sqrt(math.Pow(needleEndY-centerY, 2)+math.Pow(needleEndX-centerX, 2))
-
-		if lineDistance < 1.2 &&
-			((x-centerX)*(needleEndX-centerX)+(y-centerY)*(needleEndY-centerY)) > 0 {
-			char = "━"
-		}
-	} else if distance <= 3.0 {
-
-		char = "●"
-	} else if distance >= 8 && distance <= 12 && inArc {
-
-		if angleInDegrees >= 135.0 && angleInDegrees <= 216.0 {
-			char = "▓"
-		} else if angleInDegrees >= 216.0 && angleInDegrees <= 297.0 {
-			char = "▒"
-		} else if (angleInDegrees >= 297.0) || (angleInDegrees >= 0.0 && angleInDegrees <= 18.0) {
-			char = "░"
-		} else if angleInDegrees >= 18.0 && angleInDegrees <= 45.0 {
-			char = "▓"
-		}
+	if m.results.Upload != nil && m.results.Upload.Capped {
+		capped = append(capped, "Upload")
 	}
-
-	return char
+	return strings.Join(capped, " and ")
 }
 
-func (m speedTest) renderSpeedometer(speed float64) string {
-	var s strings.Builder
-
-	centerX, centerY := 25.0, 20.0
-	outerRadius := 18.0
-	innerRadius := 14.0
-
-	maxSpeed := 100.0
-	speedAngle := (speed / maxSpeed) * 270.0
-	if speedAngle > 270 {
-		speedAngle = 270
+// stallSummary counts the stalled connections of each transfer, e.g.
+// "2 download, 1 upload".
+func (m speedTest) stallSummary() string {
+	var parts []string
+	for _, t := range []struct {
+		name     string
+		transfer *Transfer
+	}{{"download", m.results.Download}, {"upload", m.results.Upload}} {
+		if t.transfer != nil && t.transfer.Stalls > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", t.transfer.Stalls, t.name))
+		}
 	}
+	return strings.Join(parts, ", ")
+}
 
-	s.WriteString("     ╔═══════════════════════════════════════════════╗\n")
-	s.WriteString("     ║               goFast tui                      ║\n")
-	s.WriteString("     ╚═══════════════════════════════════════════════╝\n")
-
-	for row := 0; row < 35; row++ {
-		s.WriteString("     ")
-		for col := 0; col < 50; col++ {
-			x := float64(col)
-			y := float64(row)
-
-			dx := x - centerX
-			dy := y - centerY
-			distance := math.Sqrt(dx*dx + dy*dy)
-			angle := math.Atan2(-dy, dx)
-
-			if angle < 0 {
-				angle += 2 * math.Pi
-			}
-
-			angleInDegrees := angle * 180.0 / math.Pi
-
-			inArc := (angleInDegrees >= 315.0 || angleInDegrees <= 225.0) && !(angleInDegrees > 225.0 && angleInDegrees < 315.0)
-
-			char := " "
-
-			if distance >= outerRadius-1.0 && distance <= outerRadius+1.0 && inArc {
-				char = "█"
-			} else if distance >= innerRadius-0.8 && distance <= innerRadius+0.8 && inArc {
-
-				char = "░"
-			} else if distance >= outerRadius+1.5 && distance <= outerRadius+4.0 && inArc {
-
-				tickPositions := []float64{135, 162, 189, 216, 243, 270, 297, 324, 351, 18, 45}
-
-				for i, tickAngle := range tickPositions {
-
-					normalizedTickAngle := tickAngle
-					if normalizedTickAngle > 360 {
-						normalizedTickAngle -= 360
-					}
-
-					angleDiff := math.Abs(angleInDegrees - normalizedTickAngle)
-					if angleDiff > 180 {
-						angleDiff = 360 - angleDiff
-					}
-
-					if angleDiff < 4 {
-						if distance >= outerRadius+1.5 && distance <= outerRadius+2.5 {
-							char = "│"
-						} else if distance >= outerRadius+2.8 && distance <= outerRadius+4.0 {
-
-							speedValue := i * 10
-							if speedValue <= 100 {
-
-								if speedValue == 0 {
-									char = "0"
-								} else if speedValue == 10 {
-									char = "1"
-								} else if speedValue == 20 {
-									char = "2"
-								} else if speedValue == 30 {
-									char = "3"
-								} else if speedValue == 40 {
-									char = "4"
-								} else if speedValue == 50 {
-									char = "5"
-								} else if speedValue == 60 {
-									char = "6"
-								} else if speedValue == 70 {
-									char = "7"
-								} else if speedValue == 80 {
-									char = "8"
-								} else if speedValue == 90 {
-									char = "9"
-								} else if speedValue == 100 {
-									char = "X"
-								}
-							}
-						}
-					}
-				}
-			} else if distance >= 3 && distance <= innerRadius-2 {
-
-				needleAngle := 240.0 - (speed/maxSpeed)*270.0
-				if needleAngle < 0.0 {
-					needleAngle += 360.0
-				}
-				needleAngleRad := needleAngle * math.Pi / 180.0
-
-				needleEndX := centerX + (innerRadius-3)*math.Cos(needleAngleRad)
-				needleEndY := centerY - (innerRadius-3)*math.Sin(needleAngleRad)
-
-				lineDistance := math.Abs((needleEndY-centerY)*x-(needleEndX-centerX)*y+needleEndX*centerY-needleEndY*centerX) /
-					math.Sqrt(math.Pow(needleEndY-centerY, 2)+math.Pow(needleEndX-centerX, 2))
-
-				if lineDistance < 1.2 &&
-					((x-centerX)*(needleEndX-centerX)+(y-centerY)*(needleEndY-centerY)) > 0 {
-					char = "━"
-				}
-			} else if distance <= 3.0 {
-
-				char = "●"
-			} else if distance >= 8 && distance <= 12 && inArc {
-
-				if angleInDegrees >= 135.0 && angleInDegrees <= 216.0 {
-					char = "▓"
-				} else if angleInDegrees >= 216.0 && angleInDegrees <= 297.0 {
-					char = "▒"
-				} else if (angleInDegrees >= 297.0) || (angleInDegrees >= 0.0 && angleInDegrees <= 18.0) {
-					char = "░"
-				} else if angleInDegrees >= 18.0 && angleInDegrees <= 45.0 {
-					char = "▓"
-				}
-			}
-
-			s.WriteString(char)
+// overheadSummary reports how much of the wire traffic was protocol
+// overhead, for providers whose connections are counted.
+func (m speedTest) overheadSummary() string {
+	var parts []string
+	for _, t := range []struct {
+		name     string
+		transfer *Transfer
+	}{{"download", m.results.Download}, {"upload", m.results.Upload}} {
+		if t.transfer == nil {
+			continue
+		}
+		if overhead, ok := t.transfer.Overhead(); ok {
+			parts = append(parts, fmt.Sprintf("%.1f%% %s", overhead, t.name))
 		}
-		s.WriteString("\n")
 	}
-
-	s.WriteString("     0   10   20   30   40   50   60   70   80   90  100\n")
-	s.WriteString("                           Mbps\n")
-
-	var speedDisplay string
-	if speed >= 80 {
-		speedDisplay = fmt.Sprintf("     \033[31;1mSpeed: %.1f Mbps\033[0m\n", speed)
-	} else if speed >= 60 {
-		speedDisplay = fmt.Sprintf("     \033[33;1mSpeed: %.1f Mbps\033[0m\n", speed)
-	} else if speed >= 30 {
-		speedDisplay = fmt.Sprintf("     \033[32;1mSpeed: %.1f Mbps\033[0m\n", speed)
-	} else {
-		speedDisplay = fmt.Sprintf("     \033[36;1mSpeed: %.1f Mbps\033[0m\n", speed)
+	if len(parts) == 0 {
+		return ""
 	}
-
-	s.WriteString(speedDisplay)
-
-	return s.String()
+	return strings.Join(parts, ", ")
 }
 
-func (m speedTest) renderSpeedHistory() string {
-	if len(m.speedHistory) < 2 {
-		return ""
+func (m speedTest) formatUpload(speed float64) string {
+	if !m.caps.Upload {
+		return "n/a"
 	}
+	return m.formatLive(speed)
+}
 
-	var s strings.Builder
-	s.WriteString("\nSpeed History:\n")
-
-	maxSpeed := 1.0
-	for _, speed := range m.speedHistory {
-		if speed > maxSpeed {
-			maxSpeed = speed
-		}
+// gaugeDemand is the largest value the dual gauge has to fit in the current
+// phase: the live speeds, peak markers and baseline markers.
+func (m speedTest) gaugeDemand() float64 {
+	var download, upload float64
+	switch m.phase {
+	case phaseDownloading:
+		download = m.animationSpeed
+	case phaseUploading:
+		download, upload = m.downloadSpeed, m.animationSpeed
+	default:
+		download, upload = m.downloadSpeed, m.uploadSpeed
 	}
-
-	height := 8
-	width := len(m.speedHistory)
-	if width > 50 {
-		width = 50
+	if m.opts.PeakHold {
+		download = math.Max(download, m.downloadPeak)
+		upload = math.Max(upload, m.uploadPeak)
 	}
-
-	for row := height - 1; row >= 0; row-- {
-		threshold := (float64(row) / float64(height-1)) * maxSpeed
-		for col := 0; col < width; col++ {
-			idx := len(m.speedHistory) - width + col
-			if idx >= 0 && idx < len(m.speedHistory) {
-				if m.speedHistory[idx] >= threshold {
-					s.WriteString("█")
-				} else {
-					s.WriteString(" ")
-				}
-			} else {
-				s.WriteString(" ")
-			}
-		}
-		s.WriteString("\n")
+	downloadBaseline, uploadBaseline := m.baselineSpeeds()
+	if !m.caps.Upload {
+		uploadBaseline = 0
 	}
-
-	return s.String()
+	return math.Max(math.Max(download, upload), math.Max(downloadBaseline, uploadBaseline))
 }
 
-func runSpeedTestCmd() tea.Cmd {
-	return tea.Sequence(
-
-		func() tea.Msg {
-			server := getServerLocation()
-			return serverMsg(server)
-		},
-
-		func() tea.Msg {
-			time.Sleep(1 * time.Second)
-			ping := testPing()
-			return pingMsg(ping)
-		},
+// formatLive renders a live readout in the dual gauge's current unit.
+func (m speedTest) formatLive(mbps float64) string {
+	if m.scale == 0 {
+		return formatSpeed(mbps)
+	}
+	return formatGaugeSpeed(mbps, m.scale)
+}
 
-		func() tea.Msg {
+func (m speedTest) renderSpeedometer(speed float64) string {
+	var s strings.Builder
 
-			return tea.Cmd(func() tea.Msg {
+	scale := gaugeScale(speed)
 
-				baseSpeed := 15.0 + float64(time.Now().UnixNano()%80)
+	s.WriteString("     ╔═══════════════════════════════════════════════╗\n")
+	s.WriteString("     ║               goFast tui                      ║\n")
+	s.WriteString("     ╚═══════════════════════════════════════════════╝\n")
 
-				for i := 0; i < 50; i++ {
-					time.Sleep(100 * time.Millisecond)
+	dial := gauge.New(gauge.Options{Max: scale, Theme: gauge.DefaultTheme})
+	dial.SetValue(speed)
+	for _, row := range dial.Rows() {
+		s.WriteString("     " + row + "\n")
+	}
 
-					progress := float64(i) / 49.0
-					currentSpeed := baseSpeed * (0.3 + 0.7*progress)
-					currentSpeed += float64((i%10 - 5)) * 2.0
-					if currentSpeed < 0 {
-						currentSpeed = 5.0
-					}
+	_, unit := axisLabels(scale)
+	s.WriteString(axisLine(scale) + "\n")
+	s.WriteString("                           " + unit + "\n")
 
-				}
+	speedDisplay := "     " + gradeSpeed(speed).cue().mark("Speed: "+formatSpeed(speed)) + "\n"
 
-				return speedMsg(baseSpeed)
-			})()
-		},
-
-		func() tea.Msg {
-			time.Sleep(4 * time.Second)
-			uploadSpeed := 8.0 + float64(time.Now().UnixNano()%40)
-			return uploadMsg(uploadSpeed)
-		},
+	s.WriteString(speedDisplay)
 
-		func() tea.Msg {
-			downloadSpeed := 50.0 + float64(time.Now().UnixNano()%50)
-			uploadSpeed := 25.0 + float64(time.Now().UnixNano()%25)
-			ping := 15.0 + float64(time.Now().UnixNano()%20)
-			server := getServerLocation()
-
-			return completeMsg(speedTestResults{
-				download: downloadSpeed,
-				upload:   uploadSpeed,
-				ping:     ping,
-				server:   server,
-			})
-		},
-	)
+	return s.String()
 }
 
-type speedTestResults struct {
-	download float64
-	upload   float64
-	ping     float64
-	server   string
+// getServerLocation looks up the location as "City, Region" and its
+// country.
+func getServerLocation(ctx context.Context) (string, string, error) {
+	g, err := geolocate(ctx)
+	return g.Place, g.Country, err
 }
 
-func performCompleteSpeedTest() (speedTestResults, error) {
-	results := speedTestResults{}
-
-	results.server = getServerLocation()
-
-	time.Sleep(1 * time.Second)
-	results.ping = testPing()
-
-	time.Sleep(5 * time.Second)
-	results.download = simulateRealisticSpeedTest()
-
-	time.Sleep(4 * time.Second)
-	results.upload = simulateUploadSpeed()
-
-	return results, nil
+// geolocation is where this machine's public address is, as ipapi.co
+// places it.
+type geolocation struct {
+	// Place is "City, Region", empty when the lookup found no city.
+	Place    string
+	Country  string
+	Lat, Lon float64
 }
 
-func getServerLocation() string {
+func geolocate(ctx context.Context) (geolocation, error) {
 //tempp :/q
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get("https://ipapi.co/json/")
+	client := &http.Client{Timeout: 5 * time.Second, Transport: httpTransport()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://ipapi.co/json/", nil)
 	if err != nil {
-
-		locations := []string{
-			"Mumbai, MH",
-			"Delhi, DL",
-			"Bangalore, KA",
-			"Hyderabad, TG",
-			"Chennai, TN",
-			"Kolkata, WB",
-			"Pune, MH",
-			"Ahmedabad, GJ",
-		}
-		return locations[int(time.Now().UnixNano())%len(locations)]
+		return geolocation{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return geolocation{}, err
 	}
 	defer resp.Body.Close()
 
 	var data struct {
-		City    string `json:"city"`
-		Region  string `json:"region_code"`
-		Country string `json:"country_name"`
-		ISP     string `json:"org"`
+		City      string  `json:"city"`
+		Region    string  `json:"region_code"`
+		Country   string  `json:"country_name"`
+		ISP       string  `json:"org"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "Mumbai, Maharashtra"
+		return geolocation{}, err
 	}
 
+	g := geolocation{Country: data.Country, Lat: data.Latitude, Lon: data.Longitude}
 	if data.City != "" && data.Region != "" {
-		return fmt.Sprintf("%s, %s", data.City, data.Region)
+		g.Place = fmt.Sprintf("%s, %s", data.City, data.Region)
+		return g, nil
 	}
 
-	return "Mumbai, Maharashtra"
+	return g, errors.New("location lookup returned no city")
 }
 
-func testPing() float64 {
-
-	client := &http.Client{Timeout: 2 * time.Second}
-	start := time.Now()
+func simulateUploadSpeed(rng *rand.Rand) float64 {
 
-	_, err := client.Head("https://www.google.com")
-	if err != nil {
-
-		return 15.0 + float64(time.Now().UnixNano()%20)
-	}
-
-	pingTime := time.Since(start).Milliseconds()
-	return float64(pingTime)
-}
-
-func simulateUploadSpeed() float64 {
-
-	baseSpeed := 8.0 + float64(time.Now().UnixNano()%40)
+	baseSpeed := 8.0 + float64(rng.IntN(40))
 	return baseSpeed
 }
 
-func simulateRealisticSpeedTest() float64 {
+func simulateRealisticSpeedTest(rng *rand.Rand) float64 {
 
-	seed := time.Now().UnixNano() % 100
-	baseSpeed := 15.0 + float64(seed)*0.8
+	baseSpeed := 15.0 + float64(rng.IntN(100))*0.8
 
 	return baseSpeed
 }
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*16, func(t time.Time) tea.Msg {
-		return tickMsg(t)
-	})
+func tickCmd(clock Clock, interval time.Duration) tea.Cmd {
+	return func() tea.Msg {
+		return tickMsg(<-clock.After(interval))
+	}
 }
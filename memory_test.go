@@ -0,0 +1,126 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// soakRun is a completed run carrying the raw samples a real one would.
+func soakRun(i int) Results {
+	samples := make([]float64, 50)
+	for j := range samples {
+		samples[j] = float64(80 + (i+j)%20)
+	}
+	rtts := make([]float64, 20)
+	for j := range rtts {
+		rtts[j] = float64(10 + j%5)
+	}
+	return Results{
+		Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Minute),
+		Provider:  "demo",
+		Latency:   Latency{P50: 12, Samples: rtts},
+		Download:  &Transfer{Mbps: 90, Samples: samples, LatencySamples: rtts},
+		Upload:    &Transfer{Mbps: 20, Samples: samples, LatencySamples: rtts},
+	}
+}
+
+func liveHeap() uint64 {
+	runtime.GC()
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// TestSoakHeapFlat makes 10,000 runs finish in one TUI session and one soak
+// series; what they keep must stop growing long before the end.
+func TestSoakHeapFlat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak")
+	}
+	const runs, settled = 10000, 1000
+
+	model := initialModel(engine{provider: demoProvider{}, clock: realClock{}}, viewOptions{})
+	defer model.cancel()
+	var series soakSeries
+	var atSettled uint64
+	for i := range runs {
+		if i == settled {
+			atSettled = liveHeap()
+		}
+		r := soakRun(i)
+		updated, _ := model.Update(completeMsg(r))
+		model = updated.(speedTest)
+		series.add(r)
+	}
+	grown := int64(liveHeap()) - int64(atSettled)
+
+	if len(model.session) != sessionRetention {
+		t.Errorf("session holds %d runs, want %d", len(model.session), sessionRetention)
+	}
+	if len(series.points) > soakRetention || series.runs != runs {
+		t.Errorf("soak series holds %d points over %d runs, want at most %d over %d", len(series.points), series.runs, soakRetention, runs)
+	}
+	for _, r := range model.session {
+		if r.Download.Samples != nil || r.Latency.Samples != nil {
+			t.Fatal("the session kept raw samples")
+		}
+	}
+	// The runs after the first thousand add nothing that lasts; allow for
+	// noise from the runtime itself.
+	if grown > 256<<10 {
+		t.Errorf("live heap grew %d KB over runs %d to %d", grown>>10, settled, runs)
+	}
+}
+
+func TestSampleStoreBounded(t *testing.T) {
+	var s sampleStore
+	const n = 100_000
+	for range n {
+		s.Add(100)
+	}
+	got := s.Snapshot()
+	if len(got) > sampleRetention || len(got) < sampleRetention/2 {
+		t.Errorf("%d samples kept after %d, want between %d and %d", len(got), n, sampleRetention/2, sampleRetention)
+	}
+	for _, v := range got {
+		if v != 100 {
+			t.Fatalf("merging changed a steady 100 Mbps to %g", v)
+		}
+	}
+}
+
+func TestSampleStoreEvenlySpaced(t *testing.T) {
+	var s sampleStore
+	// Each sample added is its index, so each kept one is the middle of
+	// the run it covers.
+	const n = 3*sampleRetention + 5
+	for i := range n {
+		s.Add(float64(i))
+	}
+	got := s.Snapshot()
+	if interval := s.Interval(sampleInterval); interval != 4*sampleInterval {
+		t.Fatalf("interval %v after %d samples, want %v", interval, n, 4*sampleInterval)
+	}
+	stride := 4
+	if len(got) != n/stride {
+		t.Errorf("%d samples kept, want %d", len(got), n/stride)
+	}
+	for i, v := range got {
+		if want := float64(i*stride) + float64(stride-1)/2; v != want {
+			t.Fatalf("sample %d is %g, want the mean of samples %d to %d, %g", i, v, i*stride, i*stride+stride-1, want)
+		}
+	}
+}
+
+func TestAppendSessionStripsSamples(t *testing.T) {
+	r := soakRun(0)
+	session := appendSession(nil, r)
+	if session[0].Download.Samples != nil || session[0].Upload.LatencySamples != nil || session[0].Latency.Samples != nil {
+		t.Error("the session kept raw samples")
+	}
+	if r.Download.Samples == nil || r.Latency.Samples == nil {
+		t.Error("stripping the session's copy changed the run itself")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --servers N downloads from the N best candidate servers one after
+// another, each for a share of the download's time, so one hot or
+// overloaded node can't skew the result. Ping and upload stay on the best
+// server.
+
+// maxServers bounds --servers; each server gets a share of one download's
+// time, and past a handful the shares are too short to mean much.
+const maxServers = 5
+
+// MultiServer is the download of a --servers run split by server; the
+// run's Download holds their mean.
+type MultiServer struct {
+	Legs       []ServerLeg `json:"legs"`
+	MeanMbps   float64     `json:"mean_mbps"`
+	MedianMbps float64     `json:"median_mbps"`
+}
+
+// ServerLeg is one server's share of a --servers download. A server that
+// failed has no download, and Error says why.
+type ServerLeg struct {
+	Server   Server    `json:"server"`
+	Download *Transfer `json:"download,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// serverLegMsg tells the TUI the download moved on to another server; leg
+// counts them from 1 up to of.
+type serverLegMsg struct {
+	server  Server
+	leg, of int
+}
+
+// fitLegs drops the servers a --max-duration download window can't give
+// minTransfer each, recording the cut. window is zero when the run has no
+// budget, and always holds one server when it has.
+func fitLegs(b *budget, legs []Server, window time.Duration) []Server {
+	if fit := max(int(window/minTransfer), 1); window > 0 && len(legs) > fit {
+		b.cut("download from %d of the %d servers", fit, len(legs))
+		return legs[:fit]
+	}
+	return legs
+}
+
+// multiServerDownload runs a download against each server in turn. The
+// legs share limit's time and bytes evenly, and the provider is pointed
+// back at the first server afterwards for the upload. A server that fails
+// is recorded and skipped; only all of them failing fails the run.
+func (e engine) multiServerDownload(ctx context.Context, selector serverSelector, servers []Server, limit transferLimit, full time.Duration, results *Results, emit func(tea.Msg)) (Transfer, error) {
+	defer selector.Use(servers[0])
+	window := limit.time
+	if window <= 0 {
+		window = full
+	}
+	legLimit := limit
+	// Under --max-duration the caller has already dropped the servers the
+	// window can't give minTransfer each, so this only lengthens the legs
+	// of a short unbudgeted download.
+	legLimit.time = max(window/time.Duration(len(servers)), minTransfer)
+	legLimit.bytes = limit.bytes / int64(len(servers))
+
+	multi := &MultiServer{}
+	var errs []error
+	for i, s := range servers {
+		selector.Use(s)
+		emit(serverLegMsg{server: e.privacy.redactServer(s), leg: i + 1, of: len(servers)})
+		leg := ServerLeg{Server: s}
+		t, err := e.transfer(ctx, "download", e.provider.Download, legLimit, emit)
+		switch {
+		case ctx.Err() != nil:
+			return Transfer{}, context.Cause(ctx)
+		case err != nil:
+			leg.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		default:
+			// The legs are shortened on purpose; only the byte budget
+			// caps one.
+			t.Capped = legLimit.bytes > 0 && t.Bytes >= legLimit.bytes
+			leg.Download = &t
+		}
+		multi.Legs = append(multi.Legs, leg)
+	}
+	results.MultiServer = multi
+
+	var (
+		combined Transfer
+		rates    []float64
+	)
+	for _, leg := range multi.Legs {
+		t := leg.Download
+		if t == nil {
+			continue
+		}
+		rates = append(rates, t.Mbps)
+		combined.Bytes += t.Bytes
+		combined.WireBytes += t.WireBytes
+		combined.Duration += t.Duration
+		combined.Stalls += t.Stalls
+		combined.Capped = combined.Capped || t.Capped
+		combined.Samples = append(combined.Samples, t.Samples...)
+		combined.LatencySamples = append(combined.LatencySamples, t.LatencySamples...)
+		combined.Quality = append(combined.Quality, t.Quality...)
+		if combined.Family == "" {
+			combined.Family, combined.Protocol, combined.Source = t.Family, t.Protocol, t.Source
+			combined.SampleInterval = t.SampleInterval
+		}
+	}
+	if len(rates) == 0 {
+		return Transfer{}, errors.Join(errs...)
+	}
+	for _, leg := range multi.Legs {
+		if leg.Error != "" {
+			warn(results, emit, warnServerLegFailed, "the download from %s failed (%s); the figure covers the other servers", leg.Server.Name, leg.Error)
+		}
+	}
+	var sum float64
+	for _, mbps := range rates {
+		sum += mbps
+	}
+	multi.MeanMbps, multi.MedianMbps = sum/float64(len(rates)), median(rates)
+	combined.Mbps = multi.MeanMbps
+	return combined, nil
+}
+
+// serverLegsSection lists each server's download of a --servers run, with
+// their mean and median.
+func serverLegsSection(multi *MultiServer) detailSection {
+	section := detailSection{title: "Download by server"}
+	for _, leg := range multi.Legs {
+		name := leg.Server.Name
+		if leg.Download == nil {
+			section.add(name, "\033[31mfailed\033[0m \033[90m"+leg.Error+"\033[0m")
+			continue
+		}
+		section.add(name, formatSpeed(leg.Download.Mbps))
+	}
+	section.add("Mean", formatSpeed(multi.MeanMbps))
+	section.add("Median", formatSpeed(multi.MedianMbps))
+	if spread := legSpread(multi); spread != "" {
+		section.add("Spread", spread)
+	}
+	return section
+}
+
+// legSpread is how far apart the fastest and slowest servers were, or ""
+// with fewer than two that succeeded.
+func legSpread(multi *MultiServer) string {
+	var rates []float64
+	for _, leg := range multi.Legs {
+		if leg.Download != nil {
+			rates = append(rates, leg.Download.Mbps)
+		}
+	}
+	if len(rates) < 2 {
+		return ""
+	}
+	lo, hi := slices.Min(rates), slices.Max(rates)
+	return fmt.Sprintf("%s–%s", formatSpeed(lo), formatSpeed(hi))
+}
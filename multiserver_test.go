@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFitLegs(t *testing.T) {
+	servers := []Server{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+	tests := []struct {
+		window time.Duration
+		legs   int
+		cut    bool
+	}{
+		{window: 0, legs: 5},
+		{window: 5 * minTransfer, legs: 5},
+		{window: 4*minTransfer + time.Second, legs: 4, cut: true},
+		{window: 2200 * time.Millisecond, legs: 1, cut: true},
+	}
+	for _, tt := range tests {
+		b := newBudget(realClock{}, time.Minute)
+		legs := fitLegs(b, servers, tt.window)
+		if !slices.Equal(legs, servers[:tt.legs]) {
+			t.Errorf("window %s: %d servers, want the first %d", tt.window, len(legs), tt.legs)
+		}
+		if got := len(b.cuts) > 0; got != tt.cut {
+			t.Errorf("window %s: cuts %q, want a cut %v", tt.window, b.cuts, tt.cut)
+		}
+		if tt.window > 0 && time.Duration(len(legs))*minTransfer > tt.window {
+			t.Errorf("window %s: %d legs of %s don't fit", tt.window, len(legs), minTransfer)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// noteLimit bounds a note's length in characters.
+const noteLimit = 200
+
+// runID names a run for `gofast history annotate`: its local start time to
+// the second, the same stamp reports are saved under.
+func runID(r Results) string {
+	return r.Timestamp.Local().Format("20060102-150405")
+}
+
+// Annotate sets the note of every stored run that match selects, or
+// removes it for an empty note, and reports how many runs matched.
+func (h historyStore) Annotate(match func(Results) bool, note string) (int, error) {
+	return h.rewrite(match, func(fields map[string]json.RawMessage) error {
+		if note == "" {
+			delete(fields, "note")
+			return nil
+		}
+		var err error
+		fields["note"], err = json.Marshal(note)
+		return err
+	})
+}
+
+// noteMsg reports the outcome of storing a note typed on the completion
+// screen.
+type noteMsg struct {
+	note string
+	err  error
+}
+
+// addNote opens the note input on the completion screen.
+func (m speedTest) addNote() (tea.Model, tea.Cmd) {
+	in := textinput.New()
+	in.Prompt = "Note: "
+	in.Placeholder = "e.g. after router reboot"
+	in.CharLimit = noteLimit
+	in.Width = max(min(m.width-10, 60), 20)
+	in.Cursor.SetMode(cursor.CursorStatic)
+	in.SetValue(m.results.Note)
+	in.Focus()
+	m.noteInput = &in
+	return m, nil
+}
+
+// updateNote handles keys while the note input is open: enter saves the
+// note with the run and in history, esc leaves it unchanged.
+func (m speedTest) updateNote(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.noteInput = nil
+		return m, nil
+	case "enter":
+		note := strings.TrimSpace(m.noteInput.Value())
+		m.noteInput = nil
+		m.results.Note = note
+		if n := len(m.session); n > 0 && m.session[n-1].Timestamp.Equal(m.results.Timestamp) {
+			m.session[n-1].Note = note
+		}
+		if m.engine.history == nil {
+			return m, nil
+		}
+		store, at := *m.engine.history, m.results.Timestamp
+		return m, func() tea.Msg {
+			_, err := store.Annotate(func(r Results) bool { return r.Timestamp.Equal(at) }, note)
+			return noteMsg{note: note, err: err}
+		}
+	}
+	in, cmd := m.noteInput.Update(msg)
+	m.noteInput = &in
+	return m, cmd
+}
+
+func (msg noteMsg) notice() string {
+	switch {
+	case msg.err != nil:
+		return fmt.Sprintf("\033[31mCouldn't store the note in history: %v\033[0m", msg.err)
+	case msg.note == "":
+		return "Removed the note"
+	}
+	return "Saved the note with this run"
+}
+
+// historyListLimit is how many runs `gofast history` lists by default.
+const historyListLimit = 20
+
+// runHistory implements "gofast history": list recent runs with their IDs,
+// "gofast history annotate ID NOTE" to note a past run, or "gofast history
+// diff A B" to compare two.
+func runHistory(args []string) {
+	path, err := defaultHistoryPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	store := historyStore{path: path}
+
+	if len(args) > 0 && args[0] == "diff" {
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: gofast history diff ID|last ID|last")
+			os.Exit(2)
+		}
+		runs, err := store.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var pair []Results
+		for _, id := range args[1:] {
+			i := slices.IndexFunc(runs, func(r Results) bool { return runID(r) == id })
+			if id == "last" {
+				i = len(runs) - 1
+			}
+			if i < 0 {
+				fmt.Fprintf(os.Stderr, "Error: no run %s in %s (see gofast history)\n", id, path)
+				os.Exit(1)
+			}
+			pair = append(pair, runs[i])
+		}
+		var s strings.Builder
+		writeComparison(&s, compareRuns(pair[0], pair[1]), 80)
+		fmt.Print(term.render(s.String()))
+		return
+	}
+
+	if len(args) > 0 && args[0] == "seed" {
+		runSeed(store, args[1:])
+		return
+	}
+
+	if len(args) > 0 && args[0] == "annotate" {
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: gofast history annotate ID|last NOTE  (an empty NOTE removes it)")
+			os.Exit(2)
+		}
+		id, note := args[1], strings.TrimSpace(args[2])
+		if len([]rune(note)) > noteLimit {
+			fmt.Fprintf(os.Stderr, "Error: a note is at most %d characters\n", noteLimit)
+			os.Exit(2)
+		}
+		if id == "last" {
+			runs, err := store.Load()
+			if err != nil || len(runs) == 0 {
+				fmt.Fprintf(os.Stderr, "Error: no runs in %s\n", path)
+				os.Exit(1)
+			}
+			id = runID(runs[len(runs)-1])
+		}
+		n, err := store.Annotate(func(r Results) bool { return runID(r) == id }, note)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		case n == 0:
+			fmt.Fprintf(os.Stderr, "Error: no run %s in %s (see gofast history)\n", id, path)
+			os.Exit(1)
+		}
+		fmt.Printf("Noted %d run(s) with ID %s\n", n, id)
+		return
+	}
+
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("limit", historyListLimit, "number of recent runs to list")
+	site := fs.String("site", "", "only list runs collected from this site, or \"local\" for runs made here")
+	fs.Parse(args)
+	runs, err := store.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	runs = filterSite(runs, *site)
+	if len(runs) == 0 {
+		fmt.Printf("No runs in %s yet\n", path)
+		return
+	}
+	writeHistoryList(os.Stdout, runs[max(len(runs)-*limit, 0):])
+}
+
+// writeHistoryList prints runs one per line, with a Site column when any
+// of them was collected from another site.
+func writeHistoryList(w io.Writer, runs []Results) {
+	sites := slices.ContainsFunc(runs, func(r Results) bool { return r.Site != "" })
+	site := func(s string) string {
+		if !sites {
+			return ""
+		}
+		return fmt.Sprintf("%-16s ", truncate(s, 16))
+	}
+	fmt.Fprintf(w, "%-15s  %s%12s %12s %10s  %s\n", "ID", site("Site"), "Download", "Upload", "Ping", "Note")
+	for _, r := range runs {
+		upload := "n/a"
+		if r.Upload != nil {
+			upload = formatSpeed(r.Upload.Mbps)
+		}
+		label := r.Site
+		if label == "" {
+			label = "local"
+		}
+		note := r.Note
+		if c := r.ServerChange; c != nil {
+			note = strings.TrimSpace("[" + c.String() + "] " + note)
+		}
+		if r.Synthetic {
+			note = strings.TrimSpace("[synthetic] " + note)
+		}
+		fmt.Fprintf(w, "%-15s  %s%12s %12s %10s  %s\n",
+			runID(r), site(label), formatSpeed(transferMbps(r.Download)), upload, formatPing(r.Latency), note)
+	}
+}
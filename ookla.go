@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ooklaListURL serves speedtest.net's public server list, nearest to the
+// requesting address first. Every server runs the legacy HTTP test: under
+// the directory of its upload.php, random4000x4000.jpg is a 31 MB
+// download, latency.txt a tiny file to time, and upload.php accepts a POST
+// body.
+const ooklaListURL = "https://www.speedtest.net/speedtest-servers-static.php"
+
+const (
+	// ooklaListTTL is how long the cached server list is used before it
+	// is fetched again.
+	ooklaListTTL = 6 * time.Hour
+	// ooklaCandidates is how many of the nearest servers are ranked by
+	// latency and health-checked.
+	ooklaCandidates = 5
+	// ooklaDownload is the file a download stream fetches over and over.
+	ooklaDownload = "random4000x4000.jpg"
+	// ooklaUploadChunk is the size of one upload body.
+	ooklaUploadChunk = 4 << 20
+	// ooklaDuration is a transfer's length when the profile leaves it to
+	// the provider.
+	ooklaDuration = 10 * time.Second
+	// ooklaStreams is the number of parallel transfers when the profile
+	// doesn't say.
+	ooklaStreams = 4
+)
+
+// ooklaServer is one <server> of the list.
+type ooklaServer struct {
+	URL     string  `xml:"url,attr"`
+	Lat     float64 `xml:"lat,attr"`
+	Lon     float64 `xml:"lon,attr"`
+	Name    string  `xml:"name,attr"`
+	Country string  `xml:"country,attr"`
+	Sponsor string  `xml:"sponsor,attr"`
+	ID      string  `xml:"id,attr"`
+}
+
+type ooklaList struct {
+	Servers []ooklaServer `xml:"servers>server"`
+}
+
+// ooklaProvider measures against the speedtest.net server nearest to this
+// machine, chosen from the public list by distance and then latency.
+type ooklaProvider struct {
+	clock       Clock
+	listURL     string
+	cachePath   string
+	duration    time.Duration
+	pingSamples int
+	streams     int
+	// compressible sends zeros instead of random upload data.
+	compressible bool
+	// noGeolocation picks from the list in its own order instead of by
+	// distance.
+	noGeolocation bool
+	ping          *serverPing
+	// chosen is the server in use, shared by every copy of the provider.
+	chosen *ooklaChoice
+}
+
+type ooklaChoice struct {
+	mu     sync.Mutex
+	server Server
+}
+
+func newOoklaProvider(opts providerOptions) Provider {
+	cache, _ := defaultOoklaCachePath()
+	return ooklaProvider{
+		clock:         opts.Clock,
+		listURL:       ooklaListURL,
+		cachePath:     cache,
+		duration:      opts.Duration,
+		pingSamples:   opts.PingSamples,
+		streams:       opts.Streams,
+		compressible:  opts.Compressible,
+		noGeolocation: opts.NoGeolocation,
+		ping:          newServerPing(opts.Ping),
+		chosen:        &ooklaChoice{},
+	}
+}
+
+func defaultOoklaCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofast", "ookla-servers.xml"), nil
+}
+
+func (ooklaProvider) Name() string { return "ookla" }
+
+func (ooklaProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: true, LoadedLatency: true}
+}
+
+// list returns the server list, from the cache while it is younger than
+// ooklaListTTL. A stale cache still serves when the list can't be fetched.
+func (p ooklaProvider) list(ctx context.Context) ([]ooklaServer, error) {
+	cached, cacheErr := os.ReadFile(p.cachePath)
+	if info, err := os.Stat(p.cachePath); cacheErr == nil && err == nil && p.clock.Now().Sub(info.ModTime()) < ooklaListTTL {
+		if servers, err := parseOoklaList(cached); err == nil {
+			return servers, nil
+		}
+	}
+	body, err := p.fetchList(ctx)
+	if err != nil {
+		if servers, perr := parseOoklaList(cached); cacheErr == nil && perr == nil {
+			return servers, nil
+		}
+		return nil, fmt.Errorf("fetching the speedtest.net server list: %w", err)
+	}
+	servers, err := parseOoklaList(body)
+	if err != nil {
+		return nil, err
+	}
+	if p.cachePath != "" {
+		writeOutput(p.cachePath, true, func(w io.Writer) error {
+			_, err := w.Write(body)
+			return err
+		})
+	}
+	return servers, nil
+}
+
+func (p ooklaProvider) fetchList(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: httpTransport()}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+}
+
+func parseOoklaList(data []byte) ([]ooklaServer, error) {
+	var list ooklaList
+	if err := xml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("reading the speedtest.net server list: %w", err)
+	}
+	if len(list.Servers) == 0 {
+		return nil, errors.New("the speedtest.net server list is empty")
+	}
+	return list.Servers, nil
+}
+
+// Candidates returns the servers nearest to where this machine's address
+// geolocates, or without geolocation the first of the list, which orders
+// them by the address itself.
+func (p ooklaProvider) Candidates(ctx context.Context) ([]Server, error) {
+	servers, err := p.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var here *geolocation
+	if !p.noGeolocation {
+		if g, err := geolocate(ctx); err == nil || g.Lat != 0 || g.Lon != 0 {
+			here = &g
+		}
+	}
+	candidates := make([]Server, 0, len(servers))
+	for _, s := range servers {
+		c, ok := s.server()
+		if !ok {
+			continue
+		}
+		if here != nil {
+			c.Distance = math.Round(greatCircleKm(here.Lat, here.Lon, s.Lat, s.Lon))
+		}
+		candidates = append(candidates, c)
+	}
+	if here != nil {
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Distance < candidates[j].Distance })
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("the speedtest.net server list has no usable servers")
+	}
+	return candidates[:min(len(candidates), ooklaCandidates)], nil
+}
+
+// server describes s as a Server whose URL is the directory its test
+// files are in.
+func (s ooklaServer) server() (Server, bool) {
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Host == "" {
+		return Server{}, false
+	}
+	u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1]
+	u.RawQuery = ""
+	return Server{
+		Name:           fmt.Sprintf("%s (%s)", s.Sponsor, s.ID),
+		Sponsor:        s.Sponsor,
+		Host:           u.Hostname(),
+		URL:            u.String(),
+		Location:       s.Name,
+		Country:        s.Country,
+		LocationSource: provenanceMeasured,
+	}, true
+}
+
+// greatCircleKm is the distance between two coordinates along the earth's
+// surface.
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(a, 1)))
+}
+
+func (p ooklaProvider) Handshake(ctx context.Context, s Server) error {
+	client := &http.Client{Transport: httpTransport()}
+	defer client.CloseIdleConnections()
+	return httpHandshake(ctx, client, p.clock, s.URL+"latency.txt")
+}
+
+// ProbeServer times a connect to the server, for ranking candidates.
+func (p ooklaProvider) ProbeServer(ctx context.Context, s Server) (float64, error) {
+	return connectRTT(ctx, p.clock, s.URL)
+}
+
+func (p ooklaProvider) Use(s Server) {
+	p.chosen.mu.Lock()
+	defer p.chosen.mu.Unlock()
+	p.chosen.server = s
+}
+
+func (p ooklaProvider) current() Server {
+	p.chosen.mu.Lock()
+	defer p.chosen.mu.Unlock()
+	return p.chosen.server
+}
+
+// Server returns the server in use, choosing the nearest healthy one
+// first if none is.
+func (p ooklaProvider) Server(ctx context.Context) (Server, error) {
+	if s := p.current(); s.URL != "" {
+		return s, nil
+	}
+	candidates, err := p.Candidates(ctx)
+	if err != nil {
+		return Server{}, err
+	}
+	var errs []error
+	for _, s := range candidates {
+		if err := p.Handshake(ctx, s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		p.Use(s)
+		return s, nil
+	}
+	return Server{}, &serverSelectionError{Errs: errs}
+}
+
+func (p ooklaProvider) check(resp *http.Response) error {
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// pinger times round trips to s's latency.txt.
+func (p ooklaProvider) pinger(s Server) *httpPinger {
+	return newHTTPPinger(p.clock, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"latency.txt?x="+strconv.FormatInt(p.clock.Now().UnixNano(), 36), nil)
+	}, p.check)
+}
+
+// Ping sends ICMP echoes to the server when ICMP is the ping method and the
+// server answers them, and otherwise times latency.txt round trips over one
+// kept-alive connection.
+func (p ooklaProvider) Ping(ctx context.Context) (Latency, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Latency{}, err
+	}
+	if l, ok, err := p.ping.latency(ctx, s.URL, p.pingSamples); ok || err != nil {
+		return l, err
+	}
+	pinger := p.pinger(s)
+	defer pinger.client.CloseIdleConnections()
+	return pinger.latency(ctx, p.pingSamples)
+}
+
+// Probe times one round trip on a fresh connection.
+func (p ooklaProvider) Probe(ctx context.Context) (float64, error) {
+	if rtt, ok, err := p.ping.probe(ctx, p.current().URL); ok {
+		return rtt, err
+	}
+	pinger := p.pinger(p.current())
+	defer pinger.client.CloseIdleConnections()
+	pinger.warm = true
+	return pinger.ping(ctx)
+}
+
+func (p ooklaProvider) TraceRequest(ctx context.Context) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, p.current().URL+"latency.txt?x="+strconv.FormatInt(p.clock.Now().UnixNano(), 36), nil)
+}
+
+// Download fetches random4000x4000.jpg over parallel streams, adding every
+// byte to m as it arrives.
+func (p ooklaProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Transfer{}, err
+	}
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+ooklaDownload+"?x="+strconv.FormatInt(p.clock.Now().UnixNano(), 36), nil)
+		if err != nil {
+			return err
+		}
+		record := transferRecord{URL: req.URL.String(), Offset: -1, Began: p.clock.Now()}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := p.check(resp); err != nil {
+			return err
+		}
+		record.Expected = resp.ContentLength
+		return readCounted(ctx, p.clock, resp, m, record)
+	})
+}
+
+// Upload POSTs generated payload to upload.php over parallel streams,
+// counting bytes into m as the transport reads them for sending.
+func (p ooklaProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	s, err := p.Server(ctx)
+	if err != nil {
+		return Transfer{}, err
+	}
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		body := &meteredReader{r: uploadBody(ooklaUploadChunk, p.compressible), m: m}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"upload.php", body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = ooklaUploadChunk
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return p.check(resp)
+	})
+}
+
+func (p ooklaProvider) streamed(ctx context.Context, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	duration := p.duration
+	if duration <= 0 {
+		duration = ooklaDuration
+	}
+	streams := p.streams
+	if streams <= 0 {
+		streams = ooklaStreams
+	}
+	return runStreams(ctx, p.clock, duration, streams, m, client, request)
+}
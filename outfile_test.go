@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteOutputKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(kept, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "results.json")
+	for _, path := range []string{kept, fresh} {
+		if err := writeOutput(path, false, func(w io.Writer) error {
+			_, err := io.WriteString(w, "new")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, want := range map[string]os.FileMode{kept: 0o600, fresh: 0o644} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s mode = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
+
+func TestConfigUpdateKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("units = \"bits\"\n\n[[sink]]\ntype = \"webhook\"\ntoken = \"secret\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.update(path, map[string]string{"units": `"bytes"`}, false); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o600 {
+		t.Errorf("config mode after update = %v, want 0600", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// outputWriter returns the writer for a headless output format, or nil for the
+// TUI.
+func outputWriter(format string) (func(io.Writer, Results) error, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "json":
+		return writeJSON, nil
+	case "prometheus":
+		return writePrometheus, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q (available: json, prometheus)", format)
+	}
+}
+
+func writeJSON(w io.Writer, results Results) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// writePrometheus renders results in the Prometheus text exposition format,
+// suitable for the node_exporter textfile collector. Metrics that weren't
+// measured are omitted.
+func writePrometheus(w io.Writer, r Results) error {
+	var b strings.Builder
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+
+	if r.Download != nil {
+		gauge("gofast_download_mbps", "Download throughput in Mbps.", r.Download.Mbps)
+		if r.Download.AverageMbps > 0 {
+			gauge("gofast_download_average_mbps", "Download throughput over the whole transfer, warm-up included, in Mbps.", r.Download.AverageMbps)
+		}
+		if r.Download.BurstMbps > 0 {
+			gauge("gofast_download_burst_mbps", "Download throughput over the first seconds, when it ran above the sustained rate, in Mbps.", r.Download.BurstMbps)
+		}
+	}
+	if r.Upload != nil {
+		gauge("gofast_upload_mbps", "Upload throughput in Mbps.", r.Upload.Mbps)
+		if r.Upload.AverageMbps > 0 {
+			gauge("gofast_upload_average_mbps", "Upload throughput over the whole transfer, warm-up included, in Mbps.", r.Upload.AverageMbps)
+		}
+		if r.Upload.BurstMbps > 0 {
+			gauge("gofast_upload_burst_mbps", "Upload throughput over the first seconds, when it ran above the sustained rate, in Mbps.", r.Upload.BurstMbps)
+		}
+	}
+	if r.Latency.Source.Available() {
+		gauge("gofast_ping_ms", "Idle round trip time in milliseconds.", r.Latency.Avg)
+		gauge("gofast_ping_p50_ms", "Median idle round trip time in milliseconds.", r.Latency.P50)
+		gauge("gofast_ping_p95_ms", "95th percentile idle round trip time in milliseconds.", r.Latency.P95)
+		gauge("gofast_ping_p99_ms", "99th percentile idle round trip time in milliseconds.", r.Latency.P99)
+		gauge("gofast_jitter_ms", "Mean difference between consecutive idle round trips in milliseconds.", r.Latency.Jitter)
+	}
+	if l := r.LoadedLatency; l != nil {
+		gauge("gofast_loaded_latency_ms", "Round trip time under load in milliseconds.", l.Avg)
+		gauge("gofast_loaded_latency_p50_ms", "Median round trip time under load in milliseconds.", l.P50)
+		gauge("gofast_loaded_latency_p95_ms", "95th percentile round trip time under load in milliseconds.", l.P95)
+		gauge("gofast_loaded_latency_p99_ms", "99th percentile round trip time under load in milliseconds.", l.P99)
+	}
+	if b := r.Bufferbloat; b != nil {
+		gauge("gofast_bufferbloat_ms", "Increase in round trip time under load over the idle ping in milliseconds.", b.IncreaseMs)
+	}
+	if r.Loss != nil {
+		gauge("gofast_loss_percent", "Packet loss in percent.", *r.Loss)
+	}
+	if c := r.Connection; c != nil && c.Error == "" {
+		gauge("gofast_server_dns_ms", "DNS lookup time for the test server in milliseconds.", c.DNSMs)
+		gauge("gofast_server_connect_ms", "TCP connect time to the test server in milliseconds.", c.ConnectMs)
+		gauge("gofast_server_tls_ms", "TLS handshake time with the test server in milliseconds.", c.TLSMs)
+		gauge("gofast_server_first_byte_ms", "Time to first byte from the test server in milliseconds.", c.FirstByteMs)
+	}
+	if t := r.TLS; t != nil && t.Error == "" {
+		gauge("gofast_server_tls_handshake_ms", "Average full TLS handshake time with the test server over fresh connections in milliseconds.", t.AvgMs)
+	}
+	if w := r.Web; w != nil && w.ResponsivenessMs > 0 {
+		gauge("gofast_web_responsiveness_ms", "Median time to first byte of a new connection to popular sites in milliseconds.", w.ResponsivenessMs)
+	}
+	gauge("gofast_warnings", "Non-fatal problems noticed during the run.", float64(len(r.Warnings)))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
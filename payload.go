@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+)
+
+// payloadReader is an endless stream of upload data. By default the bytes
+// come from a xorshift64* generator so compressing or deduplicating
+// middleboxes can't shrink them; Read fills the caller's buffer in place, so
+// one reader per stream is all the allocation an upload needs.
+type payloadReader struct {
+	state        uint64
+	compressible bool
+}
+
+func newPayloadReader(compressible bool) *payloadReader {
+	return &payloadReader{state: rand.Uint64() | 1, compressible: compressible}
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	if r.compressible {
+		clear(p)
+		return len(p), nil
+	}
+
+	n := len(p) &^ 7
+	for i := 0; i < n; i += 8 {
+		binary.LittleEndian.PutUint64(p[i:], r.next())
+	}
+	if n < len(p) {
+		var tail [8]byte
+		binary.LittleEndian.PutUint64(tail[:], r.next())
+		copy(p[n:], tail[:])
+	}
+	return len(p), nil
+}
+
+func (r *payloadReader) next() uint64 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	return r.state * 0x2545f4914f6cdd1d
+}
+
+// uploadBody returns n bytes of upload payload.
+func uploadBody(n int64, compressible bool) io.Reader {
+	return io.LimitReader(newPayloadReader(compressible), n)
+}
+
+// compressionRatio is how much faster a compressible upload must be than the
+// usual incompressible one before a compressing proxy is suspected.
+const compressionRatio = 1.5
+
+// compressionSuspected compares a compressible upload against the median of
+// recent comparable incompressible uploads.
+func compressionSuspected(current Results, history []Results) bool {
+	if current.Upload == nil || !current.Upload.Compressible {
+		return false
+	}
+	var baseline []float64
+	for i := len(history) - 1; i >= 0 && len(baseline) < defaultRegressionPolicy.Window; i-- {
+		past := history[i]
+		if comparable(current, past) && past.Upload != nil && !past.Upload.Compressible && !outOfBaseline(past) {
+			baseline = append(baseline, past.Upload.Mbps)
+		}
+	}
+	if len(baseline) == 0 {
+		return false
+	}
+	return current.Upload.Mbps > compressionRatio*median(baseline)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestPayloadIncompressible(t *testing.T) {
+	for _, tt := range []struct {
+		compressible bool
+		// ratio bounds the compressed size as a share of the payload.
+		ratio float64
+	}{
+		{false, 1},
+		{true, 0.01},
+	} {
+		payload, err := io.ReadAll(uploadBody(1<<20, tt.compressible))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		w, _ := flate.NewWriter(&out, flate.BestCompression)
+		w.Write(payload)
+		w.Close()
+		ratio := float64(out.Len()) / float64(len(payload))
+		if tt.compressible && ratio > tt.ratio || !tt.compressible && ratio < tt.ratio {
+			t.Errorf("compressible %v: deflate shrank 1 MiB to %.3f of its size", tt.compressible, ratio)
+		}
+	}
+}
+
+func TestPayloadFillsOddReads(t *testing.T) {
+	r := newPayloadReader(false)
+	seen := make(map[string]bool)
+	for _, size := range []int{1, 7, 8, 13, 4096 + 3} {
+		p := make([]byte, size)
+		if n, err := r.Read(p); n != size || err != nil {
+			t.Fatalf("Read of %d bytes = %d, %v", size, n, err)
+		}
+		if size >= 8 && bytes.Count(p, []byte{0}) == size {
+			t.Errorf("read of %d bytes left zeros", size)
+		}
+		if seen[string(p)] {
+			t.Errorf("read of %d bytes repeated an earlier one", size)
+		}
+		seen[string(p)] = true
+	}
+}
+
+// BenchmarkPayloadReader reports the generator's throughput, which must
+// stay well above the fastest link an upload can saturate: over 10 Gbps is
+// 1.25 GB/s.
+func BenchmarkPayloadReader(b *testing.B) {
+	for _, compressible := range []bool{false, true} {
+		for _, size := range []int{32 << 10, 1 << 20} {
+			b.Run(fmt.Sprintf("compressible=%v/%dKiB", compressible, size>>10), func(b *testing.B) {
+				r := newPayloadReader(compressible)
+				buf := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					r.Read(buf)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// percentScale is the full scale of the gauges in percent-of-baseline mode,
+// leaving room above 100%, "performing as expected".
+const percentScale = 150.0
+
+// Baseline sources for percent-of-baseline mode, the percent_of config key.
+// Auto uses the plan speeds when they are set and the median of recent
+// runs otherwise.
+const (
+	percentAuto   = "auto"
+	percentPlan   = "plan"
+	percentMedian = "median"
+)
+
+// Expectation is the baseline percent-of-baseline mode measures a run
+// against. Machine-readable results stay absolute and carry it for
+// context.
+type Expectation struct {
+	// Source is "plan" or "median".
+	Source       string  `json:"source"`
+	DownloadMbps float64 `json:"download_mbps"`
+	// UploadMbps is zero when there is no upload baseline.
+	UploadMbps float64 `json:"upload_mbps,omitempty"`
+}
+
+// percentPolicy resolves the baseline from the config's plan speeds or the
+// history.
+type percentPolicy struct {
+	Source                   string
+	PlanDownload, PlanUpload float64
+	// Window and MinRuns bound the runs a median is taken over, as for
+	// regression detection.
+	Window, MinRuns int
+}
+
+// expected returns the baseline for runs with provider, or nil when the
+// source has none. A median covers recent local runs of the provider on
+// any server, since the line is what is measured against.
+func (p percentPolicy) expected(provider string, past []Results) *Expectation {
+	if p.Source != percentMedian && p.PlanDownload > 0 {
+		return &Expectation{Source: percentPlan, DownloadMbps: p.PlanDownload, UploadMbps: p.PlanUpload}
+	}
+	if p.Source == percentPlan {
+		return nil
+	}
+	var downloads, uploads []float64
+	for i := len(past) - 1; i >= 0 && len(downloads) < p.Window; i-- {
+		r := past[i]
+		if r.Site != "" || r.Provider != provider || r.Download == nil || outOfBaseline(r) {
+			continue
+		}
+		downloads = append(downloads, r.Download.Mbps)
+		if r.Upload != nil {
+			uploads = append(uploads, r.Upload.Mbps)
+		}
+	}
+	if len(downloads) < max(p.MinRuns, 1) {
+		return nil
+	}
+	e := &Expectation{Source: percentMedian, DownloadMbps: median(downloads)}
+	if len(uploads) > 0 {
+		e.UploadMbps = median(uploads)
+	}
+	return e
+}
+
+// percentOf is mbps as a percentage of baseline, zero without one.
+func percentOf(mbps, baseline float64) float64 {
+	if baseline <= 0 {
+		return 0
+	}
+	return mbps / baseline * 100
+}
+
+// expectationMsg delivers the baseline resolved when percent mode is first
+// switched on.
+type expectationMsg struct{ expected *Expectation }
+
+// togglePercent switches the gauges between absolute speeds and percent of
+// the baseline. The baseline is resolved the first time, from the history
+// if need be.
+func (m speedTest) togglePercent() (tea.Model, tea.Cmd) {
+	if m.percent {
+		m.percent = false
+		return m, nil
+	}
+	if m.expected != nil {
+		m.percent = true
+		return m, nil
+	}
+	policy, provider, history := m.engine.percent, m.engine.provider.Name(), m.engine.history
+	return m, func() tea.Msg {
+		var past []Results
+		if history != nil {
+			past, _ = history.Load()
+		}
+		return expectationMsg{policy.expected(provider, past)}
+	}
+}
+
+func (m speedTest) showPercent(msg expectationMsg) speedTest {
+	if msg.expected == nil {
+		m.notice = "\033[33mNo baseline for percent mode: set plan speeds with 'e', or run a few more tests to build up history\033[0m"
+		return m
+	}
+	m.expected, m.percent = msg.expected, true
+	return m
+}
+
+// percentReadout shows a speed as a percentage of its baseline and in
+// absolute terms, e.g. "87% · 52.3 Mbps".
+func percentReadout(mbps, baseline float64) string {
+	if baseline <= 0 {
+		return "n/a · " + formatSpeed(mbps)
+	}
+	return fmt.Sprintf("%.0f%% · %s", percentOf(mbps, baseline), formatSpeed(mbps))
+}
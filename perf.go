@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// perfRecorder collects what --perf-report prints: how long each engine
+// phase took, and how the TUI spent its time handling messages and
+// rendering frames. Every method does nothing on a nil recorder, so
+// without the flag a hook costs one nil check.
+type perfRecorder struct {
+	mu    sync.Mutex
+	start time.Time
+	usage usageMark
+	// frame is the interval ticks are asked for at.
+	frame time.Duration
+
+	phases   []perfPhase
+	messages map[string]int
+	update   perfTiming
+	view     perfTiming
+	// allocs counts heap allocations made while rendering, by any
+	// goroutine, so it is an upper bound on the renderer's own.
+	allocs  uint64
+	sample  []metrics.Sample
+	ticks   int
+	dropped int
+}
+
+// perfTiming accumulates the durations of one kind of work.
+type perfTiming struct {
+	n          int
+	total, max time.Duration
+}
+
+func (t *perfTiming) add(d time.Duration) {
+	t.n++
+	t.total += d
+	t.max = max(t.max, d)
+}
+
+func (t perfTiming) mean() time.Duration {
+	if t.n == 0 {
+		return 0
+	}
+	return t.total / time.Duration(t.n)
+}
+
+type perfPhase struct {
+	name string
+	perfTiming
+}
+
+func newPerfRecorder(frame time.Duration) *perfRecorder {
+	return &perfRecorder{
+		start:    time.Now(),
+		usage:    markUsage(),
+		frame:    frame,
+		messages: map[string]int{},
+		sample:   []metrics.Sample{{Name: "/gc/heap/allocs:objects"}},
+	}
+}
+
+func perfDone() {}
+
+// phase starts timing an engine phase; call what it returns when the
+// phase ends. A phase run more than once, as by a retest, adds up.
+func (r *perfRecorder) phase(name string) func() {
+	if r == nil {
+		return perfDone
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		i := slices.IndexFunc(r.phases, func(p perfPhase) bool { return p.name == name })
+		if i < 0 {
+			r.phases = append(r.phases, perfPhase{name: name})
+			i = len(r.phases) - 1
+		}
+		r.phases[i].add(d)
+	}
+}
+
+func (r *perfRecorder) heapAllocs() uint64 {
+	metrics.Read(r.sample)
+	if r.sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return r.sample[0].Value.Uint64()
+}
+
+// perfModel wraps the TUI's model to time every Update and View. It is
+// only put in place with --perf-report.
+type perfModel struct {
+	tea.Model
+	r *perfRecorder
+}
+
+func (m perfModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	start := time.Now()
+	next, cmd := m.Model.Update(msg)
+	d := time.Since(start)
+
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	m.r.update.add(d)
+	kind := msg
+	if ev, ok := msg.(engineEvent); ok {
+		// Count what the engine sent rather than its envelope.
+		kind = ev.msg
+	}
+	m.r.messages[fmt.Sprintf("%T", kind)]++
+	if tick, ok := msg.(tickMsg); ok && m.r.frame > 0 {
+		// A tick handled a whole interval after it fired means the
+		// frames it should have drawn in between were never drawn.
+		m.r.ticks++
+		m.r.dropped += int(start.Sub(time.Time(tick)) / m.r.frame)
+	}
+	return perfModel{next, m.r}, cmd
+}
+
+func (m perfModel) View() string {
+	m.r.mu.Lock()
+	defer m.r.mu.Unlock()
+	allocs := m.r.heapAllocs()
+	start := time.Now()
+	s := m.Model.View()
+	m.r.view.add(time.Since(start))
+	m.r.allocs += m.r.heapAllocs() - allocs
+	return s
+}
+
+func formatPerfDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+	}
+	return fmt.Sprintf("%dµs", d.Microseconds())
+}
+
+// report prints the report to f, degraded for what f is.
+func (r *perfRecorder) report(f *os.File) {
+	if r == nil {
+		return
+	}
+	var s strings.Builder
+	r.write(&s)
+	fmt.Fprint(f, detectTerminal(f).render(s.String()))
+}
+
+// write prints the report: the process as a whole, then the engine phases
+// and, for a TUI run, its message handling and rendering.
+func (r *perfRecorder) write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wall := time.Since(r.start)
+	fmt.Fprintf(w, "\n\033[1mPerformance report\033[0m\n")
+	fmt.Fprintf(w, "  %-18s %s\n", "Wall time", formatPerfDuration(wall))
+	if u := r.usage.since(); u != nil {
+		fmt.Fprintf(w, "  %-18s %.2fs (%.0f%% of one core)\n", "CPU time", u.CPUSeconds, u.CPUPercent)
+		rss := formatBytes(u.PeakRSSBytes)
+		if u.RSSApproximate {
+			rss += " (Go runtime's footprint)"
+		}
+		fmt.Fprintf(w, "  %-18s %s\n", "Peak memory", rss)
+	}
+
+	if len(r.phases) > 0 {
+		fmt.Fprintf(w, "\n  Engine phases\n")
+		for _, p := range r.phases {
+			line := fmt.Sprintf("    %-16s %8s", p.name, formatPerfDuration(p.total))
+			if p.n > 1 {
+				line += fmt.Sprintf("  over %d runs", p.n)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if r.update.n == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n  Interface\n")
+	fmt.Fprintf(w, "    %-16s %d, %s in all, %s mean, %s longest\n", "Messages", r.update.n,
+		formatPerfDuration(r.update.total), formatPerfDuration(r.update.mean()), formatPerfDuration(r.update.max))
+	fmt.Fprintf(w, "    %-16s %d, %s in all, %s mean, %s longest\n", "Frames rendered", r.view.n,
+		formatPerfDuration(r.view.total), formatPerfDuration(r.view.mean()), formatPerfDuration(r.view.max))
+	if r.view.n > 0 {
+		fmt.Fprintf(w, "    %-16s %d\n", "Allocs per frame", r.allocs/uint64(r.view.n))
+	}
+	if r.ticks > 0 {
+		fmt.Fprintf(w, "    %-16s %d of %d animation ticks' worth, at %s a frame\n", "Dropped frames", r.dropped, r.ticks+r.dropped, formatPerfDuration(r.frame))
+	}
+	if share := r.view.total.Seconds() / wall.Seconds() * 100; wall > 0 {
+		fmt.Fprintf(w, "    %-16s %.1f%% of wall time\n", "Rendering", share)
+	}
+
+	types := make([]string, 0, len(r.messages))
+	for t := range r.messages {
+		types = append(types, t)
+	}
+	slices.SortFunc(types, func(a, b string) int {
+		if r.messages[a] != r.messages[b] {
+			return r.messages[b] - r.messages[a]
+		}
+		return strings.Compare(a, b)
+	})
+	fmt.Fprintf(w, "\n  Messages by type\n")
+	for _, t := range types {
+		fmt.Fprintf(w, "    %-28s %d\n", strings.TrimPrefix(t, "main."), r.messages[t])
+	}
+}
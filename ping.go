@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// pingHost is the target of idle latency measurements.
+const pingHost = "www.google.com"
+
+// pingTimeout bounds a single round trip.
+const pingTimeout = 2 * time.Second
+
+// pingMethod measures one round trip to a host with a particular protocol.
+type pingMethod interface {
+	Name() string
+	// Available reports why the method can't run here, or nil if it can.
+	Available() error
+	Ping(ctx context.Context, host string) (float64, error)
+}
+
+// pingPreference orders the methods from closest to what `ping` reports to
+// furthest. "auto" picks the first one that is available.
+var pingPreference = []string{"icmp", "tcp", "udp", "https"}
+
+func newPingMethod(name string, clock Clock) (pingMethod, error) {
+	switch name {
+	case "icmp":
+		return icmpPing{clock}, nil
+	case "tcp":
+		return tcpPing{clock}, nil
+	case "udp":
+		return udpPing{clock}, nil
+	case "https":
+		return httpsPing{clock}, nil
+	}
+	return nil, fmt.Errorf("unknown ping method %q (available: auto, %s)", name, strings.Join(pingPreference, ", "))
+}
+
+// choosePingMethod resolves --ping-method. An explicitly requested method
+// that can't run here is an error rather than a silent substitution.
+func choosePingMethod(name string, clock Clock) (pingMethod, error) {
+	if name != "auto" {
+		m, err := newPingMethod(name, clock)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Available(); err != nil {
+			return nil, fmt.Errorf("ping method %s: %w", name, err)
+		}
+		return m, nil
+	}
+	for _, name := range pingPreference {
+		m, _ := newPingMethod(name, clock)
+		if m.Available() == nil {
+			return m, nil
+		}
+	}
+	return httpsPing{clock}, nil
+}
+
+// comparePingMethods runs every method once against host, e.g.
+// "ICMP 11 ms · TCP 13 ms · UDP 12 ms · HTTPS 38 ms".
+func comparePingMethods(ctx context.Context, clock Clock, host string) string {
+	parts := make([]string, len(pingPreference))
+	for i, name := range pingPreference {
+		m, _ := newPingMethod(name, clock)
+		label := strings.ToUpper(name)
+		if err := m.Available(); err != nil {
+			parts[i] = label + " n/a (" + err.Error() + ")"
+			continue
+		}
+		rtt, err := m.Ping(ctx, host)
+		if err != nil {
+			parts[i] = label + " failed (" + err.Error() + ")"
+			continue
+		}
+		parts[i] = fmt.Sprintf("%s %.0f ms", label, rtt)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// pingMethodLabels describe the methods for the live readouts, so an ICMP
+// figure isn't mistaken for one that includes a TLS handshake.
+var pingMethodLabels = map[string]string{
+	"icmp":  "ICMP echo",
+	"tcp":   "TCP connect",
+	"udp":   "DNS over UDP",
+	"https": "HTTPS request",
+	"http":  "HTTP request",
+}
+
+// pingMethodNote is a dimmed " (TCP connect)" after a readout measured by
+// method, or "" when the method isn't known.
+func pingMethodNote(method string) string {
+	label, ok := pingMethodLabels[method]
+	if !ok {
+		return ""
+	}
+	return " \033[90m(" + label + ")\033[0m"
+}
+
+// pingSampleMsg is one idle ping as it comes in, the nth of of; lost is
+// set when it got no answer.
+type pingSampleMsg struct {
+	n, of int
+	rtt   float64
+	lost  bool
+}
+
+type pingProgressKey struct{}
+
+// withPingProgress has the idle pings measured under ctx reported to fn as
+// they come in.
+func withPingProgress(ctx context.Context, fn func(pingSampleMsg)) context.Context {
+	return context.WithValue(ctx, pingProgressKey{}, fn)
+}
+
+func reportPingSample(ctx context.Context, s pingSampleMsg) {
+	if fn, ok := ctx.Value(pingProgressKey{}).(func(pingSampleMsg)); ok {
+		fn(s)
+	}
+}
+
+// pingLatency summarizes samples round trips to host with m, after one
+// that isn't counted as it pays for the DNS lookup and whatever else the
+// method sets up the first time. It is unavailable when none came back.
+func pingLatency(ctx context.Context, m pingMethod, host string, samples int) (Latency, error) {
+	m.Ping(ctx, host)
+	if ctx.Err() != nil {
+		return Latency{}, ctx.Err()
+	}
+	return samplePings(ctx, m, host, samples)
+}
+
+// samplePings is pingLatency without the first round trip set aside.
+func samplePings(ctx context.Context, m pingMethod, host string, samples int) (Latency, error) {
+	var rtts []float64
+	n := max(samples, 1)
+	for i := range n {
+		rtt, err := m.Ping(ctx, host)
+		if ctx.Err() != nil {
+			return Latency{}, ctx.Err()
+		}
+		if err == nil {
+			rtts = append(rtts, rtt)
+		}
+		reportPingSample(ctx, pingSampleMsg{n: i + 1, of: n, rtt: rtt, lost: err != nil})
+	}
+	if len(rtts) == 0 {
+		return Latency{Source: provenanceUnavailable, Method: m.Name()}, nil
+	}
+	l := summarizeLatency(rtts)
+	l.Source = provenanceMeasured
+	l.Method = m.Name()
+	return l, nil
+}
+
+// serverPing pings the server of a provider that otherwise times HTTP
+// round trips, which add TLS and the server's request handling to the
+// network's latency: with ICMP echoes, or by timing TCP connects to the
+// port the server is tested on. It is only in use when one of those was
+// the chosen ping method, and stops being once the server doesn't answer
+// them, as firewalls often do; it is shared by every copy of the provider.
+type serverPing struct {
+	ping    pingMethod
+	blocked atomic.Bool
+}
+
+func newServerPing(m pingMethod) *serverPing {
+	s := &serverPing{}
+	if m != nil && (m.Name() == "icmp" || m.Name() == "tcp") {
+		s.ping = m
+	}
+	return s
+}
+
+// target is what the method pings for the server at rawURL: its host for
+// ICMP, and host:port for TCP, or "" when s isn't in use.
+func (s *serverPing) target(rawURL string) string {
+	if s == nil || s.ping == nil || s.blocked.Load() {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	if s.ping.Name() == "icmp" {
+		return u.Hostname()
+	}
+	return urlHostPort(u)
+}
+
+// urlHostPort is the host:port u is served on, the port defaulting by
+// scheme.
+func urlHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// latency pings the server at rawURL; it reports false when s isn't in
+// use or the server didn't answer, for the provider to fall back to HTTP.
+// A first ping, not counted, decides, so a server that drops them costs
+// one timeout, not one per sample.
+func (s *serverPing) latency(ctx context.Context, rawURL string, samples int) (Latency, bool, error) {
+	target := s.target(rawURL)
+	if target == "" {
+		return Latency{}, false, nil
+	}
+	if _, err := s.ping.Ping(ctx, target); err != nil {
+		if ctx.Err() != nil {
+			return Latency{}, false, ctx.Err()
+		}
+		s.blocked.Store(true)
+		return Latency{}, false, nil
+	}
+	l, err := samplePings(ctx, s.ping, target, samples)
+	if err != nil {
+		return Latency{}, false, err
+	}
+	if !l.Source.Available() {
+		s.blocked.Store(true)
+		return Latency{}, false, nil
+	}
+	return l, true, nil
+}
+
+// probe times one round trip to the server at rawURL under load, or
+// reports false when the provider should probe over HTTP instead. A lost
+// ping is a failed probe, like any other method's.
+func (s *serverPing) probe(ctx context.Context, rawURL string) (float64, bool, error) {
+	target := s.target(rawURL)
+	if target == "" {
+		return 0, false, nil
+	}
+	rtt, err := s.ping.Ping(ctx, target)
+	return rtt, true, err
+}
+
+func resolveIPv4(ctx context.Context, host string) (net.IP, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+// resolvePinned resolves host over the family ctx is pinned to, and over
+// IPv4 when it isn't pinned. An address is taken as it is.
+func resolvePinned(ctx context.Context, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	if pinnedFamily(ctx) != "tcp6" {
+		return resolveIPv4(ctx, host)
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+func withPingTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, pingTimeout)
+}
+
+// errICMPv6 fails ICMP pings on a run pinned to IPv6: echoes are only sent
+// over IPv4.
+var errICMPv6 = errors.New("ICMP pings are IPv4 only")
+
+// icmpPing sends an echo request over an unprivileged ICMP socket, which
+// Linux allows for groups in net.ipv4.ping_group_range and macOS allows
+// for everyone.
+type icmpPing struct{ clock Clock }
+
+func (icmpPing) Name() string { return "icmp" }
+
+func (icmpPing) Available() error {
+	c, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		return errors.New("unprivileged ICMP sockets aren't permitted")
+	}
+	c.Close()
+	return nil
+}
+
+func (p icmpPing) Ping(ctx context.Context, host string) (float64, error) {
+	if pinnedFamily(ctx) == "tcp6" {
+		return 0, errICMPv6
+	}
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	ip, err := resolveIPv4(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	// icmp.ListenPacket has no hook to set socket options on, so with
+	// --interface the socket is bound to the interface's address instead.
+	local := "0.0.0.0"
+	if ip := interfaceIPv4(ctx); ip != nil {
+		local = ip.String()
+	}
+	c, err := icmp.ListenPacket("udp4", local)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+
+	// The kernel assigns the echo ID of unprivileged sockets and only
+	// delivers replies to our own requests, so matching the sequence
+	// number is enough.
+	const seq = 1
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{Seq: seq, Data: []byte("gofast")},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := p.clock.Now()
+	if _, err := c.WriteTo(request, &net.UDPAddr{IP: ip}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return msSince(p.clock, start), nil
+		}
+	}
+}
+
+// tcpPing times a TCP handshake to port 443, or to the port host names.
+type tcpPing struct{ clock Clock }
+
+func (tcpPing) Name() string     { return "tcp" }
+func (tcpPing) Available() error { return nil }
+
+func (p tcpPing) Ping(ctx context.Context, host string) (float64, error) {
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	port := "443"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	ip, err := resolvePinned(ctx, host)
+	if err != nil {
+		return 0, err
+	}
+	d := bindDialer(ctx, &net.Dialer{})
+	start := p.clock.Now()
+	conn, err := d.DialContext(ctx, familyNetwork(ctx, "tcp"), net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return 0, err
+	}
+	rtt := msSince(p.clock, start)
+	conn.Close()
+	return rtt, nil
+}
+
+// udpPingResolver answers the UDP method's queries. UDP has no universal
+// echo service, so the round trip is a DNS query to a public anycast
+// resolver rather than to host itself. udpPingResolver6 is the same
+// resolver for runs pinned to IPv6.
+const (
+	udpPingResolver  = "8.8.8.8:53"
+	udpPingResolver6 = "[2001:4860:4860::8888]:53"
+)
+
+// udpResolver is the resolver UDP probes go to under ctx.
+func udpResolver(ctx context.Context) string {
+	if pinnedFamily(ctx) == "tcp6" {
+		return udpPingResolver6
+	}
+	return udpPingResolver
+}
+
+// udpPing times a DNS query for host's A record.
+type udpPing struct{ clock Clock }
+
+func (udpPing) Name() string     { return "udp" }
+func (udpPing) Available() error { return nil }
+
+func (p udpPing) Ping(ctx context.Context, host string) (float64, error) {
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	d := bindDialer(ctx, &net.Dialer{})
+	conn, err := d.DialContext(ctx, "udp", udpResolver(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	const id = 0x6f66
+	query := dnsQuery(id, host)
+	start := p.clock.Now()
+	if _, err := conn.Write(query); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n >= 2 && int(buf[0])<<8|int(buf[1]) == id {
+			return msSince(p.clock, start), nil
+		}
+	}
+}
+
+// dnsQuery builds a recursive query for host's A record.
+func dnsQuery(id uint16, host string) []byte {
+	q := []byte{byte(id >> 8), byte(id), 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	return append(q, 0, 0, 1, 0, 1)
+}
+
+// httpsPing times a whole HEAD request on a fresh connection, including
+// the TCP and TLS handshakes, which is closest to what a browser feels and
+// furthest from `ping`.
+type httpsPing struct{ clock Clock }
+
+func (httpsPing) Name() string     { return "https" }
+func (httpsPing) Available() error { return nil }
+
+func (p httpsPing) Ping(ctx context.Context, host string) (float64, error) {
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+host, nil)
+	if err != nil {
+		return 0, err
+	}
+	transport := httpTransport()
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport}
+	start := p.clock.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return msSince(p.clock, start), nil
+}
+
+func msSince(clock Clock, start time.Time) float64 {
+	return float64(clock.Since(start).Microseconds()) / 1000
+}
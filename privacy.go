@@ -0,0 +1,110 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// privacy is the [privacy] section of the config: what results may reveal
+// about the machine and its user. Every output shares the results the
+// engine redacts, so the TUI, --format output, samples, history and sinks
+// all see the same.
+type privacy struct {
+	// RedactIP drops the client's public IP address.
+	RedactIP bool
+	// CountryOnly coarsens locations to the country, and drops distances.
+	CountryOnly bool
+	// OmitHostname drops this machine's hostname.
+	OmitHostname bool
+	// OmitISP drops the client's ISP and ASN.
+	OmitISP bool
+	// NoGeolocation never looks the location up, so servers are chosen by
+	// latency alone and results carry no location.
+	NoGeolocation bool
+}
+
+// set applies one key of the [privacy] section.
+func (p *privacy) set(key string, on bool) bool {
+	switch key {
+	case "redact_ip":
+		p.RedactIP = on
+	case "country_only":
+		p.CountryOnly = on
+	case "omit_hostname":
+		p.OmitHostname = on
+	case "omit_isp":
+		p.OmitISP = on
+	case "no_geolocation":
+		p.NoGeolocation = on
+	default:
+		return false
+	}
+	return true
+}
+
+// redact removes from r what the switches withhold. It is the only place
+// that does, applied by the engine before results leave it.
+func (p privacy) redact(r Results) Results {
+	r.Server = p.redactServer(r.Server)
+	if r.MultiServer != nil {
+		multi := *r.MultiServer
+		multi.Legs = slices.Clone(multi.Legs)
+		for i := range multi.Legs {
+			multi.Legs[i].Server = p.redactServer(multi.Legs[i].Server)
+		}
+		r.MultiServer = &multi
+	}
+	if p.RedactIP {
+		r.Client.IP = ""
+	}
+	if p.OmitHostname {
+		r.Client.Hostname = ""
+	}
+	if p.OmitISP {
+		r.Client.ISP, r.Client.ASN = "", ""
+	}
+	if r.Web != nil && p.any() {
+		// The destinations say what the user browses, and errors such as
+		// a failed lookup name them; keep only the timings.
+		web := *r.Web
+		web.Destinations = slices.Clone(web.Destinations)
+		for i := range web.Destinations {
+			web.Destinations[i].Host = ""
+			if web.Destinations[i].Error != "" {
+				web.Destinations[i].Error = "failed"
+			}
+		}
+		r.Web = &web
+	}
+	return r
+}
+
+// redactServer applies the location switches to a server, which the TUI
+// shows before the run's results exist.
+func (p privacy) redactServer(s Server) Server {
+	switch {
+	case p.NoGeolocation:
+		s.Location, s.Country, s.Distance = "", "", 0
+	case p.CountryOnly:
+		s.Location, s.Distance = s.Country, 0
+	}
+	return s
+}
+
+func (p privacy) any() bool {
+	return p != privacy{}
+}
+
+// withheld lists the switches that are on, for the details panel.
+func (p privacy) withheld() string {
+	var on []string
+	for _, s := range []struct {
+		key string
+		on  bool
+	}{{"redact_ip", p.RedactIP}, {"country_only", p.CountryOnly}, {"omit_hostname", p.OmitHostname}, {"omit_isp", p.OmitISP}, {"no_geolocation", p.NoGeolocation}} {
+		if s.on {
+			on = append(on, s.key)
+		}
+	}
+	return strings.Join(on, ", ")
+}
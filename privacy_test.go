@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// identifiedProvider is the url provider with a server in a known city and
+// a client it can name, so there is something for privacy to withhold.
+type identifiedProvider struct {
+	Provider
+}
+
+func (identifiedProvider) Server(context.Context) (Server, error) {
+	return Server{Name: "mock", Location: "Springfield, Oregon", Country: "United States", Distance: 12.5, LocationSource: provenanceMeasured}, nil
+}
+
+func (identifiedProvider) Client(context.Context) (Client, error) {
+	return Client{IP: "203.0.113.7", ISP: "Example Telecom", ASN: "AS64500"}, nil
+}
+
+// serializedRun is everything one run's results were written as, by name.
+type serializedRun map[string]string
+
+// serializeRun runs e and collects its results from every output: --format
+// json and prometheus, the history file and list, a file sink, a webhook
+// body, the messages notify and the TUI saw, and the TUI's final frame.
+func serializeRun(t *testing.T, e engine) (Results, serializedRun) {
+	t.Helper()
+	dir := t.TempDir()
+	var (
+		mu      sync.Mutex
+		webhook []byte
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		webhook = body
+		mu.Unlock()
+	}))
+	defer hook.Close()
+	fileSink, err := newFileSink(map[string]string{"path": filepath.Join(dir, "sink.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hookSink, err := newWebhookSink(map[string]string{"url": hook.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.history = &historyStore{path: filepath.Join(dir, "history.jsonl")}
+	var notified []tea.Msg
+	e.notify = func(msg tea.Msg) { notified = append(notified, msg) }
+
+	var emitted []tea.Msg
+	r, err := e.run(context.Background(), func(msg tea.Msg) { emitted = append(emitted, msg) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := dispatch(context.Background(), []configuredSink{{Sink: fileSink, timeout: defaultSinkTimeout}, {Sink: hookSink, timeout: defaultSinkTimeout}}, r); len(report.Failures) > 0 {
+		t.Fatalf("sinks: %s", report)
+	}
+
+	out := serializedRun{}
+	var b bytes.Buffer
+	writeJSON(&b, r)
+	out["json"] = b.String()
+	b.Reset()
+	writePrometheus(&b, r)
+	out["prometheus"] = b.String()
+	history, _ := os.ReadFile(e.history.path)
+	out["history file"] = string(history)
+	runs, _ := e.history.Load()
+	b.Reset()
+	writeHistoryList(&b, runs)
+	out["history list"] = b.String()
+	sink, _ := os.ReadFile(filepath.Join(dir, "sink.json"))
+	out["file sink"] = string(sink)
+	out["webhook"] = string(webhook)
+	out["notify"] = fmtMsgs(notified)
+	out["emitted"] = fmtMsgs(emitted)
+
+	m := initialModel(e, viewOptions{NoMenu: true})
+	m.width, m.height = 120, 80
+	var model tea.Model = m
+	for _, msg := range append(emitted, completeMsg(r)) {
+		model, _ = model.Update(msg)
+	}
+	out["tui"] = sgrPattern.ReplaceAllString(model.View(), "")
+	return r, out
+}
+
+func fmtMsgs(msgs []tea.Msg) string {
+	var b strings.Builder
+	for _, msg := range msgs {
+		data, _ := json.Marshal(msg)
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestPrivacyAcrossOutputs(t *testing.T) {
+	srv := mockSpeedServer()
+	t.Cleanup(srv.Close)
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name     string
+		privacy  privacy
+		withheld []string
+		kept     []string
+	}{
+		{"off", privacy{}, nil, []string{"Springfield", "203.0.113.7", "Example Telecom", "AS64500"}},
+		{"all", privacy{RedactIP: true, CountryOnly: true, OmitHostname: true, OmitISP: true}, []string{"Springfield", "203.0.113.7", "Example Telecom", "AS64500"}, []string{"United States"}},
+		{"country only", privacy{CountryOnly: true}, []string{"Springfield", "Oregon"}, []string{"United States", "203.0.113.7"}},
+		{"no geolocation", privacy{NoGeolocation: true}, []string{"Springfield", "United States"}, []string{"Example Telecom"}},
+		{"ip and isp", privacy{RedactIP: true, OmitISP: true}, []string{"203.0.113.7", "Example Telecom", "AS64500"}, []string{"Springfield"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			e := headlessEngine(srv)
+			e.provider = identifiedProvider{e.provider}
+			e.fresh = nil
+			e.privacy = tt.privacy
+			r, outputs := serializeRun(t, e)
+
+			if tt.privacy.OmitHostname != (r.Client.Hostname == "") {
+				t.Errorf("hostname %q with omit_hostname %v", r.Client.Hostname, tt.privacy.OmitHostname)
+			}
+			if tt.privacy.CountryOnly && r.Server.Distance != 0 {
+				t.Errorf("distance %g kept with country_only", r.Server.Distance)
+			}
+			withheld := tt.withheld
+			if tt.privacy.OmitHostname && len(hostname) >= 6 {
+				withheld = append(withheld, hostname)
+			}
+			for name, out := range outputs {
+				for _, s := range withheld {
+					if strings.Contains(out, s) {
+						t.Errorf("%s reveals %q", name, s)
+					}
+				}
+			}
+			// The machine-readable outputs carry the results whole, so
+			// what is kept must show in all of them alike.
+			for _, name := range []string{"json", "history file", "file sink", "webhook", "notify"} {
+				for _, s := range tt.kept {
+					if !strings.Contains(outputs[name], s) {
+						t.Errorf("%s lacks %q", name, s)
+					}
+				}
+			}
+			if withheld := tt.privacy.withheld(); withheld != "" && !strings.Contains(outputs["tui"], withheld) {
+				t.Errorf("details panel doesn't list %q as withheld", withheld)
+			}
+		})
+	}
+}
+
+func TestRedactCopies(t *testing.T) {
+	r := Results{
+		MultiServer: &MultiServer{Legs: []ServerLeg{{Server: Server{Location: "Springfield, Oregon", Country: "United States"}}}},
+		Web:         &WebCheck{Destinations: []WebTiming{{Host: "example.com", Error: "lookup example.com: no such host"}}},
+	}
+	got := privacy{CountryOnly: true}.redact(r)
+	if loc := got.MultiServer.Legs[0].Server.Location; loc != "United States" {
+		t.Errorf("leg location %q, want the country", loc)
+	}
+	if d := got.Web.Destinations[0]; d.Host != "" || d.Error != "failed" {
+		t.Errorf("web destination kept %+v", d)
+	}
+	if r.MultiServer.Legs[0].Server.Location != "Springfield, Oregon" || r.Web.Destinations[0].Host != "example.com" {
+		t.Error("redact changed the results it was given")
+	}
+	if got := (privacy{}).redact(r); got.Web.Destinations[0].Host != "example.com" {
+		t.Error("no switches still withheld the web destinations")
+	}
+}
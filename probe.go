@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// probeResult is the latency of one target: the median of its successful
+// probes, or Err when none succeeded.
+type probeResult struct {
+	Server  Server
+	RTT     float64
+	Samples int
+	Err     error
+}
+
+// probePool measures many targets concurrently with a fixed number of
+// workers. Each target gets its own timeout and results are streamed as
+// targets finish, so an unresponsive target never holds back fast ones.
+// Cancelling the context stops every worker; none outlive Run's channel.
+type probePool struct {
+	Workers int
+	Timeout time.Duration
+	Probes  int
+	Probe   func(ctx context.Context, s Server) (float64, error)
+}
+
+var defaultProbePool = probePool{Workers: 8, Timeout: 2 * time.Second, Probes: 3}
+
+var errNoProbeAnswered = errors.New("no probe answered")
+
+// Run probes targets and closes the returned channel once every worker has
+// exited. Results for targets not reached before cancellation are not sent.
+func (p probePool) Run(ctx context.Context, targets []Server) <-chan probeResult {
+	jobs := make(chan Server)
+	results := make(chan probeResult)
+
+	go func() {
+		defer close(jobs)
+		for _, s := range targets {
+			select {
+			case jobs <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range max(p.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				r := p.probeTarget(ctx, s)
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+func (p probePool) probeTarget(ctx context.Context, s Server) probeResult {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	var rtts []float64
+	var lastErr error
+	for range max(p.Probes, 1) {
+		rtt, err := p.Probe(ctx, s)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		rtts = append(rtts, rtt)
+	}
+	if len(rtts) == 0 {
+		if lastErr == nil {
+			lastErr = errNoProbeAnswered
+		}
+		return probeResult{Server: s, Err: lastErr}
+	}
+	return probeResult{Server: s, RTT: median(rtts), Samples: len(rtts)}
+}
+
+// rankByLatency probes candidates and orders them by median round trip,
+// keeping the provider's order among ties and putting unreachable ones last.
+// It also returns the round trips of those that answered. Each result is
+// passed to report as it comes in.
+func (p probePool) rankByLatency(ctx context.Context, candidates []Server, report func(probeResult)) ([]Server, map[string]float64) {
+	rtt := make(map[string]float64, len(candidates))
+	for r := range p.Run(ctx, candidates) {
+		report(r)
+		if r.Err == nil {
+			rtt[r.Server.Name] = r.RTT
+		}
+	}
+
+	ranked := slices.Clone(candidates)
+	slices.SortStableFunc(ranked, func(a, b Server) int {
+		ra, aok := rtt[a.Name]
+		rb, bok := rtt[b.Name]
+		switch {
+		case aok && bok:
+			return cmp.Compare(ra, rb)
+		case aok:
+			return -1
+		case bok:
+			return 1
+		}
+		return 0
+	})
+	return ranked, rtt
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// hangingProbe answers each server with the round trip in rtts after that
+// long, and hangs until cancelled for servers it has none for.
+func hangingProbe(rtts map[string]float64) func(context.Context, Server) (float64, error) {
+	return func(ctx context.Context, s Server) (float64, error) {
+		rtt, ok := rtts[s.Name]
+		if !ok {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		select {
+		case <-time.After(time.Duration(rtt * float64(time.Millisecond))):
+			return rtt, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func TestProbePoolSlowTargets(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	rtts := map[string]float64{}
+	targets := []Server{{Name: "hang-1"}, {Name: "hang-2"}}
+	for i := range 12 {
+		name := fmt.Sprintf("fast-%d", i)
+		rtts[name] = float64(1 + i%3)
+		targets = append(targets, Server{Name: name})
+	}
+	pool := probePool{Workers: 4, Timeout: time.Second, Probes: 3, Probe: hangingProbe(rtts)}
+
+	start := time.Now()
+	var fast, failed int
+	for r := range pool.Run(context.Background(), targets) {
+		if r.Err != nil {
+			if !errors.Is(r.Err, context.DeadlineExceeded) {
+				t.Errorf("%s: %v, want a timeout", r.Server.Name, r.Err)
+			}
+			failed++
+			continue
+		}
+		if failed > 0 {
+			t.Errorf("%s arrived after a hanging target timed out", r.Server.Name)
+		}
+		if r.Samples != 3 || r.RTT != rtts[r.Server.Name] {
+			t.Errorf("%s: %g ms over %d probes, want %g over 3", r.Server.Name, r.RTT, r.Samples, rtts[r.Server.Name])
+		}
+		fast++
+	}
+	if fast != 12 || failed != 2 {
+		t.Errorf("%d answered and %d failed, want 12 and 2", fast, failed)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("pool took %v, want about the 1s timeout", elapsed)
+	}
+}
+
+func TestProbePoolCancelled(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	targets := make([]Server, 20)
+	for i := range targets {
+		targets[i] = Server{Name: fmt.Sprintf("hang-%d", i)}
+	}
+	pool := probePool{Workers: 4, Timeout: time.Minute, Probes: 3, Probe: hangingProbe(nil)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := pool.Run(ctx, targets)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	closed := make(chan int)
+	go func() {
+		n := 0
+		for range results {
+			n++
+		}
+		closed <- n
+	}()
+	select {
+	case n := <-closed:
+		if n > 4 {
+			t.Errorf("%d results after cancelling with 4 workers busy", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("results still open 2s after cancelling")
+	}
+}
+
+func TestRankByLatency(t *testing.T) {
+	candidates := []Server{{Name: "a"}, {Name: "down"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	pool := probePool{
+		Workers: 3,
+		Probes:  3,
+		Probe: func(ctx context.Context, s Server) (float64, error) {
+			switch s.Name {
+			case "a", "d":
+				return 20, nil
+			case "b":
+				return 5, nil
+			case "c":
+				return 12, nil
+			}
+			return 0, errors.New("unreachable")
+		},
+	}
+	var reported []string
+	ranked, rtt := pool.rankByLatency(context.Background(), candidates, func(r probeResult) {
+		reported = append(reported, r.Server.Name)
+	})
+	var order []string
+	for _, s := range ranked {
+		order = append(order, s.Name)
+	}
+	if want := []string{"b", "c", "a", "d", "down"}; !slices.Equal(order, want) {
+		t.Errorf("ranked %v, want %v", order, want)
+	}
+	if len(reported) != len(candidates) {
+		t.Errorf("reported %v, want every candidate", reported)
+	}
+	if _, ok := rtt["down"]; ok || rtt["b"] != 5 {
+		t.Errorf("round trips %v", rtt)
+	}
+}
+
+func TestProbeTargetMedian(t *testing.T) {
+	answers := []float64{30, -1, 10, 20}
+	i := 0
+	pool := probePool{Probes: 4, Probe: func(context.Context, Server) (float64, error) {
+		rtt := answers[i]
+		i++
+		if rtt < 0 {
+			return 0, errors.New("lost")
+		}
+		return rtt, nil
+	}}
+	r := pool.probeTarget(context.Background(), Server{Name: "x"})
+	if r.Err != nil || r.Samples != 3 || r.RTT != 20 {
+		t.Errorf("probeTarget = %+v, want 20 ms over 3 samples", r)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// profile bundles the knobs that trade accuracy for time and data. Adding a
+// profile is a matter of adding an entry to profiles.
+type profile struct {
+	Name        string
+	Description string
+	// Label is shown alongside results measured with this profile.
+	Label       string
+	PingSamples int
+	// Duration bounds each transfer direction; zero leaves it to the provider.
+	Duration time.Duration
+	Streams  int
+	// MaxDownload and MaxUpload cap the bytes moved per direction; zero
+	// means uncapped.
+	MaxDownload int64
+	MaxUpload   int64
+	// LoadedLatency probes latency while transfers saturate the link.
+	LoadedLatency bool
+}
+
+const defaultProfile = "standard"
+
+var profiles = []profile{
+	{
+		Name:          "quick",
+		Description:   "under 15 seconds, for mobile hotspots",
+		Label:         "quick test — lower accuracy",
+		PingSamples:   10,
+		Duration:      5 * time.Second,
+		Streams:       1,
+		MaxDownload:   30 << 20,
+		MaxUpload:     10 << 20,
+		LoadedLatency: true,
+	},
+	{
+		Name:          "standard",
+		Description:   "balanced default",
+		PingSamples:   10,
+		Streams:       4,
+		LoadedLatency: true,
+	},
+	{
+		Name:          "thorough",
+		Description:   "a few minutes, most complete results",
+		PingSamples:   20,
+		Duration:      30 * time.Second,
+		Streams:       8,
+		LoadedLatency: true,
+	},
+}
+
+func findProfile(name string) (profile, error) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return profile{}, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(profileNames(), ", "))
+}
+
+func profileNames() []string {
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// estimateRate is the line rate used to estimate data usage of uncapped
+// transfers, in Mbps.
+const estimateRate = 100.0
+
+// estimateBytes is the worst-case data a transfer of this profile moves at
+// estimateRate.
+func (p profile) estimateBytes(max int64) int64 {
+	d := p.Duration
+	if d == 0 {
+		d = 10 * time.Second
+	}
+	bytes := int64(estimateRate * 1e6 / 8 * d.Seconds())
+	if max > 0 && max < bytes {
+		return max
+	}
+	return bytes
+}
+
+// writeDryRun describes what a run would do without touching the network.
+func writeDryRun(w io.Writer, selected profile, p Provider) {
+	caps := p.Capabilities()
+	phases := []string{"server", "ping", "download"}
+	if caps.Upload {
+		phases = append(phases, "upload")
+	}
+
+	fmt.Fprintf(w, "Provider: %s\n", p.Name())
+	fmt.Fprintf(w, "Profile:  %s (%s)\n", selected.Name, selected.Description)
+	fmt.Fprintf(w, "Phases:   %s\n\n", strings.Join(phases, " → "))
+	fmt.Fprintf(w, "Estimated data usage at %.0f Mbps:\n", estimateRate)
+	for _, prof := range profiles {
+		marker := " "
+		if prof.Name == selected.Name {
+			marker = "*"
+		}
+		total := prof.estimateBytes(prof.MaxDownload)
+		if caps.Upload {
+			total += prof.estimateBytes(prof.MaxUpload)
+		}
+		fmt.Fprintf(w, " %s %-10s %s\n", marker, prof.Name, formatBytes(total))
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provenance records where a reported value came from, so real measurements
+// are never confused with stand-ins. An empty Provenance, as in results saved
+// before it existed, means measured.
+type Provenance string
+
+const (
+	provenanceMeasured Provenance = "measured"
+	// provenanceSimulated values come from the demo provider.
+	provenanceSimulated Provenance = "simulated"
+	// provenanceFallback values were measured by a secondary method after
+	// the primary one failed.
+	provenanceFallback Provenance = "fallback"
+	// provenanceUnavailable values couldn't be obtained at all; their
+	// numeric fields are zero and must not be reported.
+	provenanceUnavailable Provenance = "unavailable"
+)
+
+func (p Provenance) Available() bool {
+	return p != provenanceUnavailable
+}
+
+// mark is the footnote marker shown after a value of this provenance.
+func (p Provenance) mark() string {
+	switch p {
+	case provenanceSimulated, provenanceFallback:
+		return "*"
+	default:
+		return ""
+	}
+}
+
+// provenanceFootnote explains the markers used on a results screen, or ""
+// when every value was measured.
+func provenanceFootnote(r Results) string {
+	sources := []Provenance{r.Latency.Source, r.Server.LocationSource}
+	for _, t := range []*Transfer{r.Download, r.Upload} {
+		if t != nil {
+			sources = append(sources, t.Source)
+		}
+	}
+
+	var notes []string
+	seen := map[Provenance]bool{}
+	for _, p := range sources {
+		if p.mark() == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		switch p {
+		case provenanceSimulated:
+			notes = append(notes, "simulated by the demo provider")
+		case provenanceFallback:
+			notes = append(notes, "measured by a fallback method")
+		}
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("* %s", strings.Join(notes, "; "))
+}
+
+func transferMark(t *Transfer) string {
+	if t == nil {
+		return ""
+	}
+	return t.Source.mark()
+}
+
+// formatPingJitter is formatPing followed by the jitter, when there were
+// samples enough to have one: "14.2 ms  Jitter: 3.1 ms".
+func formatPingJitter(l Latency) string {
+	if !l.Source.Available() || len(l.Samples) < 2 {
+		return formatPing(l)
+	}
+	return fmt.Sprintf("%s  Jitter: %.1f ms", formatPing(l), l.Jitter)
+}
+
+// formatPing renders an idle latency's median with its provenance marker,
+// or "n/a".
+func formatPing(l Latency) string {
+	if !l.Source.Available() {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f ms%s", l.P50, l.Source.mark())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Capabilities declares which measurements a provider can produce. Phases the
+// provider can't run are skipped and their metrics reported as unavailable
+// rather than zero.
+type Capabilities struct {
+	Upload        bool
+	Loss          bool
+	LoadedLatency bool
+	// Simulated providers invent their throughput; it is reported as such.
+	Simulated bool
+}
+
+type Provider interface {
+	Name() string
+	Capabilities() Capabilities
+	Server(ctx context.Context) (Server, error)
+	Ping(ctx context.Context) (Latency, error)
+	// Download and Upload add every payload byte they move to m as it is
+	// transferred so the engine can sample live throughput.
+	Download(ctx context.Context, m *meter) (Transfer, error)
+	Upload(ctx context.Context, m *meter) (Transfer, error)
+}
+
+// providerOptions carries the dependencies and settings injected into every
+// provider.
+type providerOptions struct {
+	Clock Clock
+	Rand  *rand.Rand
+
+	// Duration bounds each transfer direction. Zero selects the provider's
+	// default.
+	Duration    time.Duration
+	PingSamples int
+	Streams     int
+	// AutoStreams ramps the stream count per transfer, with Streams as
+	// its ceiling.
+	AutoStreams bool
+	// Compressible makes upload payloads trivially compressible, for
+	// debugging middleboxes.
+	Compressible bool
+	// Ping measures idle and loaded latency.
+	Ping pingMethod
+	// NoGeolocation keeps the provider from looking up locations, and
+	// from choosing servers by them.
+	NoGeolocation bool
+	// ServerURL is the server to test against, for providers pointed at
+	// one with --server; Username and Password log in to it with HTTP
+	// basic auth.
+	ServerURL          string
+	Username, Password string
+	// PeerName is the name a peer found with --lan announced.
+	PeerName string
+	// DownloadURL and UploadURL are the resources --url and --upload-url
+	// point the url provider at.
+	DownloadURL, UploadURL string
+}
+
+// latencyProber is implemented by providers that can measure one round trip
+// cheaply enough to repeat while a transfer saturates the link.
+type latencyProber interface {
+	Probe(ctx context.Context) (float64, error)
+}
+
+// clientIdentifier is implemented by providers whose server reports the
+// client's public address and ISP as it sees them.
+type clientIdentifier interface {
+	Client(ctx context.Context) (Client, error)
+}
+
+// connectionTracer is implemented by providers that can build a small
+// request to the test server, for timing the steps of connecting to it.
+type connectionTracer interface {
+	TraceRequest(ctx context.Context) (*http.Request, error)
+}
+
+var providers = map[string]func(providerOptions) Provider{
+	"cloudflare": newCloudflareProvider,
+	"fast":       newFastProvider,
+	"lan":        newLANProvider,
+	"librespeed": newLibrespeedProvider,
+	"ookla":      newOoklaProvider,
+	"url":        newURLProvider,
+	"demo": func(opts providerOptions) Provider {
+		return demoProvider{clock: opts.Clock, rng: opts.Rand, duration: opts.Duration, pingSamples: opts.PingSamples, ping: opts.Ping, streams: opts.Streams, autoStreams: opts.AutoStreams, noGeolocation: opts.NoGeolocation}
+	},
+}
+
+func newProvider(name string, opts providerOptions) (Provider, error) {
+	factory, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (available: %s)", name, strings.Join(providerNames(), ", "))
+	}
+	return factory(opts), nil
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// demoProvider produces simulated values paced to match the gauge animation.
+// Seeding its generator makes a demo run reproducible.
+type demoProvider struct {
+	clock       Clock
+	rng         *rand.Rand
+	duration    time.Duration
+	pingSamples int
+	ping        pingMethod
+	streams     int
+	autoStreams bool
+	// noGeolocation skips the location lookup.
+	noGeolocation bool
+}
+
+func (demoProvider) Name() string { return "demo" }
+
+func (demoProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: true, LoadedLatency: true, Simulated: true}
+}
+
+func (p demoProvider) Server(ctx context.Context) (Server, error) {
+	if p.noGeolocation {
+		return Server{Name: "demo"}, nil
+	}
+	location, country, err := getServerLocation(ctx)
+	if err != nil {
+		return Server{Name: "demo", Country: country, LocationSource: provenanceUnavailable}, nil
+	}
+	return Server{Name: "demo", Location: location, Country: country, LocationSource: provenanceMeasured}, nil
+}
+
+func (p demoProvider) Ping(ctx context.Context) (Latency, error) {
+	delay := 1 * time.Second
+	if p.duration > 0 {
+		delay = p.duration / 5
+	}
+	if err := sleepCtx(ctx, p.clock, delay); err != nil {
+		return Latency{}, err
+	}
+	return pingLatency(ctx, p.ping, pingHost, p.pingSamples)
+}
+
+func (p demoProvider) Probe(ctx context.Context) (float64, error) {
+	return p.ping.Ping(ctx, pingHost)
+}
+
+func (p demoProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	if p.autoStreams {
+		return p.simulateRamp(ctx, m, simulateRealisticSpeedTest(p.rng), p.phaseDuration(5*time.Second))
+	}
+	return p.simulate(ctx, m, simulateRealisticSpeedTest(p.rng), p.phaseDuration(5*time.Second))
+}
+
+// DownloadFamily simulates one family's streams of a dual-stack download,
+// which get a share of the link. A family this host has no route for is
+// unavailable.
+func (p demoProvider) DownloadFamily(ctx context.Context, network string, m *meter) (Transfer, error) {
+	if err := familyRoute(network); err != nil {
+		return Transfer{}, err
+	}
+	share := 0.3 + p.rng.Float64()*0.4
+	return p.simulate(ctx, m, simulateRealisticSpeedTest(p.rng)*share, p.phaseDuration(5*time.Second))
+}
+
+func (p demoProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	if p.autoStreams {
+		return p.simulateRamp(ctx, m, simulateUploadSpeed(p.rng), p.phaseDuration(4*time.Second))
+	}
+	return p.simulate(ctx, m, simulateUploadSpeed(p.rng), p.phaseDuration(4*time.Second))
+}
+
+func (p demoProvider) phaseDuration(def time.Duration) time.Duration {
+	if p.duration > 0 {
+		return p.duration
+	}
+	return def
+}
+
+// simulate feeds m with a TCP-like ramp towards mbps over d.
+func (p demoProvider) simulate(ctx context.Context, m *meter, mbps float64, d time.Duration) (Transfer, error) {
+	const step = 100 * time.Millisecond
+	steps := max(int(d/step), 2)
+	d = time.Duration(steps) * step
+
+	for i := 0; i < steps; i++ {
+		if err := sleepCtx(ctx, p.clock, step); err != nil {
+			return Transfer{}, err
+		}
+		progress := float64(i) / float64(steps-1)
+		current := mbps*(0.3+0.7*progress) + (p.rng.Float64()*2-1)*mbps*0.05
+		m.Add(int64(current * 1e6 / 8 * step.Seconds()))
+	}
+
+	bytes := m.Bytes()
+	return Transfer{
+		Mbps:     float64(bytes) * 8 / 1e6 / d.Seconds(),
+		Bytes:    bytes,
+		Duration: d,
+	}, nil
+}
+
+// simulateRamp feeds m as --streams auto would see a link of mbps whose
+// flows are each shaped to a fraction of it, ramping the stream count in
+// the first part of the transfer.
+func (p demoProvider) simulateRamp(ctx context.Context, m *meter, mbps float64, d time.Duration) (Transfer, error) {
+	const step = 100 * time.Millisecond
+	steps := max(int(d/step), 2)
+	d = time.Duration(steps) * step
+	perStream := mbps / (2 + p.rng.Float64()*6)
+	window := min(rampWindow, d/2)
+
+	ramp := newStreamRamp(p.streams)
+	streams := ramp.streams
+	var interval int64
+	for i := 0; i < steps; i++ {
+		if err := sleepCtx(ctx, p.clock, step); err != nil {
+			return Transfer{}, err
+		}
+		current := streamModel(mbps, perStream, streams) * (1 + (p.rng.Float64()*2-1)*0.05)
+		n := int64(current * 1e6 / 8 * step.Seconds())
+		m.Add(n)
+		interval += n
+		elapsed := time.Duration(i+1) * step
+		if elapsed%rampInterval == 0 && !ramp.done {
+			streams = ramp.observe(float64(interval) * 8 / 1e6 / rampInterval.Seconds())
+			interval = 0
+		}
+		if elapsed >= window {
+			ramp.stop()
+		}
+	}
+
+	bytes := m.Bytes()
+	return Transfer{
+		Mbps:       float64(bytes) * 8 / 1e6 / d.Seconds(),
+		Bytes:      bytes,
+		Duration:   d,
+		Streams:    ramp.streams,
+		StreamRamp: ramp.trace,
+	}, nil
+}
+
+// streamModel is the aggregate of n flows of at most perStream each over a
+// link of mbps.
+func streamModel(mbps, perStream float64, n int) float64 {
+	return min(mbps, perStream*float64(n))
+}
+
+func sleepCtx(ctx context.Context, clock Clock, d time.Duration) error {
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
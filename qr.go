@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrMargin is the light border drawn around the code, in modules. Scanners
+// need a quiet zone and a dark terminal background doesn't provide one.
+const qrMargin = 2
+
+// renderQR draws content as a QR code with half-block characters, two
+// modules per line, dark on light whatever the terminal's colors. A zero
+// width or height means the terminal size is unknown and isn't checked.
+func renderQR(content string, width, height int) (string, error) {
+	if !term.canDrawQR() {
+		return "", errors.New("this terminal can't draw a QR code")
+	}
+	code, err := qrcode.New(content, qrcode.Low)
+	if err != nil {
+		return "", err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	size := len(bitmap) + 2*qrMargin
+	lines := (size + 1) / 2
+	if (width > 0 && size > width) || (height > 0 && lines > height) {
+		return "", fmt.Errorf("terminal too small for the QR code (needs %dx%d)", size, lines)
+	}
+
+	dark := func(x, y int) bool {
+		x, y = x-qrMargin, y-qrMargin
+		return y >= 0 && y < len(bitmap) && x >= 0 && x < len(bitmap) && bitmap[y][x]
+	}
+
+	var s strings.Builder
+	for y := 0; y < size; y += 2 {
+		s.WriteString("\033[30;107m")
+		for x := 0; x < size; x++ {
+			top, bottom := dark(x, y), dark(x, y+1)
+			switch {
+			case top && bottom:
+				s.WriteString("█")
+			case top:
+				s.WriteString("▀")
+			case bottom:
+				s.WriteString("▄")
+			default:
+				s.WriteString(" ")
+			}
+		}
+		s.WriteString("\033[0m\n")
+	}
+	return s.String(), nil
+}
+
+// shareText is the plain-text summary encoded in the results QR code.
+func shareText(r Results) string {
+	parts := []string{
+		"gofast " + r.Timestamp.Local().Format("2006-01-02 15:04"),
+		"down " + formatSpeed(transferMbps(r.Download)),
+	}
+	if r.Upload != nil {
+		parts = append(parts, "up "+formatSpeed(r.Upload.Mbps))
+	}
+	parts = append(parts, "ping "+formatPing(r.Latency))
+	if label := r.Server.Label(); label != "" {
+		parts = append(parts, "via "+label)
+	}
+	return strings.Join(parts, ", ")
+}
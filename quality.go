@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Data-quality flags name the ways a server can subtly misreport a
+// transfer. Like warning codes, a flag keeps its meaning once released.
+const (
+	flagShortRead        = "short_read"
+	flagDuplicateRange   = "duplicate_range"
+	flagNegativeDuration = "negative_duration"
+)
+
+// QualityIssue is a data-quality flag raised on a transfer.
+type QualityIssue struct {
+	Flag   string `json:"flag"`
+	Detail string `json:"detail"`
+}
+
+// transferRecord is one response of a transfer as it was read.
+type transferRecord struct {
+	URL string
+	// Offset is the first byte of the range requested, -1 for the whole
+	// resource.
+	Offset int64
+	// Expected is what the response promised, -1 when it didn't say.
+	Expected int64
+	Received int64
+	Began    time.Time
+	Ended    time.Time
+}
+
+// recordRetention bounds the records a meter keeps per transfer; later
+// responses go unchecked.
+const recordRetention = 4096
+
+// rangeOffset is the first byte a request asked for, or -1.
+func rangeOffset(req *http.Request) int64 {
+	if req == nil {
+		return -1
+	}
+	spec, ok := strings.CutPrefix(req.Header.Get("Range"), "bytes=")
+	if !ok {
+		return -1
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return offset
+}
+
+// checkDataQuality looks over a finished transfer and its response records
+// for figures that can't be right.
+func checkDataQuality(t Transfer, records []transferRecord) []QualityIssue {
+	var issues []QualityIssue
+
+	short, missing := 0, int64(0)
+	for _, r := range records {
+		if r.Expected >= 0 && r.Received < r.Expected {
+			short++
+			missing += r.Expected - r.Received
+		}
+	}
+	if short > 0 {
+		issues = append(issues, QualityIssue{flagShortRead, fmt.Sprintf("%d response(s) ended %s short of their Content-Length", short, formatBytes(missing))})
+	}
+
+	ranged := slices.DeleteFunc(slices.Clone(records), func(r transferRecord) bool { return r.Offset < 0 })
+	slices.SortFunc(ranged, func(a, b transferRecord) int {
+		return cmp.Or(strings.Compare(a.URL, b.URL), cmp.Compare(a.Offset, b.Offset))
+	})
+	var duplicate int64
+	for i := 1; i < len(ranged); i++ {
+		prev, r := ranged[i-1], ranged[i]
+		if r.URL == prev.URL && r.Offset < prev.Offset+prev.Received {
+			duplicate += min(prev.Offset+prev.Received, r.Offset+r.Received) - r.Offset
+		}
+	}
+	if duplicate > 0 {
+		issues = append(issues, QualityIssue{flagDuplicateRange, fmt.Sprintf("%s was received more than once from overlapping ranges", formatBytes(duplicate))})
+	}
+
+	backwards := 0
+	for _, r := range records {
+		// Round(0) drops the monotonic reading, leaving the wall clocks
+		// the timestamps were reported with.
+		if r.Ended.Round(0).Before(r.Began.Round(0)) {
+			backwards++
+		}
+	}
+	switch {
+	case t.Duration < 0:
+		issues = append(issues, QualityIssue{flagNegativeDuration, fmt.Sprintf("the transfer reported a duration of %s", t.Duration)})
+	case backwards > 0:
+		issues = append(issues, QualityIssue{flagNegativeDuration, fmt.Sprintf("%d response(s) ended before they began by the wall clock", backwards)})
+	}
+	return issues
+}
+
+// warnQuality raises a transfer's data-quality flags as warnings, with the
+// flag as the code.
+func warnQuality(r *Results, emit func(tea.Msg), direction string, t Transfer) {
+	for _, issue := range t.Quality {
+		warn(r, emit, issue.Flag, "%s: %s", direction, issue.Detail)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// qualityFlags is the flags raised on t and its records.
+func qualityFlags(t Transfer, records []transferRecord) map[string]string {
+	flags := map[string]string{}
+	for _, q := range checkDataQuality(t, records) {
+		flags[q.Flag] = q.Detail
+	}
+	return flags
+}
+
+// getRecorded reads one GET of url with readCounted, asking for the range
+// given, if any, and returns the meter's records.
+func getRecorded(t *testing.T, clock Clock, m *meter, url, rng string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	began := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	record := transferRecord{URL: url, Offset: rangeOffset(req), Expected: resp.ContentLength, Began: began}
+	if err := readCounted(context.Background(), clock, resp, m, record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQualityShortRead(t *testing.T) {
+	// The server promises 100,000 bytes and hangs up after 30,000.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", "100000")
+		w.Write(make([]byte, 30_000))
+		w.(http.Flusher).Flush()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	m := &meter{}
+	getRecorded(t, realClock{}, m, srv.URL, "")
+	getRecorded(t, realClock{}, m, srv.URL, "")
+
+	flags := qualityFlags(Transfer{Duration: time.Second}, m.Records())
+	if detail, ok := flags[flagShortRead]; !ok || !strings.HasPrefix(detail, "2 response(s) ended") {
+		t.Errorf("flags %v, want short_read for both responses", flags)
+	}
+	if len(flags) != 1 {
+		t.Errorf("flags %v, want short_read alone", flags)
+	}
+}
+
+func TestQualityDuplicateRange(t *testing.T) {
+	payload := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "payload", time.Time{}, bytes.NewReader(payload))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		ranges [][2]string // URL path and Range header
+		dup    bool
+	}{
+		{"adjacent ranges", [][2]string{{"/a", "bytes=0-999"}, {"/a", "bytes=1000-1999"}}, false},
+		{"overlapping ranges", [][2]string{{"/a", "bytes=0-999"}, {"/a", "bytes=500-1499"}}, true},
+		{"same range twice", [][2]string{{"/a", "bytes=0-999"}, {"/a", "bytes=0-999"}}, true},
+		{"overlap on different URLs", [][2]string{{"/a", "bytes=0-999"}, {"/b", "bytes=500-1499"}}, false},
+		{"whole resource twice", [][2]string{{"/a", ""}, {"/a", ""}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &meter{}
+			for _, r := range tt.ranges {
+				getRecorded(t, realClock{}, m, srv.URL+r[0], r[1])
+			}
+			flags := qualityFlags(Transfer{Duration: time.Second}, m.Records())
+			if _, got := flags[flagDuplicateRange]; got != tt.dup {
+				t.Errorf("flags %v, duplicate_range wanted %v", flags, tt.dup)
+			}
+		})
+	}
+}
+
+// steppedClock is the real clock with its wall time stepped back, as NTP
+// does mid-run, and no monotonic reading to correct it.
+type steppedClock struct {
+	realClock
+	step time.Duration
+}
+
+func (c steppedClock) Now() time.Time { return time.Now().Add(-c.step).Round(0) }
+
+func TestQualityNegativeDuration(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(make([]byte, 10_000))
+	}))
+	defer srv.Close()
+
+	m := &meter{}
+	getRecorded(t, steppedClock{step: time.Hour}, m, srv.URL, "")
+	if flags := qualityFlags(Transfer{Duration: time.Second}, m.Records()); !strings.Contains(flags[flagNegativeDuration], "ended before they began") {
+		t.Errorf("flags %v, want negative_duration for a response that ended before it began", flags)
+	}
+
+	m = &meter{}
+	getRecorded(t, realClock{}, m, srv.URL, "")
+	if flags := qualityFlags(Transfer{Duration: time.Second}, m.Records()); len(flags) != 0 {
+		t.Errorf("a clean response was flagged: %v", flags)
+	}
+	if flags := qualityFlags(Transfer{Duration: -time.Second}, m.Records()); !strings.Contains(flags[flagNegativeDuration], "duration of -1s") {
+		t.Errorf("flags %v, want negative_duration for a negative transfer duration", flags)
+	}
+}
+
+func TestWarnQuality(t *testing.T) {
+	var r Results
+	var emitted []Warning
+	tr := Transfer{Quality: []QualityIssue{{flagShortRead, "1 response(s) ended 10 kB short"}}}
+	warnQuality(&r, func(msg tea.Msg) {
+		if w, ok := msg.(warningMsg); ok {
+			emitted = append(emitted, Warning(w))
+		}
+	}, "download", tr)
+	want := Warning{Code: flagShortRead, Message: "download: 1 response(s) ended 10 kB short"}
+	if len(r.Warnings) != 1 || r.Warnings[0] != want {
+		t.Errorf("warnings %+v, want %+v", r.Warnings, want)
+	}
+	if len(emitted) != 1 {
+		t.Errorf("emitted %+v, want the warning once", emitted)
+	}
+}
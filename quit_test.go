@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.uber.org/goleak"
+)
+
+// abortModel passes everything on to a speedTest, keeping the engine's
+// event channel and telling started when the first speed sample arrives.
+type abortModel struct {
+	speedTest
+	started chan<- struct{}
+	events  *<-chan tea.Msg
+}
+
+func (a abortModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if ev, ok := msg.(engineEvent); ok {
+		*a.events = ev.events
+		if _, ok := ev.msg.(speedMsg); ok {
+			select {
+			case a.started <- struct{}{}:
+			default:
+			}
+		}
+	}
+	m, cmd := a.speedTest.Update(msg)
+	if st, ok := m.(speedTest); ok {
+		a.speedTest = st
+		return a, cmd
+	}
+	return m, cmd
+}
+
+// endlessSpeedServer is mockSpeedServer with a download that streams until
+// the client goes away, so a run stays in its download.
+func endlessSpeedServer() *httptest.Server {
+	return httptest.NewServer(endlessSpeedHandler())
+}
+
+func endlessSpeedHandler() http.Handler {
+	chunk := make([]byte, 32<<10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /download", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Write(chunk[:1])
+			return
+		}
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+	mux.HandleFunc("POST /upload", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	return mux
+}
+
+func TestAbortRunningTest(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv := endlessSpeedServer()
+	defer srv.Close()
+	e := headlessEngine(srv)
+	e.profile.Duration = time.Minute
+	started := make(chan struct{}, 1)
+	var events <-chan tea.Msg
+	model := abortModel{speedTest: initialModel(e, viewOptions{FPS: 30, NoMenu: true}), started: started, events: &events}
+	p := tea.NewProgram(model, tea.WithInput(strings.NewReader("")), tea.WithOutput(&syncBuffer{}), tea.WithoutSignalHandler())
+	finished := make(chan tea.Model, 1)
+	go func() {
+		final, err := p.Run()
+		if err != nil {
+			t.Errorf("program: %v", err)
+		}
+		finished <- final
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(8 * time.Second):
+		p.Kill()
+		t.Fatal("no speed sample within 8s")
+	}
+	key := func(k string) {
+		p.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+	}
+	key("q")
+	key("n")
+	key("q")
+	var final tea.Model
+	select {
+	case final = <-finished:
+		t.Fatal("q quit a running test without asking")
+	case <-time.After(100 * time.Millisecond):
+	}
+	key("y")
+	select {
+	case final = <-finished:
+	case <-time.After(2 * time.Second):
+		p.Kill()
+		t.Fatal("confirming the abort didn't quit")
+	}
+	if err := final.(abortModel).ctx.Err(); err != context.Canceled {
+		t.Errorf("the run's context after quitting: %v, want cancelled", err)
+	}
+
+	// Nobody reads the events once the program is gone; take the rest as
+	// drainEngines would, and the engine must stop promptly.
+	drained := make(chan struct{})
+	go func() {
+		for range events {
+		}
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("the engine kept running a second after the abort")
+	}
+}
+
+// TestDrainEngines runs in a child process, since drainEngines shuts every
+// engine in the process down for good.
+func TestDrainEngines(t *testing.T) {
+	if os.Getenv("GOFAST_DRAIN_CHILD") == "1" {
+		drainChild()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDrainEngines$")
+	cmd.Env = append(os.Environ(), "GOFAST_DRAIN_CHILD=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child: %v\n%s", err, out)
+	}
+}
+
+func drainChild() {
+	srv := endlessSpeedServer()
+	e := headlessEngine(srv)
+	e.profile.Duration = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	// The first event is taken and the rest left unread, as when the
+	// program quits mid-run.
+	runSpeedTestCmd(ctx, e)()
+	cancel()
+	if !drainEngines(2 * time.Second) {
+		os.Exit(3)
+	}
+	srv.Close()
+	if err := goleak.Find(); err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(4)
+	}
+}
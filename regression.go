@@ -0,0 +1,63 @@
+package main
+
+import "slices"
+
+// regressionPolicy controls how a run is compared against recent history.
+type regressionPolicy struct {
+	// Window is how many recent comparable runs form the baseline.
+	Window int
+	// Percentile below which a result counts as a notable regression.
+	Percentile float64
+	// MinRuns is the fewest comparable runs worth drawing a conclusion from.
+	MinRuns int
+}
+
+var defaultRegressionPolicy = regressionPolicy{Window: 20, Percentile: 10, MinRuns: 5}
+
+// Regression is the outcome of comparing a run against its baseline.
+type Regression struct {
+	Notable    bool    `json:"notable"`
+	Download   bool    `json:"download"`
+	Upload     bool    `json:"upload"`
+	Compared   int     `json:"compared"`
+	Percentile float64 `json:"percentile"`
+}
+
+// comparable reports whether two runs measured the same path.
+func comparable(a, b Results) bool {
+	return a.Site == b.Site && a.Provider == b.Provider && a.Server.Name == b.Server.Name && a.Server.Host == b.Server.Host
+}
+
+// detectRegression flags current when it falls below the policy percentile of
+// the most recent comparable runs. It returns nil when there is too little
+// history to judge.
+func detectRegression(current Results, history []Results, policy regressionPolicy) *Regression {
+	var downloads, uploads []float64
+	for i := len(history) - 1; i >= 0 && len(downloads) < policy.Window; i-- {
+		past := history[i]
+		if !comparable(current, past) || past.Download == nil || outOfBaseline(past) {
+			continue
+		}
+		downloads = append(downloads, past.Download.Mbps)
+		if past.Upload != nil {
+			uploads = append(uploads, past.Upload.Mbps)
+		}
+	}
+	if len(downloads) < policy.MinRuns {
+		return nil
+	}
+
+	r := &Regression{Compared: len(downloads), Percentile: policy.Percentile}
+	r.Download = belowPercentile(transferMbps(current.Download), downloads, policy.Percentile)
+	if current.Upload != nil && len(uploads) >= policy.MinRuns {
+		r.Upload = belowPercentile(current.Upload.Mbps, uploads, policy.Percentile)
+	}
+	r.Notable = r.Download || r.Upload
+	return r
+}
+
+func belowPercentile(value float64, baseline []float64, p float64) bool {
+	sorted := slices.Clone(baseline)
+	slices.Sort(sorted)
+	return value < percentile(sorted, p)
+}
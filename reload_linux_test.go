@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a pseudo-terminal, for a child whose TUI needs a terminal
+// on its input.
+func openPTY(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		t.Skipf("can't unlock a pseudo-terminal: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err == nil {
+		slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err != nil {
+		master.Close()
+		t.Skipf("can't open a pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() {
+		slave.Close()
+		master.Close()
+	})
+	return master, slave
+}
+
+func TestSoakReloadsOnHangup(t *testing.T) {
+	if runGofastChild() {
+		return
+	}
+	srv := mockSpeedServer()
+	defer srv.Close()
+	first, second := newCountingHook(), newCountingHook()
+	defer first.Close()
+	defer second.Close()
+
+	cmd, stderr, configPath := gofastCommand(t, "TestSoakReloadsOnHangup", webhookConfig(first.URL),
+		"soak", "--provider", "lan", "--server", srv.URL+"/download?bytes=0", "--every", "2s", "--for", "5m", "--no-history")
+	_, tty := openPTY(t)
+	cmd.Stdin = tty
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer func() {
+		cmd.Process.Kill()
+		<-exited
+	}()
+	wait := func(h *countingHook, what string) {
+		t.Helper()
+		select {
+		case <-h.got:
+		case <-exited:
+			t.Fatalf("the soak exited waiting for %s; stderr:\n%s", what, stderr)
+		case <-time.After(20 * time.Second):
+			t.Fatalf("no %s within 20s; stderr:\n%s", what, stderr)
+		}
+	}
+
+	wait(first, "run sent to the first sink")
+	if err := os.WriteFile(configPath, []byte(webhookConfig(first.URL, second.URL)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before := first.count()
+	cmd.Process.Signal(syscall.SIGHUP)
+	wait(second, "run sent to the sink added on reload")
+	for deadline := time.Now().Add(5 * time.Second); first.count() <= before; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the first sink was dropped on reload")
+		}
+	}
+	select {
+	case <-exited:
+		t.Fatalf("the soak exited on SIGHUP; stderr:\n%s", stderr)
+	default:
+	}
+	// The schedule goes on after the reload.
+	n := second.count()
+	wait(second, "scheduled run after the reload")
+	if second.count() <= n {
+		t.Error("no further run after the reload")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import "time"
+
+// SchemaVersion identifies the serialized layout of Results. Bump it whenever
+// a field is renamed, removed, or changes meaning.
+const SchemaVersion = 3
+
+// Results is the complete outcome of a speed test run. It is shared by the
+// engine, the TUI model and every output format. Metrics the provider can't
+// measure are nil and serialize as null.
+type Results struct {
+	SchemaVersion int              `json:"schema_version"`
+	Timestamp     time.Time        `json:"timestamp"`
+	Provider      string           `json:"provider"`
+	Profile       string           `json:"profile"`
+	Server        Server           `json:"server"`
+	Fallbacks     []ServerFallback `json:"server_fallbacks,omitempty"`
+	ServerRace    []RaceEntry      `json:"server_race,omitempty"`
+	Client        Client           `json:"client"`
+	Latency       Latency          `json:"latency"`
+	Download      *Transfer        `json:"download"`
+	Upload        *Transfer        `json:"upload"`
+	Loss          *float64         `json:"loss_percent"`
+	LoadedLatency *Latency         `json:"loaded_latency"`
+	Bufferbloat   *Bufferbloat     `json:"bufferbloat,omitempty"`
+	Duration      time.Duration    `json:"duration_ns"`
+	Regression    *Regression      `json:"regression,omitempty"`
+	// CompressionSuspected is set when a compressible upload ran much faster
+	// than recent incompressible ones on the same path.
+	CompressionSuspected bool `json:"compression_proxy_suspected,omitempty"`
+	// Partial is set when a headless run was interrupted: the phase under
+	// way reports what it measured until then, and later phases are
+	// missing.
+	Partial bool `json:"partial,omitempty"`
+	// LossMethod is how Loss was measured: "udp" for a burst of DNS
+	// queries, "http" for the provider's probe requests where UDP is
+	// blocked.
+	LossMethod string `json:"loss_method,omitempty"`
+	// Interface is the network interface the run was bound to, with
+	// --interface; Error is why it failed, for an interface whose run did.
+	Interface string `json:"interface,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Family is the address family the run was pinned to, "IPv4" or
+	// "IPv6", with --ipv4, --ipv6 or --compare-stacks.
+	Family string `json:"family,omitempty"`
+	// Protocol is the HTTP version the run asked for, "HTTP/3" with
+	// --http3 and either with --compare-protocols. Each transfer's own
+	// Protocol says what its responses actually came over.
+	Protocol string `json:"protocol,omitempty"`
+	// MultiServer splits the download by server for runs made with
+	// --servers; Download is then their mean.
+	MultiServer *MultiServer `json:"multi_server,omitempty"`
+	// BudgetCuts lists what --max-duration skipped or shortened.
+	BudgetCuts []string `json:"budget_cuts,omitempty"`
+	// TLSUnverified is set for runs made with --insecure-skip-verify.
+	TLSUnverified bool `json:"tls_unverified,omitempty"`
+	// CPUBound is set when gofast itself kept a core busy during the run,
+	// so the machine may have limited the result.
+	CPUBound bool `json:"cpu_bound,omitempty"`
+	// Process is gofast's own resource use, with --process-stats.
+	Process *ProcessUsage `json:"process,omitempty"`
+	// Warnings are the non-fatal problems noticed during the run, in the
+	// order they happened.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Environment is the pre-test report on this machine and network, with
+	// --env-report.
+	Environment []EnvItem `json:"environment,omitempty"`
+	// ServerChoice records how the server was chosen for scheduled runs,
+	// which stick to one; ServerChange annotates the run that moved to
+	// another.
+	ServerChoice *ServerChoice `json:"server_choice,omitempty"`
+	ServerChange *ServerChange `json:"server_change,omitempty"`
+	// DualStack splits the download by address family, with --dual-stack.
+	DualStack *DualStack `json:"dual_stack,omitempty"`
+	// Web is the web responsiveness check made after the test, with
+	// --web-check.
+	Web *WebCheck `json:"web,omitempty"`
+	// Connection times the steps of one fresh request to the test server,
+	// made after the transfers, for providers that support it.
+	Connection *WebTiming `json:"connection_breakdown,omitempty"`
+	// TLS times full TLS handshakes with the test server over a few fresh
+	// connections, for providers that support the connection breakdown
+	// and servers over HTTPS.
+	TLS *TLSHandshake `json:"tls_handshake,omitempty"`
+	// Expected is the baseline the gauges' percent mode compares against,
+	// when there is one.
+	Expected *Expectation `json:"expected,omitempty"`
+	// Site labels a run a collector received from another gofast; runs
+	// made here have none.
+	Site string `json:"site,omitempty"`
+	// Synthetic marks a run generated by gofast history seed rather than
+	// measured.
+	Synthetic bool `json:"synthetic,omitempty"`
+	// Note is the user's free-text annotation of the run.
+	Note string `json:"note,omitempty"`
+	// Retest links an unusual run and its confirmation test.
+	Retest *Retest `json:"retest,omitempty"`
+	// Context holds hints from history on how long the result holds; it
+	// is only set when history is on.
+	Context *RunContext `json:"context,omitempty"`
+	// Samples are every raw sample with its timestamp and phase, with
+	// --include-samples.
+	Samples []Sample `json:"samples,omitempty"`
+}
+
+type Latency struct {
+	Min     float64   `json:"min_ms"`
+	Avg     float64   `json:"avg_ms"`
+	Max     float64   `json:"max_ms"`
+	Jitter  float64   `json:"jitter_ms"`
+	P50     float64   `json:"p50_ms"`
+	P95     float64   `json:"p95_ms"`
+	P99     float64   `json:"p99_ms"`
+	Samples []float64 `json:"samples_ms,omitempty"`
+	// Source is unavailable when no round trip succeeded; the figures
+	// above are then zero.
+	Source Provenance `json:"source,omitempty"`
+	// Method is the protocol that measured the round trips: icmp, tcp,
+	// udp, https, or http for LibreSpeed's own round trips.
+	Method string `json:"method,omitempty"`
+}
+
+type Transfer struct {
+	// Mbps is the headline rate: the sustained rate when the transfer ran
+	// past its warm-up, and the average over all of it when it didn't.
+	Mbps      float64       `json:"mbps"`
+	Bytes     int64         `json:"bytes"`
+	WireBytes int64         `json:"wire_bytes,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+	Samples   []float64     `json:"samples,omitempty"`
+	// SampleInterval is the time each of Samples covers: sampleInterval,
+	// or a multiple of it once a long transfer's samples were merged.
+	// Zero is taken as sampleInterval.
+	SampleInterval time.Duration `json:"sample_interval_ns,omitempty"`
+	// AverageMbps is the bytes over the whole duration, warm-up included.
+	AverageMbps float64 `json:"average_mbps,omitempty"`
+	// SustainedMbps is the rate over the last seconds once the warm-up is
+	// left out, unset for a transfer that never got past it.
+	SustainedMbps float64 `json:"sustained_mbps,omitempty"`
+	// Settled reports that the transfer got past its warm-up, so Mbps is
+	// SustainedMbps, even when that came out as zero.
+	Settled bool `json:"settled,omitempty"`
+	// BurstMbps is set when the transfer started with a speed boost that
+	// didn't last.
+	BurstMbps float64 `json:"burst_mbps,omitempty"`
+	// LatencySamples are round trips probed while this transfer ran, in ms.
+	LatencySamples []float64 `json:"latency_samples_ms,omitempty"`
+	// Family is the address family of the transfer's connections, when
+	// the provider's connections were counted.
+	Family string `json:"ip_family,omitempty"`
+	// Protocol is the HTTP version the transfer's responses came over,
+	// when the provider's connections were counted.
+	Protocol string `json:"http_protocol,omitempty"`
+	// Capped reports that the transfer stopped early at its byte budget.
+	Capped bool `json:"capped,omitempty"`
+	// Stalls counts connections torn down for making no progress.
+	Stalls int `json:"stalls,omitempty"`
+	// Streams is the number of connections the transfer settled on with
+	// --streams auto, and StreamRamp the counts tried on the way.
+	Streams    int        `json:"streams,omitempty"`
+	StreamRamp []RampStep `json:"stream_ramp,omitempty"`
+	// Quality lists figures of this transfer that can't be right.
+	Quality []QualityIssue `json:"data_quality,omitempty"`
+	// Compressible reports that the upload payload was deliberately
+	// compressible (--compressible).
+	Compressible bool       `json:"compressible,omitempty"`
+	Source       Provenance `json:"source,omitempty"`
+}
+
+// Overhead is the share of wire bytes that wasn't payload, in percent. It is
+// unknown when the provider's connections weren't counted.
+func (t Transfer) Overhead() (float64, bool) {
+	if t.WireBytes <= 0 || t.WireBytes < t.Bytes {
+		return 0, false
+	}
+	return float64(t.WireBytes-t.Bytes) / float64(t.WireBytes) * 100, true
+}
+
+type Server struct {
+	Name     string  `json:"name"`
+	Host     string  `json:"host,omitempty"`
+	URL      string  `json:"url,omitempty"`
+	Location string  `json:"location,omitempty"`
+	Country  string  `json:"country,omitempty"`
+	Distance float64 `json:"distance_km,omitempty"`
+	// Sponsor is who runs the server, for providers whose servers are
+	// hosted by others.
+	Sponsor string `json:"sponsor,omitempty"`
+	// Via is the local interface a peer on the local network is reached
+	// through, with --provider lan.
+	Via string `json:"via,omitempty"`
+	// LocationSource is unavailable when the location couldn't be looked
+	// up; Location is then empty.
+	LocationSource Provenance `json:"location_source,omitempty"`
+}
+
+type Client struct {
+	IP       string `json:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	ISP      string `json:"isp,omitempty"`
+	ASN      string `json:"asn,omitempty"`
+}
+
+// Label is the human-readable server description shown in the TUI.
+func (s Server) Label() string {
+	switch {
+	case s.Sponsor != "" && s.Location != "":
+		return s.Sponsor + " — " + s.Location
+	case s.Location != "":
+		return s.Location
+	case s.Via != "":
+		return s.Name + " via " + s.Via
+	}
+	return s.Name
+}
+
+func newResults(p Provider, prof profile, clock Clock) Results {
+	return Results{
+		SchemaVersion: SchemaVersion,
+		// Round(0) drops the monotonic reading: a timestamp is a wall-clock
+		// label, and compares the same way before and after a round trip
+		// through history. Durations are measured from their own readings.
+		Timestamp:     clock.Now().Round(0),
+		Provider:      p.Name(),
+		Profile:       prof.Name,
+		TLSUnverified: insecureSkipVerify,
+	}
+}
+
+// transferMbps is the download or upload rate, or zero when absent.
+func transferMbps(t *Transfer) float64 {
+	if t == nil {
+		return 0
+	}
+	return t.Mbps
+}
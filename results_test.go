@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultsJSONRoundTrip(t *testing.T) {
+	loss := 0.5
+	want := Results{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC),
+		Provider:      "cloudflare",
+		Profile:       "standard",
+		Server:        Server{Name: "AMS", Host: "speed.example.net", Location: "Amsterdam", Country: "NL", Distance: 12.5, LocationSource: provenanceMeasured},
+		Client:        Client{IP: "192.0.2.10", ISP: "Example ISP", ASN: "AS64500"},
+		Latency:       Latency{Min: 8, Avg: 9.5, Max: 14, Jitter: 1.25, P50: 9, P95: 13, P99: 14, Samples: []float64{8, 9, 14}, Source: provenanceMeasured, Method: "tcp"},
+		Download: &Transfer{
+			Mbps: 940, Bytes: 1_175_000_000, WireBytes: 1_210_000_000, Duration: 10 * time.Second,
+			Samples: []float64{900, 940, 950}, AverageMbps: 920, SustainedMbps: 940, Settled: true,
+			Family: "IPv6", Protocol: "HTTP/2", Source: provenanceMeasured,
+		},
+		Upload:        &Transfer{Mbps: 0.8, Bytes: 1_000_000, Duration: 10 * time.Second, Capped: true, Stalls: 1, Source: provenanceMeasured},
+		Loss:          &loss,
+		LoadedLatency: &Latency{Avg: 40, P50: 38, Source: provenanceMeasured},
+		Duration:      23 * time.Second,
+		Warnings:      []Warning{{Code: warnServerFallback, Message: "fell back"}},
+		Note:          "after the router swap",
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got Results
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %s: %v", buf.String(), err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the results:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestResultsJSONMissingMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, Results{SchemaVersion: SchemaVersion, Provider: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"download": null`, `"upload": null`, `"loss_percent": null`, `"loaded_latency": null`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("output lacks %s:\n%s", field, buf.String())
+		}
+	}
+	var got Results
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Download != nil || got.Upload != nil || got.Loss != nil || got.LoadedLatency != nil {
+		t.Errorf("missing metrics came back set: %+v", got)
+	}
+}
+
+func TestServerLabel(t *testing.T) {
+	tests := []struct {
+		server Server
+		want   string
+	}{
+		{Server{Name: "AMS"}, "AMS"},
+		{Server{Name: "AMS", Location: "Amsterdam"}, "Amsterdam"},
+		{Server{Name: "AMS", Location: "Amsterdam", Sponsor: "Example"}, "Example — Amsterdam"},
+		{Server{Name: "nas (192.168.1.10)", Via: "eth0"}, "nas (192.168.1.10) via eth0"},
+	}
+	for _, tt := range tests {
+		if got := tt.server.Label(); got != tt.want {
+			t.Errorf("%+v.Label() = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// retestPolicy runs one confirmation test when a result's download is more
+// than Factor times away from the median of recent comparable runs, either
+// way. A zero Factor disables it; it is never set for quick or metered runs.
+type retestPolicy struct {
+	Factor float64
+	Delay  time.Duration
+}
+
+// defaultOutlierFactor is the outlier_factor used when the config has none.
+const defaultOutlierFactor = 2.0
+
+// retestDelay gives a transient local load, such as a backup, a moment to
+// pass before the confirmation test.
+const retestDelay = 10 * time.Second
+
+// Retest roles.
+const (
+	retestOutlier      = "outlier"
+	retestConfirmation = "confirmation"
+)
+
+// Retest links a run that looked like an outlier with the confirmation test
+// run after it.
+type Retest struct {
+	// Role is "outlier" or "confirmation".
+	Role string `json:"role"`
+	// Pair is the other run's timestamp. An outlier only gets it once the
+	// confirmation has finished.
+	Pair         time.Time `json:"pair,omitempty"`
+	BaselineMbps float64   `json:"baseline_mbps"`
+	// OutlierMbps is the outlier's download, on the confirmation.
+	OutlierMbps float64 `json:"outlier_mbps,omitempty"`
+	// Discounted is set on an outlier the confirmation didn't reproduce.
+	// Baselines skip discounted runs.
+	Discounted bool `json:"discounted,omitempty"`
+}
+
+// retestMsg tells the TUI a result looked like an outlier and a
+// confirmation test follows after delay.
+type retestMsg struct {
+	outlier  Results
+	baseline float64
+	delay    time.Duration
+}
+
+// discounted reports whether r is an outlier its confirmation test didn't
+// reproduce.
+func discounted(r Results) bool {
+	return r.Retest != nil && r.Retest.Discounted
+}
+
+// deviates reports whether mbps is more than Factor times away from
+// baseline.
+func (p retestPolicy) deviates(mbps, baseline float64) bool {
+	return p.Factor > 1 && baseline > 0 && (mbps < baseline/p.Factor || mbps > baseline*p.Factor)
+}
+
+// suspect returns the baseline current's download is compared against and
+// whether it deviates enough to retest. It needs as many comparable runs as
+// regression detection does.
+func (p retestPolicy) suspect(current Results, history []Results, policy regressionPolicy) (float64, bool) {
+	if p.Factor == 0 || current.Download == nil {
+		return 0, false
+	}
+	var downloads []float64
+	for i := len(history) - 1; i >= 0 && len(downloads) < policy.Window; i-- {
+		past := history[i]
+		if comparable(current, past) && past.Download != nil && !outOfBaseline(past) {
+			downloads = append(downloads, past.Download.Mbps)
+		}
+	}
+	if len(downloads) < max(policy.MinRuns, 1) {
+		return 0, false
+	}
+	baseline := median(downloads)
+	return baseline, p.deviates(current.Download.Mbps, baseline)
+}
+
+// confirm runs the confirmation test for an outlier already in history,
+// then links the two there. The confirmation run is what the caller gets;
+// if it can't run, the outlier is returned as it was.
+func (e engine) confirm(ctx context.Context, emit func(tea.Msg), outlier Results) Results {
+	baseline := outlier.Retest.BaselineMbps
+	emit(retestMsg{outlier: outlier, baseline: baseline, delay: e.retest.Delay})
+	if sleepCtx(ctx, e.clock, e.retest.Delay) != nil {
+		return outlier
+	}
+
+	inner := e
+	inner.retest = retestPolicy{}
+	inner.notify = nil
+	inner.pair = &Retest{Role: retestConfirmation, Pair: outlier.Timestamp, BaselineMbps: baseline, OutlierMbps: outlier.Download.Mbps}
+	confirmation, err := inner.run(ctx, emit)
+	if err != nil {
+		warn(&outlier, emit, warnRetested, "the confirmation test for this unusual result failed: %v", err)
+		return outlier
+	}
+
+	reproduced := e.retest.deviates(transferMbps(confirmation.Download), baseline)
+	if e.history != nil {
+		link := *outlier.Retest
+		link.Pair, link.Discounted = confirmation.Timestamp, !reproduced
+		_, _ = e.history.rewrite(func(r Results) bool { return r.Timestamp.Equal(outlier.Timestamp) }, func(fields map[string]json.RawMessage) error {
+			var err error
+			fields["retest"], err = json.Marshal(link)
+			return err
+		})
+	}
+	if reproduced {
+		warn(&confirmation, emit, warnRetested, "retested: the first run's %s was also far from the usual %s, so the change looks real", formatSpeed(outlier.Download.Mbps), formatSpeed(baseline))
+	} else {
+		warn(&confirmation, emit, warnRetested, "retested: the first run's %s was far from the usual %s; it is kept in history but left out of comparisons", formatSpeed(outlier.Download.Mbps), formatSpeed(baseline))
+	}
+	return confirmation
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// phaseAttempts is how many times the server lookup, ping and each
+	// transfer are tried before a transient failure fails the run.
+	phaseAttempts = 3
+	// retryBackoff is the wait before the second attempt, doubled before
+	// each one after.
+	retryBackoff = 500 * time.Millisecond
+)
+
+// retryMsg reports that a phase failed on a transient error and is being
+// tried again; attempt is the one about to start.
+type retryMsg struct {
+	phase       phase
+	name        string
+	attempt, of int
+	err         error
+}
+
+// retryPhase runs fn until it succeeds, fails for good, or has been tried
+// phaseAttempts times, backing off between attempts. Each retry is recorded
+// as a warning on r.
+func (e engine) retryPhase(ctx context.Context, p phase, name string, r *Results, emit func(tea.Msg), fn func() error) error {
+	wait := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == phaseAttempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+		warn(r, emit, warnPhaseRetried, "%s failed (%v); retrying (%d/%d)", name, err, attempt+1, phaseAttempts)
+		emit(retryMsg{phase: p, name: name, attempt: attempt + 1, of: phaseAttempts, err: err})
+		select {
+		case <-ctx.Done():
+			return err
+		case <-e.clock.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// isTransient reports whether err is a network failure worth trying again:
+// a timeout, a reset or dropped connection, or a network that was briefly
+// unreachable. Names that don't resolve, refused connections and
+// certificate failures won't fix themselves and fail at once.
+func isTransient(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var (
+		unknown  x509.UnknownAuthorityError
+		hostname x509.HostnameError
+		invalid  x509.CertificateInvalidError
+		verify   *tls.CertificateVerificationError
+	)
+	if errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid) || errors.As(err, &verify) ||
+		isFamilyError(err) || errors.Is(err, syscall.ECONNREFUSED) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, target := range []error{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ENETDOWN, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary)
+}
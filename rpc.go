@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// rpcServer serves --rpc: JSON-RPC 2.0 over stdin and stdout, one message
+// per line, for programs that embed gofast. Nothing else is written to
+// stdout. Methods, each taking an object or no params:
+//
+//	start      {}  start a test; fails while one runs
+//	abort      {}  cancel the running test; fails while none runs
+//	status     {}  {"running", "phase", "mbps", "profile"}
+//	configure  {"profile": "quick", "streams": 4 or "auto", "note": "...",
+//	            "max_duration": "45s", "max_bytes": "100MB",
+//	            "web_check": true}
+//	           settings for the tests that follow, every field optional;
+//	           returns the status, and fails while a test runs
+//
+// While a test runs, gofast sends these notifications:
+//
+//	phase     {"phase", "server", "ping_ms", "mbps"}  a phase began: server,
+//	          ping, download or upload. server, ping_ms and mbps carry
+//	          what the phase before it measured.
+//	sample    {"phase", "mbps"}       a live throughput sample
+//	warning   {"code", "message"}     a non-fatal problem
+//	retry     {"phase", "attempt", "of", "error"}  a phase failed on a
+//	          transient error and is being tried again
+//	complete  {"results"} or {"error"}  the test finished or failed
+//
+// Errors use the JSON-RPC codes, and rpcBusy for a call the state forbids.
+type rpcServer struct {
+	// build makes the provider for a profile and stream setting.
+	build func(prof profile, streams int, auto bool) (Provider, error)
+	// webDestinations are checked when configure turns the web check on.
+	webDestinations []string
+
+	mu      sync.Mutex
+	e       engine
+	streams int
+	auto    bool
+	status  rpcStatus
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	out sync.Mutex
+	enc *json.Encoder
+}
+
+// JSON-RPC error codes, and rpcBusy for start, abort or configure called
+// in the wrong state.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcBusy           = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+type rpcStatus struct {
+	Running bool    `json:"running"`
+	Phase   string  `json:"phase"`
+	Mbps    float64 `json:"mbps"`
+	Profile string  `json:"profile"`
+}
+
+// rpcConfigure are the params of configure; nil fields are left as they are.
+type rpcConfigure struct {
+	Profile     *string         `json:"profile"`
+	Streams     json.RawMessage `json:"streams"`
+	Note        *string         `json:"note"`
+	MaxDuration *string         `json:"max_duration"`
+	MaxBytes    *string         `json:"max_bytes"`
+	WebCheck    *bool           `json:"web_check"`
+}
+
+type rpcPhase struct {
+	Phase  string  `json:"phase"`
+	Server string  `json:"server,omitempty"`
+	PingMs float64 `json:"ping_ms,omitempty"`
+	Mbps   float64 `json:"mbps,omitempty"`
+}
+
+type rpcSample struct {
+	Phase string  `json:"phase"`
+	Mbps  float64 `json:"mbps"`
+}
+
+type rpcRetry struct {
+	Phase   string `json:"phase"`
+	Attempt int    `json:"attempt"`
+	Of      int    `json:"of"`
+	Error   string `json:"error"`
+}
+
+type rpcComplete struct {
+	Results *Results `json:"results,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func newRPCServer(e engine, streams int, auto bool, build func(profile, int, bool) (Provider, error), out io.Writer) *rpcServer {
+	return &rpcServer{
+		e:       e,
+		streams: streams,
+		auto:    auto,
+		build:   build,
+		status:  rpcStatus{Phase: "idle", Profile: e.profile.Name},
+		enc:     json.NewEncoder(out),
+	}
+}
+
+// serve answers requests from r until it ends, then aborts a running test
+// and waits for it.
+func (s *rpcServer) serve(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.write(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			s.write(rpcResponse{JSONRPC: "2.0", ID: orNull(req.ID), Error: &rpcError{rpcInvalidRequest, `expected "jsonrpc": "2.0" and a method`}})
+			continue
+		}
+		result, err := s.call(req.Method, req.Params)
+		if req.ID == nil {
+			// A notification from the client gets no reply.
+			continue
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+		if err != nil {
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				rerr = &rpcError{rpcBusy, err.Error()}
+			}
+			resp.Result, resp.Error = nil, rerr
+		}
+		s.write(resp)
+	}
+
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if done != nil {
+		cancel()
+		<-done
+	}
+	return scanner.Err()
+}
+
+func orNull(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func (s *rpcServer) call(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "start":
+		if err := decodeParams(params, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return struct{}{}, s.start()
+	case "abort":
+		if err := decodeParams(params, &struct{}{}); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.status.Running {
+			return nil, errors.New("no test is running")
+		}
+		s.cancel()
+		return struct{}{}, nil
+	case "status":
+		if err := decodeParams(params, &struct{}{}); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.status, nil
+	case "configure":
+		var c rpcConfigure
+		if err := decodeParams(params, &c); err != nil {
+			return nil, err
+		}
+		if err := s.configure(c); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.status, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
+
+// decodeParams reads by-name params into v, rejecting unknown fields.
+// Missing or null params leave v as it is.
+func decodeParams(params json.RawMessage, v any) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if params[0] != '{' {
+		return &rpcError{rpcInvalidParams, "params must be an object"}
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &rpcError{rpcInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *rpcServer) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return errors.New("a test is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	s.status = rpcStatus{Running: true, Phase: "server", Profile: s.e.profile.Name}
+	e := s.e
+	e.notify = s.publish
+	go func(done chan struct{}) {
+		defer close(done)
+		defer cancel()
+		results, err := e.run(ctx, nil)
+		if err == nil && len(e.sinks) > 0 {
+			fmt.Fprintln(os.Stderr, dispatch(ctx, e.sinks, results))
+		}
+	}(s.done)
+	return nil
+}
+
+// configure validates every field before applying any, so a call that
+// fails changes nothing.
+func (s *rpcServer) configure(c rpcConfigure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return errors.New("can't configure while a test is running")
+	}
+	invalid := func(format string, args ...any) error {
+		return &rpcError{rpcInvalidParams, fmt.Sprintf(format, args...)}
+	}
+
+	e, streams, auto := s.e, s.streams, s.auto
+	if c.Profile != nil {
+		prof, err := findProfile(*c.Profile)
+		if err != nil {
+			return invalid("profile: %v", err)
+		}
+		e.profile = prof
+	}
+	if c.Streams != nil {
+		var value string
+		if err := json.Unmarshal(c.Streams, &value); err != nil {
+			value = string(c.Streams)
+		}
+		n, a, err := parseStreams(value)
+		if err != nil || (n == 0 && !a) {
+			return invalid("streams must be a count from 1 to 64 or \"auto\", not %s", c.Streams)
+		}
+		streams, auto = n, a
+	}
+	if c.Note != nil {
+		note := strings.TrimSpace(*c.Note)
+		if len([]rune(note)) > noteLimit {
+			return invalid("note: at most %d characters", noteLimit)
+		}
+		e.note = note
+	}
+	if c.MaxDuration != nil {
+		d, err := time.ParseDuration(*c.MaxDuration)
+		if err != nil || d < 0 {
+			return invalid("max_duration must be a duration such as \"45s\", not %q", *c.MaxDuration)
+		}
+		e.maxDuration = d
+	}
+	if c.MaxBytes != nil {
+		e.maxBytes = 0
+		if *c.MaxBytes != "" {
+			n, err := parseByteSize(*c.MaxBytes)
+			if err != nil {
+				return invalid("max_bytes: %v", err)
+			}
+			e.maxBytes = n
+		}
+	}
+	if c.WebCheck != nil {
+		e.webCheck = nil
+		if *c.WebCheck {
+			e.webCheck = s.webDestinations
+		}
+	}
+
+	prof := e.profile
+	if streams > 0 {
+		prof.Streams = streams
+	}
+	provider, err := s.build(prof, streams, auto)
+	if err != nil {
+		return invalid("%v", err)
+	}
+	e.provider, e.profile = provider, prof
+	s.e, s.streams, s.auto = e, streams, auto
+	s.status.Profile = prof.Name
+	return nil
+}
+
+// publish turns an engine message into a notification. It is called from
+// the engine's goroutine.
+func (s *rpcServer) publish(msg tea.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n rpcNotification
+	switch msg := msg.(type) {
+	case runStartedMsg:
+		n = rpcNotification{Method: "phase", Params: rpcPhase{Phase: "server"}}
+	case serverMsg:
+		s.status.Phase = "ping"
+		n = rpcNotification{Method: "phase", Params: rpcPhase{Phase: "ping", Server: Server(msg).Label()}}
+	case pingMsg:
+		s.status.Phase = "download"
+		n = rpcNotification{Method: "phase", Params: rpcPhase{Phase: "download", PingMs: msg.Avg}}
+	case downloadMsg:
+		s.status.Mbps = msg.Mbps
+		if !s.e.provider.Capabilities().Upload {
+			return
+		}
+		s.status.Phase = "upload"
+		n = rpcNotification{Method: "phase", Params: rpcPhase{Phase: "upload", Mbps: msg.Mbps}}
+	case speedMsg:
+		s.status.Mbps = float64(msg)
+		n = rpcNotification{Method: "sample", Params: rpcSample{Phase: s.status.Phase, Mbps: float64(msg)}}
+	case warningMsg:
+		n = rpcNotification{Method: "warning", Params: Warning(msg)}
+	case retryMsg:
+		n = rpcNotification{Method: "retry", Params: rpcRetry{Phase: s.status.Phase, Attempt: msg.attempt, Of: msg.of, Error: msg.err.Error()}}
+	case completeMsg:
+		results := Results(msg)
+		s.status.Running, s.status.Phase = false, "complete"
+		n = rpcNotification{Method: "complete", Params: rpcComplete{Results: &results}}
+	case errorMsg:
+		s.status.Running, s.status.Phase = false, "error"
+		n = rpcNotification{Method: "complete", Params: rpcComplete{Error: error(msg).Error()}}
+	default:
+		return
+	}
+	n.JSONRPC = "2.0"
+	s.write(n)
+}
+
+func (s *rpcServer) write(v any) {
+	s.out.Lock()
+	defer s.out.Unlock()
+	s.enc.Encode(v)
+}
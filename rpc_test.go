@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+// rpcMessage is any line the server writes: a reply or a notification.
+type rpcMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// rpcClient drives an rpcServer the way an embedding UI would, over a pair
+// of pipes.
+type rpcClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Scanner
+	served chan error
+	nextID int
+	// pending are notifications read while waiting for a reply.
+	pending []rpcMessage
+}
+
+// newRPCClient serves an engine against srv, with streams and profiles
+// rebuilt by configure the way --rpc does.
+func newRPCClient(t *testing.T, srv *httptest.Server) *rpcClient {
+	t.Helper()
+	e := headlessEngine(srv)
+	build := func(prof profile, streams int, auto bool) (Provider, error) {
+		return newURLProvider(providerOptions{
+			Clock:       realClock{},
+			Rand:        newRand(1),
+			Duration:    e.profile.Duration,
+			PingSamples: e.profile.PingSamples,
+			Streams:     prof.Streams,
+			AutoStreams: auto,
+			DownloadURL: srv.URL + "/download?bytes=0",
+			UploadURL:   srv.URL + "/upload",
+		}), nil
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := newRPCServer(e, 2, false, build, outW)
+	c := &rpcClient{t: t, in: inW, out: bufio.NewScanner(outR), served: make(chan error, 1)}
+	c.out.Buffer(make([]byte, 64<<10), 4<<20)
+	go func() {
+		c.served <- s.serve(inR)
+		outW.Close()
+	}()
+	return c
+}
+
+func (c *rpcClient) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.in, line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and returns its reply, keeping the notifications
+// that arrive first.
+func (c *rpcClient) call(method string, params any) rpcMessage {
+	c.t.Helper()
+	c.nextID++
+	req, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	c.send(string(req))
+	for {
+		m := c.read()
+		if m.ID != nil && *m.ID == c.nextID {
+			return m
+		}
+		c.pending = append(c.pending, m)
+	}
+}
+
+// notification returns the next notification.
+func (c *rpcClient) notification() rpcMessage {
+	c.t.Helper()
+	if len(c.pending) > 0 {
+		m := c.pending[0]
+		c.pending = c.pending[1:]
+		return m
+	}
+	return c.read()
+}
+
+func (c *rpcClient) read() rpcMessage {
+	c.t.Helper()
+	if !c.out.Scan() {
+		c.t.Fatalf("the server closed its output: %v", c.out.Err())
+	}
+	var m rpcMessage
+	if err := json.Unmarshal(c.out.Bytes(), &m); err != nil {
+		c.t.Fatalf("not JSON-RPC: %s", c.out.Text())
+	}
+	return m
+}
+
+// close ends the input, as an embedding program exiting would, and waits
+// for serve to return.
+func (c *rpcClient) close() {
+	c.t.Helper()
+	c.in.Close()
+	// Read what is left so the server never blocks writing it.
+	go func() {
+		for c.out.Scan() {
+		}
+	}()
+	select {
+	case err := <-c.served:
+		if err != nil {
+			c.t.Errorf("serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("serve didn't return after its input closed")
+	}
+}
+
+func (c *rpcClient) status() rpcStatus {
+	c.t.Helper()
+	var s rpcStatus
+	if r := c.call("status", nil); r.Error != nil || json.Unmarshal(r.Result, &s) != nil {
+		c.t.Fatalf("status: %+v", r)
+	}
+	return s
+}
+
+func TestRPCInvalidCalls(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := mockSpeedServer()
+	defer srv.Close()
+	c := newRPCClient(t, srv)
+
+	for _, bad := range []struct {
+		method string
+		params any
+		code   int
+	}{
+		{"configure", map[string]any{"streams": 0}, rpcInvalidParams},
+		{"configure", map[string]any{"streams": "many"}, rpcInvalidParams},
+		{"configure", map[string]any{"profile": "nonexistent"}, rpcInvalidParams},
+		{"configure", map[string]any{"max_duration": "soon"}, rpcInvalidParams},
+		{"configure", map[string]any{"max_bytes": "lots"}, rpcInvalidParams},
+		{"configure", map[string]any{"colour": "blue"}, rpcInvalidParams},
+		{"configure", []any{1}, rpcInvalidParams},
+		{"status", map[string]any{"verbose": true}, rpcInvalidParams},
+		{"abort", nil, rpcBusy},
+		{"launch", nil, rpcMethodNotFound},
+	} {
+		if r := c.call(bad.method, bad.params); r.Error == nil || r.Error.Code != bad.code {
+			t.Errorf("%s %v: got %+v, want error %d", bad.method, bad.params, r, bad.code)
+		}
+	}
+
+	for _, bad := range []struct {
+		line string
+		code int
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method":`, rpcParseError},
+		{`{"id": 1, "method": "status"}`, rpcInvalidRequest},
+		{`{"jsonrpc": "2.0", "id": 1}`, rpcInvalidRequest},
+	} {
+		c.send(bad.line)
+		if r := c.read(); r.Error == nil || r.Error.Code != bad.code {
+			t.Errorf("%s: got %+v, want error %d", bad.line, r, bad.code)
+		}
+	}
+
+	// A request without an id is a notification and gets no reply, so the
+	// next line is the status call's.
+	c.send(`{"jsonrpc": "2.0", "method": "configure", "params": {"note": "quiet"}}`)
+	if s := c.status(); s.Running || s.Phase != "idle" || s.Profile != "quick" {
+		t.Errorf("status after the invalid calls: %+v", s)
+	}
+	c.close()
+}
+
+func TestRPCConfigureAllOrNothing(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := mockSpeedServer()
+	defer srv.Close()
+	c := newRPCClient(t, srv)
+
+	if r := c.call("configure", map[string]any{"profile": "thorough", "streams": 99}); r.Error == nil {
+		t.Fatal("configure with a bad stream count succeeded")
+	}
+	if s := c.status(); s.Profile != "quick" {
+		t.Errorf("a failed configure switched the profile to %s", s.Profile)
+	}
+	var s rpcStatus
+	if r := c.call("configure", map[string]any{"profile": "standard", "streams": "auto"}); r.Error != nil || json.Unmarshal(r.Result, &s) != nil || s.Profile != "standard" {
+		t.Errorf("configure: %+v", r)
+	}
+	c.close()
+}
+
+func TestRPCFullRun(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := endlessSpeedServer()
+	defer srv.Close()
+	c := newRPCClient(t, srv)
+
+	if r := c.call("configure", map[string]any{"streams": 2, "note": "rpc test"}); r.Error != nil {
+		t.Fatalf("configure: %s", r.Error.Message)
+	}
+	if r := c.call("start", nil); r.Error != nil {
+		t.Fatalf("start: %s", r.Error.Message)
+	}
+	if r := c.call("start", nil); r.Error == nil || r.Error.Code != rpcBusy {
+		t.Errorf("a second start while running: %+v", r)
+	}
+	if r := c.call("configure", map[string]any{"note": "late"}); r.Error == nil || r.Error.Code != rpcBusy {
+		t.Errorf("configure while running: %+v", r)
+	}
+	if s := c.status(); !s.Running {
+		t.Errorf("status while running: %+v", s)
+	}
+
+	var phases []string
+	samples := 0
+	var done rpcComplete
+	for done.Results == nil && done.Error == "" {
+		n := c.notification()
+		switch n.Method {
+		case "phase":
+			var p rpcPhase
+			if err := json.Unmarshal(n.Params, &p); err != nil {
+				t.Fatal(err)
+			}
+			phases = append(phases, p.Phase)
+		case "sample":
+			var s rpcSample
+			if err := json.Unmarshal(n.Params, &s); err != nil || s.Mbps < 0 {
+				t.Errorf("sample %s", n.Params)
+			}
+			samples++
+		case "complete":
+			if err := json.Unmarshal(n.Params, &done); err != nil {
+				t.Fatal(err)
+			}
+		case "warning", "retry":
+		default:
+			t.Errorf("unexpected message %+v", n)
+		}
+	}
+	if done.Error != "" {
+		t.Fatalf("run failed: %s", done.Error)
+	}
+	if r := done.Results; r.Download == nil || r.Download.Mbps <= 0 || r.Upload == nil || r.Note != "rpc test" {
+		t.Errorf("completed with %+v", r)
+	}
+	if want := []string{"server", "ping", "download", "upload"}; !slices.Equal(phases, want) {
+		t.Errorf("phases %v, want %v", phases, want)
+	}
+	if samples == 0 {
+		t.Error("no sample notifications")
+	}
+	if s := c.status(); s.Running || s.Phase != "complete" || s.Mbps <= 0 {
+		t.Errorf("status after the run: %+v", s)
+	}
+	c.close()
+}
+
+func TestRPCAbort(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	srv := endlessSpeedServer()
+	defer srv.Close()
+	c := newRPCClient(t, srv)
+
+	if r := c.call("start", nil); r.Error != nil {
+		t.Fatalf("start: %s", r.Error.Message)
+	}
+	for c.notification().Method != "sample" {
+	}
+	if r := c.call("abort", nil); r.Error != nil {
+		t.Fatalf("abort: %s", r.Error.Message)
+	}
+	for {
+		n := c.notification()
+		if n.Method != "complete" {
+			continue
+		}
+		var done rpcComplete
+		if err := json.Unmarshal(n.Params, &done); err != nil || done.Error == "" {
+			t.Errorf("an aborted run completed with %s", n.Params)
+		}
+		break
+	}
+	if s := c.status(); s.Running || s.Phase != "error" {
+		t.Errorf("status after abort: %+v", s)
+	}
+	if r := c.call("abort", nil); r.Error == nil || r.Error.Code != rpcBusy {
+		t.Errorf("abort with nothing running: %+v", r)
+	}
+
+	// Closing the input mid-run aborts the test, and serve waits for it.
+	if r := c.call("start", nil); r.Error != nil {
+		t.Fatalf("restart: %s", r.Error.Message)
+	}
+	for c.notification().Method != "sample" {
+	}
+	c.close()
+}
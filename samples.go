@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sample is one raw measurement as the engine took it, before any
+// aggregation. Metric names carry their unit.
+type Sample struct {
+	// At is the wall-clock time, which NTP may step during a run.
+	At time.Time `json:"at"`
+	// Elapsed is the monotonic time since the run started, in seconds;
+	// unlike At it never jumps.
+	Elapsed float64 `json:"elapsed_s"`
+	// Phase is ping, download or upload.
+	Phase string `json:"phase"`
+	// Metric is throughput_mbps or rtt_ms.
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+}
+
+const (
+	metricThroughput = "throughput_mbps"
+	metricRTT        = "rtt_ms"
+)
+
+// sampleHeader is the first row of a --samples file.
+var sampleHeader = []string{"run_started", "timestamp", "elapsed_s", "phase", "metric", "value"}
+
+// sampleCSV streams samples to a --samples file as they are taken, one
+// flushed row at a time, so a long run never holds them in memory. It
+// outlives runs: a session appends every run to the same file, told apart
+// by run_started, which matches the results' timestamp.
+type sampleCSV struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *csv.Writer
+	err error
+}
+
+func createSampleCSV(path string, mkdir bool) (*sampleCSV, error) {
+	if mkdir {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	} else if err := checkOutputDir(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sampleCSV{f: f, w: csv.NewWriter(f)}
+	s.write(sampleHeader)
+	return s, s.err
+}
+
+func (s *sampleCSV) write(row []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.w.Write(row)
+	s.w.Flush()
+	s.err = s.w.Error()
+}
+
+// Close reports the first write error, if any, so a full disk isn't
+// mistaken for a complete export.
+func (s *sampleCSV) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Close(); s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// sampleLog receives the samples of one run from the engine's sampler and
+// prober goroutines. A nil sampleLog drops them.
+type sampleLog struct {
+	mu sync.Mutex
+	// run is the results' timestamp; start is the run's monotonic origin.
+	run   time.Time
+	start time.Time
+	clock Clock
+	csv   *sampleCSV
+	// keep collects the samples for the results, with --include-samples.
+	keep bool
+	list []Sample
+}
+
+func (l *sampleLog) add(phase, metric string, v float64) {
+	if l == nil {
+		return
+	}
+	s := Sample{At: l.clock.Now().Round(0), Elapsed: l.clock.Since(l.start).Seconds(), Phase: phase, Metric: metric, Value: v}
+	if l.csv != nil {
+		l.csv.write([]string{
+			l.run.Format(time.RFC3339Nano),
+			s.At.Format(time.RFC3339Nano),
+			strconv.FormatFloat(s.Elapsed, 'f', 3, 64),
+			phase,
+			metric,
+			strconv.FormatFloat(v, 'f', -1, 64),
+		})
+	}
+	if l.keep {
+		l.mu.Lock()
+		l.list = append(l.list, s)
+		l.mu.Unlock()
+	}
+}
+
+func (l *sampleLog) samples() []Sample {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.list
+}
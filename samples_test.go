@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSampleCountsMatchResults(t *testing.T) {
+	srv := endlessSpeedServer()
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "samples.csv")
+	out, err := createSampleCSV(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := headlessEngine(srv)
+	e.profile.Duration, e.profile.LoadedLatency = time.Second, true
+	e.sampleCSV, e.includeSamples = out, true
+	r, err := e.run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 || !slices.Equal(rows[0], sampleHeader) {
+		t.Fatalf("the file doesn't start with the header: %q", rows)
+	}
+	rows = rows[1:]
+	if len(rows) != len(r.Samples) {
+		t.Errorf("%d rows in the file, %d samples in the results", len(rows), len(r.Samples))
+	}
+
+	type key struct{ phase, metric string }
+	counted := map[key]int{}
+	for _, row := range rows {
+		if row[0] != r.Timestamp.Format(time.RFC3339Nano) {
+			t.Fatalf("row from run %s in the file of run %s", row[0], r.Timestamp)
+		}
+		counted[key{row[3], row[4]}]++
+	}
+	want := map[key]int{
+		{"ping", metricRTT}:            len(r.Latency.Samples),
+		{"download", metricThroughput}: len(r.Download.Samples),
+		{"download", metricRTT}:        len(r.Download.LatencySamples),
+		{"upload", metricThroughput}:   len(r.Upload.Samples),
+		{"upload", metricRTT}:          len(r.Upload.LatencySamples),
+	}
+	for k, n := range want {
+		if counted[k] != n {
+			t.Errorf("%d %s %s rows, want %d as in the results", counted[k], k.phase, k.metric, n)
+		}
+		delete(counted, k)
+	}
+	for k, n := range counted {
+		t.Errorf("%d unexpected %s %s rows", n, k.phase, k.metric)
+	}
+	if len(r.Download.Samples) == 0 || len(r.Latency.Samples) == 0 {
+		t.Errorf("the run took %d download and %d ping samples, want some of each", len(r.Download.Samples), len(r.Latency.Samples))
+	}
+}
+
+func TestSampleLogElapsed(t *testing.T) {
+	clock := newFakeClock()
+	l := &sampleLog{run: clock.Now(), start: clock.Now(), clock: clock, keep: true}
+	<-clock.After(1500 * time.Millisecond)
+	l.add("download", metricThroughput, 42)
+	var nilLog *sampleLog
+	nilLog.add("download", metricThroughput, 42)
+
+	got := l.samples()
+	if len(got) != 1 || got[0].Elapsed != 1.5 || got[0].Value != 42 || got[0].Phase != "download" {
+		t.Errorf("samples %+v, want one of 42 at 1.5s", got)
+	}
+	if nilLog.samples() != nil {
+		t.Error("a nil log kept samples")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// schedule is the planned timeline of a repeating test. Slot i starts at
+// start + i·every, delayed by a jitter derived from the seed and i alone, so
+// a preview shows exactly the times the runs will use. The first slot is
+// never delayed.
+type schedule struct {
+	start  time.Time
+	every  time.Duration
+	jitter time.Duration
+	ends   time.Time
+	seed   uint64
+}
+
+func (s schedule) at(i int) time.Time {
+	t := s.start.Add(time.Duration(i) * s.every)
+	if i > 0 && s.jitter > 0 {
+		t = t.Add(time.Duration(rand.New(rand.NewPCG(s.seed, uint64(i))).Int64N(int64(s.jitter))))
+	}
+	return t
+}
+
+// upcoming lists up to n slots starting after t and before the schedule
+// ends. A slot that passed while a run overran is skipped, not queued.
+func (s schedule) upcoming(t time.Time, n int) []time.Time {
+	i := 0
+	if t.After(s.start) {
+		i = int(t.Sub(s.start) / s.every)
+	}
+	var slots []time.Time
+	for ; len(slots) < n; i++ {
+		at := s.at(i)
+		if at.After(s.ends) {
+			break
+		}
+		if at.After(t) {
+			slots = append(slots, at)
+		}
+	}
+	return slots
+}
+
+// schedulePreview is how many upcoming runs a status shows.
+const schedulePreview = 10
+
+// recentOutcomes is how many finished runs a status keeps.
+const recentOutcomes = 5
+
+// runOutcome is one finished scheduled run.
+type runOutcome struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	// Outcome is "ok", "violation" or "failed: " and the error.
+	Outcome string `json:"outcome"`
+}
+
+// scheduleStatus is the scheduler's part of the control socket's status.
+type scheduleStatus struct {
+	Next    []time.Time  `json:"next_runs"`
+	Outputs []string     `json:"outputs"`
+	Recent  []runOutcome `json:"recent_runs"`
+}
+
+// writeStatusTable prints a control status compactly; `gofast status`
+// shows the same fields as the socket's JSON.
+func writeStatusTable(w io.Writer, st controlStatus, now time.Time) {
+	if st.Running {
+		fmt.Fprintf(w, "Running:  %s", st.Phase)
+		if st.Mbps > 0 {
+			fmt.Fprintf(w, " at %s", formatSpeed(st.Mbps))
+		}
+		fmt.Fprintln(w)
+	} else if st.Phase == "idle" {
+		fmt.Fprintln(w, "Idle:     no run yet")
+	} else {
+		fmt.Fprintf(w, "Idle:     last run %s\n", st.Phase)
+	}
+	if u := st.Process; u != nil {
+		fmt.Fprintf(w, "Process:  %s\n", formatUsage(u))
+	}
+	sch := st.Schedule
+	if sch == nil {
+		return
+	}
+
+	if len(sch.Outputs) > 0 {
+		fmt.Fprintf(w, "Outputs:  %s\n", strings.Join(sch.Outputs, ", "))
+	} else {
+		fmt.Fprintln(w, "Outputs:  none")
+	}
+	fmt.Fprintln(w, "\nNext runs:")
+	if len(sch.Next) == 0 {
+		fmt.Fprintln(w, "  none; the schedule has ended")
+	}
+	for i, t := range sch.Next {
+		fmt.Fprintf(w, "  %2d  %s  in %s\n", i+1, t.Local().Format("Jan 02 15:04:05"), max(t.Sub(now), 0).Round(time.Second))
+	}
+	fmt.Fprintln(w, "\nRecent runs:")
+	if len(sch.Recent) == 0 {
+		fmt.Fprintln(w, "  none yet")
+	}
+	for _, r := range sch.Recent {
+		fmt.Fprintf(w, "  %s  %7s  %s\n", r.Started.Local().Format("Jan 02 15:04:05"), r.Duration.Round(100*time.Millisecond), r.Outcome)
+	}
+}
+
+// statusRefresh is how often --watch redraws.
+const statusRefresh = 2 * time.Second
+
+// runStatus implements "gofast status": the status of a gofast serving a
+// control socket, with its schedule when it runs one.
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	socket := fs.String("control-socket", "", "control socket of the gofast to query")
+	watch := fs.Bool("watch", false, "refresh every "+statusRefresh.String()+" until interrupted")
+	jsonOut := fs.Bool("json", false, "print the status as JSON")
+	fs.Parse(args)
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "Error: --control-socket is required")
+		os.Exit(2)
+	}
+
+	for {
+		st, err := queryStatus(*socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *watch && !*jsonOut {
+			fmt.Print("\033[H\033[2J")
+		}
+		if *jsonOut {
+			json.NewEncoder(os.Stdout).Encode(st)
+		} else {
+			writeStatusTable(os.Stdout, st, time.Now())
+		}
+		if !*watch {
+			return
+		}
+		time.Sleep(statusRefresh)
+	}
+}
+
+func queryStatus(path string) (controlStatus, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return controlStatus{}, fmt.Errorf("control socket: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "status\n"); err != nil {
+		return controlStatus{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return controlStatus{}, err
+	}
+	var reply controlReply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return controlStatus{}, err
+	}
+	if !reply.OK || reply.Status == nil {
+		return controlStatus{}, errors.New(reply.Error)
+	}
+	return *reply.Status, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seedModel describes a line for gofast history seed. A seeded run's
+// download is
+//
+//	Download × (1 − Congestion × evening(hour)) × noise
+//
+// where evening rises smoothly from 0 at 17:00 to 1 at 21:00 and falls back
+// to 0 by 01:00, and noise is normal around 1 with a 6% deviation. Upload
+// follows the same shape at half the congestion, and ping adds up to
+// Bufferbloat ms at the evening peak to Ping. With probability OutlierRate
+// a run is an outlier: a fifth to two fifths of the usual speed at three
+// times the ping. Halfway through the seeded period the line moves to
+// ISPAfter, whose speeds are ISPFactor times ISP's.
+type seedModel struct {
+	Download, Upload float64
+	Ping             float64
+	Bufferbloat      float64
+	Congestion       float64
+	OutlierRate      float64
+	ISP, ISPAfter    string
+	ISPFactor        float64
+}
+
+var seedModels = map[string]seedModel{
+	"cable-300": {Download: 300, Upload: 20, Ping: 18, Bufferbloat: 25, Congestion: 0.35, OutlierRate: 0.03,
+		ISP: "Example Cable", ISPAfter: "Example Cable Gigabit", ISPFactor: 1.6},
+	"fiber-1000": {Download: 940, Upload: 880, Ping: 6, Bufferbloat: 4, Congestion: 0.08, OutlierRate: 0.02,
+		ISP: "Example Fiber", ISPAfter: "Other Fiber", ISPFactor: 0.95},
+	"dsl-50": {Download: 48, Upload: 9, Ping: 28, Bufferbloat: 40, Congestion: 0.15, OutlierRate: 0.04,
+		ISP: "Example DSL", ISPAfter: "Example Cable", ISPFactor: 4},
+	"lte-100": {Download: 100, Upload: 25, Ping: 42, Bufferbloat: 60, Congestion: 0.5, OutlierRate: 0.06,
+		ISP: "Example Mobile", ISPAfter: "Other Mobile", ISPFactor: 0.8},
+}
+
+func seedModelNames() []string {
+	names := make([]string, 0, len(seedModels))
+	for name := range seedModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// syntheticBaselines lets seeded runs count towards the baselines that flag
+// regressions, outliers and compressing proxies, and towards percent mode.
+// They are left out unless the config's synthetic_baselines says so.
+var syntheticBaselines bool
+
+// outOfBaseline reports whether r is kept out of the baselines results are
+// judged against.
+func outOfBaseline(r Results) bool {
+	return discounted(r) || r.Synthetic && !syntheticBaselines
+}
+
+// evening is the share of the evening congestion at hour h, 0 to 1.
+func evening(h float64) float64 {
+	// Hours since 17:00, so the curve doesn't wrap at midnight.
+	since := math.Mod(h-17+24, 24)
+	if since >= 8 {
+		return 0
+	}
+	return (1 - math.Cos(since/8*2*math.Pi)) / 2
+}
+
+// seedRuns generates perDay runs a day for days days ending at now,
+// following model. Run times are spread at random across each day.
+func seedRuns(model seedModel, provider string, days, perDay int, now time.Time, rng *rand.Rand) []Results {
+	start := now.Add(-time.Duration(days) * 24 * time.Hour)
+	var runs []Results
+	for day := range days {
+		for range perDay {
+			at := start.Add(time.Duration(day)*24*time.Hour + time.Duration(rng.Int64N(int64(24*time.Hour))))
+			if at.After(now) {
+				continue
+			}
+			runs = append(runs, seedRun(model, provider, at, at.Sub(start) >= now.Sub(start)/2, rng))
+		}
+	}
+	slices.SortFunc(runs, func(a, b Results) int { return a.Timestamp.Compare(b.Timestamp) })
+	return runs
+}
+
+func seedRun(model seedModel, provider string, at time.Time, switched bool, rng *rand.Rand) Results {
+	local := at.Local()
+	peak := evening(float64(local.Hour()) + float64(local.Minute())/60)
+	isp, factor := model.ISP, 1.0
+	if switched {
+		isp, factor = model.ISPAfter, model.ISPFactor
+	}
+	noise := func() float64 { return max(1+rng.NormFloat64()*0.06, 0.5) }
+	down := model.Download * factor * (1 - model.Congestion*peak) * noise()
+	up := model.Upload * factor * (1 - model.Congestion/2*peak) * noise()
+	ping := model.Ping + model.Bufferbloat*peak
+	if rng.Float64() < model.OutlierRate {
+		slump := 0.2 + rng.Float64()*0.2
+		down, up, ping = down*slump, up*slump, ping*3
+	}
+
+	samples := make([]float64, 10)
+	for i := range samples {
+		samples[i] = math.Round(max(ping+rng.NormFloat64()*ping*0.1, 1)*10) / 10
+	}
+	latency := summarizeLatency(samples)
+	latency.Source, latency.Method, latency.Samples = provenanceSimulated, "tcp", nil
+	transfer := func(mbps float64) *Transfer {
+		d := 10 * time.Second
+		return &Transfer{Mbps: math.Round(mbps*10) / 10, Bytes: int64(mbps * 1e6 / 8 * d.Seconds()), Duration: d, Source: provenanceSimulated}
+	}
+	return Results{
+		SchemaVersion: SchemaVersion,
+		Timestamp:     at.Truncate(time.Second),
+		Provider:      provider,
+		Profile:       defaultProfile,
+		Server:        Server{Name: "Seeded server", LocationSource: provenanceSimulated},
+		Client:        Client{ISP: isp},
+		Latency:       latency,
+		Download:      transfer(down),
+		Upload:        transfer(up),
+		Duration:      25 * time.Second,
+		Synthetic:     true,
+	}
+}
+
+// runSeed implements gofast history seed, which fills history with
+// synthetic runs to try the history features on, and --remove, which
+// removes them again.
+func runSeed(store historyStore, args []string) {
+	fs := flag.NewFlagSet("history seed", flag.ExitOnError)
+	days := fs.Int("days", 90, "days of history to generate, ending now")
+	perDay := fs.Int("per-day", 4, "runs per day")
+	modelName := fs.String("profile", "cable-300", "line to model: "+strings.Join(seedModelNames(), ", "))
+	provider := fs.String("provider", "cloudflare", "provider the runs are attributed to")
+	seed := fs.Uint64("seed", 0, "seed for reproducible data")
+	remove := fs.Bool("remove", false, "remove every seeded run instead")
+	fs.Parse(args)
+
+	if *remove {
+		n, err := store.Remove(func(r Results) bool { return r.Synthetic })
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d seeded run(s) from %s\n", n, store.path)
+		return
+	}
+	model, ok := seedModels[*modelName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown profile %q (available: %s)\n", *modelName, strings.Join(seedModelNames(), ", "))
+		os.Exit(2)
+	}
+	if *days < 1 || *perDay < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --days and --per-day must be at least 1")
+		os.Exit(2)
+	}
+
+	runs := seedRuns(model, *provider, *days, *perDay, time.Now(), newRand(*seed))
+	for _, r := range runs {
+		if err := store.Append(r); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Added %d synthetic run(s) over %d days to %s\n", len(runs), *days, store.path)
+	fmt.Println("They are marked synthetic and left out of regression baselines; gofast history seed --remove deletes them.")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seeded is 90 days of cable-300 history at 4 runs a day, ending at now.
+func seeded(seed uint64) (runs []Results, now time.Time) {
+	now = time.Date(2026, 6, 1, 12, 0, 0, 0, time.Local)
+	return seedRuns(seedModels["cable-300"], "cloudflare", 90, 4, now, newRand(seed)), now
+}
+
+// withSyntheticBaselines sets the synthetic_baselines config for the test.
+func withSyntheticBaselines(t *testing.T, on bool) {
+	saved := syntheticBaselines
+	syntheticBaselines = on
+	t.Cleanup(func() { syntheticBaselines = saved })
+}
+
+func downloads(runs []Results, keep func(Results) bool) []float64 {
+	var mbps []float64
+	for _, r := range runs {
+		if keep(r) {
+			mbps = append(mbps, r.Download.Mbps)
+		}
+	}
+	return mbps
+}
+
+func TestSeedRuns(t *testing.T) {
+	runs, now := seeded(1)
+	model := seedModels["cable-300"]
+	if len(runs) != 90*4 {
+		t.Fatalf("%d runs, want %d", len(runs), 90*4)
+	}
+	start, half := now.Add(-90*24*time.Hour), now.Add(-45*24*time.Hour)
+	for i, r := range runs {
+		if !r.Synthetic || r.Provider != "cloudflare" || r.SchemaVersion != SchemaVersion || r.Download == nil || r.Upload == nil {
+			t.Fatalf("run %d isn't a complete synthetic run: %+v", i, r)
+		}
+		if r.Timestamp.Before(start) || r.Timestamp.After(now) || i > 0 && r.Timestamp.Before(runs[i-1].Timestamp) {
+			t.Fatalf("run %d at %s is out of order or outside the 90 days", i, r.Timestamp)
+		}
+		if want := model.ISP; r.Timestamp.After(half) != (r.Client.ISP == model.ISPAfter) {
+			t.Errorf("run %d at %s is on %s; the switch from %s is at %s", i, r.Timestamp, r.Client.ISP, want, half)
+		}
+	}
+
+	again, _ := seeded(1)
+	if !reflect.DeepEqual(runs, again) {
+		t.Error("the same seed generated different history")
+	}
+	if other, _ := seeded(2); reflect.DeepEqual(runs, other) {
+		t.Error("another seed generated the same history")
+	}
+
+	before := func(r Results) bool { return r.Client.ISP == model.ISP }
+	after := func(r Results) bool { return r.Client.ISP == model.ISPAfter }
+	if ratio := median(downloads(runs, after)) / median(downloads(runs, before)); ratio < 1.4 || ratio > 1.8 {
+		t.Errorf("the ISP change moved the median download by %.2f×, want about %g×", ratio, model.ISPFactor)
+	}
+
+	hours := func(from, to int) func(Results) bool {
+		return func(r Results) bool {
+			h := r.Timestamp.Local().Hour()
+			return before(r) && h >= from && h < to
+		}
+	}
+	if ratio := median(downloads(runs, hours(20, 23))) / median(downloads(runs, hours(7, 13))); ratio < 1-model.Congestion-0.1 || ratio > 0.85 {
+		t.Errorf("evenings run at %.2f of mornings, want about %.2f", ratio, 1-model.Congestion)
+	}
+
+	outliers := 0
+	for _, r := range runs {
+		usual := median(downloads(runs, before))
+		if after(r) {
+			usual = median(downloads(runs, after))
+		}
+		if r.Download.Mbps < usual/2 {
+			outliers++
+			if r.Latency.Avg < 2*model.Ping {
+				t.Errorf("outlier at %s kept a usual ping of %.1f ms", r.Timestamp, r.Latency.Avg)
+			}
+		}
+	}
+	if outliers == 0 || outliers > 30 {
+		t.Errorf("%d outliers in %d runs at a %g rate", outliers, len(runs), model.OutlierRate)
+	}
+}
+
+// The history features have something to say about seeded history once
+// synthetic_baselines lets it count.
+func TestSeededHistoryFeatures(t *testing.T) {
+	withSyntheticBaselines(t, true)
+	runs, now := seeded(1)
+	last := runs[len(runs)-1]
+	usual := median(downloads(runs[len(runs)-20:], func(Results) bool { return true }))
+
+	t.Run("regression", func(t *testing.T) {
+		current := Results{Provider: last.Provider, Server: last.Server, Download: &Transfer{Mbps: usual / 4}, Upload: &Transfer{Mbps: last.Upload.Mbps}}
+		r := detectRegression(current, runs, defaultRegressionPolicy)
+		if r == nil || !r.Notable || !r.Download || r.Compared != defaultRegressionPolicy.Window {
+			t.Errorf("a quarter of the usual download: %+v", r)
+		}
+		current.Download.Mbps = usual
+		if r := detectRegression(current, runs, defaultRegressionPolicy); r == nil || r.Notable {
+			t.Errorf("the usual download: %+v", r)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		// Hours are compared on one line, since across the ISP change an
+		// hour's median falls on either side of it, at enough runs a day
+		// that every hour has its usual speed.
+		var line []Results
+		for _, r := range seedRuns(seedModels["cable-300"], "cloudflare", 90, 12, now, newRand(1)) {
+			if r.Client.ISP == last.Client.ISP {
+				line = append(line, r)
+			}
+		}
+		stats := hourlyDownload(line, "cloudflare")
+		for h, s := range stats {
+			if s.Runs < hourlyMinRuns {
+				t.Errorf("%02d:00 has %d seeded runs", h, s.Runs)
+			}
+		}
+		morning := time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0, 0, time.Local)
+		hints := expiryHints(morning, stats)
+		if len(hints) != 1 || hints[0].FromHour < 18 || hints[0].FromHour > 22 || hints[0].ChangePercent >= 0 {
+			t.Fatalf("measured at 10:00, hints %+v; want the evening slowdown", hints)
+		}
+		evening := morning.Add(11 * time.Hour)
+		if hints := expiryHints(evening, stats); len(hints) != 1 || hints[0].ChangePercent <= 0 {
+			t.Errorf("measured at 21:00, hints %+v; want speeds recovering later", hints)
+		}
+	})
+
+	t.Run("percent of median", func(t *testing.T) {
+		e := percentPolicy{Source: percentMedian, Window: defaultRegressionPolicy.Window, MinRuns: defaultRegressionPolicy.MinRuns}.expected("cloudflare", runs)
+		if e == nil || e.Source != percentMedian || e.DownloadMbps != usual || e.UploadMbps <= 0 {
+			t.Fatalf("expectation %+v, want the median of the last 20 runs, %g", e, usual)
+		}
+		if p := percentOf(usual/2, e.DownloadMbps); p != 50 {
+			t.Errorf("half the usual speed is %g%%", p)
+		}
+	})
+
+	t.Run("change between runs", func(t *testing.T) {
+		first, last := runs[0], runs[len(runs)-1]
+		c := compareRuns(first, last)
+		want := fmt.Sprintf("(%+.0f%%)", (last.Download.Mbps-first.Download.Mbps)/first.Download.Mbps*100)
+		if d := c.Metrics[0]; d.Name != "Download" || !strings.Contains(d.change(), want) {
+			t.Errorf("download change %q, want %s", d.change(), want)
+		}
+		if !strings.Contains(c.deltas(), "%)") {
+			t.Errorf("no percent changes in\n%s", c.deltas())
+		}
+	})
+}
+
+// Seeded runs stay out of every baseline real runs are judged by unless
+// synthetic_baselines says otherwise.
+func TestSyntheticOutOfAggregates(t *testing.T) {
+	runs, _ := seeded(1)
+	// Real runs on a much slower line, among the last seeded ones.
+	var history []Results
+	var real []float64
+	for i, r := range runs {
+		history = append(history, r)
+		if i >= len(runs)-40 && i%5 == 0 {
+			mbps := 48 + float64(i%7)
+			real = append(real, mbps)
+			history = append(history, Results{Timestamp: r.Timestamp.Add(time.Minute), Provider: r.Provider, Server: r.Server,
+				Download: &Transfer{Mbps: mbps}, Upload: &Transfer{Mbps: 10}})
+		}
+	}
+	current := Results{Provider: "cloudflare", Server: runs[0].Server, Download: &Transfer{Mbps: 45}, Upload: &Transfer{Mbps: 16, Compressible: true}}
+	policy := regressionPolicy{Window: 20, Percentile: 10, MinRuns: 5}
+
+	for _, synthetic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("synthetic_baselines=%v", synthetic), func(t *testing.T) {
+			withSyntheticBaselines(t, synthetic)
+
+			r := detectRegression(current, history, policy)
+			if got, want := r.Compared, len(real); synthetic != (got != want) {
+				t.Errorf("regression compared %d runs; %d are real", got, want)
+			}
+			if !synthetic && !r.Download {
+				t.Errorf("45 Mbps against a real 48-54: regression %+v", r)
+			}
+
+			e := percentPolicy{Source: percentMedian, Window: policy.Window, MinRuns: policy.MinRuns}.expected("cloudflare", history)
+			if (e.DownloadMbps == median(real)) == synthetic {
+				t.Errorf("percent baseline %g; the real median is %g", e.DownloadMbps, median(real))
+			}
+
+			counted := 0
+			for _, h := range hourlyDownload(history, "cloudflare") {
+				counted += h.Runs
+			}
+			if (counted == len(real)) == synthetic {
+				t.Errorf("hourly speeds count %d runs; %d are real", counted, len(real))
+			}
+
+			baseline, _ := retestPolicy{Factor: 3}.suspect(current, history, policy)
+			if (baseline == median(real)) == synthetic {
+				t.Errorf("retest baseline %g; the real median is %g", baseline, median(real))
+			}
+
+			if compressionSuspected(current, history) == synthetic {
+				t.Errorf("16 Mbps compressible against 10 Mbps real uploads: suspected %v", !synthetic)
+			}
+		})
+	}
+}
+
+func TestSeedRemove(t *testing.T) {
+	store := historyStore{path: filepath.Join(t.TempDir(), "history.jsonl")}
+	real := Results{SchemaVersion: SchemaVersion, Timestamp: time.Now().Add(-time.Hour), Provider: "cloudflare", Download: &Transfer{Mbps: 50}}
+	if err := store.Append(real); err != nil {
+		t.Fatal(err)
+	}
+	runSeed(store, []string{"--days", "3", "--per-day", "2", "--seed", "1"})
+	runs, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1+3*2 {
+		t.Fatalf("%d runs after seeding 6 next to 1", len(runs))
+	}
+	runSeed(store, []string{"--remove"})
+	if runs, _ = store.Load(); len(runs) != 1 || runs[0].Synthetic || !runs[0].Timestamp.Equal(real.Timestamp) {
+		t.Errorf("after --remove: %+v", runs)
+	}
+}
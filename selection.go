@@ -0,0 +1,302 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// serverSelector is implemented by providers that can choose between several
+// servers. The engine health-checks candidates best first and switches the
+// provider to the first one that passes.
+type serverSelector interface {
+	// Candidates returns the servers worth trying, best first.
+	Candidates(ctx context.Context) ([]Server, error)
+	// Handshake runs a lightweight request against s.
+	Handshake(ctx context.Context, s Server) error
+	// Use directs every following phase at s.
+	Use(s Server)
+}
+
+// ServerFallback records a candidate that was skipped because it failed its
+// handshake.
+type ServerFallback struct {
+	Server string `json:"server"`
+	Reason string `json:"reason"`
+}
+
+type fallbackMsg []ServerFallback
+
+// serverProber is implemented by selectors that can measure a candidate's
+// round trip cheaply. Candidates are then re-ranked by measured latency
+// before the handshakes.
+type serverProber interface {
+	ProbeServer(ctx context.Context, s Server) (float64, error)
+}
+
+// connectRTT times a TCP connect to the port the server at rawURL is
+// served on, for racing candidates: the handshake is the cheapest round
+// trip every server answers, whatever software it runs.
+func connectRTT(ctx context.Context, clock Clock, rawURL string) (float64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	if u.Hostname() == "" {
+		return 0, fmt.Errorf("%q names no host", rawURL)
+	}
+	return tcpPing{clock}.Ping(ctx, urlHostPort(u))
+}
+
+// RaceEntry is one candidate's result in the race for the closest server:
+// its connect latency, or why it had none.
+type RaceEntry struct {
+	Server string  `json:"server"`
+	RTTMs  float64 `json:"rtt_ms,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// candidatesMsg is the race among total candidates so far, in the order
+// the results came in.
+type candidatesMsg struct {
+	total int
+	race  []RaceEntry
+}
+
+// summary is the init screen's line on the race, e.g. "Racing 3 servers:
+// cdg 9 ms · fra 14 ms · ams failed".
+func (c candidatesMsg) summary() string {
+	s := "Racing " + strconv.Itoa(c.total) + " servers: " + formatRace(c.race)
+	if left := c.total - len(c.race); left > 0 {
+		s += fmt.Sprintf(" · %d to go", left)
+	}
+	return s
+}
+
+// formatRace lists the candidates' latencies, fastest first and failures
+// last.
+func formatRace(entries []RaceEntry) string {
+	race := slices.Clone(entries)
+	slices.SortStableFunc(race, func(a, b RaceEntry) int {
+		switch {
+		case a.Error != "" && b.Error != "":
+			return 0
+		case a.Error != "":
+			return 1
+		case b.Error != "":
+			return -1
+		}
+		return cmp.Compare(a.RTTMs, b.RTTMs)
+	})
+	parts := make([]string, len(race))
+	for i, r := range race {
+		switch {
+		case r.Error != "":
+			parts[i] = r.Server + " failed"
+		case r.RTTMs < 10:
+			parts[i] = fmt.Sprintf("%s %.1f ms", r.Server, r.RTTMs)
+		default:
+			parts[i] = fmt.Sprintf("%s %.0f ms", r.Server, r.RTTMs)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// serverPick is the outcome of server selection: the server, the
+// candidates that failed before it and, under a sticky policy, how it was
+// chosen. Race holds the candidates' latencies when they were raced.
+type serverPick struct {
+	Server    Server
+	Fallbacks []ServerFallback
+	Choice    *ServerChoice
+	Change    *ServerChange
+	Race      []RaceEntry
+	// Others are the next candidates that passed their handshake, best
+	// first, when the engine wants several servers with --servers.
+	Others []Server
+}
+
+// selectServer picks the provider's server, falling back through ranked
+// candidates when the provider offers them. Under a sticky policy the last
+// run's server is kept, without ranking the others, while the policy
+// allows.
+func (e engine) selectServer(ctx context.Context, emit func(tea.Msg)) (serverPick, error) {
+	selector, ok := e.provider.(serverSelector)
+	if !ok {
+		s, err := e.provider.Server(ctx)
+		return serverPick{Server: s}, err
+	}
+
+	candidates, err := selector.Candidates(ctx)
+	if err != nil {
+		return serverPick{}, err
+	}
+	var pick serverPick
+	var rtt map[string]float64
+	prober, probes := selector.(serverProber)
+	sticky := e.sticky != nil && probes
+	kept := -1
+	if sticky {
+		kept, pick.Choice, pick.Change = e.stick(ctx, candidates, prober)
+		if ctx.Err() != nil {
+			return serverPick{}, ctx.Err()
+		}
+	}
+	if kept >= 0 {
+		candidates = append([]Server{candidates[kept]}, slices.Delete(slices.Clone(candidates), kept, kept+1)...)
+	} else if probes && len(candidates) > 1 {
+		pool := defaultProbePool
+		pool.Probe = prober.ProbeServer
+		emit(candidatesMsg{total: len(candidates)})
+		candidates, rtt = pool.rankByLatency(ctx, candidates, func(r probeResult) {
+			entry := RaceEntry{Server: r.Server.Name, RTTMs: r.RTT}
+			if r.Err != nil {
+				entry.Error = r.Err.Error()
+			}
+			pick.Race = append(pick.Race, entry)
+			emit(candidatesMsg{total: len(candidates), race: slices.Clone(pick.Race)})
+		})
+		if ctx.Err() != nil {
+			return serverPick{}, ctx.Err()
+		}
+	}
+	if i := slices.IndexFunc(candidates, func(s Server) bool { return s.Name == e.avoidServer }); i >= 0 {
+		avoided := candidates[i]
+		candidates = append(slices.Delete(slices.Clone(candidates), i, i+1), avoided)
+	}
+	if i := slices.IndexFunc(candidates, func(s Server) bool { return s.Name == e.preferServer }); i > 0 {
+		preferred := candidates[i]
+		candidates = append([]Server{preferred}, slices.Delete(slices.Clone(candidates), i, i+1)...)
+	}
+	var errs []error
+	found := false
+	for _, s := range candidates {
+		if found && 1+len(pick.Others) >= e.servers {
+			break
+		}
+		if err := selector.Handshake(ctx, s); err != nil {
+			if ctx.Err() != nil {
+				return pick, ctx.Err()
+			}
+			if !found {
+				// Past the first server, a candidate that fails is
+				// only one fewer to average over.
+				pick.Fallbacks = append(pick.Fallbacks, ServerFallback{Server: s.Name, Reason: err.Error()})
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if found {
+			pick.Others = append(pick.Others, s)
+			continue
+		}
+		found = true
+		selector.Use(s)
+		pick.Server = s
+		if sticky {
+			pick.sticky(e.clock.Now(), rtt)
+		}
+	}
+	if found {
+		return pick, nil
+	}
+	pick.Choice, pick.Change = nil, nil
+	return pick, &serverSelectionError{Errs: errs}
+}
+
+// sticky completes the record of a choice made under a sticky policy once
+// the server has passed its handshake. A server that wasn't kept was
+// chosen now, and is a change when the last run used another.
+func (p *serverPick) sticky(now time.Time, rtt map[string]float64) {
+	if p.Choice != nil {
+		if len(p.Fallbacks) == 0 {
+			return
+		}
+		// The kept server failed its handshake.
+		p.Change = &ServerChange{From: p.Fallbacks[0].Server, Reason: "it failed its handshake: " + p.Fallbacks[0].Reason}
+	}
+	p.Choice = &ServerChoice{EvaluatedAt: now, BaselineMs: rtt[p.Server.Name], RTTMs: rtt[p.Server.Name]}
+	if p.Change == nil {
+		return
+	}
+	if p.Change.From == p.Server.Name {
+		p.Change = nil
+		return
+	}
+	p.Change.To = p.Server.Name
+}
+
+// serverSelectionError reports that every candidate failed its handshake,
+// keeping each failure for errors.Is and errors.As.
+type serverSelectionError struct {
+	Errs []error
+}
+
+func (e *serverSelectionError) Error() string {
+	return fmt.Sprintf("no server passed its handshake (%d tried)", len(e.Errs))
+}
+
+func (e *serverSelectionError) Unwrap() []error { return e.Errs }
+
+// fallbackNote summarizes a fallback for the header and results, e.g.
+// "primary server BOM failed handshake (503), used DEL instead".
+func fallbackNote(fallbacks []ServerFallback, used Server) string {
+	if len(fallbacks) == 0 {
+		return ""
+	}
+	first := fallbacks[0]
+	note := fmt.Sprintf("primary server %s failed handshake (%s), used %s instead", first.Server, first.Reason, used.Name)
+	if len(fallbacks) > 1 {
+		note += fmt.Sprintf(" after %d failures", len(fallbacks))
+	}
+	return note
+}
+
+// handshakeTimeout bounds how long a healthy server may take to answer.
+const handshakeTimeout = 3 * time.Second
+
+var errSlowHandshake = errors.New("too slow")
+
+// httpHandshake fetches at most 1 KB from url and checks that the server
+// answers in time with a success status over HTTP/1.1 or later.
+func httpHandshake(ctx context.Context, client *http.Client, clock Clock, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-1023")
+
+	start := clock.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errSlowHandshake
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("%d", resp.StatusCode)
+	case resp.ProtoMajor < 1 || (resp.ProtoMajor == 1 && resp.ProtoMinor < 1):
+		return fmt.Errorf("unsupported protocol %s", resp.Proto)
+	case clock.Since(start) > handshakeTimeout:
+		return errSlowHandshake
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+// serveMaxBytes bounds what one download request may ask a peer for.
+const serveMaxBytes = 1 << 30
+
+// peerHandler is what gofast serve answers speed tests with: a GET sends
+// the number of bytes its bytes query parameter asks for, urlChunk when it
+// doesn't say, and a POST takes an upload body and throws it away. It is
+// the ?bytes= generator and upload URL --provider lan expects.
+type peerHandler struct{}
+
+func (peerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		n := int64(urlChunk)
+		if b := r.URL.Query().Get("bytes"); b != "" {
+			v, err := strconv.ParseInt(b, 10, 64)
+			if err != nil || v < 0 {
+				http.Error(w, "bytes must be a whole number of bytes", http.StatusBadRequest)
+				return
+			}
+			n = min(v, serveMaxBytes)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodGet {
+			io.CopyN(w, newPayloadReader(false), n)
+		}
+	case http.MethodPost:
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "speed tests GET and POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// runServe implements "gofast serve": a peer on the local network for
+// another machine's gofast --lan to test against, answering its discovery
+// broadcasts so it needn't be given an address.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address to serve speed tests on")
+	name := fs.String("name", "", "name to announce to gofast --lan (default the hostname)")
+	noAnnounce := fs.Bool("no-announce", false, "don't answer gofast --lan discovery; clients then need --server")
+	fs.Parse(args)
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !*noAnnounce {
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: lanDiscoveryPort})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: discovery: %v (--no-announce serves without it)\n", err)
+			os.Exit(1)
+		}
+		go answerDiscovery(ctx, conn, lanAnnouncement{Name: *name, Port: ln.Addr().(*net.TCPAddr).Port})
+	}
+
+	srv := &http.Server{Handler: peerHandler{}, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	if *noAnnounce {
+		fmt.Fprintf(os.Stderr, "Serving speed tests on %s\n", ln.Addr())
+	} else {
+		fmt.Fprintf(os.Stderr, "Serving speed tests on %s, found by gofast --lan on UDP port %d\n", ln.Addr(), lanDiscoveryPort)
+	}
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-runewidth"
+)
+
+// serverMapRange is the latency at the right end of the map's axis, in ms.
+// Slower servers are drawn at the end, labelled "200+".
+const serverMapRange = 200.0
+
+// serverMapLanes is how many rows of labels the map stacks under its axis
+// before leaving servers unlabelled.
+const serverMapLanes = 4
+
+// serverMap plots the provider's candidate servers by measured latency. The
+// results arrive one by one from the probe pool; gen tells a refresh's
+// results from those of the probe it replaced.
+type serverMap struct {
+	gen     int
+	results []probeResult
+	cursor  int
+	probing bool
+	err     error
+	cancel  context.CancelFunc
+}
+
+type serverMapMsg struct {
+	gen    int
+	result *probeResult
+	err    error
+	next   <-chan probeResult
+}
+
+// canMapServers reports whether the provider has several servers and can
+// measure each one's latency.
+func (m speedTest) canMapServers() bool {
+	sel, ok := m.engine.provider.(serverSelector)
+	if !ok {
+		return false
+	}
+	_, ok = sel.(serverProber)
+	return ok
+}
+
+func (m speedTest) openServerMap() (tea.Model, tea.Cmd) {
+	m.serverMap = &serverMap{}
+	return m.probeServers()
+}
+
+// probeServers (re)measures every candidate, dropping earlier results.
+func (m speedTest) probeServers() (tea.Model, tea.Cmd) {
+	sm := m.serverMap
+	if sm.cancel != nil {
+		sm.cancel()
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	sm.gen++
+	sm.results, sm.cursor, sm.probing, sm.err, sm.cancel = nil, 0, true, nil, cancel
+	gen, sel := sm.gen, m.engine.provider.(serverSelector)
+	return m, func() tea.Msg {
+		candidates, err := sel.Candidates(ctx)
+		if err != nil {
+			return serverMapMsg{gen: gen, err: err}
+		}
+		pool := defaultProbePool
+		pool.Probe = sel.(serverProber).ProbeServer
+		return waitForProbe(gen, pool.Run(ctx, candidates))()
+	}
+}
+
+func waitForProbe(gen int, results <-chan probeResult) tea.Cmd {
+	return func() tea.Msg {
+		r, ok := <-results
+		if !ok {
+			return serverMapMsg{gen: gen}
+		}
+		return serverMapMsg{gen: gen, result: &r, next: results}
+	}
+}
+
+// addProbe places a result on the map, keeping the selected server selected
+// as faster ones arrive.
+func (sm *serverMap) addProbe(msg serverMapMsg) tea.Cmd {
+	if msg.gen != sm.gen {
+		return nil
+	}
+	if msg.result == nil {
+		sm.probing, sm.err, sm.cancel = false, msg.err, nil
+		return nil
+	}
+	var selected string
+	if sm.cursor < len(sm.results) {
+		selected = sm.results[sm.cursor].Server.Name
+	}
+	sm.results = append(sm.results, *msg.result)
+	slices.SortStableFunc(sm.results, func(a, b probeResult) int {
+		switch {
+		case a.Err == nil && b.Err == nil:
+			return cmp.Compare(a.RTT, b.RTT)
+		case a.Err == nil:
+			return -1
+		case b.Err == nil:
+			return 1
+		}
+		return 0
+	})
+	sm.cursor = max(slices.IndexFunc(sm.results, func(r probeResult) bool { return r.Server.Name == selected }), 0)
+	return waitForProbe(msg.gen, msg.next)
+}
+
+// reachable is how many servers answered; they sort first.
+func (sm *serverMap) reachable() int {
+	n := 0
+	for _, r := range sm.results {
+		if r.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// updateServerMap handles keys while the map is open: left/right choose a
+// server, enter runs a test against it, 'r' probes again and 'm' or esc
+// returns to the results.
+func (m speedTest) updateServerMap(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	sm := m.serverMap
+	n := sm.reachable()
+	switch msg.String() {
+	case "m", "esc":
+		if sm.cancel != nil {
+			sm.cancel()
+		}
+		m.serverMap = nil
+	case "left", "h", "up", "k":
+		if n > 0 {
+			sm.cursor = (sm.cursor + n - 1) % n
+		}
+	case "right", "l", "down", "j":
+		if n > 0 {
+			sm.cursor = (sm.cursor + 1) % n
+		}
+	case "r":
+		return m.probeServers()
+	case "enter":
+		if n == 0 {
+			break
+		}
+		if sm.cancel != nil {
+			sm.cancel()
+		}
+		m.engine.preferServer = sm.results[sm.cursor].Server.Name
+		return m.restart()
+	}
+	return m, nil
+}
+
+// mapColumn is where a round trip falls on an axis width columns wide.
+func mapColumn(rtt float64, width int) int {
+	return min(int(rtt/serverMapRange*float64(width-1)+0.5), width-1)
+}
+
+// view draws the map: a marker per column holding servers, with the number
+// of servers when several share it, an axis, and labels stacked in lanes so
+// they don't overlap. The selected server's details follow.
+func (sm *serverMap) view(width int) string {
+	var s strings.Builder
+	s.WriteString("Server latency map\n\n")
+	if sm.err != nil {
+		s.WriteString(fmt.Sprintf("\033[31mCouldn't list servers: %v\033[0m\n", sm.err))
+		s.WriteString("\n\033[90m'r' to try again, 'm' to return to the results\033[0m")
+		return s.String()
+	}
+	n := sm.reachable()
+	if n == 0 {
+		if sm.probing {
+			s.WriteString("Probing servers...\n")
+		} else {
+			s.WriteString("\033[33mNo server answered.\033[0m\n")
+		}
+	}
+
+	if n > 0 {
+		width = min(max(width-4, 40), 100)
+		buckets := make(map[int][]int)
+		var columns []int
+		for i, r := range sm.results[:n] {
+			c := mapColumn(r.RTT, width)
+			if len(buckets[c]) == 0 {
+				columns = append(columns, c)
+			}
+			buckets[c] = append(buckets[c], i)
+		}
+		selected := mapColumn(sm.results[sm.cursor].RTT, width)
+
+		markers := []rune(strings.Repeat(" ", width))
+		for _, c := range columns {
+			switch k := len(buckets[c]); {
+			case k == 1:
+				markers[c] = '●'
+			case k <= 9:
+				markers[c] = rune('0' + k)
+			default:
+				markers[c] = '+'
+			}
+		}
+		s.WriteString("  " + string(markers[:selected]))
+		s.WriteString("\033[36;1m" + string(markers[selected]) + "\033[0m")
+		s.WriteString(string(markers[selected+1:]) + "\n")
+
+		axis := []rune(strings.Repeat("─", width))
+		ticks := []rune(strings.Repeat(" ", width+6))
+		for ms := 0.0; ms <= serverMapRange; ms += 50 {
+			c := mapColumn(ms, width)
+			axis[c] = '┼'
+			label := fmt.Sprint(ms)
+			if ms == serverMapRange {
+				label += "+ ms"
+			}
+			copy(ticks[max(c-len(label)/2, 0):], []rune(label))
+		}
+		axis[0], axis[width-1] = '├', '┤'
+		s.WriteString("  " + string(axis) + "\n")
+		s.WriteString("  \033[90m" + strings.TrimRight(string(ticks), " ") + "\033[0m\n")
+
+		lanes := make([][]rune, serverMapLanes)
+		for i := range lanes {
+			lanes[i] = []rune(strings.Repeat(" ", width))
+		}
+		unlabelled := 0
+		for _, c := range columns {
+			label := serverMapLabel(sm, buckets[c])
+			start := max(min(c, width-len(label)), 0)
+			placed := false
+			for _, lane := range lanes {
+				if strings.TrimSpace(string(lane[max(start-1, 0):min(start+len(label)+1, width)])) != "" {
+					continue
+				}
+				copy(lane[start:], label)
+				placed = true
+				break
+			}
+			if !placed {
+				unlabelled += len(buckets[c])
+			}
+		}
+		for _, lane := range lanes {
+			if line := strings.TrimRight(strings.ReplaceAll(string(lane), "\x00", ""), " "); line != "" {
+				s.WriteString("  " + line + "\n")
+			}
+		}
+		if unlabelled > 0 {
+			s.WriteString(fmt.Sprintf("  \033[90m%d more not labelled; ←/→ to step through them\033[0m\n", unlabelled))
+		}
+
+		r := sm.results[sm.cursor]
+		s.WriteString(fmt.Sprintf("\n\033[36;1m▸ %s\033[0m", r.Server.Name))
+		if r.Server.Location != "" && r.Server.Location != r.Server.Name {
+			s.WriteString(" (" + r.Server.Location + ")")
+		}
+		s.WriteString(fmt.Sprintf("  %.0f ms, median of %d probes\n", r.RTT, r.Samples))
+	}
+
+	if n < len(sm.results) {
+		var names []string
+		for _, r := range sm.results[n:] {
+			names = append(names, r.Server.Name)
+		}
+		s.WriteString(fmt.Sprintf("\033[90mNo answer from %s\033[0m\n", strings.Join(names, ", ")))
+	}
+	if sm.probing && n > 0 {
+		s.WriteString(fmt.Sprintf("\033[90mProbing... %d answered so far\033[0m\n", n))
+	}
+	s.WriteString("\n\033[90m←/→ to choose, enter to test against it, 'r' to probe again, 'm' to return to the results\033[0m")
+	return s.String()
+}
+
+// serverMapLabel names the servers of one column: the selected one if it is
+// there, otherwise the fastest, with how many others share the column. It
+// returns one rune per column, a wide rune followed by a zero.
+func serverMapLabel(sm *serverMap, bucket []int) []rune {
+	shown := bucket[0]
+	if slices.Contains(bucket, sm.cursor) {
+		shown = sm.cursor
+	}
+	label := fmt.Sprintf("%s %.0f", truncate(sm.results[shown].Server.Name, 18), sm.results[shown].RTT)
+	if len(bucket) > 1 {
+		label += fmt.Sprintf(" +%d", len(bucket)-1)
+	}
+	var cells []rune
+	for _, r := range label {
+		cells = append(cells, r)
+		if runewidth.RuneWidth(r) == 2 {
+			cells = append(cells, 0)
+		}
+	}
+	return cells
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+)
+
+var longNames = []Server{
+	{Name: "Frankfurt am Main Internet Exchange — Deutsche Telekom Backbone Node 7"},
+	{Name: "東京都千代田区大手町データセンター第三計算機室バックボーン"},
+	{Name: "sp", Location: "São Paulo, Brazil"},
+}
+
+func TestServerLineGolden(t *testing.T) {
+	saved := term
+	defer func() { term = saved }()
+	term = newTerminal(termenv.ANSI, true)
+
+	var s strings.Builder
+	for _, width := range []int{0, 40} {
+		for _, srv := range longNames {
+			m := speedTest{width: width}
+			m.results.Server = srv
+			line := m.serverLine("Tested via: ")
+			s.WriteString(line)
+			if width == 0 {
+				continue
+			}
+			for _, l := range strings.Split(strings.TrimRight(sgrPattern.ReplaceAllString(line, ""), "\n"), "\n") {
+				if w := runewidth.StringWidth(l); w > width {
+					t.Errorf("%q is %d columns wide on a %d-column terminal", l, w, width)
+				}
+			}
+		}
+	}
+	checkGolden(t, "server-lines", sgrPattern.ReplaceAllString(s.String(), ""))
+}
+
+// The details panel shows the name whole, however narrow the terminal.
+func TestServerDetailsWhole(t *testing.T) {
+	m := speedTest{width: 40}
+	m.results.Server = longNames[1]
+	for _, sec := range m.detailSections() {
+		for _, row := range sec.rows {
+			if row[0] == "Server" {
+				if want := longNames[1].Name; row[1] != want {
+					t.Errorf("details show %q, want %q", row[1], want)
+				}
+				return
+			}
+		}
+	}
+	t.Error("the details have no Server row")
+}
+
+func TestServerMapGolden(t *testing.T) {
+	saved := term
+	defer func() { term = saved }()
+	term = newTerminal(termenv.ANSI, true)
+
+	sm := &serverMap{}
+	results := []probeResult{{Server: Server{Name: "大阪"}, RTT: 14, Samples: 3}}
+	for i, srv := range longNames {
+		results = append(results, probeResult{Server: srv, RTT: float64(12 + 40*i), Samples: 3})
+	}
+	results = append(results, probeResult{Server: Server{Name: "unreachable.example"}, Err: errors.New("timeout")})
+	for _, r := range results {
+		sm.addProbe(serverMapMsg{result: &r})
+	}
+	got := sgrPattern.ReplaceAllString(sm.view(64), "")
+	checkGolden(t, "server-map", got)
+}
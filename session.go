@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// sessionRetention bounds the runs a long-lived TUI keeps for the session
+// views; the oldest are dropped first.
+const sessionRetention = 100
+
+// appendSession adds r to runs without its raw samples, which the session
+// views never show.
+func appendSession(runs []Results, r Results) []Results {
+	r.Latency.Samples, r.Samples = nil, nil
+	for _, t := range []**Transfer{&r.Download, &r.Upload} {
+		if *t != nil {
+			stripped := **t
+			stripped.Samples, stripped.LatencySamples = nil, nil
+			*t = &stripped
+		}
+	}
+	if len(runs) >= sessionRetention {
+		// Copy rather than reslice so the dropped runs can be collected.
+		runs = append(make([]Results, 0, sessionRetention), runs[len(runs)-sessionRetention+1:]...)
+	}
+	return append(runs, r)
+}
+
+// sessionStrip is the one-line summary of every run in this TUI session,
+// e.g. "This session: 84.1 / 81.0 / 88.2 Mbps down".
+func sessionStrip(runs []Results) string {
+	if len(runs) < 2 {
+		return ""
+	}
+	speeds := make([]string, len(runs))
+	for i, r := range runs {
+		speeds[i] = fmt.Sprintf("%.1f", transferMbps(r.Download))
+	}
+	return "This session: " + strings.Join(speeds, " / ") + " Mbps down"
+}
+
+// sessionNoteWidth is how much of each run's note the tables show.
+const sessionNoteWidth = 30
+
+// sessionTable lists the runs of this session for comparison, with the
+// difference of each from the first run.
+func sessionTable(runs []Results) string {
+	var s strings.Builder
+	noted := slices.ContainsFunc(runs, func(r Results) bool { return r.Note != "" })
+	s.WriteString(fmt.Sprintf("%-3s %-8s %12s %12s %10s %9s", "#", "Time", "Download", "Upload", "Ping", "Δ down"))
+	if noted {
+		s.WriteString("  Note")
+	}
+	s.WriteString("\n")
+	for i, r := range runs {
+		upload := "n/a"
+		if r.Upload != nil {
+			upload = formatSpeed(r.Upload.Mbps)
+		}
+		delta := ""
+		if i > 0 {
+			if base := transferMbps(runs[0].Download); base > 0 {
+				delta = fmt.Sprintf("%+.1f%%", (transferMbps(r.Download)-base)/base*100)
+			}
+		}
+		s.WriteString(fmt.Sprintf("%-3d %-8s %12s %12s %10s %9s",
+			i+1, r.Timestamp.Format("15:04:05"), formatSpeed(transferMbps(r.Download)), upload, formatPing(r.Latency), delta))
+		if noted {
+			s.WriteString("  " + truncate(r.Note, sessionNoteWidth))
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+// exitSummary is what stays in the scrollback once the TUI's alternate
+// screen is gone: the latest completed run in plain text.
+func exitSummary(runs []Results) string {
+	r := runs[len(runs)-1]
+	var s strings.Builder
+	s.WriteString("gofast " + r.Timestamp.Local().Format("2006-01-02 15:04:05"))
+	if label := r.Server.Label(); label != "" {
+		s.WriteString(" via " + truncate(label, 60))
+	}
+	s.WriteString("\n")
+	fmt.Fprintf(&s, "  Download  %s %s\n", formatSpeed(transferMbps(r.Download)), gradeSpeed(transferMbps(r.Download)).cue().Tag)
+	if r.Upload != nil {
+		fmt.Fprintf(&s, "  Upload    %s %s\n", formatSpeed(r.Upload.Mbps), gradeSpeed(r.Upload.Mbps).cue().Tag)
+	}
+	fmt.Fprintf(&s, "  Ping      %s\n", formatPingJitter(r.Latency))
+	if r.Note != "" {
+		fmt.Fprintf(&s, "  Note      %s\n", r.Note)
+	}
+	if len(runs) > 1 {
+		fmt.Fprintf(&s, "  (latest of %d runs this session; --session-summary lists them all)\n", len(runs))
+	}
+	return s.String()
+}
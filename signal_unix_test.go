@@ -0,0 +1,233 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gofastArgsEnv holds, in the child processes of these tests, the
+// arguments gofast runs with there, one per line.
+const gofastArgsEnv = "GOFAST_CHILD_ARGS"
+
+// runGofastChild runs main when this is a child process a test started
+// with gofastCommand, and reports whether it was.
+func runGofastChild() bool {
+	args, ok := os.LookupEnv(gofastArgsEnv)
+	if !ok {
+		return false
+	}
+	os.Args = append([]string{"gofast"}, strings.Split(args, "\n")...)
+	main()
+	return true
+}
+
+// gofastCommand runs test again in a child process, as gofast with args
+// and with config in its config file, whose path it returns. The child's
+// stderr is collected for failure messages.
+func gofastCommand(t *testing.T, test, config string, args ...string) (cmd *exec.Cmd, stderr *syncBuffer, configPath string) {
+	t.Helper()
+	home := t.TempDir()
+	dir := filepath.Join(home, ".config")
+	if runtime.GOOS == "darwin" {
+		dir = filepath.Join(home, "Library", "Application Support")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "gofast"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	configPath = filepath.Join(dir, "gofast", "config.toml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(os.Args[0], "-test.run=^"+test+"$")
+	cmd.Env = append(os.Environ(), gofastArgsEnv+"="+strings.Join(args, "\n"), "HOME="+home, "XDG_CONFIG_HOME="+filepath.Join(home, ".config"))
+	stderr = &syncBuffer{}
+	cmd.Stderr = stderr
+	return cmd, stderr, configPath
+}
+
+// waitExit waits up to d for cmd to exit and returns its exit code.
+func waitExit(t *testing.T, cmd *exec.Cmd, stderr *syncBuffer, d time.Duration) int {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+		return cmd.ProcessState.ExitCode()
+	case <-time.After(d):
+		cmd.Process.Kill()
+		<-done
+		t.Fatalf("gofast still running after %s; stderr:\n%s", d, stderr)
+	}
+	return 0
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// downloadStarted wraps h to close the returned channel when the first
+// transfer request, rather than a ranged ping, arrives.
+func downloadStarted(h http.Handler) (http.Handler, <-chan struct{}) {
+	started := make(chan struct{})
+	var once sync.Once
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/download" && r.Header.Get("Range") == "" {
+			once.Do(func() { close(started) })
+		}
+		h.ServeHTTP(w, r)
+	}), started
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}, what string, stderr *syncBuffer) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(15 * time.Second):
+		t.Fatalf("no %s within 15s; stderr:\n%s", what, stderr)
+	}
+}
+
+// headlessArgs run gofast headless against srv, with a download long
+// enough to be interrupted.
+func headlessArgs(srv *httptest.Server, output string) []string {
+	return []string{"--provider", "url", "--url", srv.URL + "/download", "--upload-url", srv.URL + "/upload",
+		"--quick", "--duration", "30s", "--json", "--output", output, "--no-history", "--no-wizard"}
+}
+
+func readResults(t *testing.T, path string) Results {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Results
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("%s: %v\n%s", path, err, data)
+	}
+	return r
+}
+
+func TestInterruptWritesPartialResults(t *testing.T) {
+	if runGofastChild() {
+		return
+	}
+	handler, started := downloadStarted(endlessSpeedHandler())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	dir := t.TempDir()
+	output, sinkPath := filepath.Join(dir, "out.json"), filepath.Join(dir, "sink.json")
+	cmd, stderr, _ := gofastCommand(t, "TestInterruptWritesPartialResults",
+		"[[sink]]\ntype = \"file\"\npath = \""+sinkPath+"\"\n", headlessArgs(srv, output)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, started, "download", stderr)
+	time.Sleep(time.Second)
+	cmd.Process.Signal(os.Interrupt)
+
+	if code := waitExit(t, cmd, stderr, 10*time.Second); code != exitPartial {
+		t.Fatalf("exit code %d, want %d; stderr:\n%s", code, exitPartial, stderr)
+	}
+	if !strings.Contains(stderr.String(), "Interrupted") {
+		t.Errorf("no notice of the interrupt on stderr:\n%s", stderr)
+	}
+	r := readResults(t, output)
+	if !r.Partial {
+		t.Error("the results aren't marked partial")
+	}
+	if r.Download == nil || r.Download.Bytes == 0 || r.Download.Mbps <= 0 {
+		t.Errorf("the interrupted download wasn't kept: %+v", r.Download)
+	}
+	if r.Upload != nil {
+		t.Errorf("the upload ran after the interrupt: %+v", r.Upload)
+	}
+	if sink := readResults(t, sinkPath); !sink.Partial || !sink.Timestamp.Equal(r.Timestamp) {
+		t.Errorf("the sink got %v partial %v, want the partial run of %v", sink.Timestamp, sink.Partial, r.Timestamp)
+	}
+}
+
+func TestSecondInterruptQuits(t *testing.T) {
+	if runGofastChild() {
+		return
+	}
+	handler, started := downloadStarted(endlessSpeedHandler())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	// The webhook never answers, so gofast is still sending the partial
+	// results when the second interrupt comes.
+	posted := make(chan struct{})
+	var once sync.Once
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is read first so the server notices gofast going away.
+		io.Copy(io.Discard, r.Body)
+		once.Do(func() { close(posted) })
+		<-r.Context().Done()
+	}))
+	defer hook.Close()
+	output := filepath.Join(t.TempDir(), "out.json")
+	cmd, stderr, _ := gofastCommand(t, "TestSecondInterruptQuits",
+		"[[sink]]\ntype = \"webhook\"\nurl = \""+hook.URL+"\"\ntimeout = \"1m\"\n", headlessArgs(srv, output)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, started, "download", stderr)
+	cmd.Process.Signal(os.Interrupt)
+	waitFor(t, posted, "webhook post", stderr)
+	cmd.Process.Signal(os.Interrupt)
+
+	if code := waitExit(t, cmd, stderr, 5*time.Second); code != exitForced {
+		t.Fatalf("exit code %d, want %d; stderr:\n%s", code, exitForced, stderr)
+	}
+	if r := readResults(t, output); !r.Partial {
+		t.Error("the output written before the second interrupt isn't marked partial")
+	}
+}
+
+// countingHook counts the results posted to it and signals each one.
+type countingHook struct {
+	*httptest.Server
+	mu    sync.Mutex
+	posts int
+	got   chan struct{}
+}
+
+func newCountingHook() *countingHook {
+	h := &countingHook{got: make(chan struct{}, 64)}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.posts++
+		h.mu.Unlock()
+		h.got <- struct{}{}
+	}))
+	return h
+}
+
+func (h *countingHook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.posts
+}
+
+// webhookConfig is a config sending results to each of urls.
+func webhookConfig(urls ...string) string {
+	var b bytes.Buffer
+	for _, u := range urls {
+		b.WriteString("[[sink]]\ntype = \"webhook\"\nurl = \"" + u + "\"\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Sink delivers finished results somewhere after each run.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, r Results) error
+}
+
+// sinkTypes builds a sink from the options of one [[sink]] table in the
+// config. New sink types only need an entry here.
+var sinkTypes = map[string]func(opts map[string]string) (Sink, error){
+	"file":    newFileSink,
+	"webhook": newWebhookSink,
+}
+
+// defaultSinkTimeout bounds a sink without its own timeout option.
+const defaultSinkTimeout = 10 * time.Second
+
+// configuredSink is a sink together with its per-sink timeout.
+type configuredSink struct {
+	Sink
+	timeout time.Duration
+}
+
+// newSinks builds the sinks declared in the config, in order.
+func newSinks(decls []map[string]string) ([]configuredSink, error) {
+	var sinks []configuredSink
+	for i, opts := range decls {
+		newSink, ok := sinkTypes[opts["type"]]
+		if !ok {
+			return nil, fmt.Errorf("sink %d: unknown type %q (available: %s)", i+1, opts["type"], strings.Join(sinkTypeNames(), ", "))
+		}
+		s, err := newSink(opts)
+		if err != nil {
+			return nil, fmt.Errorf("sink %d (%s): %w", i+1, opts["type"], err)
+		}
+		timeout := defaultSinkTimeout
+		if v := opts["timeout"]; v != "" {
+			if timeout, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("sink %d (%s): timeout: %w", i+1, opts["type"], err)
+			}
+		}
+		sinks = append(sinks, configuredSink{Sink: s, timeout: timeout})
+	}
+	return sinks, nil
+}
+
+func sinkTypeNames() []string {
+	names := make([]string, 0, len(sinkTypes))
+	for name := range sinkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sinkReport is the outcome of one dispatch: how many sinks ran and the
+// error of each that failed, in declaration order.
+type sinkReport struct {
+	Total    int
+	Failures []sinkFailure
+}
+
+type sinkFailure struct {
+	Sink string
+	Err  error
+}
+
+type sinkReportMsg sinkReport
+
+// String summarizes a dispatch, e.g. "2/3 sinks succeeded; mqtt: connection
+// refused".
+func (r sinkReport) String() string {
+	s := fmt.Sprintf("%d/%d sinks succeeded", r.Total-len(r.Failures), r.Total)
+	for _, f := range r.Failures {
+		s += fmt.Sprintf("; %s: %v", f.Sink, f.Err)
+	}
+	return s
+}
+
+// dispatch writes r to every sink concurrently, each under its own timeout.
+func dispatch(ctx context.Context, sinks []configuredSink, r Results) sinkReport {
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			errs[i] = s.Write(ctx, r)
+		}()
+	}
+	wg.Wait()
+
+	report := sinkReport{Total: len(sinks)}
+	for i, err := range errs {
+		if err != nil {
+			report.Failures = append(report.Failures, sinkFailure{Sink: sinks[i].Name(), Err: err})
+		}
+	}
+	return report
+}
+
+func dispatchCmd(sinks []configuredSink, r Results) tea.Cmd {
+	return func() tea.Msg {
+		return sinkReportMsg(dispatch(context.Background(), sinks, r))
+	}
+}
+
+// fileSink writes results to a file in one of the --format formats. The path
+// takes the same strftime placeholders as --output.
+type fileSink struct {
+	path  string
+	mkdir bool
+	write func(io.Writer, Results) error
+}
+
+func newFileSink(opts map[string]string) (Sink, error) {
+	path := opts["path"]
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	format := opts["format"]
+	if format == "" {
+		format = formatForPath(path)
+	}
+	write, err := outputWriter(format)
+	if err != nil {
+		return nil, err
+	}
+	return fileSink{path: path, mkdir: opts["mkdir"] == "true", write: write}, nil
+}
+
+func (s fileSink) Name() string { return "file " + s.path }
+
+func (s fileSink) Write(ctx context.Context, r Results) error {
+	path := expandOutputPath(s.path, r.Timestamp)
+	return writeOutput(path, s.mkdir, func(w io.Writer) error { return s.write(w, r) })
+}
+
+// webhookSink POSTs the results as JSON. It is named by host only, since
+// webhook URLs often embed tokens. For a `gofast collect` receiver, site
+// labels the results and token is sent as a bearer token.
+type webhookSink struct {
+	url   string
+	host  string
+	site  string
+	token string
+}
+
+func newWebhookSink(opts map[string]string) (Sink, error) {
+	if opts["url"] == "" {
+		return nil, errors.New("url is required")
+	}
+	u, err := url.Parse(opts["url"])
+	if err != nil {
+		return nil, err
+	}
+	if opts["site"] != "" && !siteLabel.MatchString(opts["site"]) {
+		return nil, fmt.Errorf("site %q must be 1 to 64 letters, digits, dots, dashes or underscores", opts["site"])
+	}
+	return webhookSink{url: opts["url"], host: u.Host, site: opts["site"], token: opts["token"]}, nil
+}
+
+func (s webhookSink) Name() string { return "webhook " + s.host }
+
+func (s webhookSink) Write(ctx context.Context, r Results) error {
+	if s.site != "" {
+		r.Site = s.site
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
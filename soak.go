@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// soakRetention bounds the points kept for a soak test. When it is reached,
+// neighbouring points are merged, so a day-long soak keeps its whole shape
+// at a coarser resolution in constant memory.
+const soakRetention = 512
+
+// soakWindow is how many consecutive points make up the "worst window".
+const soakWindow = 5
+
+// soakPoint is one run of a soak test, or the average of several merged
+// runs.
+type soakPoint struct {
+	At       time.Time
+	Download float64
+	Upload   float64
+	Ping     float64
+	Runs     int
+}
+
+// soakThresholds count violations; zero disables a check.
+type soakThresholds struct {
+	MinDownload float64
+	MinUpload   float64
+	MaxPing     float64
+}
+
+type soakSeries struct {
+	points     []soakPoint
+	thresholds soakThresholds
+	runs       int
+	failures   int
+	violations int
+}
+
+func (s *soakSeries) add(r Results) {
+	p := soakPoint{
+		At:       r.Timestamp,
+		Download: transferMbps(r.Download),
+		Upload:   transferMbps(r.Upload),
+		Ping:     r.Latency.Avg,
+		Runs:     1,
+	}
+	s.runs++
+	t := s.thresholds
+	if (t.MinDownload > 0 && p.Download < t.MinDownload) ||
+		(t.MinUpload > 0 && r.Upload != nil && p.Upload < t.MinUpload) ||
+		(t.MaxPing > 0 && r.Latency.Source.Available() && p.Ping > t.MaxPing) {
+		s.violations++
+	}
+
+	s.points = append(s.points, p)
+	if len(s.points) > soakRetention {
+		s.compact()
+	}
+}
+
+// compact halves the resolution by merging neighbouring points.
+func (s *soakSeries) compact() {
+	merged := s.points[:0]
+	for i := 0; i+1 < len(s.points); i += 2 {
+		a, b := s.points[i], s.points[i+1]
+		n := float64(a.Runs + b.Runs)
+		avg := func(x, y float64) float64 { return (x*float64(a.Runs) + y*float64(b.Runs)) / n }
+		merged = append(merged, soakPoint{
+			At:       a.At.Add(b.At.Sub(a.At) / 2),
+			Download: avg(a.Download, b.Download),
+			Upload:   avg(a.Upload, b.Upload),
+			Ping:     avg(a.Ping, b.Ping),
+			Runs:     a.Runs + b.Runs,
+		})
+	}
+	if len(s.points)%2 == 1 {
+		merged = append(merged, s.points[len(s.points)-1])
+	}
+	s.points = merged
+}
+
+func (s *soakSeries) values(pick func(soakPoint) float64) []float64 {
+	values := make([]float64, len(s.points))
+	for i, p := range s.points {
+		values[i] = pick(p)
+	}
+	return values
+}
+
+// slopePerHour fits a least-squares line through a series, weighted by how
+// many runs each point stands for.
+func (s *soakSeries) slopePerHour(pick func(soakPoint) float64) float64 {
+	if len(s.points) < 2 {
+		return 0
+	}
+	start := s.points[0].At
+	var sw, sx, sy, sxx, sxy float64
+	for _, p := range s.points {
+		w := float64(p.Runs)
+		x := p.At.Sub(start).Hours()
+		y := pick(p)
+		sw += w
+		sx += w * x
+		sy += w * y
+		sxx += w * x * x
+		sxy += w * x * y
+	}
+	denom := sw*sxx - sx*sx
+	if denom == 0 {
+		return 0
+	}
+	return (sw*sxy - sx*sy) / denom
+}
+
+// worstWindow returns the start time and mean of the soakWindow consecutive
+// points with the lowest mean, or the highest when higherIsWorse.
+func (s *soakSeries) worstWindow(pick func(soakPoint) float64, higherIsWorse bool) (time.Time, float64, bool) {
+	n := min(soakWindow, len(s.points))
+	if n == 0 {
+		return time.Time{}, 0, false
+	}
+	var worstAt time.Time
+	worst := math.Inf(1)
+	if higherIsWorse {
+		worst = math.Inf(-1)
+	}
+	for i := 0; i+n <= len(s.points); i++ {
+		var sum float64
+		for _, p := range s.points[i : i+n] {
+			sum += pick(p)
+		}
+		mean := sum / float64(n)
+		if (higherIsWorse && mean > worst) || (!higherIsWorse && mean < worst) {
+			worst, worstAt = mean, s.points[i].At
+		}
+	}
+	return worstAt, worst, true
+}
+
+var soakMetrics = []struct {
+	name          string
+	color         string
+	pick          func(soakPoint) float64
+	format        func(float64) string
+	unit          string
+	higherIsWorse bool
+}{
+	{"Download", "32", func(p soakPoint) float64 { return p.Download }, formatSpeed, "Mbps", false},
+	{"Upload", "36", func(p soakPoint) float64 { return p.Upload }, formatSpeed, "Mbps", false},
+	{"Ping", "33", func(p soakPoint) float64 { return p.Ping }, func(v float64) string { return fmt.Sprintf("%.1f ms", v) }, "ms", true},
+}
+
+// report summarizes the drift over the whole soak.
+func (s *soakSeries) report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Soak: %d runs, %d failed, %d threshold violations\n", s.runs, s.failures, s.violations)
+	for _, metric := range soakMetrics {
+		at, worst, ok := s.worstWindow(metric.pick, metric.higherIsWorse)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "  %-9s trend %+.2f %s/h, worst %d-run window %s at %s\n",
+			metric.name, s.slopePerHour(metric.pick), metric.unit, soakWindow, metric.format(worst), at.Local().Format("15:04"))
+	}
+	return b.String()
+}
+
+// renderSeriesGraph draws the last width values of a series as a filled
+// graph, scaled to its own maximum.
+func renderSeriesGraph(title, color string, values []float64, width, height int, format func(float64) string) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	maxValue := 0.0
+	for _, v := range values {
+		maxValue = math.Max(maxValue, v)
+	}
+
+	var s strings.Builder
+	last := "–"
+	if len(values) > 0 {
+		last = format(values[len(values)-1])
+	}
+	fmt.Fprintf(&s, "\033[1m%s\033[0m  %s  \033[90m(max %s)\033[0m\n", title, last, format(maxValue))
+	for row := height - 1; row >= 0; row-- {
+		s.WriteString("\033[" + color + "m")
+		threshold := float64(row) / float64(height) * maxValue
+		for _, v := range values {
+			if maxValue > 0 && v > threshold {
+				s.WriteString("█")
+			} else {
+				s.WriteString(" ")
+			}
+		}
+		s.WriteString("\033[0m\n")
+	}
+	return s.String()
+}
+
+// soakModel runs a compact test every interval until the soak ends,
+// drawing the series as it grows.
+type soakModel struct {
+	engine engine
+	plan   schedule
+	series soakSeries
+	// outputs names where results go, for the control socket's status.
+	outputs []string
+	// ctl, when set, serves the schedule's status.
+	ctl *controlServer
+	// stickyFromConfig is set when the config, not --sticky-server,
+	// decides whether runs stick to a server.
+	stickyFromConfig bool
+	// reload is a config reloaded on SIGHUP, applied from the next run.
+	reload *soakReloadMsg
+	// notice is the outcome of the last reload or sink dispatch.
+	notice string
+
+	running  bool
+	runStart time.Time
+	next     time.Time
+	now      time.Time
+	done     bool
+	width    int
+	// recent are the last recentOutcomes runs.
+	recent []runOutcome
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type soakRunMsg struct{}
+type soakClockMsg time.Time
+
+// soakReloadMsg carries the config file as reread on SIGHUP.
+type soakReloadMsg struct {
+	cfg   config
+	sinks []configuredSink
+	err   error
+}
+
+// reloadConfig rereads the config at path and makes its sinks.
+func reloadConfig(path string) soakReloadMsg {
+	cfg, _, err := loadConfig(path)
+	if err != nil {
+		return soakReloadMsg{err: err}
+	}
+	sinks, err := newSinks(cfg.Sinks)
+	return soakReloadMsg{cfg: cfg, sinks: sinks, err: err}
+}
+
+// applyConfig takes up the settings of a reloaded config that a soak
+// follows: privacy, sinks, whether seeded runs count towards baselines and
+// the sticky server policy. It is only called between runs, so no engine
+// is reading them.
+func (m soakModel) applyConfig(r soakReloadMsg) soakModel {
+	m.engine.privacy = r.cfg.Privacy
+	m.engine.sinks = r.sinks
+	syntheticBaselines = r.cfg.SyntheticBaselines
+	sticky := m.engine.sticky != nil
+	if m.stickyFromConfig {
+		sticky = r.cfg.StickyServer
+	}
+	m.engine.sticky = nil
+	if sticky {
+		m.engine.sticky = &stickyPolicy{Reevaluate: r.cfg.StickyReevaluate, Degrade: r.cfg.StickyDegrade}
+	}
+	return m
+}
+
+func (m soakModel) Init() tea.Cmd {
+	return tea.Batch(m.startRun(), m.clockCmd())
+}
+
+func (m soakModel) clockCmd() tea.Cmd {
+	return func() tea.Msg {
+		return soakClockMsg(<-m.engine.clock.After(time.Second))
+	}
+}
+
+func (m soakModel) startRun() tea.Cmd {
+	return func() tea.Msg { return soakRunMsg{} }
+}
+
+func (m soakModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			m.cancel()
+			return m, tea.Quit
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+
+	case soakClockMsg:
+		m.now = time.Time(msg)
+		if m.done {
+			return m, nil
+		}
+		return m, m.clockCmd()
+
+	case soakReloadMsg:
+		if msg.err != nil {
+			m.notice = "\033[31mConfig not reloaded: " + msg.err.Error() + "\033[0m"
+			return m, nil
+		}
+		if m.running {
+			m.reload = &msg
+			m.notice = "Config reloaded; it applies from the next run"
+			return m, nil
+		}
+		m = m.applyConfig(msg)
+		m.notice = "Config reloaded"
+		return m, nil
+
+	case sinkReportMsg:
+		if len(msg.Failures) > 0 {
+			m.notice = "\033[33m" + sinkReport(msg).String() + "\033[0m"
+		}
+
+	case soakRunMsg:
+		if m.reload != nil {
+			m = m.applyConfig(*m.reload)
+			m.reload = nil
+		}
+		m.running = true
+		m.runStart = m.engine.clock.Now()
+		return m, runSpeedTestCmd(m.ctx, m.engine)
+
+	case engineEvent:
+		updated, cmd := m.Update(msg.msg)
+		return updated, tea.Batch(cmd, waitForEvent(msg.events))
+
+	case completeMsg:
+		violations := m.series.violations
+		m.series.add(Results(msg))
+		outcome := "ok"
+		if m.series.violations > violations {
+			outcome = "violation"
+		}
+		next, cmd := m.scheduleNext(outcome)
+		if len(m.engine.sinks) > 0 {
+			cmd = tea.Batch(cmd, dispatchCmd(m.engine.sinks, Results(msg)))
+		}
+		return next, cmd
+
+	case errorMsg:
+		if m.ctx.Err() != nil {
+			return m, nil
+		}
+		m.series.failures++
+		return m.scheduleNext("failed: " + error(msg).Error())
+	}
+	return m, nil
+}
+
+// scheduleNext records how the run went and waits for the next slot of the
+// plan, or ends the soak when no slot is left.
+func (m soakModel) scheduleNext(outcome string) (tea.Model, tea.Cmd) {
+	m.running = false
+	now := m.engine.clock.Now()
+	m.recent = append(m.recent, runOutcome{Started: m.runStart, Duration: now.Sub(m.runStart), Outcome: outcome})
+	m.recent = slices.Clone(m.recent[max(len(m.recent)-recentOutcomes, 0):])
+	if m.ctl != nil {
+		m.ctl.SetSchedule(m.plan, m.outputs, m.recent)
+	}
+	slots := m.plan.upcoming(now, 1)
+	if len(slots) == 0 {
+		m.done = true
+		return m, nil
+	}
+	m.next = slots[0]
+	wait := max(m.next.Sub(now), 0)
+	clock := m.engine.clock
+	return m, func() tea.Msg {
+		<-clock.After(wait)
+		return soakRunMsg{}
+	}
+}
+
+func (m soakModel) View() string {
+	var s strings.Builder
+	s.WriteString(term.title("GoFast - Soak Test") + "\n\n")
+
+	now := m.now
+	if now.IsZero() {
+		now = m.engine.clock.Now()
+	}
+	switch {
+	case m.done:
+		s.WriteString("Soak complete.\n\n")
+	case m.running:
+		fmt.Fprintf(&s, "Run %d in progress… soak ends in %s\n\n", m.series.runs+m.series.failures+1, m.plan.ends.Sub(now).Round(time.Second))
+	default:
+		fmt.Fprintf(&s, "Next run in %s, soak ends in %s\n\n", max(m.next.Sub(now), 0).Round(time.Second), m.plan.ends.Sub(now).Round(time.Second))
+	}
+
+	if m.notice != "" {
+		s.WriteString(m.notice + "\n\n")
+	}
+
+	width := m.width - 2
+	if width <= 0 {
+		width = 60
+	}
+	for _, metric := range soakMetrics {
+		if metric.name == "Upload" && !m.engine.provider.Capabilities().Upload {
+			continue
+		}
+		s.WriteString(renderSeriesGraph(metric.name, metric.color, m.series.values(metric.pick), width, 5, metric.format))
+		s.WriteString("\n")
+	}
+
+	s.WriteString(m.series.report())
+	s.WriteString("\nPress 'q' to stop")
+	return term.render(s.String())
+}
+
+// runSoak implements "gofast soak": repeated quick tests over a bounded
+// period, for catching intermittent slowdowns in one sitting.
+func runSoak(args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	every := fs.Duration("every", 2*time.Minute, "interval between the starts of consecutive runs")
+	jitter := fs.Duration("jitter", 0, "delay each run by a random amount up to this, to spread load; must be under --every")
+	controlSocket := fs.String("control-socket", "", "serve status, including the upcoming runs, on this Unix socket")
+	total := fs.Duration("for", time.Hour, "how long to keep testing")
+	providerName := fs.String("provider", "cloudflare", "speed test provider ("+strings.Join(providerNames(), ", ")+")")
+	serverURL := fs.String("server", "", "URL of the server to test against, for --provider librespeed, or of the peer for --provider lan")
+	credentials := fs.String("credentials", "", "user:password for a server behind HTTP basic auth; @FILE reads them from a file")
+	profileName := fs.String("profile", "quick", "test profile for each run")
+	var minDownload, minUpload speedFlag
+	fs.Var(&minDownload, "min-download", "count runs slower than this down as violations, e.g. 50Mbps or 6MB/s")
+	fs.Var(&minUpload, "min-upload", "count runs slower than this up as violations")
+	maxPing := fs.Float64("max-ping", 0, "count runs with ping above this many ms as violations")
+	pingMethodName := fs.String("ping-method", "auto", "latency probe: icmp, tcp, udp, https, or auto")
+	noHistory := fs.Bool("no-history", false, "don't store the soak's runs in history")
+	sticky := fs.Bool("sticky-server", true, "keep runs on the last run's server, chosen afresh as the config's sticky_reevaluate and sticky_degrade say (default the config's sticky_server)")
+	fs.Parse(args)
+
+	cfg := defaultConfig()
+	configPath, err := defaultConfigPath()
+	if err == nil {
+		if cfg, _, err = loadConfig(configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	sinks, err := newSinks(cfg.Sinks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	syntheticBaselines = cfg.SyntheticBaselines
+	stickySet := false
+	fs.Visit(func(f *flag.Flag) { stickySet = stickySet || f.Name == "sticky-server" })
+	if !stickySet {
+		*sticky = cfg.StickyServer
+	}
+
+	if *every <= 0 || *total < *every {
+		fmt.Fprintln(os.Stderr, "Error: --for must be at least one --every interval")
+		os.Exit(2)
+	}
+	if *jitter < 0 || *jitter >= *every {
+		fmt.Fprintln(os.Stderr, "Error: --jitter must be shorter than --every")
+		os.Exit(2)
+	}
+	prof, err := findProfile(*profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	clock := realClock{}
+	ping, err := choosePingMethod(*pingMethodName, clock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	popts := providerOptions{
+		Clock:         clock,
+		Rand:          newRand(0),
+		Duration:      prof.Duration,
+		PingSamples:   prof.PingSamples,
+		Streams:       prof.Streams,
+		Ping:          ping,
+		NoGeolocation: cfg.Privacy.NoGeolocation,
+		ServerURL:     *serverURL,
+	}
+	if *credentials != "" {
+		if popts.Username, popts.Password, err = parseCredentials(*credentials); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --credentials: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var environment []Warning
+	if *providerName == "lan" {
+		ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+		popts.UploadURL, err = checkLANPeer(ctx, *serverURL)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if popts.UploadURL == "" {
+			environment = append(environment, Warning{Code: warnLANDownloadOnly, Message: fmt.Sprintf("%s doesn't take POST bodies, so only the download is tested", *serverURL)})
+		}
+	}
+	provider, err := newProvider(*providerName, popts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	e := engine{provider: provider, profile: prof, clock: clock, regression: defaultRegressionPolicy, privacy: cfg.Privacy, sinks: sinks, environment: environment}
+	if *sticky {
+		e.sticky = &stickyPolicy{Reevaluate: cfg.StickyReevaluate, Degrade: cfg.StickyDegrade}
+	}
+	var outputs []string
+	if !*noHistory {
+		if path, err := defaultHistoryPath(); err == nil {
+			e.history = &historyStore{path: path}
+			outputs = append(outputs, "history "+path)
+		}
+	}
+	var bare []bareSpeed
+	if minDownload.bare {
+		bare = append(bare, bareSpeed{Name: "--min-download", Mbps: minDownload.mbps})
+	}
+	if minUpload.bare {
+		bare = append(bare, bareSpeed{Name: "--min-upload", Mbps: minUpload.mbps, Upload: true})
+	}
+	checkBareSpeeds(os.Stderr, bare, e.history)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := clock.Now()
+	m := soakModel{
+		engine:  e,
+		plan:    schedule{start: start, every: *every, jitter: *jitter, ends: start.Add(*total), seed: rand.Uint64()},
+		series:  soakSeries{thresholds: soakThresholds{MinDownload: minDownload.mbps, MinUpload: minUpload.mbps, MaxPing: *maxPing}},
+		outputs: outputs,
+		ctx:     ctx,
+		cancel:  cancel,
+
+		stickyFromConfig: !stickySet,
+	}
+	if *controlSocket != "" {
+		ctl, err := listenControl(*controlSocket, func(cmd string) error {
+			return fmt.Errorf("%s isn't supported during a soak; it runs on its schedule", cmd)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		defer ctl.Close()
+		ctl.SetSchedule(m.plan, outputs, nil)
+		m.ctl = ctl
+		m.engine.notify = ctl.Publish
+	}
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	// SIGHUP rereads the config without disturbing the schedule; the
+	// interval and thresholds are flags and stay as they are.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if configPath != "" {
+				p.Send(reloadConfig(configPath))
+			}
+		}
+	}()
+	final, err := p.Run()
+	cancel()
+	drainEngines(time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if m, ok := final.(soakModel); ok {
+		fmt.Print(m.series.report())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// --ipv4 and --ipv6 pin a run to one address family. Like --interface, the
+// family travels on the run's context, so every connection the run dials,
+// by any provider, keeps to it.
+
+type familyKey struct{}
+
+// withFamily pins connections dialed under ctx to network, "tcp4" or
+// "tcp6"; "" leaves the choice to the resolver.
+func withFamily(ctx context.Context, network string) context.Context {
+	if network == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, familyKey{}, network)
+}
+
+func pinnedFamily(ctx context.Context) string {
+	network, _ := ctx.Value(familyKey{}).(string)
+	return network
+}
+
+// familyNetwork is the network to dial in place of network under ctx:
+// "tcp" becomes "tcp6" on a run pinned to IPv6, and "udp" "udp6". Networks
+// that already name a family are left alone.
+func familyNetwork(ctx context.Context, network string) string {
+	family := pinnedFamily(ctx)
+	if family == "" || (network != "tcp" && network != "udp") {
+		return network
+	}
+	return network + strings.TrimPrefix(family, "tcp")
+}
+
+// familyError is a run pinned to an address family that this machine, or
+// the server, can't be reached over.
+type familyError struct {
+	network string
+	err     error
+}
+
+func (e *familyError) Error() string {
+	return fmt.Sprintf("no %s connectivity: %v", familyName(e.network), e.err)
+}
+
+func (e *familyError) Unwrap() error { return e.err }
+
+func isFamilyError(err error) bool {
+	var f *familyError
+	return errors.As(err, &f)
+}
+
+// checkFamily reports whether this host has a route over network's family,
+// before a run pinned to it.
+func checkFamily(network string) error {
+	if err := familyRoute(network); err != nil {
+		return &familyError{network, err}
+	}
+	return nil
+}
+
+// familyFailure explains err when it is a dial on a run pinned to network
+// that found no address of that family for the server, which Go reports
+// as a bare address error.
+func familyFailure(network string, err error) error {
+	var addrErr *net.AddrError
+	if network == "" || !errors.As(err, &addrErr) {
+		return err
+	}
+	return &familyError{network, fmt.Errorf("the server has no %s address (%w)", familyName(network), err)}
+}
+
+// compareStacks runs a ping and a download over IPv4 and then over IPv6,
+// for --compare-stacks. A family that can't be reached is reported in its
+// entry and doesn't stop the other.
+func compareStacks(ctx context.Context, e engine) []Results {
+	var runs []Results
+	for _, network := range stackFamilies {
+		runs = append(runs, e.stackRun(ctx, network))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return runs
+}
+
+// stackRun is one family's entry, with a provider of its own so the server
+// is chosen over that family too.
+func (e engine) stackRun(ctx context.Context, network string) Results {
+	if e.fresh != nil {
+		e.provider = e.fresh()
+	}
+	r := newResults(e.provider, e.profile, e.clock)
+	r.Interface, r.Family = e.iface, familyName(network)
+	err := func() error {
+		if err := checkFamily(network); err != nil {
+			return err
+		}
+		ctx := withFamily(withInterface(ctx, e.iface), network)
+		server, err := e.provider.Server(ctx)
+		if err != nil {
+			return err
+		}
+		r.Server = server
+		if r.Latency, err = e.provider.Ping(ctx); err != nil {
+			return err
+		}
+		limit := transferLimit{bytes: tighterCap(e.profile.MaxDownload, e.maxBytes/int64(len(stackFamilies)))}
+		download, err := e.transfer(ctx, "download", e.provider.Download, limit, func(tea.Msg) {})
+		if err != nil {
+			return err
+		}
+		r.Download = &download
+		return nil
+	}()
+	if err != nil {
+		r.Error = familyFailure(network, err).Error()
+	}
+	return e.privacy.redact(r)
+}
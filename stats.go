@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// percentile returns the nearest-rank p-th percentile (0 < p <= 100) of an
+// ascending slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+	return sorted[rank-1]
+}
+
+// summarizeLatency reduces a series of round trips, in ms, to its summary
+// statistics. The samples are kept in their original order.
+func summarizeLatency(samples []float64) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Latency{
+		Min:     sorted[0],
+		Avg:     sum / float64(len(sorted)),
+		Max:     sorted[len(sorted)-1],
+		Jitter:  jitter(samples),
+		P50:     percentile(sorted, 50),
+		P95:     percentile(sorted, 95),
+		P99:     percentile(sorted, 99),
+		Samples: samples,
+	}
+}
+
+// jitter is the mean absolute difference between consecutive round trips,
+// zero for fewer than two.
+func jitter(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sum float64
+	for i := 1; i < len(samples); i++ {
+		sum += math.Abs(samples[i] - samples[i-1])
+	}
+	return sum / float64(len(samples)-1)
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return percentile(sorted, 50)
+}
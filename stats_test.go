@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// upTo is 1, 2, ... n.
+func upTo(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = float64(i + 1)
+	}
+	return s
+}
+
+func TestPercentile(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{"p50 of 100", upTo(100), 50, 50},
+		{"p95 of 100", upTo(100), 95, 95},
+		{"p99 of 100", upTo(100), 99, 99},
+		{"p50 of 20", upTo(20), 50, 10},
+		{"p95 of 20", upTo(20), 95, 19},
+		{"p99 of 20 rounds up", upTo(20), 99, 20},
+		{"p50 of an even count is the lower middle", upTo(4), 50, 2},
+		{"p50 of an odd count is the middle", upTo(5), 50, 3},
+		{"p50 of one", []float64{7.5}, 50, 7.5},
+		{"p99 of one", []float64{7.5}, 99, 7.5},
+		{"empty", nil, 50, 0},
+		{"p0 clamps to the smallest", upTo(10), 0, 1},
+		{"p100 is the largest", upTo(10), 100, 10},
+		{"past p100 clamps to the largest", upTo(10), 150, 10},
+		{"repeated values", []float64{1, 2, 2, 2, 9}, 95, 9},
+	} {
+		if got := percentile(tt.sorted, tt.p); got != tt.want {
+			t.Errorf("%s: percentile(%v, %g) = %g, want %g", tt.name, tt.sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeLatency(t *testing.T) {
+	samples := []float64{10, 14, 12, 30, 11}
+	got := summarizeLatency(samples)
+	// Sorted: 10 11 12 14 30. Consecutive differences in the order taken:
+	// 4, 2, 18 and 19, so jitter is 43 / 4.
+	want := Latency{Min: 10, Avg: 15.4, Max: 30, Jitter: 10.75, P50: 12, P95: 30, P99: 30}
+	if got.Min != want.Min || math.Abs(got.Avg-want.Avg) > 1e-9 || got.Max != want.Max || got.Jitter != want.Jitter ||
+		got.P50 != want.P50 || got.P95 != want.P95 || got.P99 != want.P99 {
+		t.Errorf("summarizeLatency(%v) = %+v, want %+v", samples, got, want)
+	}
+	if !slices.Equal(got.Samples, []float64{10, 14, 12, 30, 11}) {
+		t.Errorf("samples reordered to %v", got.Samples)
+	}
+	if m := median(samples); m != got.P50 {
+		t.Errorf("median %g differs from p50 %g", m, got.P50)
+	}
+
+	if got := summarizeLatency([]float64{20}); got.Jitter != 0 || got.P50 != 20 || got.P99 != 20 || got.Avg != 20 {
+		t.Errorf("one sample: %+v", got)
+	}
+	if got := summarizeLatency(nil); got.Samples != nil || got.P50 != 0 || got.Jitter != 0 {
+		t.Errorf("no samples: %+v", got)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for _, tt := range []struct {
+		samples []float64
+		want    float64
+	}{
+		{nil, 0},
+		{[]float64{12}, 0},
+		{[]float64{12, 12, 12}, 0},
+		{[]float64{10, 20}, 10},
+		{[]float64{10, 20, 10, 20}, 10},
+		// Order matters: the same values sorted barely move.
+		{[]float64{10, 30, 11, 29}, (20 + 19 + 18) / 3.0},
+		{[]float64{10, 11, 29, 30}, (1 + 18 + 1) / 3.0},
+	} {
+		if got := jitter(tt.samples); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("jitter(%v) = %g, want %g", tt.samples, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// stickyPolicy keeps scheduled runs on one server so their results form a
+// comparable series. The server is chosen afresh once Reevaluate has
+// passed since it was chosen, or sooner when its round trip has grown past
+// Degrade times what it was then.
+type stickyPolicy struct {
+	Reevaluate time.Duration
+	Degrade    float64
+}
+
+var defaultStickyPolicy = stickyPolicy{Reevaluate: 24 * time.Hour, Degrade: 1.5}
+
+// ServerChoice records how a run's server was chosen under a sticky
+// policy, for the next run to keep it by.
+type ServerChoice struct {
+	// Sticky is set when the server was kept from an earlier run rather
+	// than chosen from every candidate.
+	Sticky bool `json:"sticky"`
+	// EvaluatedAt is when the candidates were last compared.
+	EvaluatedAt time.Time `json:"evaluated_at"`
+	// BaselineMs is the server's round trip when it was chosen, zero when
+	// it wasn't measured; RTTMs is its round trip for this run.
+	BaselineMs float64 `json:"baseline_ms,omitempty"`
+	RTTMs      float64 `json:"rtt_ms,omitempty"`
+}
+
+// ServerChange annotates the run where a sticky policy moved to another
+// server, so a step in the series isn't mistaken for the connection's.
+type ServerChange struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+func (c ServerChange) String() string {
+	return fmt.Sprintf("server changed from %s to %s (%s)", c.From, c.To, c.Reason)
+}
+
+// stickyServer is the previous run's server and how it was chosen, from
+// the latest local run of provider that was chosen under a sticky policy.
+func stickyServer(past []Results, provider string) (string, ServerChoice, bool) {
+	for i := len(past) - 1; i >= 0; i-- {
+		r := past[i]
+		if r.Site == "" && r.Provider == provider && r.ServerChoice != nil {
+			return r.Server.Name, *r.ServerChoice, true
+		}
+	}
+	return "", ServerChoice{}, false
+}
+
+// keep reports whether a server chosen as prev still serves at now, given
+// its round trip rtt and whether it answered, with the reason when not.
+func (p stickyPolicy) keep(now time.Time, prev ServerChoice, rtt float64, answered bool) (bool, string) {
+	switch {
+	case !answered:
+		return false, "it didn't answer"
+	case p.Reevaluate > 0 && now.Sub(prev.EvaluatedAt) >= p.Reevaluate:
+		return false, fmt.Sprintf("re-evaluated %.0fh after it was chosen", now.Sub(prev.EvaluatedAt).Hours())
+	case p.Degrade > 0 && prev.BaselineMs > 0 && rtt > prev.BaselineMs*p.Degrade:
+		return false, fmt.Sprintf("its latency rose from %.1f to %.1f ms", prev.BaselineMs, rtt)
+	}
+	return true, ""
+}
+
+// stick looks up the server the last run stuck to among candidates and
+// probes it. It returns the server's index when it is kept, or -1 and why
+// it isn't; a first run has neither.
+func (e engine) stick(ctx context.Context, candidates []Server, prober serverProber) (int, *ServerChoice, *ServerChange) {
+	if e.history == nil {
+		return -1, nil, nil
+	}
+	past, _ := e.history.Load()
+	name, prev, ok := stickyServer(past, e.provider.Name())
+	if !ok {
+		return -1, nil, nil
+	}
+	change := &ServerChange{From: name}
+	i := -1
+	for j, s := range candidates {
+		if s.Name == name {
+			i = j
+		}
+	}
+	if i < 0 {
+		change.Reason = "it is no longer offered"
+		return -1, nil, change
+	}
+	pool := defaultProbePool
+	pool.Probe = prober.ProbeServer
+	r := pool.probeTarget(ctx, candidates[i])
+	keep, reason := e.sticky.keep(e.clock.Now(), prev, r.RTT, r.Err == nil)
+	if !keep {
+		change.Reason = reason
+		return -1, nil, change
+	}
+	// A server taken after a failed handshake wasn't measured then; its
+	// first kept run sets the baseline.
+	baseline := prev.BaselineMs
+	if baseline == 0 {
+		baseline = r.RTT
+	}
+	return i, &ServerChoice{Sticky: true, EvaluatedAt: prev.EvaluatedAt, BaselineMs: baseline, RTTMs: r.RTT}, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// driftingProber is a selector whose servers' round trips change over
+// time: rtt gives each one's at a time since the first run. Servers in
+// down fail their handshake.
+type driftingProber struct {
+	Provider
+	clock *fakeClock
+	start time.Time
+	rtt   func(name string, since time.Duration) float64
+	down  map[string]bool
+}
+
+func (*driftingProber) Name() string { return "drifting" }
+
+func (*driftingProber) Candidates(context.Context) ([]Server, error) {
+	return []Server{{Name: "a"}, {Name: "b"}, {Name: "c"}}, nil
+}
+
+func (p *driftingProber) Handshake(_ context.Context, s Server) error {
+	if p.down[s.Name] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (*driftingProber) Use(Server) {}
+
+func (p *driftingProber) ProbeServer(_ context.Context, s Server) (float64, error) {
+	return p.rtt(s.Name, p.clock.Since(p.start)), nil
+}
+
+// latencies takes each server's round trip at the first run, then pairs of
+// a time since the first run and the round trips from then on.
+func latencies(steps ...any) func(string, time.Duration) float64 {
+	return func(name string, since time.Duration) float64 {
+		rtts := steps[0].(map[string]float64)
+		for i := 1; i+1 < len(steps); i += 2 {
+			if since >= steps[i].(time.Duration) {
+				rtts = steps[i+1].(map[string]float64)
+			}
+		}
+		return rtts[name]
+	}
+}
+
+type stickyRun struct {
+	// after is the time since the previous run.
+	after time.Duration
+	// down fails these servers' handshakes for the run.
+	down   []string
+	server string
+	sticky bool
+	// unmeasured is set when the server was taken without a probe, after
+	// the kept one failed its handshake.
+	unmeasured bool
+	// change is "from>to reason" of the ServerChange, "" for none.
+	change string
+}
+
+func TestStickySelection(t *testing.T) {
+	policy := stickyPolicy{Reevaluate: 24 * time.Hour, Degrade: 1.5}
+	tests := []struct {
+		name string
+		rtt  func(string, time.Duration) float64
+		runs []stickyRun
+	}{
+		{
+			name: "kept within degrade",
+			rtt: latencies(
+				map[string]float64{"a": 10, "b": 20, "c": 30},
+				6*time.Hour, map[string]float64{"a": 14, "b": 5, "c": 30},
+			),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: 6 * time.Hour, server: "a", sticky: true},
+				{after: 6 * time.Hour, server: "a", sticky: true},
+			},
+		},
+		{
+			name: "replaced after degrading",
+			rtt: latencies(
+				map[string]float64{"a": 10, "b": 20, "c": 30},
+				6*time.Hour, map[string]float64{"a": 16, "b": 12, "c": 30},
+			),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: 6 * time.Hour, server: "b", change: "a>b its latency rose from 10.0 to 16.0 ms"},
+				{after: time.Hour, server: "b", sticky: true},
+			},
+		},
+		{
+			name: "degraded but still the best",
+			rtt: latencies(
+				map[string]float64{"a": 10, "b": 20, "c": 30},
+				6*time.Hour, map[string]float64{"a": 16, "b": 20, "c": 30},
+			),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: 6 * time.Hour, server: "a"},
+				{after: time.Hour, server: "a", sticky: true},
+			},
+		},
+		{
+			name: "re-evaluated after a day",
+			rtt: latencies(
+				map[string]float64{"a": 10, "b": 20, "c": 30},
+				2*time.Hour, map[string]float64{"a": 12, "b": 8, "c": 30},
+			),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: 12 * time.Hour, server: "a", sticky: true},
+				{after: 12 * time.Hour, server: "b", change: "a>b re-evaluated 24h after it was chosen"},
+				{after: 12 * time.Hour, server: "b", sticky: true},
+			},
+		},
+		{
+			name: "re-evaluated to the same server",
+			rtt:  latencies(map[string]float64{"a": 10, "b": 20, "c": 30}),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: 25 * time.Hour, server: "a"},
+				{after: 23 * time.Hour, server: "a", sticky: true},
+			},
+		},
+		{
+			name: "kept server fails its handshake",
+			rtt:  latencies(map[string]float64{"a": 10, "b": 20, "c": 30}),
+			runs: []stickyRun{
+				{server: "a"},
+				{after: time.Hour, down: []string{"a"}, server: "b", unmeasured: true, change: "a>b it failed its handshake: connection refused"},
+				{after: time.Hour, server: "b", sticky: true},
+				{after: time.Hour, down: []string{"c"}, server: "b", sticky: true},
+			},
+		},
+		{
+			name: "first choice fails its handshake",
+			rtt:  latencies(map[string]float64{"a": 10, "b": 20, "c": 30}),
+			runs: []stickyRun{
+				{down: []string{"a"}, server: "b"},
+				{after: time.Hour, server: "b", sticky: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			p := &driftingProber{clock: clock, start: clock.Now(), rtt: tt.rtt}
+			store := &historyStore{path: filepath.Join(t.TempDir(), "history.jsonl")}
+			e := engine{provider: p, clock: clock, history: store, sticky: &policy}
+			var chosen time.Time
+			for i, run := range tt.runs {
+				<-clock.After(run.after)
+				p.down = make(map[string]bool)
+				for _, name := range run.down {
+					p.down[name] = true
+				}
+				pick, err := e.selectServer(context.Background(), func(tea.Msg) {})
+				if err != nil {
+					t.Fatalf("run %d: %v", i, err)
+				}
+				if pick.Server.Name != run.server || pick.Choice == nil || pick.Choice.Sticky != run.sticky {
+					t.Fatalf("run %d: server %s, choice %+v; want %s, sticky %v", i, pick.Server.Name, pick.Choice, run.server, run.sticky)
+				}
+				change := ""
+				if c := pick.Change; c != nil {
+					change = c.From + ">" + c.To + " " + c.Reason
+				}
+				if change != run.change {
+					t.Errorf("run %d: change %q, want %q", i, change, run.change)
+				}
+				if !run.sticky {
+					chosen = clock.Now()
+				}
+				if !pick.Choice.EvaluatedAt.Equal(chosen) {
+					t.Errorf("run %d: evaluated at %s, want %s", i, pick.Choice.EvaluatedAt, chosen)
+				}
+				rtt := tt.rtt(run.server, clock.Since(p.start))
+				if run.unmeasured {
+					rtt = 0
+				}
+				if pick.Choice.RTTMs != rtt {
+					t.Errorf("run %d: round trip %g, want %g", i, pick.Choice.RTTMs, rtt)
+				}
+				if run.sticky && pick.Choice.BaselineMs == 0 {
+					t.Errorf("run %d: kept without a baseline", i)
+				}
+				if err := store.Append(Results{SchemaVersion: SchemaVersion, Timestamp: clock.Now(), Provider: p.Name(), Server: pick.Server, ServerChoice: pick.Choice, ServerChange: pick.Change}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStickyPolicyKeep(t *testing.T) {
+	chosen := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := ServerChoice{EvaluatedAt: chosen, BaselineMs: 10}
+	for _, tt := range []struct {
+		name     string
+		policy   stickyPolicy
+		prev     ServerChoice
+		after    time.Duration
+		rtt      float64
+		answered bool
+		keep     bool
+		reason   string
+	}{
+		{"within both", defaultStickyPolicy, prev, time.Hour, 14.9, true, true, ""},
+		{"at the degrade limit", defaultStickyPolicy, prev, time.Hour, 15, true, true, ""},
+		{"past the degrade limit", defaultStickyPolicy, prev, time.Hour, 15.1, true, false, "latency rose"},
+		{"due", defaultStickyPolicy, prev, 24 * time.Hour, 10, true, false, "re-evaluated 24h"},
+		{"silent", defaultStickyPolicy, prev, time.Hour, 0, false, false, "didn't answer"},
+		{"no baseline", defaultStickyPolicy, ServerChoice{EvaluatedAt: chosen}, time.Hour, 500, true, true, ""},
+		{"never re-evaluated", stickyPolicy{Degrade: 1.5}, prev, 1000 * time.Hour, 10, true, true, ""},
+		{"degrade off", stickyPolicy{Reevaluate: 24 * time.Hour}, prev, time.Hour, 500, true, true, ""},
+	} {
+		keep, reason := tt.policy.keep(chosen.Add(tt.after), tt.prev, tt.rtt, tt.answered)
+		if keep != tt.keep || !strings.Contains(reason, tt.reason) {
+			t.Errorf("%s: keep %v (%q), want %v (%q)", tt.name, keep, reason, tt.keep, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamsAuto is the --streams value that picks the stream count per
+// transfer instead of taking the profile's.
+const streamsAuto = "auto"
+
+// The ramp of --streams auto starts with rampStart streams and adds more,
+// up to rampMax, one rampInterval at a time while each added stream raises
+// the aggregate by at least rampGain. It is over within rampWindow, so the
+// rest of the transfer measures at the count it settled on.
+const (
+	rampStart    = 2
+	rampMax      = 16
+	rampInterval = 400 * time.Millisecond
+	rampWindow   = 3 * time.Second
+	rampGain     = 0.05
+)
+
+// rampCounts are the counts the ramp tries in turn, growing faster as they
+// get larger so the ramp fits its window.
+var rampCounts = []int{2, 3, 4, 6, 8, 12, 16}
+
+// RampStep is one count the ramp tried and the aggregate it measured.
+type RampStep struct {
+	Streams int     `json:"streams"`
+	Mbps    float64 `json:"mbps"`
+}
+
+// streamRamp decides the stream count of one transfer from the aggregate
+// throughput measured at each count. Providers that open their own
+// connections feed it one observation per rampInterval until it is done.
+type streamRamp struct {
+	max   int
+	next  int
+	trace []RampStep
+	// streams is the count in use, and the one settled on once done.
+	streams int
+	done    bool
+}
+
+func newStreamRamp(max int) *streamRamp {
+	if max <= 0 {
+		max = rampMax
+	}
+	r := &streamRamp{max: max, streams: min(rampStart, max)}
+	r.done = r.streams >= max
+	return r
+}
+
+// observe records the aggregate measured over the last interval at the
+// current count and returns the count to use from now on. A count that
+// didn't add rampGain per added stream isn't worth its connections; the
+// ramp settles on the one before it.
+func (r *streamRamp) observe(mbps float64) int {
+	if r.done {
+		return r.streams
+	}
+	r.trace = append(r.trace, RampStep{Streams: r.streams, Mbps: mbps})
+	if n := len(r.trace); n > 1 {
+		prev := r.trace[n-2]
+		added := float64(r.streams - prev.Streams)
+		if prev.Mbps > 0 && (mbps-prev.Mbps)/prev.Mbps < rampGain*added {
+			r.streams, r.done = prev.Streams, true
+			return r.streams
+		}
+	}
+	for r.next < len(rampCounts) && rampCounts[r.next] <= r.streams {
+		r.next++
+	}
+	if r.next == len(rampCounts) || rampCounts[r.next] > r.max {
+		r.done = true
+		return r.streams
+	}
+	r.streams = rampCounts[r.next]
+	return r.streams
+}
+
+// stop ends the ramp at the current count, when its window is over.
+func (r *streamRamp) stop() { r.done = true }
+
+// parseStreams reads --streams: empty for the profile's count, a count, or
+// auto.
+func parseStreams(v string) (streams int, auto bool, err error) {
+	switch v {
+	case "":
+		return 0, false, nil
+	case streamsAuto:
+		return rampMax, true, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 64 {
+		return 0, false, fmt.Errorf("--streams must be a count from 1 to 64, or %s", streamsAuto)
+	}
+	return n, false, nil
+}
+
+// formatRamp summarises how a transfer's stream count was chosen, e.g.
+// "6 (2 → 40.0 Mbps, 3 → 60.0 Mbps, …)".
+func formatRamp(t *Transfer) string {
+	if t == nil || t.Streams == 0 {
+		return ""
+	}
+	if len(t.StreamRamp) == 0 {
+		return strconv.Itoa(t.Streams)
+	}
+	steps := make([]string, len(t.StreamRamp))
+	for i, s := range t.StreamRamp {
+		steps[i] = fmt.Sprintf("%d → %s", s.Streams, formatSpeed(s.Mbps))
+	}
+	return fmt.Sprintf("%d (%s)", t.Streams, strings.Join(steps, ", "))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// rampOver runs a ramp against a link of mbps whose flows are each shaped
+// to perStream, as the synthetic per-stream model has it, until the ramp
+// settles.
+func rampOver(mbps, perStream float64, max int) *streamRamp {
+	r := newStreamRamp(max)
+	streams := r.streams
+	for !r.done {
+		streams = r.observe(streamModel(mbps, perStream, streams))
+	}
+	return r
+}
+
+func rampCountsTried(r *streamRamp) []int {
+	var counts []int
+	for _, s := range r.trace {
+		counts = append(counts, s.Streams)
+	}
+	return counts
+}
+
+func TestStreamRamp(t *testing.T) {
+	tests := []struct {
+		name            string
+		mbps, perStream float64
+		max             int
+		want            int
+		tried           []int
+	}{
+		{"saturates at 2", 200, 100, rampMax, 2, []int{2, 3}},
+		{"saturates at 4", 400, 100, rampMax, 4, []int{2, 3, 4, 6}},
+		{"saturates at 8", 800, 100, rampMax, 8, []int{2, 3, 4, 6, 8, 12}},
+		{"saturates at 16", 1600, 100, rampMax, 16, []int{2, 3, 4, 6, 8, 12, 16}},
+		{"faster than every count", 5000, 100, rampMax, 16, []int{2, 3, 4, 6, 8, 12, 16}},
+		{"lower cap", 5000, 100, 6, 6, []int{2, 3, 4, 6}},
+		// 4 to 6 adds 7.5%, less than the 10% two streams must pay for.
+		{"gain too small per stream", 430, 100, rampMax, 4, []int{2, 3, 4, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rampOver(tt.mbps, tt.perStream, tt.max)
+			if r.streams != tt.want {
+				t.Errorf("settled on %d streams, want %d", r.streams, tt.want)
+			}
+			if got := rampCountsTried(r); !slices.Equal(got, tt.tried) {
+				t.Errorf("tried %v, want %v", got, tt.tried)
+			}
+			for _, s := range r.trace {
+				if want := streamModel(tt.mbps, tt.perStream, s.Streams); s.Mbps != want {
+					t.Errorf("trace has %g Mbps at %d streams, want %g", s.Mbps, s.Streams, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamRampFitsWindow(t *testing.T) {
+	if d := time.Duration(len(rampCounts)) * rampInterval; d > rampWindow {
+		t.Errorf("trying every count takes %s, more than the %s window", d, rampWindow)
+	}
+	if r := newStreamRamp(1); !r.done || r.streams != 1 || r.observe(100) != 1 || len(r.trace) != 0 {
+		t.Errorf("a cap of 1 ramped: %+v", r)
+	}
+	r := newStreamRamp(rampMax)
+	r.observe(100)
+	r.stop()
+	if got := r.observe(1000); got != 3 || len(r.trace) != 1 {
+		t.Errorf("a stopped ramp moved to %d streams with trace %v", got, r.trace)
+	}
+}
+
+func TestDemoStreamRamp(t *testing.T) {
+	clock := newFakeClock()
+	p := demoProvider{clock: clock, rng: newRand(7), duration: 5 * time.Second, streams: rampMax, autoStreams: true, noGeolocation: true}
+	start := clock.Now()
+	got, err := p.Download(context.Background(), &meter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Streams < rampStart || got.Streams > rampMax || len(got.StreamRamp) == 0 {
+		t.Fatalf("transfer reported %d streams after %v", got.Streams, got.StreamRamp)
+	}
+	if d := time.Duration(len(got.StreamRamp)) * rampInterval; d > rampWindow {
+		t.Errorf("ramp took %s of the %s transfer", d, clock.Since(start))
+	}
+	if formatRamp(&got) == "" {
+		t.Error("details panel has no stream ramp")
+	}
+}
+
+func TestParseStreams(t *testing.T) {
+	tests := []struct {
+		in      string
+		streams int
+		auto    bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"4", 4, false, false},
+		{"64", 64, false, false},
+		{"auto", rampMax, true, false},
+		{"0", 0, false, true},
+		{"65", 0, false, true},
+		{"many", 0, false, true},
+	}
+	for _, tt := range tests {
+		streams, auto, err := parseStreams(tt.in)
+		if streams != tt.streams || auto != tt.auto || (err != nil) != tt.wantErr {
+			t.Errorf("parseStreams(%q) = %d, %v, %v", tt.in, streams, auto, err)
+		}
+	}
+}
+
+func TestFormatRamp(t *testing.T) {
+	tests := []struct {
+		t    *Transfer
+		want string
+	}{
+		{nil, ""},
+		{&Transfer{}, ""},
+		{&Transfer{Streams: 4}, "4"},
+		{&Transfer{Streams: 3, StreamRamp: []RampStep{{2, 40}, {3, 60}, {4, 61}}}, "3 (2 → " + formatSpeed(40) + ", 3 → " + formatSpeed(60) + ", 4 → " + formatSpeed(61) + ")"},
+	}
+	for _, tt := range tests {
+		if got := formatRamp(tt.t); got != tt.want {
+			t.Errorf("formatRamp(%+v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+)
+
+// terminal is what the output terminal can show, negotiated once at
+// startup. Views are written with 16-color ANSI escapes and Unicode glyphs
+// and degraded to it on the way out, so no view has to know.
+type terminal struct {
+	profile termenv.Profile
+	// unicode is false when the terminal can't draw box-drawing and block
+	// characters, such as a legacy Windows console on an OEM code page.
+	unicode bool
+	titles  lipgloss.Style
+}
+
+// term is the terminal the TUIs render for. It assumes a capable terminal
+// until main detects the real one.
+var term = newTerminal(termenv.ANSI, true)
+
+func newTerminal(profile termenv.Profile, unicode bool) terminal {
+	r := lipgloss.NewRenderer(os.Stdout)
+	r.SetColorProfile(profile)
+	return terminal{
+		profile: profile,
+		unicode: unicode,
+		titles:  r.NewStyle().Bold(true).Foreground(lipgloss.Color("7")).Background(lipgloss.Color("4")),
+	}
+}
+
+// detectTerminal honors NO_COLOR and CLICOLOR_FORCE as well as what the
+// terminal reports.
+func detectTerminal(f *os.File) terminal {
+	return newTerminal(termenv.NewOutput(f).EnvColorProfile(), unicodeOutput())
+}
+
+// title renders a screen's title bar.
+func (t terminal) title(text string) string {
+	return t.titles.Render(" " + text + " ")
+}
+
+var sgrPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// asciiPairs maps every non-ASCII glyph the views draw to an ASCII one of
+// the same width, so a line keeps its width wherever the glyph sits in it.
+var asciiPairs = []string{
+	"═", "=", "║", "|", "╔", "+", "╗", "+", "╚", "+", "╝", "+",
+	"─", "-", "│", "|", "━", "=", "├", "+", "┼", "+", "┤", "+",
+	"█", "#", "▓", "#", "▒", "+", "░", ".",
+	"●", "o", "•", "o", "◆", "*", "▪", "*", "▸", ">", "▶", ">", "★", "*",
+	"✓", "+", "✗", "x", "⋯", "~", "⚠", "!", "🌐", "()",
+	"·", ".", "–", "-", "—", "-", "…", ".", "→", ">", "←", "<", "×", "x",
+	"↑", "^", "↓", "v", "Δ", "d", "⟳", "@", "µ", "u",
+}
+
+var asciiGlyphs = strings.NewReplacer(asciiPairs...)
+
+// render degrades a finished frame: escapes are dropped for a terminal
+// without color and glyphs replaced for one without Unicode.
+func (t terminal) render(s string) string {
+	if t.profile == termenv.Ascii {
+		s = sgrPattern.ReplaceAllString(s, "")
+	}
+	if !t.unicode {
+		s = asciiGlyphs.Replace(s)
+	}
+	return s
+}
+
+// canDrawQR reports whether half blocks and forced colors are available;
+// without either a code wouldn't scan.
+func (t terminal) canDrawQR() bool {
+	return t.unicode && t.profile != termenv.Ascii
+}
+
+// truncate shortens s to at most width columns, marking the cut with an
+// ellipsis. East Asian wide characters take two columns. A width of zero,
+// before the terminal size is known, leaves s whole. Names are truncated
+// only where they are drawn; results and exports keep them in full.
+func truncate(s string, width int) string {
+	if width <= 0 || runewidth.StringWidth(s) <= width {
+		return s
+	}
+	tail := "…"
+	if !term.unicode {
+		tail = "..."
+	}
+	return runewidth.Truncate(s, width, tail)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/termenv"
+)
+
+func TestASCIIGlyphsKeepWidth(t *testing.T) {
+	for i := 0; i < len(asciiPairs); i += 2 {
+		glyph, ascii := asciiPairs[i], asciiPairs[i+1]
+		if g, a := runewidth.StringWidth(glyph), runewidth.StringWidth(ascii); g != a {
+			t.Errorf("%q is %d columns but its fallback %q is %d", glyph, g, ascii, a)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	frame := "\033[32;1m🌐 Server: Example\033[0m\n╔══╗\n║▸…║ 1 → 2\n╚══╝"
+	tests := []struct {
+		profile termenv.Profile
+		unicode bool
+		want    string
+	}{
+		{termenv.ANSI, true, frame},
+		{termenv.ANSI, false, "\033[32;1m() Server: Example\033[0m\n+==+\n|>.| 1 > 2\n+==+"},
+		{termenv.Ascii, true, "🌐 Server: Example\n╔══╗\n║▸…║ 1 → 2\n╚══╝"},
+		{termenv.Ascii, false, "() Server: Example\n+==+\n|>.| 1 > 2\n+==+"},
+	}
+	for _, tt := range tests {
+		got := newTerminal(tt.profile, tt.unicode).render(frame)
+		if got != tt.want {
+			t.Errorf("profile %v, unicode %v:\n%s\nwant:\n%s", tt.profile, tt.unicode, got, tt.want)
+		}
+		if !tt.unicode {
+			for _, line := range strings.Split(sgrPattern.ReplaceAllString(got, ""), "\n") {
+				for _, r := range line {
+					if r > 127 {
+						t.Errorf("profile %v: %q left in %q", tt.profile, r, line)
+					}
+				}
+			}
+		}
+	}
+	in, out := strings.Split(sgrPattern.ReplaceAllString(frame, ""), "\n"), strings.Split(newTerminal(termenv.Ascii, false).render(frame), "\n")
+	for i := range in {
+		if runewidth.StringWidth(in[i]) != runewidth.StringWidth(out[i]) {
+			t.Errorf("line %d changed width: %q to %q", i, in[i], out[i])
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	saved := term
+	defer func() { term = saved }()
+	for _, tt := range []struct {
+		unicode bool
+		s       string
+		width   int
+		want    string
+	}{
+		{true, "Frankfurt am Main", 0, "Frankfurt am Main"},
+		{true, "Frankfurt am Main", 20, "Frankfurt am Main"},
+		{true, "Frankfurt am Main", 10, "Frankfurt…"},
+		{false, "Frankfurt am Main", 10, "Frankfu..."},
+		{true, "東京都千代田区", 7, "東京都…"},
+	} {
+		term = newTerminal(termenv.ANSI, tt.unicode)
+		if got := truncate(tt.s, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) unicode %v = %q, want %q", tt.s, tt.width, tt.unicode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// utf8CodePage is CP_UTF8.
+const utf8CodePage = 65001
+
+// unicodeOutput reports whether the console can draw the gauges' glyphs:
+// Windows Terminal always can, conhost only on the UTF-8 code page.
+func unicodeOutput() bool {
+	if os.Getenv("WT_SESSION") != "" {
+		return true
+	}
+	cp, err := windows.GetConsoleOutputCP()
+	return err == nil && cp == utf8CodePage
+}
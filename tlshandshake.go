@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// tlsHandshakes is how many fresh connections the TLS handshake figure is
+// averaged over.
+const tlsHandshakes = 3
+
+// TLSHandshake times full TLS handshakes with the test server, each on a
+// fresh connection, apart from the rest of connecting to it. Version,
+// CipherSuite and ALPN are what the last handshake negotiated.
+type TLSHandshake struct {
+	AvgMs       float64   `json:"avg_ms"`
+	MinMs       float64   `json:"min_ms"`
+	MaxMs       float64   `json:"max_ms"`
+	SamplesMs   []float64 `json:"samples_ms,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	CipherSuite string    `json:"cipher_suite,omitempty"`
+	ALPN        string    `json:"alpn,omitempty"`
+	// Error is why no handshake completed; the figures are then zero.
+	Error string `json:"error,omitempty"`
+}
+
+// measureTLSHandshake makes tlsHandshakes handshakes with the server the
+// tracer's request goes to. It returns nil for a server over plain HTTP,
+// which has no handshake to time.
+func measureTLSHandshake(ctx context.Context, clock Clock, tracer connectionTracer) *TLSHandshake {
+	req, err := tracer.TraceRequest(ctx)
+	if err != nil {
+		return &TLSHandshake{Error: err.Error()}
+	}
+	if req.URL.Scheme != "https" {
+		return nil
+	}
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "443"
+	}
+	t := &TLSHandshake{}
+	for range tlsHandshakes {
+		ms, state, err := timeHandshake(ctx, clock, net.JoinHostPort(host, port), host)
+		if err != nil {
+			t.Error = err.Error()
+			break
+		}
+		t.SamplesMs = append(t.SamplesMs, ms)
+		t.Version, t.CipherSuite, t.ALPN = tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol
+	}
+	if len(t.SamplesMs) == 0 {
+		return t
+	}
+	// Handshakes that did complete are still a figure.
+	t.Error = ""
+	var sum float64
+	for _, ms := range t.SamplesMs {
+		sum += ms
+	}
+	t.AvgMs = sum / float64(len(t.SamplesMs))
+	t.MinMs, t.MaxMs = slices.Min(t.SamplesMs), slices.Max(t.SamplesMs)
+	return t
+}
+
+// timeHandshake connects to addr and times the TLS handshake alone, from
+// the ClientHello to the handshake's end. Each call has a config of its
+// own, with no session cache, so nothing is resumed.
+func timeHandshake(ctx context.Context, clock Clock, addr, host string) (float64, tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	raw, err := dialBound(ctx, "tcp", addr)
+	if err != nil {
+		return 0, tls.ConnectionState{}, err
+	}
+	defer raw.Close()
+	conn := tls.Client(raw, &tls.Config{ServerName: host, InsecureSkipVerify: insecureSkipVerify, NextProtos: []string{"h2", "http/1.1"}})
+	start := clock.Now()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return 0, tls.ConnectionState{}, fmt.Errorf("TLS handshake: %w", err)
+	}
+	return msSince(clock, start), conn.ConnectionState(), nil
+}
+
+// tlsSection shows the TLS handshake figure, with --verbose.
+func tlsSection(t *TLSHandshake) detailSection {
+	section := detailSection{title: "TLS handshake"}
+	if t.Error != "" {
+		section.add("Handshake", "\033[33m"+t.Error+"\033[0m")
+		return section
+	}
+	section.add("Average", fmt.Sprintf("%.1f ms \033[90m(%.1f–%.1f ms over %d fresh connections)\033[0m", t.AvgMs, t.MinMs, t.MaxMs, len(t.SamplesMs)))
+	section.add("Version", t.Version)
+	section.add("Cipher suite", t.CipherSuite)
+	if t.ALPN != "" {
+		section.add("ALPN", t.ALPN)
+	}
+	return section
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// insecureSkipVerify disables certificate checks on the test's own
+// connections, for networks that intercept TLS. Results of such runs are
+// flagged as unverified.
+var insecureSkipVerify bool
+
+// httpTransport returns a fresh transport honoring --insecure-skip-verify
+// and --interface.
+func httpTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialBound
+	if insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return transport
+}
+
+// stallTimeout is how long a transfer connection may go without moving a
+// byte before it is torn down. A request-level timeout can't tell a stream
+// that stopped making progress from a long healthy one.
+const stallTimeout = 10 * time.Second
+
+// errStalled is returned by reads and writes on a stalled connection.
+// Providers should restart the stream on a fresh connection; the stall
+// has already been counted.
+var errStalled = errors.New("connection stalled")
+
+// countingConn attributes every byte crossing the socket, headers and TLS
+// records included, to a meter's wire counter. Every read and write gets
+// its own deadline, so a peer that accepts the connection and then goes
+// quiet fails the operation instead of freezing the phase.
+type countingConn struct {
+	net.Conn
+	m     *meter
+	stall time.Duration
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.stall))
+	n, err := c.Conn.Read(b)
+	c.m.AddWire(int64(n))
+	return n, c.checkStall(err)
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.stall))
+	n, err := c.Conn.Write(b)
+	c.m.AddWire(int64(n))
+	return n, c.checkStall(err)
+}
+
+func (c countingConn) checkStall(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.m.AddStall()
+		return fmt.Errorf("%w: no progress for %s", errStalled, c.stall)
+	}
+	return err
+}
+
+// newTransferClient returns a client whose connections count wire bytes into
+// m. Each transfer gets its own transport so pooled connections are never
+// reused across phases and their bytes can't be attributed to the wrong one;
+// callers should CloseIdleConnections when the transfer ends.
+func newTransferClient(m *meter) *http.Client {
+	return &http.Client{Transport: &transferTransport{tcp: newTCPTransferTransport(m), quic: newQUICTransport(m), m: m}}
+}
+
+// transferTransport sends a transfer's requests over TCP, or over QUIC when
+// the request's context asks for HTTP/3, and counts the protocol of every
+// response into m.
+type transferTransport struct {
+	tcp  *http.Transport
+	quic http.RoundTripper
+	m    *meter
+}
+
+func (t *transferTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := http.RoundTripper(t.tcp)
+	if wantsHTTP3(req.Context()) {
+		rt = t.quic
+	}
+	resp, err := rt.RoundTrip(req)
+	if err == nil {
+		t.m.AddProtocol(protocolName(resp))
+	}
+	return resp, err
+}
+
+func (t *transferTransport) CloseIdleConnections() {
+	t.tcp.CloseIdleConnections()
+	if c, ok := t.quic.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// newTCPTransferTransport is the TCP side of a transfer client.
+func newTCPTransferTransport(m *meter) *http.Transport {
+	transport := httpTransport()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer := bindDialer(ctx, &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second})
+		conn, err := dialer.DialContext(ctx, familyNetwork(ctx, network), addr)
+		if err != nil {
+			return nil, err
+		}
+		m.AddConn(conn)
+		return countingConn{Conn: conn, m: m, stall: stallTimeout}, nil
+	}
+	// The transport keeps reading idle connections, so they must be closed
+	// before their read deadline passes or they'd count as stalls.
+	transport.IdleConnTimeout = stallTimeout / 2
+	return transport
+}
+
+// runStreams runs one request after another on each of streams parallel
+// streams until duration is up, then reports what m counted. A stalled
+// stream starts over on a fresh request; any other failure ends the phase.
+func runStreams(ctx context.Context, clock Clock, duration time.Duration, streams int, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	phase, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	defer client.CloseIdleConnections()
+	start := clock.Now()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for phase.Err() == nil {
+				err := request(phase)
+				if err == nil || errors.Is(err, errStalled) || phase.Err() != nil {
+					continue
+				}
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		return Transfer{}, ctx.Err()
+	case firstErr != nil:
+		return Transfer{}, firstErr
+	}
+	elapsed := clock.Since(start)
+	bytes := m.Bytes()
+	return Transfer{
+		Mbps:     float64(bytes) * 8 / 1e6 / elapsed.Seconds(),
+		Bytes:    bytes,
+		Duration: elapsed,
+		Streams:  streams,
+	}, nil
+}
+
+// httpPinger times HTTP round trips on one kept-alive connection, for
+// servers whose only latency endpoint is a tiny resource. The first
+// request opens the connection and isn't timed.
+type httpPinger struct {
+	clock   Clock
+	client  *http.Client
+	request func(context.Context) (*http.Request, error)
+	// check turns a response that isn't a success into an error.
+	check func(*http.Response) error
+	warm  bool
+}
+
+func newHTTPPinger(clock Clock, request func(context.Context) (*http.Request, error), check func(*http.Response) error) *httpPinger {
+	transport := httpTransport()
+	transport.MaxIdleConnsPerHost = 1
+	return &httpPinger{clock: clock, client: &http.Client{Transport: transport, Timeout: handshakeTimeout}, request: request, check: check}
+}
+
+func (h *httpPinger) roundTrip(ctx context.Context) (float64, error) {
+	req, err := h.request(ctx)
+	if err != nil {
+		return 0, err
+	}
+	start := h.clock.Now()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if err := h.check(resp); err != nil {
+		return 0, err
+	}
+	return float64(h.clock.Since(start).Microseconds()) / 1000, nil
+}
+
+func (h *httpPinger) ping(ctx context.Context) (float64, error) {
+	if !h.warm {
+		if _, err := h.roundTrip(ctx); err != nil {
+			return 0, err
+		}
+		h.warm = true
+	}
+	return h.roundTrip(ctx)
+}
+
+// latency takes samples round trips, after the warm-up one ping makes,
+// reported with method "http".
+func (h *httpPinger) latency(ctx context.Context, samples int) (Latency, error) {
+	var rtts []float64
+	n := max(samples, 1)
+	for i := range n {
+		rtt, err := h.ping(ctx)
+		if ctx.Err() != nil {
+			return Latency{}, ctx.Err()
+		}
+		if err == nil {
+			rtts = append(rtts, rtt)
+		}
+		reportPingSample(ctx, pingSampleMsg{n: i + 1, of: n, rtt: rtt, lost: err != nil})
+	}
+	if len(rtts) == 0 {
+		return Latency{Source: provenanceUnavailable, Method: "http"}, nil
+	}
+	l := summarizeLatency(rtts)
+	l.Source = provenanceMeasured
+	l.Method = "http"
+	return l, nil
+}
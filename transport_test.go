@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortStallClient is a transfer client whose connections stall out after
+// stall rather than stallTimeout, so a stall takes a test moments.
+func shortStallClient(m *meter, stall time.Duration) *http.Client {
+	transport := newTCPTransferTransport(m)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return countingConn{Conn: conn, m: m, stall: stall}, nil
+	}
+	return &http.Client{Transport: transport}
+}
+
+// stallingServer sends 1000 bytes of a larger body, then goes quiet until
+// the test ends.
+func stallingServer(t *testing.T) *httptest.Server {
+	quit := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		w.Write(make([]byte, 1000))
+		w.(http.Flusher).Flush()
+		select {
+		case <-quit:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(quit)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestStalledReadTornDown(t *testing.T) {
+	srv := stallingServer(t)
+	m := &meter{}
+	client := shortStallClient(m, 200*time.Millisecond)
+	defer client.CloseIdleConnections()
+
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, errStalled) {
+		t.Fatalf("reading a stalled body: %v, want errStalled", err)
+	}
+	if n != 1000 {
+		t.Errorf("read %d bytes before the stall, want 1000", n)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the stall took %v to notice, want about 200ms", elapsed)
+	}
+	if m.Stalls() != 1 {
+		t.Errorf("%d stalls counted, want 1", m.Stalls())
+	}
+	if m.WireBytes() < 1000 {
+		t.Errorf("%d wire bytes counted, want at least the 1000 of the body", m.WireBytes())
+	}
+}
+
+// A connection that keeps moving, however slowly, never stalls.
+func TestSlowReadNotStalled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(5*100))
+		for range 5 {
+			w.Write(make([]byte, 100))
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	m := &meter{}
+	client := shortStallClient(m, 300*time.Millisecond)
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil || n != 500 {
+		t.Errorf("read %d bytes, %v; want all 500", n, err)
+	}
+	if m.Stalls() != 0 {
+		t.Errorf("%d stalls counted on a moving connection", m.Stalls())
+	}
+}
+
+// A stalled stream starts over on a fresh request and the phase carries on;
+// any other failure ends it.
+func TestRunStreamsRestartsStalled(t *testing.T) {
+	m := &meter{}
+	var calls atomic.Int32
+	transfer, err := runStreams(context.Background(), realClock{}, 300*time.Millisecond, 2, m, &http.Client{}, func(ctx context.Context) error {
+		if calls.Add(1)%3 == 0 {
+			return errStalled
+		}
+		m.Add(1000)
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("a stall ended the phase: %v", err)
+	}
+	if transfer.Bytes == 0 || transfer.Streams != 2 {
+		t.Errorf("transfer %+v, want bytes over 2 streams", transfer)
+	}
+
+	failure := errors.New("403 Forbidden")
+	_, err = runStreams(context.Background(), realClock{}, 5*time.Second, 2, &meter{}, &http.Client{}, func(ctx context.Context) error {
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("runStreams after a failed request: %v, want %v", err, failure)
+	}
+}
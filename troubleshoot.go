@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// troubleshootCheck is one step of the guided troubleshooter. Each run takes
+// the findings so far and returns them with its own measurements added,
+// together with a one-line summary of what it saw.
+type troubleshootCheck struct {
+	name string
+	// what describes the check before it runs.
+	what string
+	run  func(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error)
+}
+
+// troubleshootChecks run in this order, cheapest first. A new check is one
+// entry here plus any rules that read its findings.
+var troubleshootChecks = []troubleshootCheck{
+	{"Router latency", "round trip to your router and to " + pingHost, checkGateway},
+	{"DNS lookup", "lookup time of your resolver and of " + udpPingResolver, checkDNS},
+	{"Single vs multi-stream", "a short download over one connection, then several", checkStreams},
+	{"IPv4 vs IPv6", "TCP handshake to " + pingHost + " over each", checkIPVersions},
+	{"Alternate server", "a short download from a different server", checkAlternateServer},
+}
+
+// troubleshootTransfer is the length of each download a check runs.
+const troubleshootTransfer = 5 * time.Second
+
+// errNotApplicable marks a check that can't run against this provider or
+// system; it is shown as n/a rather than as a failure.
+var errNotApplicable = errors.New("not applicable")
+
+type troubleshootEnv struct {
+	engine  engine
+	results Results
+}
+
+// troubleshootFindings collects the numbers the checks measured; zero means
+// not measured.
+type troubleshootFindings struct {
+	Download float64
+
+	Method      string
+	GatewayRTT  float64
+	InternetRTT float64
+
+	DNSSystem float64
+	DNSPublic float64
+
+	Streams    int
+	SingleMbps float64
+	MultiMbps  float64
+
+	IPv4RTT float64
+	IPv6RTT float64
+
+	AltServer string
+	AltMbps   float64
+}
+
+func checkGateway(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return f, "", fmt.Errorf("%w: %v", errNotApplicable, err)
+	}
+	clock := env.engine.clock
+	var internet pingMethod = tcpPing{clock}
+	f.Method = "tcp"
+	if (icmpPing{}).Available() == nil {
+		internet = icmpPing{clock}
+		f.Method = "icmp"
+	}
+	if f.GatewayRTT, err = gatewayRTT(ctx, clock, f.Method, gateway); err != nil {
+		return f, "", fmt.Errorf("router %s: %w", gateway, err)
+	}
+	if f.InternetRTT, err = internet.Ping(ctx, pingHost); err != nil {
+		// The router alone still tells the local network apart.
+		return f, fmt.Sprintf("router %s %.0f ms, %s unreachable (%s)", gateway, f.GatewayRTT, pingHost, f.Method), nil
+	}
+	return f, fmt.Sprintf("router %s %.0f ms, %s %.0f ms (%s)", gateway, f.GatewayRTT, pingHost, f.InternetRTT, f.Method), nil
+}
+
+// gatewayRTT times one round trip to the router. Over TCP a refused
+// connection is as good as an accepted one: the reset comes back just as
+// fast.
+func gatewayRTT(ctx context.Context, clock Clock, method string, ip net.IP) (float64, error) {
+	if method == "icmp" {
+		return icmpPing{clock}.Ping(ctx, ip.String())
+	}
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	var d net.Dialer
+	start := clock.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), "80"))
+	rtt := msSince(clock, start)
+	if err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+		return 0, err
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	return rtt, nil
+}
+
+// defaultGateway is the router of the IPv4 default route.
+func defaultGateway() (net.IP, error) {
+	_, gateway, err := defaultRoute()
+	if err == nil && gateway == nil {
+		err = errors.New("the default route has no gateway")
+	}
+	return gateway, err
+}
+
+// defaultRoute reads the IPv4 default routes from /proc/net/route, so it is
+// only known on Linux. It returns the interface of the first and the first
+// gateway: a route straight out of an interface, as VPNs add, has none.
+func defaultRoute() (string, net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", nil, errors.New("the default gateway is only known on Linux")
+	}
+	defer f.Close()
+	var iface string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		if iface == "" {
+			iface = fields[0]
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, uint32(gw))
+		return iface, ip, nil
+	}
+	if iface != "" {
+		return iface, nil, nil
+	}
+	return "", nil, errors.New("no default route")
+}
+
+func checkDNS(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error) {
+	clock := env.engine.clock
+	lookupCtx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	start := clock.Now()
+	if _, err := net.DefaultResolver.LookupHost(lookupCtx, pingHost); err != nil {
+		return f, "", fmt.Errorf("your resolver: %w", err)
+	}
+	f.DNSSystem = msSince(clock, start)
+	summary := fmt.Sprintf("your resolver %.0f ms", f.DNSSystem)
+	if rtt, err := (udpPing{clock}).Ping(ctx, pingHost); err == nil {
+		f.DNSPublic = rtt
+		summary += fmt.Sprintf(", %s %.0f ms", udpPingResolver, rtt)
+	}
+	return f, summary, nil
+}
+
+func checkStreams(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error) {
+	e := env.engine
+	if e.withStreams == nil || e.provider.Capabilities().Simulated {
+		return f, "", fmt.Errorf("%w: %s has no real connections to vary", errNotApplicable, e.provider.Name())
+	}
+	f.Streams = max(e.profile.Streams, 4)
+	single, err := downloadWith(ctx, e, e.withStreams(1))
+	if err != nil {
+		return f, "", err
+	}
+	multi, err := downloadWith(ctx, e, e.withStreams(f.Streams))
+	if err != nil {
+		return f, "", err
+	}
+	f.SingleMbps, f.MultiMbps = single.Mbps, multi.Mbps
+	return f, fmt.Sprintf("1 stream %s, %d streams %s", formatSpeed(f.SingleMbps), f.Streams, formatSpeed(f.MultiMbps)), nil
+}
+
+// downloadWith runs a troubleshootTransfer-long download through p with the
+// engine's sampling, discarding the progress messages.
+func downloadWith(ctx context.Context, e engine, p Provider) (Transfer, error) {
+	e.provider = p
+	return e.transfer(ctx, "download", p.Download, transferLimit{time: troubleshootTransfer}, func(tea.Msg) {})
+}
+
+func checkIPVersions(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error) {
+	clock := env.engine.clock
+	var err4, err6 error
+	f.IPv4RTT, err4 = handshakeRTT(ctx, clock, "tcp4", pingHost)
+	f.IPv6RTT, err6 = handshakeRTT(ctx, clock, "tcp6", pingHost)
+	switch {
+	case err4 != nil && err6 != nil:
+		return f, "", err4
+	case err6 != nil:
+		return f, fmt.Sprintf("IPv4 %.0f ms, IPv6 unavailable", f.IPv4RTT), nil
+	case err4 != nil:
+		return f, fmt.Sprintf("IPv4 unavailable, IPv6 %.0f ms", f.IPv6RTT), nil
+	}
+	return f, fmt.Sprintf("IPv4 %.0f ms, IPv6 %.0f ms", f.IPv4RTT, f.IPv6RTT), nil
+}
+
+// handshakeRTT times a TCP handshake to host's port 443 over network, which
+// is "tcp4" or "tcp6". The lookup isn't timed.
+func handshakeRTT(ctx context.Context, clock Clock, network, host string) (float64, error) {
+	ctx, cancel := withPingTimeout(ctx)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+strings.TrimPrefix(network, "tcp"), host)
+	if err != nil {
+		return 0, err
+	}
+	var d net.Dialer
+	start := clock.Now()
+	conn, err := d.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), "443"))
+	if err != nil {
+		return 0, err
+	}
+	rtt := msSince(clock, start)
+	conn.Close()
+	return rtt, nil
+}
+
+func checkAlternateServer(ctx context.Context, env troubleshootEnv, f troubleshootFindings) (troubleshootFindings, string, error) {
+	e := env.engine
+	if _, ok := e.provider.(serverSelector); !ok {
+		return f, "", fmt.Errorf("%w: %s offers a single server", errNotApplicable, e.provider.Name())
+	}
+	e.avoidServer, e.sticky = env.results.Server.Name, nil
+	pick, err := e.selectServer(ctx, func(tea.Msg) {})
+	if err != nil {
+		return f, "", err
+	}
+	if pick.Server.Name == env.results.Server.Name {
+		return f, "", fmt.Errorf("%w: no other server passed its handshake", errNotApplicable)
+	}
+	t, err := downloadWith(ctx, e, e.provider)
+	if err != nil {
+		return f, "", err
+	}
+	f.AltServer, f.AltMbps = pick.Server.Label(), t.Mbps
+	return f, fmt.Sprintf("%s %s", truncate(f.AltServer, 32), formatSpeed(f.AltMbps)), nil
+}
+
+// culprit is a likely cause of a poor result with the numbers behind it.
+// Weight orders culprits; it grows with how far the evidence is from
+// normal.
+type culprit struct {
+	Name     string
+	Evidence string
+	Weight   float64
+}
+
+// troubleshootRule infers one culprit from the findings, reporting a zero
+// weight when the evidence doesn't point at it.
+type troubleshootRule struct {
+	name  string
+	match func(f troubleshootFindings) (weight float64, evidence string)
+}
+
+var troubleshootRules = []troubleshootRule{
+	{"Local network (Wi-Fi or cabling)", func(f troubleshootFindings) (float64, string) {
+		if f.GatewayRTT <= 10 {
+			return 0, ""
+		}
+		return f.GatewayRTT / 10, fmt.Sprintf("your router answers in %.0f ms → a healthy home network is under 5 ms", f.GatewayRTT)
+	}},
+	{"ISP or upstream path", func(f troubleshootFindings) (float64, string) {
+		if f.GatewayRTT == 0 || f.InternetRTT <= 60 || f.InternetRTT < 5*f.GatewayRTT {
+			return 0, ""
+		}
+		return f.InternetRTT / 60, fmt.Sprintf("router %.0f ms but %s %.0f ms → the delay is beyond your network", f.GatewayRTT, pingHost, f.InternetRTT)
+	}},
+	{"Per-flow shaping or a high-latency path", func(f troubleshootFindings) (float64, string) {
+		if f.SingleMbps == 0 || f.MultiMbps < 2*f.SingleMbps {
+			return 0, ""
+		}
+		ratio := f.MultiMbps / f.SingleMbps
+		return ratio, fmt.Sprintf("%d streams are %.1f× one stream (%s vs %s) → per-flow shaping or high latency path", f.Streams, ratio, formatSpeed(f.MultiMbps), formatSpeed(f.SingleMbps))
+	}},
+	{"Line capacity", func(f troubleshootFindings) (float64, string) {
+		if f.SingleMbps == 0 || f.MultiMbps >= 1.25*f.SingleMbps {
+			return 0, ""
+		}
+		return 1.5, fmt.Sprintf("more streams add nothing (%s vs %s) → the line itself is full", formatSpeed(f.MultiMbps), formatSpeed(f.SingleMbps))
+	}},
+	{"Test server", func(f troubleshootFindings) (float64, string) {
+		if f.AltMbps == 0 || f.Download == 0 || f.AltMbps < 1.5*f.Download {
+			return 0, ""
+		}
+		return f.AltMbps / f.Download, fmt.Sprintf("%s reached %s vs %s → the first server was the bottleneck", truncate(f.AltServer, 32), formatSpeed(f.AltMbps), formatSpeed(f.Download))
+	}},
+	{"IPv6 path", func(f troubleshootFindings) (float64, string) {
+		if f.IPv4RTT == 0 || f.IPv6RTT < 1.5*f.IPv4RTT || f.IPv6RTT-f.IPv4RTT < 20 {
+			return 0, ""
+		}
+		return f.IPv6RTT / f.IPv4RTT, fmt.Sprintf("IPv6 %.0f ms vs IPv4 %.0f ms → apps preferring IPv6 take the slower route", f.IPv6RTT, f.IPv4RTT)
+	}},
+	{"Slow DNS resolver", func(f troubleshootFindings) (float64, string) {
+		if f.DNSSystem <= 100 || (f.DNSPublic > 0 && f.DNSSystem < 3*f.DNSPublic) {
+			return 0, ""
+		}
+		return 1, fmt.Sprintf("lookups take %.0f ms → pages start slowly, though downloads aren't affected", f.DNSSystem)
+	}},
+}
+
+// diagnose applies every rule and ranks the culprits, most likely first.
+func diagnose(f troubleshootFindings) []culprit {
+	var culprits []culprit
+	for _, rule := range troubleshootRules {
+		if weight, evidence := rule.match(f); weight > 0 {
+			culprits = append(culprits, culprit{Name: rule.name, Evidence: evidence, Weight: weight})
+		}
+	}
+	slices.SortStableFunc(culprits, func(a, b culprit) int { return cmp.Compare(b.Weight, a.Weight) })
+	return culprits
+}
+
+type checkStatus int
+
+const (
+	checkPending checkStatus = iota
+	checkRunning
+	checkDone
+	checkSkipped
+	checkFailed
+	checkNA
+)
+
+// troubleshooting is the state of the guided flow on the completion screen.
+type troubleshooting struct {
+	step     int
+	status   []checkStatus
+	summary  []string
+	findings troubleshootFindings
+	// cancel stops the running check when it is skipped.
+	cancel context.CancelFunc
+}
+
+type troubleshootMsg struct {
+	step     int
+	findings troubleshootFindings
+	summary  string
+	err      error
+}
+
+// needsTroubleshooting offers the troubleshooter when the download was
+// fair or worse, unusually slow for this connection, or under half the plan.
+func (m speedTest) needsTroubleshooting() bool {
+	if m.results.Download == nil {
+		return false
+	}
+	if gradeSpeed(m.downloadSpeed) <= gradeFair {
+		return true
+	}
+	if r := m.results.Regression; r != nil && r.Notable {
+		return true
+	}
+	return m.opts.PlanDownload > 0 && m.downloadSpeed < m.opts.PlanDownload/2
+}
+
+func (m speedTest) troubleshoot() (tea.Model, tea.Cmd) {
+	m.troubleshooting = &troubleshooting{
+		status:   make([]checkStatus, len(troubleshootChecks)),
+		summary:  make([]string, len(troubleshootChecks)),
+		findings: troubleshootFindings{Download: m.downloadSpeed},
+	}
+	return m, nil
+}
+
+// updateTroubleshoot handles keys while the troubleshooter is open: enter
+// runs the current check, 'x' skips it, 't' or esc returns to the results.
+func (m speedTest) updateTroubleshoot(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	t := m.troubleshooting
+	switch msg.String() {
+	case "t", "esc":
+		if t.cancel != nil {
+			t.cancel()
+		}
+		m.troubleshooting = nil
+	case "enter":
+		if t.step >= len(t.status) || t.status[t.step] == checkRunning {
+			break
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		t.cancel = cancel
+		t.status[t.step] = checkRunning
+		step, env, findings := t.step, troubleshootEnv{engine: m.engine, results: m.results}, t.findings
+		return m, func() tea.Msg {
+			defer cancel()
+			f, summary, err := troubleshootChecks[step].run(ctx, env, findings)
+			return troubleshootMsg{step: step, findings: f, summary: summary, err: err}
+		}
+	case "x":
+		if t.step >= len(t.status) {
+			break
+		}
+		if t.cancel != nil {
+			t.cancel()
+			t.cancel = nil
+		}
+		t.status[t.step] = checkSkipped
+		t.step++
+	}
+	return m, nil
+}
+
+// finishCheck records a check's outcome unless it was skipped meanwhile.
+func (t *troubleshooting) finishCheck(msg troubleshootMsg) {
+	if msg.step != t.step || t.status[msg.step] != checkRunning {
+		return
+	}
+	t.cancel = nil
+	switch {
+	case errors.Is(msg.err, errNotApplicable):
+		t.status[msg.step] = checkNA
+		t.summary[msg.step] = strings.TrimPrefix(msg.err.Error(), errNotApplicable.Error()+": ")
+	case msg.err != nil:
+		t.status[msg.step] = checkFailed
+		t.summary[msg.step] = msg.err.Error()
+	default:
+		t.status[msg.step] = checkDone
+		t.summary[msg.step] = msg.summary
+		t.findings = msg.findings
+	}
+	t.step++
+}
+
+func (t *troubleshooting) view() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Troubleshooting a %s download\n\n", formatSpeed(t.findings.Download)))
+	for i, check := range troubleshootChecks {
+		line := fmt.Sprintf("%-24s", check.name)
+		switch t.status[i] {
+		case checkPending:
+			if i == t.step {
+				line = fmt.Sprintf("\033[36;1m▸ %s\033[0m \033[90m%s\033[0m", line, check.what)
+			} else {
+				line = "  " + line
+			}
+		case checkRunning:
+			line = fmt.Sprintf("\033[36;1m⋯ %s\033[0m running...", line)
+		case checkDone:
+			line = fmt.Sprintf("\033[32m✓\033[0m %s %s", line, t.summary[i])
+		case checkSkipped:
+			line = fmt.Sprintf("\033[90m– %s skipped\033[0m", line)
+		case checkFailed:
+			line = fmt.Sprintf("\033[31m✗\033[0m %s \033[31m%s\033[0m", line, t.summary[i])
+		case checkNA:
+			line = fmt.Sprintf("\033[90m· %s n/a: %s\033[0m", line, t.summary[i])
+		}
+		s.WriteString("  " + line + "\n")
+	}
+
+	finished := t.step >= len(t.status)
+	if culprits := diagnose(t.findings); len(culprits) > 0 {
+		s.WriteString("\nLikely culprits:\n")
+		for i, c := range culprits {
+			s.WriteString(fmt.Sprintf("  %d. \033[1m%s\033[0m\n     \033[90m%s\033[0m\n", i+1, c.Name, c.Evidence))
+		}
+	} else if finished {
+		s.WriteString("\nNo clear culprit: nothing the checks measured looks abnormal.\n")
+	}
+
+	switch {
+	case finished:
+		s.WriteString("\n\033[90m't' to return to the results\033[0m")
+	case t.status[t.step] == checkRunning:
+		s.WriteString("\n\033[90m'x' to skip this check, 't' to return to the results\033[0m")
+	default:
+		s.WriteString("\n\033[90menter to run this check, 'x' to skip it, 't' to return to the results\033[0m")
+	}
+	return s.String()
+}
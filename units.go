@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// gaugeScales are the full-scale ranges the gauges step through, in Mbps.
+var gaugeScales = []float64{1, 10, 100, 1000, 10000}
+
+// gaugeScale returns the smallest gauge range that fits speed.
+func gaugeScale(speed float64) float64 {
+	for _, scale := range gaugeScales {
+		if speed <= scale {
+			return scale
+		}
+	}
+	return gaugeScales[len(gaugeScales)-1]
+}
+
+// scaleHysteresis is how far into a smaller range a speed must fall before a
+// gauge steps down to it, so a speed hovering on a boundary doesn't flap.
+const scaleHysteresis = 0.8
+
+// nextGaugeScale steps a gauge's range to fit speed: up as soon as speed
+// overflows the current range, down only once it is well inside a smaller one.
+// A zero current range picks the smallest that fits.
+func nextGaugeScale(current, speed float64) float64 {
+	want := gaugeScale(speed)
+	if current == 0 || want > current {
+		return want
+	}
+	if want < current && speed < want*scaleHysteresis {
+		return want
+	}
+	return current
+}
+
+// formatGaugeSpeed renders a readout in the unit of the gauge it sits under,
+// so a gauge ranged in kbps isn't captioned in Mbps.
+func formatGaugeSpeed(mbps, scale float64) string {
+	switch {
+	case scale <= 1:
+		return fmt.Sprintf("%.0f kbps", mbps*1000)
+	case mbps < 1:
+		return fmt.Sprintf("%.2f Mbps", mbps)
+	default:
+		return formatSpeed(mbps)
+	}
+}
+
+// speedUnits is "bits" or "bytes", from the config. It is set once before
+// the TUI starts; machine-readable output is always in Mbps.
+var speedUnits = "bits"
+
+// formatSpeed renders an Mbps value in the most readable unit.
+func formatSpeed(mbps float64) string {
+	if speedUnits == "bytes" {
+		return formatByteRate(mbps / 8)
+	}
+	switch {
+	case mbps > 0 && mbps < 1:
+		return fmt.Sprintf("%.0f kbps", mbps*1000)
+	case mbps >= 1000:
+		return fmt.Sprintf("%.2f Gbps", mbps/1000)
+	default:
+		return fmt.Sprintf("%.2f Mbps", mbps)
+	}
+}
+
+func formatByteRate(mbytes float64) string {
+	switch {
+	case mbytes > 0 && mbytes < 1:
+		return fmt.Sprintf("%.0f kB/s", mbytes*1000)
+	case mbytes >= 1000:
+		return fmt.Sprintf("%.2f GB/s", mbytes/1000)
+	default:
+		return fmt.Sprintf("%.2f MB/s", mbytes)
+	}
+}
+
+// speedSuffixes are the units accepted after a configured speed, in Mbps.
+// Lowercase b is bits and uppercase B bytes, as on every speed test site;
+// nobody means millibits, so "mbps" is Mbps.
+var speedSuffixes = map[string]float64{
+	"bps": 1e-6, "kbps": 1e-3, "Kbps": 1e-3, "Mbps": 1, "Gbps": 1000,
+	"mbps": 1, "gbps": 1000,
+	"kb/s": 1e-3, "Mb/s": 1, "Gb/s": 1000,
+	"kbit/s": 1e-3, "Mbit/s": 1, "Gbit/s": 1000,
+	"B/s": 8e-6, "kB/s": 8e-3, "KB/s": 8e-3, "MB/s": 8, "GB/s": 8000,
+}
+
+// parseSpeed reads a speed such as "500", "500Mbps", "62.5 MB/s" or
+// "0,5Gbps" and returns it in Mbps. A bare number is taken as Mbps and
+// reported as bare, since it is easily meant as MB/s. A decimal comma is
+// accepted, except where it could be a thousands separator.
+func parseSpeed(s string) (mbps float64, bare bool, err error) {
+	s = strings.TrimSpace(s)
+	number, unit := s, ""
+	if i := strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789.,-+", r) }); i >= 0 {
+		number, unit = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i:])
+	}
+	if strings.Count(number, ",") > 1 {
+		return 0, false, fmt.Errorf("%q: leave out the thousands separators: write %s", s, strings.ReplaceAll(number, ",", ""))
+	}
+	if whole, frac, ok := strings.Cut(number, ","); ok {
+		if strings.ContainsAny(frac, ".,") || strings.Contains(whole, ".") {
+			return 0, false, fmt.Errorf("%q: use a decimal point or a decimal comma, not both", s)
+		}
+		if len(frac) == 3 {
+			return 0, false, fmt.Errorf("%q is ambiguous: write %s%s or %s.%s", s, whole, frac, whole, frac)
+		}
+		number = whole + "." + frac
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%q is not a speed", s)
+	}
+	if v < 0 {
+		return 0, false, fmt.Errorf("%q: must not be negative", s)
+	}
+	if unit == "" {
+		return v, true, nil
+	}
+	factor, ok := speedSuffixes[unit]
+	if !ok {
+		return 0, false, fmt.Errorf("%q: unknown unit %q (try Mbps, Gbps or MB/s)", s, unit)
+	}
+	return v * factor, false, nil
+}
+
+// speedFlag is a flag.Value for speeds with optional unit suffixes.
+type speedFlag struct {
+	mbps float64
+	bare bool
+}
+
+func (f *speedFlag) String() string { return strconv.FormatFloat(f.mbps, 'f', -1, 64) }
+
+func (f *speedFlag) Set(s string) (err error) {
+	f.mbps, f.bare, err = parseSpeed(s)
+	return err
+}
+
+// bareSpeed is a setting given as a bare number, checked against history
+// in case the user meant another unit.
+type bareSpeed struct {
+	Name   string
+	Mbps   float64
+	Upload bool
+}
+
+// unitHint returns a hint when a bare speed is wildly inconsistent with
+// every measured run in the same direction, or "" if it is plausible or
+// there isn't enough history to tell.
+func unitHint(b bareSpeed, runs []Results) string {
+	lo, hi, n := math.Inf(1), 0.0, 0
+	for _, r := range runs {
+		t := r.Download
+		if b.Upload {
+			t = r.Upload
+		}
+		if t == nil || t.Source != provenanceMeasured {
+			continue
+		}
+		lo, hi, n = math.Min(lo, t.Mbps), math.Max(hi, t.Mbps), n+1
+	}
+	if n < 3 || b.Mbps == 0 || (b.Mbps <= 4*hi && b.Mbps >= lo/4) {
+		return ""
+	}
+	return fmt.Sprintf("Hint: %s is %g, but every run in history measured %.0f–%.0f Mbps. Bare numbers are Mbps; add a unit such as %gMB/s or %gkbps if you meant another one.",
+		b.Name, b.Mbps, lo, hi, b.Mbps, b.Mbps)
+}
+
+// axisLabels returns the eleven tick labels for a gauge range and the unit
+// they are expressed in. Sub-megabit ranges are labelled in kbps.
+func axisLabels(scale float64) ([]string, string) {
+	labels := make([]string, 11)
+	if scale <= 1 {
+		for i := range labels {
+			labels[i] = strconv.FormatFloat(scale*1000*float64(i)/10, 'f', -1, 64)
+		}
+		return labels, "kbps"
+	}
+
+	unit, divisor := "Mbps", 1.0
+	if scale >= 10000 {
+		unit, divisor = "Gbps", 1000
+	}
+	for i := range labels {
+		labels[i] = strconv.FormatFloat(scale*float64(i)/10/divisor, 'f', -1, 64)
+	}
+	return labels, unit
+}
+
+// axisLine renders the tick labels under a gauge, aligned to its columns.
+func axisLine(scale float64) string {
+	labels, _ := axisLabels(scale)
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("%6s", labels[0]))
+	for _, label := range labels[1:] {
+		s.WriteString(fmt.Sprintf("%5s", label))
+	}
+	return s.String()
+}
+
+type speedGrade int
+
+const (
+	gradePoor speedGrade = iota
+	gradeFair
+	gradeGood
+	gradeExcellent
+)
+
+// gradeSpeed gives a verdict on an absolute throughput figure.
+func gradeSpeed(mbps float64) speedGrade {
+	switch {
+	case mbps < 1:
+		return gradePoor
+	case mbps < 10:
+		return gradeFair
+	case mbps < 100:
+		return gradeGood
+	default:
+		return gradeExcellent
+	}
+}
+
+func (g speedGrade) String() string {
+	switch g {
+	case gradePoor:
+		return "poor"
+	case gradeFair:
+		return "fair"
+	case gradeGood:
+		return "good"
+	default:
+		return "excellent"
+	}
+}
+
+// cue is how readouts of this grade are shown.
+func (g speedGrade) cue() cue {
+	return cues.Grades[g]
+}
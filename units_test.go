@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gofast/gauge"
+)
+
+func TestSpeedEdgeCases(t *testing.T) {
+	tests := []struct {
+		mbps     float64
+		text     string
+		bytes    string
+		scale    float64
+		readout  string
+		axisUnit string
+		fraction float64
+		grade    speedGrade
+	}{
+		{0.05, "50 kbps", "6 kB/s", 1, "50 kbps", "kbps", 0.05, gradePoor},
+		{0.8, "800 kbps", "100 kB/s", 1, "800 kbps", "kbps", 0.8, gradePoor},
+		{95, "95.00 Mbps", "11.88 MB/s", 100, "95.00 Mbps", "Mbps", 0.95, gradeGood},
+		{940, "940.00 Mbps", "117.50 MB/s", 1000, "940.00 Mbps", "Mbps", 0.94, gradeExcellent},
+		{2400, "2.40 Gbps", "300.00 MB/s", 10000, "2.40 Gbps", "Gbps", 0.24, gradeExcellent},
+	}
+	saved := speedUnits
+	defer func() { speedUnits = saved }()
+	for _, tt := range tests {
+		speedUnits = "bits"
+		if got := formatSpeed(tt.mbps); got != tt.text {
+			t.Errorf("formatSpeed(%g) = %q, want %q", tt.mbps, got, tt.text)
+		}
+		scale := gaugeScale(tt.mbps)
+		if scale != tt.scale {
+			t.Errorf("gaugeScale(%g) = %g, want %g", tt.mbps, scale, tt.scale)
+		}
+		if got := formatGaugeSpeed(tt.mbps, scale); got != tt.readout {
+			t.Errorf("formatGaugeSpeed(%g, %g) = %q, want %q", tt.mbps, scale, got, tt.readout)
+		}
+		if _, unit := axisLabels(scale); unit != tt.axisUnit {
+			t.Errorf("axis of %g is in %s, want %s", tt.mbps, unit, tt.axisUnit)
+		}
+		if got := gauge.New(gauge.Options{Max: scale}).Fraction(tt.mbps); math.Abs(got-tt.fraction) > 1e-9 {
+			t.Errorf("needle for %g at %g of the dial, want %g", tt.mbps, got, tt.fraction)
+		}
+		if got := gradeSpeed(tt.mbps); got != tt.grade {
+			t.Errorf("gradeSpeed(%g) = %v, want %v", tt.mbps, got, tt.grade)
+		}
+		speedUnits = "bytes"
+		if got := formatSpeed(tt.mbps); got != tt.bytes {
+			t.Errorf("formatSpeed(%g) in bytes = %q, want %q", tt.mbps, got, tt.bytes)
+		}
+	}
+}
+
+func TestNextGaugeScale(t *testing.T) {
+	tests := []struct {
+		current, speed, want float64
+	}{
+		{0, 0.05, 1},
+		{0, 2400, 10000},
+		{100, 940, 1000},
+		{1000, 95, 1000},
+		{1000, 79, 100},
+		{10, 0.8, 10},
+		{10, 0.5, 1},
+	}
+	for _, tt := range tests {
+		if got := nextGaugeScale(tt.current, tt.speed); got != tt.want {
+			t.Errorf("nextGaugeScale(%g, %g) = %g, want %g", tt.current, tt.speed, got, tt.want)
+		}
+	}
+}
+
+func TestParseSpeed(t *testing.T) {
+	tests := []struct {
+		in   string
+		mbps float64
+		bare bool
+	}{
+		{"500", 500, true},
+		{"500Mbps", 500, false},
+		{"62.5 MB/s", 500, false},
+		{"0,5Gbps", 500, false},
+		{"800kbps", 0.8, false},
+		{"2.4 Gbit/s", 2400, false},
+	}
+	for _, tt := range tests {
+		mbps, bare, err := parseSpeed(tt.in)
+		if err != nil || math.Abs(mbps-tt.mbps) > 1e-9 || bare != tt.bare {
+			t.Errorf("parseSpeed(%q) = %g, %v, %v; want %g, %v", tt.in, mbps, bare, err, tt.mbps, tt.bare)
+		}
+	}
+
+	rejected := []struct {
+		in, err string
+	}{
+		{"1,000", "ambiguous: write 1000 or 1.000"},
+		{"1,000Mbps", "ambiguous"},
+		{"1,000,000", "leave out the thousands separators: write 1000000"},
+		{"2,500,000 kbps", "write 2500000"},
+		{"-5", "must not be negative"},
+		{"-0,5Gbps", "must not be negative"},
+		{"500 furlongs", `unknown unit "furlongs"`},
+		{"500 mb", `unknown unit "mb"`},
+		{"1.000,5", "not both"},
+		{"1,5.0", "not both"},
+		{"", "is not a speed"},
+		{"   ", "is not a speed"},
+		{"Mbps", "is not a speed"},
+		{"1..5", "is not a speed"},
+	}
+	for _, tt := range rejected {
+		mbps, _, err := parseSpeed(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseSpeed(%q) = %g, %v; want an error containing %q", tt.in, mbps, err, tt.err)
+		}
+	}
+}
+
+func TestUnitHint(t *testing.T) {
+	measured := func(down, up float64) Results {
+		return Results{Download: &Transfer{Mbps: down, Source: provenanceMeasured}, Upload: &Transfer{Mbps: up, Source: provenanceMeasured}}
+	}
+	history := []Results{measured(400, 20), measured(480, 22), measured(520, 25)}
+	simulated := Results{Download: &Transfer{Mbps: 5000, Source: provenanceSimulated}}
+	for _, tt := range []struct {
+		name string
+		bare bareSpeed
+		runs []Results
+		hint bool
+	}{
+		{"plausible", bareSpeed{Name: "min_download", Mbps: 300}, history, false},
+		{"at four times the fastest", bareSpeed{Name: "min_download", Mbps: 2080}, history, false},
+		{"at a quarter of the slowest", bareSpeed{Name: "min_download", Mbps: 100}, history, false},
+		{"meant kbps", bareSpeed{Name: "min_download", Mbps: 50000}, history, true},
+		{"meant Gbps", bareSpeed{Name: "min_download", Mbps: 0.5}, history, true},
+		{"upload against uploads", bareSpeed{Name: "min_upload", Mbps: 20, Upload: true}, history, false},
+		{"upload against downloads", bareSpeed{Name: "min_upload", Mbps: 400, Upload: true}, history, true},
+		{"zero", bareSpeed{Name: "min_download", Mbps: 0}, history, false},
+		{"too little history", bareSpeed{Name: "min_download", Mbps: 50000}, history[:2], false},
+		{"simulated runs don't count", bareSpeed{Name: "min_download", Mbps: 5000}, append([]Results{simulated, simulated}, history[0]), false},
+		{"runs without the direction", bareSpeed{Name: "min_upload", Mbps: 5000, Upload: true}, []Results{{}, {}, {}, {}}, false},
+	} {
+		hint := unitHint(tt.bare, tt.runs)
+		if (hint != "") != tt.hint {
+			t.Errorf("%s: unitHint(%+v) = %q, want a hint %v", tt.name, tt.bare, hint, tt.hint)
+		}
+		if hint != "" && !strings.Contains(hint, tt.bare.Name) {
+			t.Errorf("%s: hint %q doesn't name %s", tt.name, hint, tt.bare.Name)
+		}
+	}
+	if got := unitHint(bareSpeed{Name: "min_download", Mbps: 50000}, history); !strings.Contains(got, "400–520 Mbps") || !strings.Contains(got, "50000kbps") {
+		t.Errorf("hint %q doesn't give the measured range and a unit", got)
+	}
+}
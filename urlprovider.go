@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// urlChunk is what a download asks a ?bytes= generator for in one
+	// request, and the size of one upload body.
+	urlChunk = 25 << 20
+	// urlDuration is a transfer's length when the profile leaves it to the
+	// provider.
+	urlDuration = 10 * time.Second
+	// urlStreams is the number of parallel transfers when the profile
+	// doesn't say.
+	urlStreams = 4
+)
+
+// urlProvider measures against any HTTP(S) resource given with --url, and
+// any URL that accepts POST bodies given with --upload-url. The resource
+// may be a fixed-size file, fetched again and again, or a generator that
+// takes the size to send in a bytes query parameter, such as Cloudflare's
+// __down.
+type urlProvider struct {
+	clock       Clock
+	download    string
+	upload      string
+	duration    time.Duration
+	pingSamples int
+	streams     int
+	// compressible sends zeros instead of random upload data.
+	compressible bool
+	ping         *serverPing
+}
+
+func newURLProvider(opts providerOptions) Provider {
+	return urlProvider{
+		clock:        opts.Clock,
+		download:     opts.DownloadURL,
+		upload:       opts.UploadURL,
+		duration:     opts.Duration,
+		pingSamples:  opts.PingSamples,
+		streams:      opts.Streams,
+		compressible: opts.Compressible,
+		ping:         newServerPing(opts.Ping),
+	}
+}
+
+func (urlProvider) Name() string { return "url" }
+
+func (p urlProvider) Capabilities() Capabilities {
+	return Capabilities{Upload: p.upload != "", LoadedLatency: true}
+}
+
+func (p urlProvider) host() string {
+	if u, err := url.Parse(p.download); err == nil {
+		return u.Host
+	}
+	return p.download
+}
+
+// sized is the download URL asking for n bytes when it is a generator, and
+// as given when it is a fixed resource.
+func (p urlProvider) sized(n int64) string {
+	return sizedURL(p.download, n)
+}
+
+func sizedURL(raw string, n int64) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	if !q.Has("bytes") {
+		return raw
+	}
+	q.Set("bytes", strconv.FormatInt(n, 10))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// small requests as little of the resource as it will send: nothing from a
+// generator, and the first byte of a fixed resource from servers that
+// honor ranges.
+func (p urlProvider) small(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.sized(0), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	return req, nil
+}
+
+func (p urlProvider) check(resp *http.Response) error {
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// Server names the server by the URL's host; an arbitrary server reports
+// no name or location of its own.
+func (p urlProvider) Server(ctx context.Context) (Server, error) {
+	if p.download == "" {
+		return Server{}, errors.New("the url provider needs --url with the resource to download")
+	}
+	return Server{Name: p.host(), Host: p.host(), URL: p.download, LocationSource: provenanceUnavailable}, nil
+}
+
+func (p urlProvider) pinger() *httpPinger {
+	return newHTTPPinger(p.clock, p.small, p.check)
+}
+
+func (p urlProvider) Ping(ctx context.Context) (Latency, error) {
+	if l, ok, err := p.ping.latency(ctx, p.download, p.pingSamples); ok || err != nil {
+		return l, err
+	}
+	pinger := p.pinger()
+	defer pinger.client.CloseIdleConnections()
+	return pinger.latency(ctx, p.pingSamples)
+}
+
+func (p urlProvider) Probe(ctx context.Context) (float64, error) {
+	if rtt, ok, err := p.ping.probe(ctx, p.download); ok {
+		return rtt, err
+	}
+	pinger := p.pinger()
+	defer pinger.client.CloseIdleConnections()
+	pinger.warm = true
+	return pinger.ping(ctx)
+}
+
+func (p urlProvider) TraceRequest(ctx context.Context) (*http.Request, error) {
+	return p.small(ctx)
+}
+
+// Download fetches the resource over parallel streams, adding every byte
+// to m as it arrives.
+func (p urlProvider) Download(ctx context.Context, m *meter) (Transfer, error) {
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		u := p.sized(urlChunk)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		record := transferRecord{URL: u, Offset: -1, Began: p.clock.Now()}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := p.check(resp); err != nil {
+			return err
+		}
+		record.Expected = resp.ContentLength
+		return readCounted(ctx, p.clock, resp, m, record)
+	})
+}
+
+// Upload POSTs generated payload to the upload URL over parallel streams,
+// counting bytes into m as the transport reads them for sending.
+func (p urlProvider) Upload(ctx context.Context, m *meter) (Transfer, error) {
+	if p.upload == "" {
+		return Transfer{}, errors.New("the url provider uploads only with --upload-url")
+	}
+	client := newTransferClient(m)
+	return p.streamed(ctx, m, client, func(ctx context.Context) error {
+		body := &meteredReader{r: uploadBody(urlChunk, p.compressible), m: m}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.upload, body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = urlChunk
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return p.check(resp)
+	})
+}
+
+// streamed runs request on every stream until the phase's duration is up,
+// with the provider's defaults for what the profile leaves open.
+func (p urlProvider) streamed(ctx context.Context, m *meter, client *http.Client, request func(context.Context) error) (Transfer, error) {
+	duration := p.duration
+	if duration <= 0 {
+		duration = urlDuration
+	}
+	streams := p.streams
+	if streams <= 0 {
+		streams = urlStreams
+	}
+	return runStreams(ctx, p.clock, duration, streams, m, client, request)
+}
+
+// checkTestURLs makes sure, before the test starts, that the resource
+// given with --url can be downloaded and the upload URL takes a POST, so
+// a typo fails with advice instead of partway through the run.
+func checkTestURLs(ctx context.Context, download, upload string) error {
+	client := &http.Client{Transport: httpTransport(), Timeout: handshakeTimeout}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sizedURL(download, 1024), nil)
+	if err != nil {
+		return fmt.Errorf("--url %s: %w", download, err)
+	}
+	req.Header.Set("Range", "bytes=0-1023")
+	if err := checkTestRequest(client, req, "--url"); err != nil {
+		return err
+	}
+	if upload == "" {
+		return nil
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, upload, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("--upload-url %s: %w", upload, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return checkTestRequest(client, req, "--upload-url")
+}
+
+func checkTestRequest(client *http.Client, req *http.Request, flag string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s %s: couldn't reach it: %w", flag, req.URL, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed && req.Method == http.MethodPost:
+		return fmt.Errorf("%s %s doesn't accept POST bodies (%s); give a URL that takes uploads, or leave %s out to skip the upload", flag, req.URL, resp.Status, flag)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s %s: the server refused access (%s)", flag, req.URL, resp.Status)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s %s: the server answered %s; check the URL", flag, req.URL, resp.Status)
+	}
+	return nil
+}
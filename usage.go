@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"time"
+)
+
+// ProcessUsage is gofast's own resource use over an interval. On weak
+// hardware the process itself can become the bottleneck of a fast test.
+type ProcessUsage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// CPUPercent is CPU time over wall time; 100 is one core fully busy.
+	CPUPercent   float64 `json:"cpu_percent"`
+	PeakRSSBytes int64   `json:"peak_rss_bytes"`
+	// RSSApproximate is set where the peak RSS isn't available and the
+	// Go runtime's own footprint is reported instead.
+	RSSApproximate bool `json:"rss_approximate,omitempty"`
+}
+
+// cpuBoundPercent is the CPU use above which a run is flagged: gofast was
+// busy enough that the measurement may have been limited by this machine.
+const cpuBoundPercent = 80
+
+// processStart anchors the lifetime usage reported over the control socket.
+var processStart = time.Now()
+
+// usageMark is a reading of cumulative CPU time, taken at the start of an
+// interval.
+type usageMark struct {
+	at  time.Time
+	cpu time.Duration
+}
+
+func markUsage() usageMark {
+	cpu, _ := processCPU()
+	return usageMark{at: time.Now(), cpu: cpu}
+}
+
+// since returns the usage from the mark until now, or nil where the
+// process's CPU time can't be read.
+func (u usageMark) since() *ProcessUsage {
+	cpu, ok := processCPU()
+	if !ok {
+		return nil
+	}
+	usage := &ProcessUsage{CPUSeconds: (cpu - u.cpu).Seconds()}
+	if wall := time.Since(u.at).Seconds(); wall > 0 {
+		usage.CPUPercent = usage.CPUSeconds / wall * 100
+	}
+	if rss, ok := processPeakRSS(); ok {
+		usage.PeakRSSBytes = rss
+	} else {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		usage.PeakRSSBytes, usage.RSSApproximate = int64(stats.Sys), true
+	}
+	return usage
+}
+
+// CPUBound reports whether the process was busy enough to skew results.
+func (u *ProcessUsage) CPUBound() bool {
+	return u != nil && u.CPUPercent >= cpuBoundPercent
+}
+
+// loopbackDuration is how long --measure-overhead streams over loopback.
+const loopbackDuration = 2 * time.Second
+
+// measureLoopback streams generated payload to itself over a loopback TCP
+// connection. The rate is a ceiling on what gofast can measure on this
+// machine, whatever the network.
+func measureLoopback(ctx context.Context) (mbps float64, usage *ProcessUsage, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+
+	mark := markUsage()
+	conn.SetWriteDeadline(time.Now().Add(loopbackDuration))
+	n, _ := io.Copy(conn, newPayloadReader(false))
+	elapsed := time.Since(mark.at)
+	return float64(n) * 8 / 1e6 / elapsed.Seconds(), mark.since(), nil
+}
+
+// formatUsage renders usage for the details view, e.g.
+// "0.4s CPU (12% of a core), 18 MB peak RSS".
+func formatUsage(u *ProcessUsage) string {
+	rss := fmt.Sprintf("%.0f MB peak RSS", float64(u.PeakRSSBytes)/1e6)
+	if u.RSSApproximate {
+		rss = "~" + rss
+	}
+	return fmt.Sprintf("%.1fs CPU (%.0f%% of a core), %s", u.CPUSeconds, u.CPUPercent, rss)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func processCPU() (time.Duration, bool) {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(windows.CurrentProcess(), &creation, &exit, &kernel, &user); err != nil {
+		return 0, false
+	}
+	// Filetimes count 100ns intervals.
+	ticks := (int64(kernel.HighDateTime)<<32 | int64(kernel.LowDateTime)) + (int64(user.HighDateTime)<<32 | int64(user.LowDateTime))
+	return time.Duration(ticks * 100), true
+}
+
+// processPeakRSS isn't read on Windows; the runtime's footprint stands in.
+func processPeakRSS() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runOptions are the command-line options as given, before shorthands are
+// resolved, for validateOptions. set reports whether a flag was given
+// explicitly.
+type runOptions struct {
+	Provider             string
+	Server               string
+	LAN                  bool
+	URL, UploadURL       string
+	Profile              string
+	Quick, Thorough      bool
+	Format               string
+	JSON                 bool
+	Output               string
+	RPC                  bool
+	Streams              string
+	Connections          int
+	PingMethod           string
+	Duration             time.Duration
+	MaxBytes             string
+	MaxDuration          time.Duration
+	FPS                  int
+	LowBandwidth         bool
+	RegressionWindow     int
+	RegressionPercentile float64
+	DualStack            bool
+	IPv4, IPv6           bool
+	CompareStacks        bool
+	HTTP3                bool
+	CompareProtocols     bool
+	Servers              int
+	Interfaces           []string
+	MeasureOverhead      bool
+	PingCompare          bool
+	set                  func(name string) bool
+}
+
+// Groups of option problems, in the order they are listed.
+const (
+	problemConflict = "Conflicting options"
+	problemValue    = "Invalid values"
+	problemName     = "Unknown names"
+)
+
+var problemGroups = []string{problemConflict, problemValue, problemName}
+
+// optionProblem is one thing wrong with the options. Suggestion, when set,
+// is a corrected option to offer instead.
+type optionProblem struct {
+	Group      string
+	Option     string
+	Message    string
+	Suggestion string
+}
+
+// validateOptions checks the options as a whole and returns every problem
+// at once, so a command line can be fixed in one go rather than one error
+// per attempt.
+func validateOptions(o runOptions) []optionProblem {
+	var problems []optionProblem
+	add := func(group, option, format string, args ...any) *optionProblem {
+		problems = append(problems, optionProblem{Group: group, Option: option, Message: fmt.Sprintf(format, args...)})
+		return &problems[len(problems)-1]
+	}
+	set := o.set
+	if set == nil {
+		set = func(string) bool { return false }
+	}
+
+	if o.Quick && o.Thorough {
+		add(problemConflict, "--quick", "--quick and --thorough pick different profiles; give one")
+	}
+	if (o.Quick || o.Thorough) && set("profile") {
+		add(problemConflict, "--profile", "--profile can't be combined with --quick or --thorough")
+	}
+	if o.JSON && o.Format != "" && o.Format != "json" {
+		add(problemConflict, "--json", "--json asks for json but --format asks for %s", o.Format)
+	}
+	if o.RPC && (o.JSON || o.Format != "" || o.Output != "-") {
+		add(problemConflict, "--rpc", "--rpc answers on stdout and can't be combined with --json, --format or --output")
+	}
+	if o.MeasureOverhead && o.PingCompare {
+		add(problemConflict, "--measure-overhead", "--measure-overhead and --ping-compare each run on their own and exit; give one")
+	}
+	if o.CompareStacks && (o.MeasureOverhead || o.PingCompare) {
+		add(problemConflict, "--compare-stacks", "--compare-stacks, --measure-overhead and --ping-compare each run on their own and exit; give one")
+	}
+	if o.IPv4 && o.IPv6 {
+		add(problemConflict, "--ipv4", "--ipv4 and --ipv6 each pin the run to one family; give one, or --compare-stacks for both")
+	}
+	if (o.IPv4 || o.IPv6) && (o.DualStack || o.CompareStacks) {
+		add(problemConflict, "--ipv4", "--ipv4 and --ipv6 can't be combined with --dual-stack or --compare-stacks, which use both families")
+	}
+	if o.DualStack && o.CompareStacks {
+		add(problemConflict, "--compare-stacks", "--compare-stacks and --dual-stack both measure both families; give one")
+	}
+	if o.PingMethod == "icmp" && (o.IPv6 || o.CompareStacks) {
+		add(problemConflict, "--ping-method", "--ping-method icmp is IPv4 only; use tcp or https with --ipv6 and --compare-stacks")
+	}
+	if o.CompareStacks && (o.RPC || len(o.Interfaces) > 1) {
+		add(problemConflict, "--compare-stacks", "--compare-stacks can't be combined with --rpc or several --interface")
+	}
+	if o.CompareStacks && (o.Format == "prometheus" || o.Format == "" && formatForPath(o.Output) == "prometheus") {
+		add(problemConflict, "--compare-stacks", "--format prometheus writes one run; use --format json with --compare-stacks")
+	}
+	if o.CompareProtocols && (o.CompareStacks || o.MeasureOverhead || o.PingCompare) {
+		add(problemConflict, "--compare-protocols", "--compare-protocols, --compare-stacks, --measure-overhead and --ping-compare each run on their own and exit; give one")
+	}
+	if o.HTTP3 && o.CompareProtocols {
+		add(problemConflict, "--http3", "--compare-protocols already runs a download over HTTP/3; drop --http3")
+	}
+	if o.HTTP3 && (o.DualStack || o.CompareStacks) {
+		add(problemConflict, "--http3", "--http3 can't be combined with --dual-stack or --compare-stacks, which pin TCP connections to each family")
+	}
+	if o.CompareProtocols && (o.RPC || len(o.Interfaces) > 1) {
+		add(problemConflict, "--compare-protocols", "--compare-protocols can't be combined with --rpc or several --interface")
+	}
+	if o.CompareProtocols && (o.Format == "prometheus" || o.Format == "" && formatForPath(o.Output) == "prometheus") {
+		add(problemConflict, "--compare-protocols", "--format prometheus writes one run; use --format json with --compare-protocols")
+	}
+	if o.Servers > 1 && (o.DualStack || o.CompareStacks || o.CompareProtocols) {
+		add(problemConflict, "--servers", "--servers can't be combined with --dual-stack, --compare-stacks or --compare-protocols")
+	}
+	if o.RPC && len(o.Interfaces) > 0 {
+		add(problemConflict, "--interface", "--interface can't be combined with --rpc")
+	}
+	if len(o.Interfaces) > 1 && (o.Format == "prometheus" || o.Format == "" && formatForPath(o.Output) == "prometheus") {
+		add(problemConflict, "--interface", "--format prometheus writes one run; give one --interface or use --format json")
+	}
+	if o.LowBandwidth && set("fps") {
+		add(problemConflict, "--low-bandwidth", "--low-bandwidth sets the frame rate itself; drop --fps or --low-bandwidth")
+	}
+
+	if set("connections") && set("streams") {
+		add(problemConflict, "--connections", "--connections and --streams both set the stream count; give one")
+	}
+	if set("connections") && (o.Connections < 1 || o.Connections > 64) {
+		add(problemValue, "--connections", "--connections %d: a count from 1 to 64", o.Connections)
+	}
+	if _, _, err := parseStreams(o.Streams); err != nil {
+		add(problemValue, "--streams", "--streams %q: a count from 1 to 64, or %s", o.Streams, streamsAuto)
+	}
+	if o.Servers < 1 || o.Servers > maxServers {
+		add(problemValue, "--servers", "--servers %d: a count from 1 to %d", o.Servers, maxServers)
+	}
+	if o.Duration < 0 || (o.Duration > 0 && o.Duration < minTransfer) {
+		add(problemValue, "--duration", "--duration %s: at least %s per direction, or 0 for the profile's", o.Duration, minTransfer)
+	}
+	if o.MaxBytes != "" {
+		if _, err := parseByteSize(o.MaxBytes); err != nil {
+			add(problemValue, "--max-bytes", "--max-bytes %v", err)
+		}
+	}
+	if o.MaxDuration < 0 {
+		add(problemValue, "--max-duration", "--max-duration %s is negative; use 0 for no limit", o.MaxDuration)
+	}
+	if o.FPS < 1 {
+		add(problemValue, "--fps", "--fps %d: at least 1 redraw per second", o.FPS)
+	}
+	if o.RegressionWindow < 1 {
+		add(problemValue, "--regression-window", "--regression-window %d: at least 1 run", o.RegressionWindow)
+	}
+	if o.RegressionPercentile <= 0 || o.RegressionPercentile >= 100 {
+		add(problemValue, "--regression-percentile", "--regression-percentile %g: between 0 and 100", o.RegressionPercentile)
+	}
+
+	factory, ok := providers[o.Provider]
+	if !ok {
+		p := add(problemName, "--provider", "unknown provider %q (available: %s)", o.Provider, strings.Join(providerNames(), ", "))
+		if s := suggest(o.Provider, providerNames()); s != "" {
+			p.Suggestion = "--provider " + s
+		}
+	} else if _, family := factory(providerOptions{}).(familyDownloader); o.DualStack && !family {
+		add(problemConflict, "--dual-stack", "--dual-stack: the %s provider can't pin connections to an address family", o.Provider)
+	} else if _, selects := factory(providerOptions{}).(serverSelector); o.Servers > 1 && !selects {
+		add(problemConflict, "--servers", "--servers: the %s provider tests against one server it doesn't choose", o.Provider)
+	} else if (o.HTTP3 || o.CompareProtocols) && factory(providerOptions{}).Capabilities().Simulated {
+		add(problemConflict, "--http3", "--http3 and --compare-protocols need a real server; the %s provider simulates its transfers", o.Provider)
+	}
+	if o.Provider == "url" && o.URL == "" {
+		add(problemValue, "--url", "--provider url needs --url with the resource to download")
+	}
+	for _, f := range []struct{ name, value string }{{"--url", o.URL}, {"--upload-url", o.UploadURL}} {
+		if f.value == "" {
+			continue
+		}
+		if u, err := url.Parse(f.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(problemValue, f.name, "%s %q: an http:// or https:// URL", f.name, f.value)
+		} else if ok && o.Provider != "url" && o.URL != "" {
+			add(problemConflict, f.name, "%s tests against your own server and can't be combined with --provider %s", f.name, o.Provider)
+		}
+	}
+	if o.UploadURL != "" && o.URL == "" {
+		add(problemValue, "--upload-url", "--upload-url needs --url for the download")
+	}
+	if o.Provider == "librespeed" && o.Server == "" {
+		add(problemValue, "--server", "--provider librespeed needs --server with the LibreSpeed server's URL")
+	}
+	if o.Provider == "lan" && o.Server == "" && !o.LAN {
+		add(problemValue, "--server", "--provider lan needs --server with the peer's URL, e.g. http://192.168.1.10:8080, or --lan to look for one")
+	}
+	if o.LAN && o.Provider != "lan" {
+		add(problemConflict, "--lan", "--lan tests against a peer on the local network and can't be combined with --provider %s", o.Provider)
+	}
+	if o.Server != "" {
+		for _, s := range librespeedServers(o.Server) {
+			if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add(problemValue, "--server", "--server %q: an http:// or https:// URL, or several separated by commas", s)
+			}
+		}
+		switch {
+		case o.Provider == "lan" && strings.Contains(o.Server, ","):
+			add(problemValue, "--server", "--provider lan tests against one peer; give a single --server URL")
+		case ok && o.Provider != "librespeed" && o.Provider != "lan":
+			add(problemConflict, "--server", "--server only applies to --provider librespeed or lan")
+		}
+	}
+	if !o.Quick && !o.Thorough {
+		if _, err := findProfile(o.Profile); err != nil {
+			p := add(problemName, "--profile", "%v", err)
+			if s := suggest(o.Profile, profileNames()); s != "" {
+				p.Suggestion = "--profile " + s
+			}
+		}
+	}
+	if o.Format != "" {
+		if _, err := outputWriter(o.Format); err != nil {
+			p := add(problemName, "--format", "%v", err)
+			if s := suggest(o.Format, []string{"json", "prometheus"}); s != "" {
+				p.Suggestion = "--format " + s
+			}
+		}
+	}
+	if len(o.Interfaces) > 0 {
+		if err := interfaceBindingSupported(); err != nil {
+			add(problemValue, "--interface", "--interface: %v", err)
+		}
+	}
+	for i, name := range o.Interfaces {
+		if slices.Contains(o.Interfaces[:i], name) {
+			add(problemValue, "--interface", "--interface %s is given more than once", name)
+		} else if _, err := net.InterfaceByName(name); err != nil {
+			p := add(problemName, "--interface", "no network interface named %q (available: %s)", name, strings.Join(interfaceNames(), ", "))
+			if s := suggest(name, interfaceNames()); s != "" {
+				p.Suggestion = "--interface " + s
+			}
+		}
+	}
+	if o.PingMethod != "auto" {
+		if _, err := newPingMethod(o.PingMethod, realClock{}); err != nil {
+			p := add(problemName, "--ping-method", "%v", err)
+			if s := suggest(o.PingMethod, append([]string{"auto"}, pingPreference...)); s != "" {
+				p.Suggestion = "--ping-method " + s
+			}
+		}
+	}
+	return problems
+}
+
+// suggest returns the name closest to a mistyped value: one it starts, or
+// one within two edits of it. It returns "" when nothing is that close.
+func suggest(value string, names []string) string {
+	value = strings.ToLower(value)
+	if value == "" {
+		return ""
+	}
+	best, bestDist := "", 3
+	for _, name := range names {
+		if strings.HasPrefix(name, value) {
+			return name
+		}
+		if d := editDistance(value, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+// writeProblems lists problems grouped by kind, each with its suggestion.
+func writeProblems(w io.Writer, problems []optionProblem) {
+	fmt.Fprintf(w, "\033[1mgofast can't start: %d problem", len(problems))
+	if len(problems) != 1 {
+		fmt.Fprint(w, "s")
+	}
+	fmt.Fprint(w, " with the options\033[0m\n")
+	for _, group := range problemGroups {
+		header := false
+		for _, p := range problems {
+			if p.Group != group {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(w, "\n  %s\n", group)
+				header = true
+			}
+			fmt.Fprintf(w, "    \033[31m✗\033[0m %s\n", p.Message)
+			if p.Suggestion != "" {
+				fmt.Fprintf(w, "      \033[90mdid you mean %s?\033[0m\n", p.Suggestion)
+			}
+		}
+	}
+	fmt.Fprint(w, "\nRun gofast --help for every option.\n")
+}
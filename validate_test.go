@@ -0,0 +1,195 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validOptions are the defaults of a plain run, which pass validation.
+func validOptions() runOptions {
+	return runOptions{
+		Provider:             "demo",
+		Profile:              "standard",
+		Output:               "-",
+		PingMethod:           "auto",
+		FPS:                  defaultFPS,
+		RegressionWindow:     defaultRegressionPolicy.Window,
+		RegressionPercentile: defaultRegressionPolicy.Percentile,
+		Servers:              1,
+	}
+}
+
+// given reports the named flags as set explicitly.
+func given(names ...string) func(string) bool {
+	return func(name string) bool { return slices.Contains(names, name) }
+}
+
+func TestValidOptionsPass(t *testing.T) {
+	for name, edit := range map[string]func(*runOptions){
+		"defaults":      func(*runOptions) {},
+		"quick":         func(o *runOptions) { o.Quick = true },
+		"json and json": func(o *runOptions) { o.JSON, o.Format = true, "json" },
+		"auto streams":  func(o *runOptions) { o.Streams = "auto" },
+		"own server": func(o *runOptions) {
+			o.Provider, o.URL, o.UploadURL = "url", "https://example.com/100MB.bin", "https://example.com/upload"
+		},
+		"librespeed":      func(o *runOptions) { o.Provider, o.Server = "librespeed", "https://a.example,https://b.example" },
+		"several servers": func(o *runOptions) { o.Provider, o.Servers = "fast", maxServers },
+		"byte cap":        func(o *runOptions) { o.MaxBytes, o.MaxDuration = "100MB", 45*time.Second },
+		"rpc":             func(o *runOptions) { o.RPC = true },
+		"lan peer":        func(o *runOptions) { o.Provider, o.Server = "lan", "http://192.168.1.10:8080" },
+		"lan discovery":   func(o *runOptions) { o.Provider, o.LAN = "lan", true },
+	} {
+		o := validOptions()
+		edit(&o)
+		if problems := validateOptions(o); len(problems) > 0 {
+			t.Errorf("%s: %+v", name, problems)
+		}
+	}
+}
+
+func TestValidateOptionsRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(*runOptions)
+		group      string
+		option     string
+		suggestion string
+	}{
+		// Conflicts.
+		{"quick and thorough", func(o *runOptions) { o.Quick, o.Thorough = true, true }, problemConflict, "--quick", ""},
+		{"profile with quick", func(o *runOptions) { o.Quick, o.set = true, given("profile") }, problemConflict, "--profile", ""},
+		{"json with prometheus", func(o *runOptions) { o.JSON, o.Format = true, "prometheus" }, problemConflict, "--json", ""},
+		{"rpc with json", func(o *runOptions) { o.RPC, o.JSON = true, true }, problemConflict, "--rpc", ""},
+		{"lan with another provider", func(o *runOptions) { o.LAN, o.set = true, given("provider") }, problemConflict, "--lan", ""},
+		{"rpc with output", func(o *runOptions) { o.RPC, o.Output = true, "run.json" }, problemConflict, "--rpc", ""},
+		{"overhead and ping compare", func(o *runOptions) { o.MeasureOverhead, o.PingCompare = true, true }, problemConflict, "--measure-overhead", ""},
+		{"compare stacks and overhead", func(o *runOptions) { o.CompareStacks, o.MeasureOverhead = true, true }, problemConflict, "--compare-stacks", ""},
+		{"ipv4 and ipv6", func(o *runOptions) { o.IPv4, o.IPv6 = true, true }, problemConflict, "--ipv4", ""},
+		{"ipv6 with dual stack", func(o *runOptions) { o.IPv6, o.DualStack = true, true }, problemConflict, "--ipv4", ""},
+		{"dual stack and compare stacks", func(o *runOptions) { o.DualStack, o.CompareStacks = true, true }, problemConflict, "--compare-stacks", ""},
+		{"icmp over ipv6", func(o *runOptions) { o.PingMethod, o.IPv6 = "icmp", true }, problemConflict, "--ping-method", ""},
+		{"compare stacks over rpc", func(o *runOptions) { o.CompareStacks, o.RPC = true, true }, problemConflict, "--compare-stacks", ""},
+		{"compare stacks to prometheus", func(o *runOptions) { o.CompareStacks, o.Output = true, "/var/lib/node/gofast.prom" }, problemConflict, "--compare-stacks", ""},
+		{"compare protocols and stacks", func(o *runOptions) { o.CompareProtocols, o.CompareStacks = true, true }, problemConflict, "--compare-protocols", ""},
+		{"http3 and compare protocols", func(o *runOptions) { o.Provider, o.HTTP3, o.CompareProtocols = "cloudflare", true, true }, problemConflict, "--http3", ""},
+		{"http3 with dual stack", func(o *runOptions) { o.HTTP3, o.DualStack = true, true }, problemConflict, "--http3", ""},
+		{"compare protocols over rpc", func(o *runOptions) { o.Provider, o.CompareProtocols, o.RPC = "cloudflare", true, true }, problemConflict, "--compare-protocols", ""},
+		{"compare protocols to prometheus", func(o *runOptions) { o.Provider, o.CompareProtocols, o.Format = "cloudflare", true, "prometheus" }, problemConflict, "--compare-protocols", ""},
+		{"servers with dual stack", func(o *runOptions) { o.Servers, o.DualStack = 2, true }, problemConflict, "--servers", ""},
+		{"interface over rpc", func(o *runOptions) { o.Interfaces, o.RPC = []string{"lo"}, true }, problemConflict, "--interface", ""},
+		{"interfaces to prometheus", func(o *runOptions) { o.Interfaces, o.Format = []string{"lo", "eth0"}, "prometheus" }, problemConflict, "--interface", ""},
+		{"low bandwidth with fps", func(o *runOptions) { o.LowBandwidth, o.set = true, given("fps") }, problemConflict, "--low-bandwidth", ""},
+		{"connections and streams", func(o *runOptions) { o.Connections, o.Streams, o.set = 4, "4", given("connections", "streams") }, problemConflict, "--connections", ""},
+		{"dual stack without families", func(o *runOptions) { o.Provider, o.URL, o.DualStack = "url", "https://example.com/a", true }, problemConflict, "--dual-stack", ""},
+		{"servers on one server", func(o *runOptions) { o.Servers = 3 }, problemConflict, "--servers", ""},
+		{"http3 simulated", func(o *runOptions) { o.HTTP3 = true }, problemConflict, "--http3", ""},
+		{"url with another provider", func(o *runOptions) { o.Provider, o.URL = "fast", "https://example.com/a" }, problemConflict, "--url", ""},
+		{"server with another provider", func(o *runOptions) { o.Provider, o.Server = "fast", "https://a.example" }, problemConflict, "--server", ""},
+
+		// Values.
+		{"connections out of range", func(o *runOptions) { o.Connections, o.set = 65, given("connections") }, problemValue, "--connections", ""},
+		{"streams zero", func(o *runOptions) { o.Streams = "0" }, problemValue, "--streams", ""},
+		{"streams word", func(o *runOptions) { o.Streams = "many" }, problemValue, "--streams", ""},
+		{"no servers", func(o *runOptions) { o.Servers = 0 }, problemValue, "--servers", ""},
+		{"too many servers", func(o *runOptions) { o.Provider, o.Servers = "fast", maxServers+1 }, problemValue, "--servers", ""},
+		{"negative duration", func(o *runOptions) { o.Duration = -time.Second }, problemValue, "--duration", ""},
+		{"duration too short", func(o *runOptions) { o.Duration = minTransfer / 2 }, problemValue, "--duration", ""},
+		{"malformed size", func(o *runOptions) { o.MaxBytes = "lots" }, problemValue, "--max-bytes", ""},
+		{"negative max duration", func(o *runOptions) { o.MaxDuration = -time.Minute }, problemValue, "--max-duration", ""},
+		{"no frames", func(o *runOptions) { o.FPS = 0 }, problemValue, "--fps", ""},
+		{"empty regression window", func(o *runOptions) { o.RegressionWindow = 0 }, problemValue, "--regression-window", ""},
+		{"percentile out of range", func(o *runOptions) { o.RegressionPercentile = 100 }, problemValue, "--regression-percentile", ""},
+		{"url provider without url", func(o *runOptions) { o.Provider = "url" }, problemValue, "--url", ""},
+		{"url not http", func(o *runOptions) { o.Provider, o.URL = "url", "ftp://example.com/a" }, problemValue, "--url", ""},
+		{"upload url alone", func(o *runOptions) { o.UploadURL = "https://example.com/up" }, problemValue, "--upload-url", ""},
+		{"librespeed without server", func(o *runOptions) { o.Provider = "librespeed" }, problemValue, "--server", ""},
+		{"lan without server", func(o *runOptions) { o.Provider = "lan" }, problemValue, "--server", ""},
+		{"lan with several peers", func(o *runOptions) { o.Provider, o.Server = "lan", "http://a:8080,http://b:8080" }, problemValue, "--server", ""},
+		{"server not a URL", func(o *runOptions) { o.Provider, o.Server = "librespeed", "speed.example" }, problemValue, "--server", ""},
+		{"interface twice", func(o *runOptions) { o.Interfaces = []string{"lo", "lo"} }, problemValue, "--interface", ""},
+
+		// Names.
+		{"misspelt provider", func(o *runOptions) { o.Provider = "librespee" }, problemName, "--provider", "--provider librespeed"},
+		{"provider prefix", func(o *runOptions) { o.Provider = "cloud" }, problemName, "--provider", "--provider cloudflare"},
+		{"unknown provider", func(o *runOptions) { o.Provider = "speedtest-net" }, problemName, "--provider", ""},
+		{"misspelt profile", func(o *runOptions) { o.Profile = "standrd" }, problemName, "--profile", "--profile standard"},
+		{"misspelt format", func(o *runOptions) { o.Format = "jsno" }, problemName, "--format", "--format json"},
+		{"unknown interface", func(o *runOptions) { o.Interfaces = []string{"no-such-if0"} }, problemName, "--interface", ""},
+		{"misspelt ping method", func(o *runOptions) { o.PingMethod = "tpc" }, problemName, "--ping-method", "--ping-method tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := validOptions()
+			tt.edit(&o)
+			problems := validateOptions(o)
+			i := slices.IndexFunc(problems, func(p optionProblem) bool { return p.Group == tt.group && p.Option == tt.option })
+			if i < 0 {
+				t.Fatalf("no %s problem with %s in %+v", tt.group, tt.option, problems)
+			}
+			if got := problems[i].Suggestion; got != tt.suggestion {
+				t.Errorf("suggested %q, want %q", got, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestValidateOptionsCollectsAll(t *testing.T) {
+	o := validOptions()
+	o.Quick, o.Thorough = true, true
+	o.MaxBytes = "lots"
+	o.FPS = 0
+	o.Provider = "librespee"
+	problems := validateOptions(o)
+	var options []string
+	for _, p := range problems {
+		options = append(options, p.Option)
+	}
+	if want := []string{"--quick", "--max-bytes", "--fps", "--provider"}; !slices.Equal(options, want) {
+		t.Errorf("problems with %v, want %v", options, want)
+	}
+
+	var b strings.Builder
+	writeProblems(&b, problems)
+	got := sgrPattern.ReplaceAllString(b.String(), "")
+	want := "gofast can't start: 4 problems with the options\n\n" +
+		"  Conflicting options\n" +
+		"    ✗ " + problems[0].Message + "\n\n" +
+		"  Invalid values\n" +
+		"    ✗ " + problems[1].Message + "\n" +
+		"    ✗ " + problems[2].Message + "\n\n" +
+		"  Unknown names\n" +
+		"    ✗ " + problems[3].Message + "\n" +
+		"      did you mean --provider librespeed?\n\n" +
+		"Run gofast --help for every option.\n"
+	if got != want {
+		t.Errorf("listed as:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"cloudflare", "fast", "librespeed", "ookla"}
+	for _, tt := range []struct{ value, want string }{
+		{"fast", "fast"},
+		{"FAST", "fast"},
+		{"ook", "ookla"},
+		{"okla", "ookla"},
+		{"libre-speed", "librespeed"},
+		{"speedtest", ""},
+		{"", ""},
+	} {
+		if got := suggest(tt.value, names); got != tt.want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{{"", "abc", 3}, {"kitten", "sitting", 3}, {"fast", "fast", 0}, {"東京", "京都", 2}} {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
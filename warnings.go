@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Warning is a non-fatal problem noticed during a run. Code is stable so
+// scripts can filter on it; Message is for people and may change.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warning codes. A code keeps its meaning once released; new problems get
+// new codes.
+const (
+	warnServerFallback      = "server_fallback"
+	warnLocationUnavailable = "location_unavailable"
+	warnStreamRestarted     = "stream_restarted"
+	warnClockJump           = "clock_jump"
+	warnCPUBound            = "cpu_bound"
+	warnTLSUnverified       = "tls_unverified"
+	warnRetested            = "retested"
+	warnNoCACertificates    = "no_ca_certificates"
+	warnNoResolvConf        = "no_resolv_conf"
+	warnNoTZData            = "no_tzdata"
+	warnFamilyUnavailable   = "family_unavailable"
+	warnDataCapped          = "data_capped"
+	warnPhaseRetried        = "phase_retried"
+	warnHTTP3Unavailable    = "http3_unavailable"
+	warnFewerServers        = "fewer_servers"
+	warnServerLegFailed     = "server_leg_failed"
+	warnLANDownloadOnly     = "lan_download_only"
+	warnLANPeers            = "lan_peers"
+)
+
+type warningMsg Warning
+
+// clockJumpThreshold is how far the wall clock may drift from the monotonic
+// clock during a run before timestamps are called into question.
+const clockJumpThreshold = time.Second
+
+// warn records a warning on r and reports it to the TUI as it happens.
+func warn(r *Results, emit func(tea.Msg), code, format string, args ...any) {
+	w := Warning{Code: code, Message: fmt.Sprintf(format, args...)}
+	r.Warnings = append(r.Warnings, w)
+	emit(warningMsg(w))
+}
+
+// clockJump compares the wall time elapsed since start with the monotonic
+// time, returning how far the wall clock was stepped, e.g. by NTP.
+func clockJump(clock Clock, start time.Time) time.Duration {
+	wall := clock.Now().Round(0).Sub(start.Round(0))
+	jump := wall - clock.Since(start)
+	if jump < 0 {
+		return -jump
+	}
+	return jump
+}
+
+// warningBadge is the header's count of undismissed warnings, e.g. "⚠ 2".
+func (m speedTest) warningBadge() string {
+	n := len(m.warnings) - m.dismissedWarnings
+	if n <= 0 {
+		return ""
+	}
+	if m.showWarnings {
+		return fmt.Sprintf(" \033[33;1m⚠ %d\033[0m \033[90m('!' to hide, 'x' to dismiss)\033[0m", n)
+	}
+	return fmt.Sprintf(" \033[33;1m⚠ %d\033[0m \033[90m('!' to show)\033[0m", n)
+}
+
+// warningList expands the badge into one line per undismissed warning.
+func (m speedTest) warningList() string {
+	var s strings.Builder
+	for _, w := range m.warnings[m.dismissedWarnings:] {
+		s.WriteString(fmt.Sprintf("\033[33m⚠ %s\033[0m \033[90m[%s]\033[0m\n", w.Message, w.Code))
+	}
+	return s.String()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultWebDestinations are small resources of widely used sites, fetched
+// by the web responsiveness check when the config names none.
+var defaultWebDestinations = []string{
+	"https://www.google.com/favicon.ico",
+	"https://www.youtube.com/favicon.ico",
+	"https://www.wikipedia.org/static/favicon/wikipedia.ico",
+	"https://www.amazon.com/favicon.ico",
+	"https://github.com/favicon.ico",
+}
+
+// webCheckBudget caps the payload the whole check may read. A favicon is a
+// few KB; destinations past the cap are skipped.
+const webCheckBudget = 300 << 10
+
+// webCheckTimeout bounds one destination, from lookup to the last byte.
+const webCheckTimeout = 5 * time.Second
+
+// WebCheck is how quickly the connection makes websites usable: what a
+// browser waits for before the first byte of a new site, whatever the
+// bandwidth.
+type WebCheck struct {
+	// ResponsivenessMs is the median time to first byte over the
+	// destinations that answered, lookup and handshakes included.
+	ResponsivenessMs float64     `json:"responsiveness_ms"`
+	Destinations     []WebTiming `json:"destinations"`
+}
+
+// WebTiming is one destination's connection setup, each step in ms. A step
+// that didn't happen, such as TLS for plain HTTP, is zero.
+type WebTiming struct {
+	// Host is empty when privacy settings withhold it.
+	Host        string  `json:"host,omitempty"`
+	DNSMs       float64 `json:"dns_ms"`
+	ConnectMs   float64 `json:"connect_ms"`
+	TLSMs       float64 `json:"tls_ms"`
+	FirstByteMs float64 `json:"first_byte_ms"`
+	Bytes       int64   `json:"bytes"`
+	// Reused is set when the request went over a connection that was
+	// already open, which leaves no lookup, connect or handshake to time.
+	Reused bool   `json:"reused,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// webCheckMsg tells the TUI the web responsiveness check has started.
+type webCheckMsg struct{}
+
+// parseWebDestinations reads the web_destinations config key: URLs
+// separated by commas.
+func parseWebDestinations(value string) ([]string, error) {
+	var urls []string
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("%q isn't an http or https URL", raw)
+		}
+		urls = append(urls, raw)
+	}
+	return urls, nil
+}
+
+// runWebCheck times a fresh connection to every destination in turn, so
+// each pays for its own lookup and handshakes, and stops reading once the
+// check has used webCheckBudget.
+func runWebCheck(ctx context.Context, clock Clock, destinations []string) *WebCheck {
+	check := &WebCheck{}
+	remaining := int64(webCheckBudget)
+	var firstBytes []float64
+	for _, dest := range destinations {
+		t := WebTiming{Host: dest}
+		if u, err := url.Parse(dest); err == nil {
+			t.Host = u.Host
+		}
+		if remaining <= 0 {
+			t.Error = "skipped: the check's traffic cap was reached"
+			check.Destinations = append(check.Destinations, t)
+			continue
+		}
+		t = fetchTimed(ctx, clock, dest, remaining, t)
+		remaining -= t.Bytes
+		if t.Error == "" {
+			firstBytes = append(firstBytes, t.FirstByteMs)
+		}
+		check.Destinations = append(check.Destinations, t)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(firstBytes) > 0 {
+		check.ResponsivenessMs = median(firstBytes)
+	}
+	return check
+}
+
+func fetchTimed(ctx context.Context, clock Clock, dest string, limit int64, t WebTiming) WebTiming {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dest, nil)
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	return timeRequest(ctx, clock, req, limit, t)
+}
+
+// timeRequest makes req on a connection of its own, timing each step of
+// its setup, and reads up to limit bytes of the answer.
+func timeRequest(ctx context.Context, clock Clock, req *http.Request, limit int64, t WebTiming) WebTiming {
+	ctx, cancel := context.WithTimeout(ctx, webCheckTimeout)
+	defer cancel()
+
+	var dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = clock.Now() },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err == nil {
+				t.DNSMs = msSince(clock, dnsStart)
+			}
+		},
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = clock.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.ConnectMs = msSince(clock, connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = clock.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.TLSMs = msSince(clock, tlsStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) { t.Reused = info.Reused },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+	transport := httpTransport()
+	transport.DisableKeepAlives = true
+	defer transport.CloseIdleConnections()
+
+	start := clock.Now()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		// Without the URL, so the error says nothing privacy redaction
+		// would have to remove.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("no answer within %s", webCheckTimeout)
+		}
+		t.Error = err.Error()
+		return t
+	}
+	defer resp.Body.Close()
+	t.FirstByteMs = msSince(clock, start)
+	t.Bytes, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, limit))
+	if resp.StatusCode >= 400 {
+		t.Error = resp.Status
+	}
+	return t
+}
+
+// formatWeb is the web responsiveness row, e.g. "84 ms (median of 5 sites)".
+func formatWeb(w *WebCheck) string {
+	answered := 0
+	for _, t := range w.Destinations {
+		if t.Error == "" {
+			answered++
+		}
+	}
+	if answered == 0 {
+		return "n/a (no site answered)"
+	}
+	return fmt.Sprintf("%.0f ms (median of %d of %d sites)", w.ResponsivenessMs, answered, len(w.Destinations))
+}
+
+// webSection lists each destination's connection setup.
+func webSection(w *WebCheck) detailSection {
+	section := detailSection{title: "Web responsiveness"}
+	for i, t := range w.Destinations {
+		host := t.Host
+		if host == "" {
+			host = fmt.Sprintf("destination %d", i+1)
+		}
+		if t.Error != "" {
+			section.add(host, "\033[33m"+t.Error+"\033[0m")
+			continue
+		}
+		section.add(host, fmt.Sprintf("%.0f ms (DNS %.0f · connect %.0f · TLS %.0f)", t.FirstByteMs, t.DNSMs, t.ConnectMs, t.TLSMs))
+	}
+	return section
+}
+
+// traceRequestLimit is the most of the answer the connection breakdown
+// reads; the request asks for an empty one.
+const traceRequestLimit = 64 << 10
+
+// traceConnection times one fresh request to the test server, step by
+// step. Its Host is left empty: the server is already in the results, and
+// privacy redaction handles it there.
+func traceConnection(ctx context.Context, clock Clock, tracer connectionTracer) *WebTiming {
+	t := &WebTiming{}
+	req, err := tracer.TraceRequest(ctx)
+	if err != nil {
+		t.Error = err.Error()
+		return t
+	}
+	*t = timeRequest(ctx, clock, req, traceRequestLimit, *t)
+	return t
+}
+
+// connectionSection breaks the request to the test server down into its
+// steps. A step that didn't happen, such as the lookup for a server given
+// by address or TLS over plain HTTP, shows as a dash.
+func connectionSection(t *WebTiming) detailSection {
+	section := detailSection{title: "Connection to server"}
+	if t.Error != "" {
+		section.add("Breakdown", "\033[33m"+t.Error+"\033[0m")
+		return section
+	}
+	step := func(ms float64) string {
+		switch {
+		case t.Reused:
+			return "– \033[90m(reused connection)\033[0m"
+		case ms == 0:
+			return "–"
+		}
+		return fmt.Sprintf("%.1f ms", ms)
+	}
+	section.add("DNS lookup", step(t.DNSMs))
+	section.add("TCP connect", step(t.ConnectMs))
+	section.add("TLS handshake", step(t.TLSMs))
+	section.add("First byte", fmt.Sprintf("%.1f ms", t.FirstByteMs))
+	return section
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// widgetFormat is the default `gofast widget` template.
+const widgetFormat = "{icon} {ping}ms"
+
+// widgetTTL is how long a probe is reused by later invocations. Status bars
+// typically poll every few seconds; one probe per half minute is plenty.
+const widgetTTL = 30 * time.Second
+
+// widgetProbe is the last latency probe, shared between invocations through
+// the cache file.
+type widgetProbe struct {
+	At     time.Time `json:"at"`
+	Host   string    `json:"host"`
+	Method string    `json:"method"`
+	RTT    float64   `json:"rtt_ms,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func defaultWidgetCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofast", "widget.json"), nil
+}
+
+// widgetIcons are shown for a fast, slow, bad and failed probe.
+type widgetIcons [4]string
+
+func parseWidgetIcons(s string) (widgetIcons, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return widgetIcons{}, fmt.Errorf("--icons wants four comma-separated icons (fast, slow, bad, down), got %q", s)
+	}
+	return widgetIcons(parts), nil
+}
+
+// icon picks the icon for a probe: under slow ms is fast, under bad ms is
+// slow, anything else bad.
+func (icons widgetIcons) icon(p widgetProbe, slow, bad float64) string {
+	switch {
+	case p.Error != "":
+		return icons[3]
+	case p.RTT < slow:
+		return icons[0]
+	case p.RTT < bad:
+		return icons[1]
+	}
+	return icons[2]
+}
+
+// formatWidget fills in a widget template. Placeholders are {icon}, {ping}
+// (whole ms, "--" when the probe failed), {method}, {host} and {age} (whole
+// seconds since the probe).
+func formatWidget(format string, p widgetProbe, icon string, now time.Time) string {
+	ping := "--"
+	if p.Error == "" {
+		ping = fmt.Sprint(math.Round(p.RTT))
+	}
+	return strings.NewReplacer(
+		"{icon}", icon,
+		"{ping}", ping,
+		"{method}", p.Method,
+		"{host}", p.Host,
+		"{age}", fmt.Sprint(int(now.Sub(p.At).Seconds())),
+	).Replace(format)
+}
+
+// cachedProbe returns the cached probe while it is younger than ttl and was
+// taken the same way, and otherwise probes and caches the result. The cache
+// is locked throughout, so a burst of invocations probes once and the rest
+// wait for its result. Without a usable cache it just probes.
+func cachedProbe(ctx context.Context, path string, ttl time.Duration, host, method string, clock Clock) widgetProbe {
+	if path == "" {
+		return widgetProbeOnce(ctx, host, method, clock)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return widgetProbeOnce(ctx, host, method, clock)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return widgetProbeOnce(ctx, host, method, clock)
+	}
+	defer f.Close()
+	if err := lockFile(f, true); err != nil {
+		return widgetProbeOnce(ctx, host, method, clock)
+	}
+
+	var cached widgetProbe
+	if data, err := io.ReadAll(f); err == nil && json.Unmarshal(data, &cached) == nil {
+		age := clock.Now().Sub(cached.At)
+		if age >= 0 && age < ttl && cached.Host == host && (method == "auto" || cached.Method == method) {
+			return cached
+		}
+	}
+
+	p := widgetProbeOnce(ctx, host, method, clock)
+	if data, err := json.Marshal(p); err == nil && f.Truncate(0) == nil {
+		f.WriteAt(data, 0)
+	}
+	return p
+}
+
+// widgetProbeOnce measures one round trip to host.
+func widgetProbeOnce(ctx context.Context, host, method string, clock Clock) widgetProbe {
+	p := widgetProbe{At: clock.Now(), Host: host, Method: method}
+	m, err := choosePingMethod(method, clock)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	p.Method = m.Name()
+	if p.RTT, err = m.Ping(ctx, host); err != nil {
+		p.RTT, p.Error = 0, err.Error()
+	}
+	return p
+}
+
+// runWidget implements "gofast widget": one line of latency for a status
+// bar such as polybar, tmux or i3status, from a single cheap probe that
+// frequent invocations share through a cache.
+func runWidget(args []string) {
+	fs := flag.NewFlagSet("widget", flag.ExitOnError)
+	format := fs.String("format", widgetFormat, "output template; placeholders {icon}, {ping}, {method}, {host}, {age}")
+	method := fs.String("ping-method", "auto", "latency probe: icmp, tcp, udp, https, or auto for the most accurate one available")
+	host := fs.String("host", pingHost, "host to probe")
+	ttl := fs.Duration("ttl", widgetTTL, "reuse a probe younger than this instead of probing again; 0 always probes")
+	slow := fs.Float64("slow", 50, "round trips from this many ms get the slow icon")
+	bad := fs.Float64("bad", 150, "round trips from this many ms get the bad icon")
+	iconList := fs.String("icons", "●,◐,○,✕", "icons for fast, slow, bad and failed probes, comma-separated")
+	cache := fs.String("cache", "", "cache file (default in the user cache directory)")
+	fs.Parse(args)
+
+	icons, err := parseWidgetIcons(*iconList)
+	if err == nil && *method != "auto" {
+		_, err = newPingMethod(*method, realClock{})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	path := *cache
+	switch {
+	case *ttl <= 0:
+		path = ""
+	case path == "":
+		path, _ = defaultWidgetCachePath()
+	}
+
+	clock := realClock{}
+	p := cachedProbe(context.Background(), path, *ttl, *host, *method, clock)
+	fmt.Println(formatWidget(*format, p, icons.icon(p, *slow, *bad), clock.Now()))
+}
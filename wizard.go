@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// wizardStep is one question of the first-run setup. Steps without choices
+// take a speed.
+type wizardStep struct {
+	title   string
+	help    string
+	choices []string
+	apply   func(c *config, choice int, number float64)
+}
+
+var wizardSteps = []wizardStep{
+	{
+		title:   "Show speeds in",
+		choices: []string{"Mbps (bits)", "MB/s (bytes)"},
+		apply:   func(c *config, i int, _ float64) { c.Units = []string{"bits", "bytes"}[i] },
+	},
+	{
+		title:   "Keep a history of runs to spot unusually slow results?",
+		choices: []string{"Yes", "No"},
+		apply:   func(c *config, i int, _ float64) { c.History = i == 0 },
+	},
+	{
+		title: "Plan download speed",
+		help:  "e.g. 500, 62.5MB/s or 1Gbps (bare numbers are Mbps); leave empty if you don't know",
+		apply: func(c *config, _ int, v float64) { c.PlanDownload = v },
+	},
+	{
+		title: "Plan upload speed",
+		help:  "e.g. 50, 6.25MB/s or 1Gbps (bare numbers are Mbps); leave empty if you don't know",
+		apply: func(c *config, _ int, v float64) { c.PlanUpload = v },
+	},
+	{
+		title:   "Is this a metered connection? Metered runs use the quick profile.",
+		choices: []string{"No", "Yes"},
+		apply:   func(c *config, i int, _ float64) { c.Metered = i == 1 },
+	},
+}
+
+// wizardModel walks through wizardSteps before the first test. Esc skips
+// the rest with defaults; ctrl+c leaves gofast altogether.
+type wizardModel struct {
+	cfg    config
+	step   int
+	choice int
+	input  string
+	err    string
+	quit   bool
+}
+
+func (m wizardModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m wizardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+	step := wizardSteps[m.step]
+
+	switch key.String() {
+	case "ctrl+c":
+		m.quit = true
+		return m, tea.Quit
+	case "esc":
+		return m, tea.Quit
+	case "enter":
+		number := 0.0
+		if step.choices == nil && strings.TrimSpace(m.input) != "" {
+			v, _, err := parseSpeed(m.input)
+			if err != nil {
+				m.err = err.Error()
+				return m, nil
+			}
+			number = v
+		}
+		step.apply(&m.cfg, m.choice, number)
+		m.step, m.choice, m.input, m.err = m.step+1, 0, "", ""
+		if m.step == len(wizardSteps) {
+			return m, tea.Quit
+		}
+	case "up", "left", "shift+tab":
+		if step.choices != nil {
+			m.choice = (m.choice + len(step.choices) - 1) % len(step.choices)
+		}
+	case "down", "right", "tab":
+		if step.choices != nil {
+			m.choice = (m.choice + 1) % len(step.choices)
+		}
+	case "backspace":
+		if m.input != "" {
+			m.input = m.input[:len(m.input)-1]
+		}
+	default:
+		if step.choices == nil && key.Type == tea.KeyRunes {
+			for _, r := range key.Runes {
+				if strings.ContainsRune("0123456789.,/ ", r) || unicode.IsLetter(r) {
+					m.input += string(r)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m wizardModel) View() string {
+	var s strings.Builder
+	s.WriteString(term.title("GoFast - Setup") + "\n\n")
+	s.WriteString(fmt.Sprintf("\033[90mStep %d of %d\033[0m\n\n", m.step+1, len(wizardSteps)))
+
+	step := wizardSteps[m.step]
+	s.WriteString("\033[1m" + step.title + "\033[0m\n")
+	if step.help != "" {
+		s.WriteString("\033[90m" + step.help + "\033[0m\n")
+	}
+	s.WriteString("\n")
+
+	if step.choices == nil {
+		s.WriteString("  > " + m.input + "█\n")
+	} else {
+		for i, choice := range step.choices {
+			if i == m.choice {
+				s.WriteString("  \033[36;1m▶ " + choice + "\033[0m\n")
+			} else {
+				s.WriteString("    " + choice + "\n")
+			}
+		}
+	}
+	if m.err != "" {
+		s.WriteString("\n\033[31m" + m.err + "\033[0m\n")
+	}
+
+	s.WriteString("\n\033[90menter to confirm · esc to skip setup · ctrl+c to quit\033[0m")
+	return term.render(s.String())
+}
+
+// runWizard asks the setup questions, starting from cfg, and saves the
+// answers; skipping saves cfg so it isn't asked again. It reports false
+// when the user quit instead.
+func runWizard(path string, cfg config) (config, bool, error) {
+	final, err := tea.NewProgram(wizardModel{cfg: cfg}).Run()
+	if err != nil {
+		return config{}, false, err
+	}
+	m := final.(wizardModel)
+	if m.quit {
+		return config{}, false, nil
+	}
+	if err := m.cfg.save(path); err != nil {
+		return m.cfg, true, err
+	}
+	return m.cfg, true, nil
+}
+
+// interactive reports whether stdin and stdout are terminals.
+func interactive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		info, err := f.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}